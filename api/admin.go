@@ -12,7 +12,7 @@ func AdminAuth(c *fiber.Ctx) error {
 		return ErrUnAuthorized()
 	}
 	if !user.IsAdmin {
-		return ErrUnAuthorized()
+		return ErrForbidden()
 	}
 	return c.Next()
 }
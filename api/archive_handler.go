@@ -0,0 +1,89 @@
+package api
+
+import (
+	"context"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/johnson7543/ims/db"
+	"github.com/johnson7543/ims/types"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	// ArchiveRetentionEnvName sets how many days archived records are kept before the daily
+	// purge removes them. The purge only runs on a schedule when it is set.
+	ArchiveRetentionEnvName = "ARCHIVE_RETENTION_DAYS"
+
+	defaultArchiveRetentionDays = 90
+)
+
+// ArchiveRetention returns the configured retention, and whether one is configured.
+func ArchiveRetention() (time.Duration, bool) {
+	days, err := strconv.Atoi(os.Getenv(ArchiveRetentionEnvName))
+	if err != nil || days <= 0 {
+		return defaultArchiveRetentionDays * 24 * time.Hour, false
+	}
+	return time.Duration(days) * 24 * time.Hour, true
+}
+
+// RunArchivePurge purges records archived longer than retention once every interval.
+// It blocks, so start it in its own goroutine.
+func RunArchivePurge(store *db.Store, retention, interval time.Duration) {
+	for {
+		results, err := store.Archive.PurgeArchived(context.Background(), time.Now().Add(-retention))
+		if err != nil {
+			log.Printf("archive purge: %v", err)
+		}
+		for _, r := range results {
+			if r.Purged > 0 || r.Skipped > 0 {
+				log.Printf("archive purge: %s purged %d, skipped %d still referenced", r.Collection, r.Purged, r.Skipped)
+			}
+		}
+		time.Sleep(interval)
+	}
+}
+
+type ArchiveHandler struct {
+	store *db.Store
+}
+
+func NewArchiveHandler(store *db.Store) *ArchiveHandler {
+	return &ArchiveHandler{
+		store: store,
+	}
+}
+
+// HandlePurgeArchived permanently deletes records archived longer than the retention period.
+//
+// @Summary Purge archived records
+// @Description Permanently deletes orders, material orders, processing items, products, materials and parties archived longer than the retention period.
+// @Description Archived records that live records still refer to are kept and counted as skipped. Admins only.
+// @Tags Admin
+// @Produce json
+// @Param retentionDays query int false "Days to keep archived records, defaults to ARCHIVE_RETENTION_DAYS or 90"
+// @Success 200 {array} types.PurgeResult
+// @Router /admin/purge [post]
+func (h *ArchiveHandler) HandlePurgeArchived(c *fiber.Ctx) error {
+	retention, _ := ArchiveRetention()
+	if days := c.Query("retentionDays"); days != "" {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return NewError(fiber.StatusBadRequest, "retentionDays must be a whole number of days")
+		}
+		retention = time.Duration(n) * 24 * time.Hour
+	}
+
+	results, err := h.store.Archive.PurgeArchived(c.Context(), time.Now().Add(-retention))
+	if err != nil {
+		return err
+	}
+	if results == nil {
+		results = []*types.PurgeResult{}
+	}
+
+	return c.JSON(results)
+}
@@ -35,16 +35,8 @@ type AuthResponse struct {
 	Token string      `json:"token"`
 }
 
-type genericResp struct {
-	Type string `json:"type"`
-	Msg  string `json:"msg"`
-}
-
 func invalidCredentials(c *fiber.Ctx) error {
-	return c.Status(http.StatusBadRequest).JSON(genericResp{
-		Type: "error",
-		Msg:  "invalid credentials",
-	})
+	return NewError(http.StatusBadRequest, "invalid credentials")
 }
 
 // HandleAuthenticate handles user authentication.
@@ -56,7 +48,7 @@ func invalidCredentials(c *fiber.Ctx) error {
 // @Produce json
 // @Param authParams body AuthParams true "Authentication parameters"
 // @Success 200 {object} AuthResponse
-// @Failure 400 {object} genericResp
+// @Failure 400 {object} Error
 // @Router /auth [post]
 func (h *AuthHandler) HandleAuthenticate(c *fiber.Ctx) error {
 	var params AuthParams
@@ -0,0 +1,453 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/johnson7543/ims/db"
+	"github.com/johnson7543/ims/types"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type InsertCustomerReturnParams struct {
+	OrderID string `json:"orderId" validate:"required,objectid"`
+	// WarehouseID and Bin pick where restocked products go back. The order's location is used
+	// when they are left out.
+	WarehouseID string             `json:"warehouseId" validate:"objectid"`
+	Bin         string             `json:"bin"`
+	Lines       []ReturnLineParams `json:"lines" validate:"required"`
+	Remark      string             `json:"remark"`
+}
+
+type ReturnLineParams struct {
+	// Line is the index of the line in the order's items, starting at 0.
+	Line     int    `json:"line" validate:"min=0"`
+	Quantity int    `json:"quantity" validate:"required,gt=0"`
+	Reason   string `json:"reason" validate:"required,enum=returnReason"`
+	Remark   string `json:"remark"`
+}
+
+func (p InsertCustomerReturnParams) validate() error {
+	errs := fieldErrors(p)
+	for i, line := range p.Lines {
+		for _, other := range p.Lines[:i] {
+			if line.Line == other.Line {
+				errs[fmt.Sprintf("lines[%d].line", i)] = fmt.Sprintf("order line %d is returned twice", line.Line)
+			}
+		}
+	}
+	return errs.err()
+}
+
+type InspectCustomerReturnParams struct {
+	Inspections []ReturnInspectionParams `json:"inspections" validate:"required"`
+}
+
+type ReturnInspectionParams struct {
+	// Line is the order line of the return line inspected.
+	Line     int    `json:"line" validate:"min=0"`
+	Outcome  string `json:"outcome" validate:"required,enum=returnOutcome"`
+	Quantity int    `json:"quantity" validate:"required,gt=0"`
+	Remark   string `json:"remark"`
+	// WorkerID, WorkerName and Price describe the processing item reworked products go to.
+	// A worker is required for rework.
+	WorkerID   string  `json:"workerId" validate:"objectid"`
+	WorkerName string  `json:"workerName"`
+	Price      float64 `json:"price" validate:"min=0"`
+}
+
+func (p InspectCustomerReturnParams) validate() error {
+	errs := fieldErrors(p)
+	for i, inspection := range p.Inspections {
+		if inspection.Outcome == types.ReturnRework && inspection.WorkerID == "" {
+			errs[fmt.Sprintf("inspections[%d].workerId", i)] = "is required to rework"
+		}
+	}
+	return errs.err()
+}
+
+type IssueCreditNoteParams struct {
+	Remark string `json:"remark"`
+}
+
+type CustomerReturnHandler struct {
+	store *db.Store
+}
+
+func NewCustomerReturnHandler(store *db.Store) *CustomerReturnHandler {
+	return &CustomerReturnHandler{
+		store: store,
+	}
+}
+
+// HandleGetCustomerReturns lists customer returns.
+//
+// @Summary Get customer returns
+// @Description Lists return authorizations, newest first.
+// @Tags CustomerReturn
+// @Param orderId query string false "Order returned"
+// @Param customerId query string false "Customer"
+// @Param status query string false "authorized, completed or canceled"
+// @Produce json
+// @Success 200 {array} types.CustomerReturn
+// @Router /customerReturn [get]
+func (h *CustomerReturnHandler) HandleGetCustomerReturns(c *fiber.Ctx) error {
+	filter := bson.M{}
+
+	if status := c.Query("status"); status != "" {
+		if !contains(types.ReturnStatuses, status, false) {
+			return NewError(fiber.StatusBadRequest, "Invalid status")
+		}
+		filter["status"] = status
+	}
+	if err := idFilter(c, filter, "orderId", "customerId"); err != nil {
+		return err
+	}
+
+	returns, err := h.store.CustomerReturn.GetCustomerReturns(c.Context(), filter)
+	if err != nil {
+		return err
+	}
+
+	if len(returns) == 0 {
+		return NewError(fiber.StatusNotFound, "No Matches data found")
+	}
+
+	return c.JSON(returns)
+}
+
+// HandleGetCustomerReturn retrieves a customer return by ID.
+//
+// @Summary Get customer return
+// @Description Get a return authorization with its lines and what inspection made of them. The ETag header carries its version, to be sent back as If-Match when inspecting it.
+// @Tags CustomerReturn
+// @Produce json
+// @Param id path string true "Customer return ID"
+// @Success 200 {object} types.CustomerReturn
+// @Header 200 {string} ETag "Version of the return"
+// @Router /customerReturn/{id} [get]
+func (h *CustomerReturnHandler) HandleGetCustomerReturn(c *fiber.Ctx) error {
+	ret, err := findRecord(c, "Customer return", h.store.CustomerReturn.GetCustomerReturn)
+	if err != nil {
+		return err
+	}
+
+	setETag(c, ret.Version)
+	return c.JSON(ret)
+}
+
+// HandleInsertCustomerReturn authorizes a customer return.
+//
+// @Summary Authorize customer return
+// @Description Issues a return authorization (RMA) for lines of an order, each with a quantity and reason. Only what was delivered and not returned already can come back: the whole line once the order is shipped, what its shipments carried before. Stock does not change until the products are inspected.
+// @Tags CustomerReturn
+// @Accept json
+// @Produce json
+// @Param body body InsertCustomerReturnParams true "Return"
+// @Success 200 {object} types.CustomerReturn
+// @Failure 409 {object} Error
+// @Router /customerReturn [post]
+func (h *CustomerReturnHandler) HandleInsertCustomerReturn(c *fiber.Ctx) error {
+	var params InsertCustomerReturnParams
+	if err := c.BodyParser(&params); err != nil {
+		return err
+	}
+
+	if err := params.validate(); err != nil {
+		return err
+	}
+
+	orderID, _ := primitive.ObjectIDFromHex(params.OrderID)
+	order, err := h.store.Order.GetOrder(c.Context(), orderID)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return ValidationError{"orderId": fmt.Sprintf("%s does not exist", params.OrderID)}
+	}
+	if err != nil {
+		return err
+	}
+
+	errs := ValidationError{}
+	lines := make([]types.ReturnLine, len(params.Lines))
+	for i, line := range params.Lines {
+		if line.Line >= len(order.OrderItems) {
+			errs[fmt.Sprintf("lines[%d].line", i)] = fmt.Sprintf("the order has %d lines", len(order.OrderItems))
+			continue
+		}
+		if left := order.Delivered(line.Line) - order.OrderItems[line.Line].Returned; line.Quantity > left {
+			errs[fmt.Sprintf("lines[%d].quantity", i)] = fmt.Sprintf("only %d delivered and not returned", left)
+			continue
+		}
+		lines[i] = types.ReturnLine{Line: line.Line, Quantity: line.Quantity, Reason: line.Reason, Remark: line.Remark}
+	}
+	if err := errs.err(); err != nil {
+		return err
+	}
+
+	user, err := getAuthUser(c)
+	if err != nil {
+		return err
+	}
+
+	ret := types.CustomerReturn{
+		OrderID:   orderID,
+		Lines:     lines,
+		Location:  order.Location,
+		Remark:    params.Remark,
+		CreatedBy: user.ID,
+		CreatedAt: time.Now(),
+	}
+	if params.WarehouseID != "" || params.Bin != "" {
+		if ret.Location, err = stockLocation(c.Context(), h.store.Warehouse, "", params.WarehouseID, params.Bin); err != nil {
+			return err
+		}
+	}
+
+	inserted, err := h.store.CustomerReturn.InsertCustomerReturn(c.Context(), &ret)
+	if errors.Is(err, db.ErrVersionConflict) {
+		return versionMismatch("Order")
+	}
+	if err != nil {
+		return err
+	}
+
+	setETag(c, inserted.Version)
+	return c.JSON(inserted)
+}
+
+// HandleInspectCustomerReturn records what inspection made of returned products.
+//
+// @Summary Inspect customer return
+// @Description Records the outcome of inspecting returned products, for part or all of a line: restock puts them back into stock with a movement in the stock ledger, rework creates a processing item for the worker given, scrap only records them. Either every inspection is recorded or none is. The return is completed once all of it is inspected. Requires the If-Match header with the ETag of the return.
+// @Tags CustomerReturn
+// @Accept json
+// @Produce json
+// @Param id path string true "Customer return ID"
+// @Param If-Match header string true "ETag of the return as last read"
+// @Param body body InspectCustomerReturnParams true "Inspections"
+// @Success 200 {object} types.CustomerReturn
+// @Failure 409 {object} Error
+// @Failure 412 {object} Error
+// @Router /customerReturn/{id}/inspect [post]
+func (h *CustomerReturnHandler) HandleInspectCustomerReturn(c *fiber.Ctx) error {
+	ret, err := findRecord(c, "Customer return", h.store.CustomerReturn.GetCustomerReturn)
+	if err != nil {
+		return err
+	}
+
+	version, err := ifMatchVersion(c)
+	if err != nil {
+		return err
+	}
+
+	var params InspectCustomerReturnParams
+	if err := c.BodyParser(&params); err != nil {
+		return err
+	}
+
+	if err := params.validate(); err != nil {
+		return err
+	}
+
+	user, err := getAuthUser(c)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	errs := ValidationError{}
+	inspecting := map[int]int{}
+	inputs := make([]db.ReturnInspectionInput, len(params.Inspections))
+	for i, inspection := range params.Inspections {
+		line := ret.FindLine(inspection.Line)
+		if line == nil {
+			errs[fmt.Sprintf("inspections[%d].line", i)] = fmt.Sprintf("order line %d is not part of the return", inspection.Line)
+			continue
+		}
+		inspecting[inspection.Line] += inspection.Quantity
+		if left := line.Quantity - line.Inspected; inspecting[inspection.Line] > left {
+			errs[fmt.Sprintf("inspections[%d].quantity", i)] = fmt.Sprintf("only %d left to inspect", left)
+			continue
+		}
+
+		inputs[i] = db.ReturnInspectionInput{
+			Line: inspection.Line,
+			Inspection: types.ReturnInspection{
+				Outcome:     inspection.Outcome,
+				Quantity:    inspection.Quantity,
+				Remark:      inspection.Remark,
+				InspectedBy: user.ID,
+				InspectedAt: now,
+			},
+		}
+		if inspection.Outcome == types.ReturnRework {
+			workerID, _ := primitive.ObjectIDFromHex(inspection.WorkerID)
+			inputs[i].Rework = &types.ProcessingItem{
+				Price:      inspection.Price,
+				WorkerID:   workerID,
+				WorkerName: inspection.WorkerName,
+				StartDate:  now,
+				Remarks:    inspection.Remark,
+			}
+		}
+	}
+	if err := errs.err(); err != nil {
+		return err
+	}
+
+	inspected, err := h.store.CustomerReturn.InspectCustomerReturn(c.Context(), ret.ID, version, inputs)
+	switch {
+	case errors.Is(err, db.ErrVersionConflict):
+		return versionMismatch("Customer return")
+	case errors.Is(err, db.ErrReturnClosed):
+		return NewError(fiber.StatusConflict, fmt.Sprintf("Return %s is no longer open", ret.Number))
+	case err != nil:
+		return err
+	}
+
+	setETag(c, inspected.Version)
+	return c.JSON(inspected)
+}
+
+// HandleCancelCustomerReturn cancels a customer return.
+//
+// @Summary Cancel customer return
+// @Description Cancels a return authorization before any of it was inspected, so its quantities can be returned again.
+// @Tags CustomerReturn
+// @Produce json
+// @Param id path string true "Customer return ID"
+// @Success 200 {object} map[string]string
+// @Failure 409 {object} Error
+// @Router /customerReturn/{id}/cancel [post]
+func (h *CustomerReturnHandler) HandleCancelCustomerReturn(c *fiber.Ctx) error {
+	ret, err := findRecord(c, "Customer return", h.store.CustomerReturn.GetCustomerReturn)
+	if err != nil {
+		return err
+	}
+
+	if _, err := h.store.CustomerReturn.CancelCustomerReturn(c.Context(), ret.ID); err != nil {
+		if errors.Is(err, db.ErrReturnClosed) {
+			return NewError(fiber.StatusConflict, fmt.Sprintf("Return %s is no longer open or was partly inspected", ret.Number))
+		}
+		if errors.Is(err, db.ErrVersionConflict) {
+			return versionMismatch("Customer return")
+		}
+		return err
+	}
+
+	return c.JSON(fiber.Map{"message": fmt.Sprintf("Return %s canceled", ret.Number)})
+}
+
+// HandleIssueCreditNote issues the credit note of a customer return.
+//
+// @Summary Issue credit note
+// @Description Credits a completed return against its order, every line at the price it was sold for on the order, and adds the amount to what the order was credited. A return is credited once.
+// @Tags CustomerReturn
+// @Accept json
+// @Produce json
+// @Param id path string true "Customer return ID"
+// @Param body body IssueCreditNoteParams false "Remark"
+// @Success 200 {object} types.CreditNote
+// @Failure 409 {object} Error
+// @Router /customerReturn/{id}/creditNote [post]
+func (h *CustomerReturnHandler) HandleIssueCreditNote(c *fiber.Ctx) error {
+	ret, err := findRecord(c, "Customer return", h.store.CustomerReturn.GetCustomerReturn)
+	if err != nil {
+		return err
+	}
+
+	var params IssueCreditNoteParams
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&params); err != nil {
+			return err
+		}
+	}
+
+	user, err := getAuthUser(c)
+	if err != nil {
+		return err
+	}
+
+	creditNote := types.CreditNote{
+		Remark:   params.Remark,
+		IssuedBy: user.ID,
+		IssuedAt: time.Now(),
+	}
+	issued, err := h.store.CustomerReturn.IssueCreditNote(c.Context(), ret.ID, &creditNote)
+	switch {
+	case errors.Is(err, db.ErrReturnNotCredited):
+		return NewError(fiber.StatusConflict, fmt.Sprintf("Return %s is not completed or was credited already", ret.Number))
+	case errors.Is(err, db.ErrVersionConflict):
+		return versionMismatch("Customer return")
+	case err != nil:
+		return err
+	}
+
+	return c.JSON(issued)
+}
+
+// HandleGetCreditNotes lists credit notes.
+//
+// @Summary Get credit notes
+// @Description Lists credit notes, newest first.
+// @Tags CustomerReturn
+// @Param orderId query string false "Order credited"
+// @Param customerId query string false "Customer"
+// @Param returnId query string false "Customer return"
+// @Produce json
+// @Success 200 {array} types.CreditNote
+// @Router /creditNote [get]
+func (h *CustomerReturnHandler) HandleGetCreditNotes(c *fiber.Ctx) error {
+	filter := bson.M{}
+	if err := idFilter(c, filter, "orderId", "customerId", "returnId"); err != nil {
+		return err
+	}
+
+	creditNotes, err := h.store.CustomerReturn.GetCreditNotes(c.Context(), filter)
+	if err != nil {
+		return err
+	}
+
+	if len(creditNotes) == 0 {
+		return NewError(fiber.StatusNotFound, "No Matches data found")
+	}
+
+	return c.JSON(creditNotes)
+}
+
+// HandleGetCreditNote retrieves a credit note by ID.
+//
+// @Summary Get credit note
+// @Description Get a credit note by ID.
+// @Tags CustomerReturn
+// @Produce json
+// @Param id path string true "Credit note ID"
+// @Success 200 {object} types.CreditNote
+// @Router /creditNote/{id} [get]
+func (h *CustomerReturnHandler) HandleGetCreditNote(c *fiber.Ctx) error {
+	creditNote, err := findRecord(c, "Credit note", h.store.CustomerReturn.GetCreditNote)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(creditNote)
+}
+
+// idFilter adds the ID query parameters given to filter, each matching the field of the same
+// name.
+func idFilter(c *fiber.Ctx, filter bson.M, queries ...string) error {
+	for _, query := range queries {
+		if id := c.Query(query); id != "" {
+			objID, err := primitive.ObjectIDFromHex(id)
+			if err != nil {
+				return NewError(fiber.StatusBadRequest, fmt.Sprintf("Invalid %s", query))
+			}
+			filter[query] = objID
+		}
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package api
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/johnson7543/ims/db"
+	"github.com/johnson7543/ims/types"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	dashboardCacheTTL = 30 * time.Second
+	// dashboardCacheSize bounds the cache, as clients pick its keys through lowStock and tz.
+	dashboardCacheSize       = 256
+	defaultLowStockThreshold = 10
+)
+
+type dashboardCacheEntry struct {
+	summary   *types.DashboardSummary
+	expiresAt time.Time
+}
+
+type DashboardHandler struct {
+	store *db.Store
+
+	mu    sync.Mutex
+	cache map[string]dashboardCacheEntry
+}
+
+func NewDashboardHandler(store *db.Store) *DashboardHandler {
+	return &DashboardHandler{
+		store: store,
+		cache: map[string]dashboardCacheEntry{},
+	}
+}
+
+// HandleGetDashboard returns the KPIs for the home page in one response.
+//
+// @Summary Get dashboard
+// @Description Returns open orders, deliveries, unpaid totals, low stock counts, in-progress processing items per worker and monthly sales. Results are cached for 30 seconds.
+// @Tags Dashboard
+// @Param lowStock query int false "Available quantity at or below which stock counts as low (default 10)"
+// @Param tz query string false "Timezone used for month boundaries, e.g. Asia/Taipei"
+// @Produce json
+// @Success 200 {object} types.DashboardSummary
+// @Router /dashboard [get]
+func (h *DashboardHandler) HandleGetDashboard(c *fiber.Ctx) error {
+	threshold := c.QueryInt("lowStock", defaultLowStockThreshold)
+	if threshold < 0 {
+		return NewError(fiber.StatusBadRequest, "Invalid low stock threshold")
+	}
+
+	loc := time.UTC
+	if tz := c.Query("tz"); tz != "" {
+		l, err := time.LoadLocation(tz)
+		if err != nil {
+			return NewError(fiber.StatusBadRequest, "Invalid timezone")
+		}
+		loc = l
+	}
+
+	key := fmt.Sprintf("%d|%s", threshold, loc.String())
+	now := time.Now()
+	if summary, ok := h.cached(key, now); ok {
+		return c.JSON(summary)
+	}
+
+	localNow := now.In(loc)
+	thisMonthStart := time.Date(localNow.Year(), localNow.Month(), 1, 0, 0, 0, 0, loc)
+	period := db.DashboardPeriod{
+		Now:               now,
+		ThisMonthStart:    thisMonthStart,
+		LastMonthStart:    thisMonthStart.AddDate(0, -1, 0),
+		LowStockThreshold: threshold,
+	}
+
+	summary, err := h.store.Dashboard.GetDashboardSummary(c.Context(), period)
+	if err != nil {
+		return err
+	}
+
+	if summary.SalesLastMonth != 0 {
+		summary.SalesChangePercent = roundMoney((summary.SalesThisMonth - summary.SalesLastMonth) / summary.SalesLastMonth * 100)
+	}
+
+	h.keep(key, summary, now)
+
+	return c.JSON(summary)
+}
+
+// keep caches summary under key, first dropping expired entries. Once the cache is full the
+// summary is not kept.
+func (h *DashboardHandler) keep(key string, summary *types.DashboardSummary, now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for k, entry := range h.cache {
+		if now.After(entry.expiresAt) {
+			delete(h.cache, k)
+		}
+	}
+	if len(h.cache) >= dashboardCacheSize {
+		return
+	}
+	h.cache[key] = dashboardCacheEntry{summary: summary, expiresAt: now.Add(dashboardCacheTTL)}
+}
+
+func (h *DashboardHandler) cached(key string, now time.Time) (*types.DashboardSummary, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	entry, ok := h.cache[key]
+	if !ok {
+		return nil, false
+	}
+	if now.After(entry.expiresAt) {
+		delete(h.cache, key)
+		return nil, false
+	}
+	return entry.summary, true
+}
@@ -0,0 +1,86 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/johnson7543/ims/db"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// deleteOptions reads the mode query parameter of a delete request and records who deletes.
+// Cascading to referencing documents and archiving a referenced record must be asked for
+// explicitly, and only by admins.
+func deleteOptions(c *fiber.Ctx) (db.DeleteOptions, error) {
+	user, err := getAuthUser(c)
+	if err != nil {
+		return db.DeleteOptions{}, ErrUnAuthorized()
+	}
+
+	mode, err := db.ParseDeleteMode(c.Query("mode"))
+	if err != nil {
+		return db.DeleteOptions{}, NewError(fiber.StatusBadRequest, err.Error())
+	}
+	if mode != db.DeleteRestrict && !user.IsAdmin {
+		return db.DeleteOptions{}, NewError(fiber.StatusForbidden, fmt.Sprintf("Only admins can delete with mode %s", mode))
+	}
+
+	return db.DeleteOptions{Mode: mode, By: user.ID}, nil
+}
+
+// deleteError answers 409 with the blocking documents when a delete failed on references.
+func deleteError(c *fiber.Ctx, entity string, err error) error {
+	var refErr *db.ReferenceError
+	if errors.As(err, &refErr) {
+		problem := NewError(fiber.StatusConflict, fmt.Sprintf("%s is still referenced, delete with mode=cascade or mode=archive to override", entity))
+		problem.References = refErr.References
+		return problem
+	}
+	return err
+}
+
+// deletedMessage words the success message for a delete. Every delete archives the record,
+// so the message points at the restore route.
+func deletedMessage(entity string) string {
+	return entity + " archived successfully, it can be restored until it is purged"
+}
+
+// archivedFilter applies the includeArchived query parameter of a list request. Archived
+// records are left out unless it is true, or only, which lists just the archived records.
+func archivedFilter(c *fiber.Ctx, filter bson.M) error {
+	switch strings.ToLower(c.Query("includeArchived")) {
+	case "", "false":
+	case "true":
+		db.IncludeArchived(filter)
+	case "only":
+		db.OnlyArchived(filter)
+	default:
+		return NewError(fiber.StatusBadRequest, "includeArchived must be true, false or only")
+	}
+	return nil
+}
+
+// restoreRecord brings back the archived record named by the id route parameter.
+func restoreRecord(c *fiber.Ctx, entity string, restore func(context.Context, primitive.ObjectID) (int64, error)) error {
+	objID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return NewError(fiber.StatusBadRequest, fmt.Sprintf("Invalid %s ID", strings.ToLower(entity)))
+	}
+
+	restored, err := restore(c.Context(), objID)
+	if err != nil {
+		return err
+	}
+	if restored == 0 {
+		return NewError(fiber.StatusNotFound, fmt.Sprintf("No archived %s with this ID", strings.ToLower(entity)))
+	}
+
+	return c.JSON(fiber.Map{
+		"message": fmt.Sprintf("%s restored successfully", entity),
+	})
+}
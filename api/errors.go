@@ -1,60 +1,189 @@
 package api
 
 import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
 	"net/http"
 
+	"github.com/johnson7543/ims/db"
+	"github.com/johnson7543/ims/types"
+
 	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
-func ErrorHandler(c *fiber.Ctx, err error) error {
-	if apiError, ok := err.(Error); ok {
-		return c.Status(apiError.Code).JSON(apiError)
-	}
-	apiError := NewError(http.StatusInternalServerError, err.Error())
-	return c.Status(apiError.Code).JSON(apiError)
-}
+// mimeProblem is the media type of an RFC 7807 problem details body.
+const mimeProblem = "application/problem+json"
 
+// Error codes tell clients what kind of error happened. Unlike the detail text they are
+// stable, so clients can branch on them.
+const (
+	CodeBadRequest            = "bad_request"
+	CodeUnauthorized          = "unauthorized"
+	CodeForbidden             = "forbidden"
+	CodeNotFound              = "not_found"
+	CodeConflict              = "conflict"
+	CodeInsufficientStock     = "insufficient_stock"
+	CodeIdempotencyKeyReused  = "idempotency_key_reused"
+	CodeIdempotencyInProgress = "idempotency_in_progress"
+	CodePreconditionFailed    = "precondition_failed"
+	CodePreconditionRequired  = "precondition_required"
+	CodeUnsupportedMediaType  = "unsupported_media_type"
+	CodeValidation            = "validation_failed"
+	CodeInternal              = "internal"
+)
+
+// Error is an RFC 7807 problem. ErrorHandler renders it as application/problem+json.
 type Error struct {
-	Code int    `json:"code"`
-	Err  string `json:"error"`
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Detail    string `json:"detail,omitempty"`
+	Instance  string `json:"instance,omitempty"`
+	Code      string `json:"code"`
+	RequestID string `json:"requestId,omitempty"`
+	// Fields lists what is wrong with each field of a request that failed validation.
+	Fields ValidationError `json:"fields,omitempty"`
+	// References lists the documents that keep a record from being deleted.
+	References []*types.DocumentReferences `json:"references,omitempty"`
 }
 
 // Error implements the Error interface
 func (e Error) Error() string {
-	return e.Err
+	return e.Detail
+}
+
+// NewError builds a problem with the code that goes with the HTTP status.
+func NewError(status int, detail string) Error {
+	return newProblem(status, codeForStatus(status), detail)
 }
 
-func NewError(code int, err string) Error {
+func newProblem(status int, code, detail string) Error {
 	return Error{
-		Code: code,
-		Err:  err,
+		Type:   "urn:ims:problem:" + code,
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+		Code:   code,
 	}
 }
 
-func ErrUnAuthorized() Error {
-	return Error{
-		Code: http.StatusUnauthorized,
-		Err:  "unauthorized request",
+func codeForStatus(status int) string {
+	switch status {
+	case http.StatusUnauthorized:
+		return CodeUnauthorized
+	case http.StatusForbidden:
+		return CodeForbidden
+	case http.StatusNotFound:
+		return CodeNotFound
+	case http.StatusConflict:
+		return CodeConflict
+	case http.StatusPreconditionFailed:
+		return CodePreconditionFailed
+	case http.StatusPreconditionRequired:
+		return CodePreconditionRequired
+	case http.StatusUnsupportedMediaType:
+		return CodeUnsupportedMediaType
+	case http.StatusUnprocessableEntity:
+		return CodeValidation
 	}
+	if status >= http.StatusInternalServerError {
+		return CodeInternal
+	}
+	return CodeBadRequest
+}
+
+func ErrUnAuthorized() Error {
+	return NewError(http.StatusUnauthorized, "unauthorized request")
+}
+
+func ErrForbidden() Error {
+	return NewError(http.StatusForbidden, "admin rights are required")
 }
 
 func ErrNotResourceNotFound(res string) Error {
-	return Error{
-		Code: http.StatusNotFound,
-		Err:  res + " resource not found",
-	}
+	return NewError(http.StatusNotFound, res+" resource not found")
 }
 
 func ErrBadRequest() Error {
-	return Error{
-		Code: http.StatusBadRequest,
-		Err:  "invalid JSON request",
-	}
+	return NewError(http.StatusBadRequest, "invalid JSON request")
 }
 
 func ErrInvalidID() Error {
-	return Error{
-		Code: http.StatusBadRequest,
-		Err:  "invalid id given",
+	return NewError(http.StatusBadRequest, "invalid id given")
+}
+
+// ErrInsufficientStock is the problem for taking more of a product or material than is in stock.
+func ErrInsufficientStock(detail string) Error {
+	return newProblem(http.StatusConflict, CodeInsufficientStock, detail)
+}
+
+// problemFor maps an error returned by a handler, including the errors of the stores, to the
+// problem sent to the client.
+func problemFor(err error) Error {
+	var (
+		problem    Error
+		fields     ValidationError
+		refErr     *db.ReferenceError
+		fiberError *fiber.Error
+		syntaxErr  *json.SyntaxError
+		typeErr    *json.UnmarshalTypeError
+		hexErr     hex.InvalidByteError
+	)
+	switch {
+	case errors.As(err, &problem):
+		return problem
+	case errors.As(err, &fields):
+		problem = newProblem(http.StatusUnprocessableEntity, CodeValidation, "validation failed")
+		problem.Fields = fields
+	case errors.As(err, &refErr):
+		problem = NewError(http.StatusConflict, "the record is still referenced by other documents")
+		problem.References = refErr.References
+	case errors.Is(err, db.ErrVersionConflict):
+		problem = NewError(http.StatusPreconditionFailed, "the record was changed since it was read, fetch it again and retry")
+	case errors.Is(err, db.ErrInsufficientStock):
+		problem = ErrInsufficientStock("not enough in stock")
+	case errors.Is(err, db.ErrLotExists), errors.Is(err, db.ErrLotConsumed), errors.Is(err, db.ErrStocktakeClosed),
+		errors.Is(err, db.ErrOrderCanceled), errors.Is(err, db.ErrOverShipment),
+		errors.Is(err, db.ErrOverReturn), errors.Is(err, db.ErrReturnClosed), errors.Is(err, db.ErrReturnNotCredited),
+		errors.Is(err, db.ErrMaterialOrderNotCompleted), errors.Is(err, db.ErrOverSupplierReturn),
+		errors.Is(err, db.ErrSupplierReturnClosed), errors.Is(err, db.ErrNoRefundDue):
+		problem = NewError(http.StatusConflict, err.Error())
+	case errors.Is(err, mongo.ErrNoDocuments):
+		problem = NewError(http.StatusNotFound, "record not found")
+	case errors.As(err, &syntaxErr), errors.As(err, &typeErr):
+		// Request bodies that are not JSON, or hold a value of the wrong type.
+		problem = NewError(http.StatusBadRequest, fmt.Sprintf("invalid JSON body: %v", err))
+	case errors.Is(err, primitive.ErrInvalidHex), errors.As(err, &hexErr):
+		problem = NewError(http.StatusBadRequest, "invalid ID")
+	case errors.As(err, &fiberError):
+		problem = NewError(fiberError.Code, fiberError.Message)
+	default:
+		problem = NewError(http.StatusInternalServerError, err.Error())
+	}
+	return problem
+}
+
+// ErrorHandler renders every error as an RFC 7807 problem. The details of internal errors are
+// logged with the request ID instead of being sent to the client.
+func ErrorHandler(c *fiber.Ctx, err error) error {
+	problem := problemFor(err)
+	problem.Instance = c.Path()
+	problem.RequestID = requestID(c)
+
+	if problem.Status >= http.StatusInternalServerError {
+		log.Printf("request %s: %s %s: %v", problem.RequestID, c.Method(), c.Path(), err)
+		problem.Detail = fmt.Sprintf("internal server error, quote request ID %s when reporting it", problem.RequestID)
+	}
+
+	body, err := json.Marshal(problem)
+	if err != nil {
+		return err
 	}
+	c.Set(fiber.HeaderContentType, mimeProblem)
+	return c.Status(problem.Status).Send(body)
 }
@@ -0,0 +1,77 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/johnson7543/ims/db"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// etag formats a document version as an entity tag.
+func etag(version int64) string {
+	return strconv.Quote(strconv.FormatInt(version, 10))
+}
+
+func setETag(c *fiber.Ctx, version int64) {
+	c.Set(fiber.HeaderETag, etag(version))
+}
+
+// ifMatchVersion reads the version an update is based on from the If-Match header. Updates
+// without it are refused, so a client cannot overwrite changes it has not seen.
+func ifMatchVersion(c *fiber.Ctx) (int64, error) {
+	header := strings.TrimSpace(c.Get(fiber.HeaderIfMatch))
+	if header == "" {
+		return 0, NewError(fiber.StatusPreconditionRequired, "If-Match header with the ETag of the record is required")
+	}
+
+	tag := strings.Trim(strings.TrimPrefix(header, "W/"), `"`)
+	version, err := strconv.ParseInt(tag, 10, 64)
+	if err != nil || version < 0 {
+		return 0, NewError(fiber.StatusBadRequest, "Invalid If-Match header, expected the ETag of the record")
+	}
+	return version, nil
+}
+
+// versionMismatch is the 412 answer to an update based on an outdated version.
+func versionMismatch(entity string) error {
+	return NewError(fiber.StatusPreconditionFailed, fmt.Sprintf("%s was changed since it was read, fetch it again and retry", entity))
+}
+
+// updateError answers 412 when a versioned update lost against a concurrent change.
+func updateError(entity string, err error) error {
+	if errors.Is(err, db.ErrVersionConflict) {
+		return versionMismatch(entity)
+	}
+	return err
+}
+
+// findRecord loads the record named by the id route parameter, answering 400 for a malformed
+// ID and 404 when there is no such record. Archived records are only found by a GET asking
+// for includeArchived=true, so they can be read but not changed or used.
+func findRecord[T any](c *fiber.Ctx, entity string, get func(context.Context, primitive.ObjectID) (*T, error)) (*T, error) {
+	objID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return nil, NewError(fiber.StatusBadRequest, fmt.Sprintf("Invalid %s ID", strings.ToLower(entity)))
+	}
+
+	var ctx context.Context = c.Context()
+	if c.Method() == fiber.MethodGet && strings.EqualFold(c.Query("includeArchived"), "true") {
+		ctx = db.WithArchived(ctx)
+	}
+
+	record, err := get(ctx, objID)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, NewError(fiber.StatusNotFound, fmt.Sprintf("%s not found", entity))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return record, nil
+}
@@ -0,0 +1,266 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/johnson7543/ims/types"
+	"github.com/johnson7543/ims/xlsx"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const (
+	exportCSV  = "csv"
+	exportXLSX = "xlsx"
+
+	mimeCSV  = "text/csv"
+	mimeXLSX = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+)
+
+// exportFormat picks the spreadsheet format a list request asks for, preferring the format
+// query parameter over the Accept header. It returns "" when JSON should be served.
+func exportFormat(c *fiber.Ctx) string {
+	switch strings.ToLower(c.Query("format")) {
+	case exportCSV:
+		return exportCSV
+	case exportXLSX:
+		return exportXLSX
+	case "json":
+		return ""
+	}
+
+	switch c.Accepts(fiber.MIMEApplicationJSON, mimeCSV, mimeXLSX) {
+	case mimeCSV:
+		return exportCSV
+	case mimeXLSX:
+		return exportXLSX
+	}
+	return ""
+}
+
+type tableWriter interface {
+	Write([]string) error
+	Flush() error
+	Close() error
+}
+
+type csvTableWriter struct {
+	*csv.Writer
+}
+
+func (w csvTableWriter) Flush() error {
+	w.Writer.Flush()
+	return w.Writer.Error()
+}
+
+func (w csvTableWriter) Close() error {
+	return w.Flush()
+}
+
+// safeTableWriter keeps spreadsheet programs from running cell values as formulas: text
+// starting with =, +, - or @ is prefixed with a single quote. Numbers are left alone.
+type safeTableWriter struct {
+	tableWriter
+}
+
+func (w safeTableWriter) Write(record []string) error {
+	escaped := make([]string, len(record))
+	for i, value := range record {
+		escaped[i] = escapeFormula(value)
+	}
+	return w.tableWriter.Write(escaped)
+}
+
+func escapeFormula(value string) string {
+	if value == "" || !strings.ContainsRune("=+-@", rune(value[0])) {
+		return value
+	}
+	if _, err := strconv.ParseFloat(value, 64); err == nil {
+		return value
+	}
+	return "'" + value
+}
+
+// exportIncomplete is written as the last row when an export stops early, so a cut short
+// file is not taken for the full list.
+var exportIncomplete = []string{"error: the export stopped early and this file is incomplete"}
+
+// exportFlushEvery is how many rows are buffered before they are pushed to the client.
+const exportFlushEvery = 500
+
+// streamExport writes the documents matching filter as CSV or XLSX directly to the response.
+// iterate runs after the handler has returned, so it is given a background context. The status
+// is sent by then too, so a failure part way is logged and marked in the file itself.
+func streamExport[T any](c *fiber.Ctx, name, format string, filter bson.M, iterate func(context.Context, bson.M, func(*T) error) error, header []string, records func(*T) [][]string) error {
+	filename := fmt.Sprintf("%s_%s.%s", name, time.Now().Format("20060102"), format)
+	if format == exportCSV {
+		c.Set(fiber.HeaderContentType, mimeCSV+"; charset=utf-8")
+	} else {
+		c.Set(fiber.HeaderContentType, mimeXLSX)
+	}
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="%s"`, filename))
+
+	c.Context().SetBodyStreamWriter(func(bw *bufio.Writer) {
+		var w tableWriter
+		if format == exportCSV {
+			// A byte order mark makes Excel open UTF-8 CSV files with Chinese text correctly.
+			bw.WriteString("\xef\xbb\xbf")
+			w = csvTableWriter{csv.NewWriter(bw)}
+		} else {
+			xw, err := xlsx.NewWriter(bw)
+			if err != nil {
+				log.Printf("export %s: %v", name, err)
+				return
+			}
+			w = xw
+		}
+		w = safeTableWriter{w}
+
+		if err := w.Write(header); err != nil {
+			log.Printf("export %s: %v", name, err)
+			return
+		}
+
+		rows := 0
+		err := iterate(context.Background(), filter, func(doc *T) error {
+			for _, record := range records(doc) {
+				if err := w.Write(record); err != nil {
+					return err
+				}
+				rows++
+				if rows%exportFlushEvery == 0 {
+					if err := w.Flush(); err != nil {
+						return err
+					}
+					if err := bw.Flush(); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			log.Printf("export %s: %v", name, err)
+			if err := w.Write(exportIncomplete); err != nil {
+				log.Printf("export %s: %v", name, err)
+			}
+		}
+
+		if err := w.Close(); err != nil {
+			log.Printf("export %s: %v", name, err)
+		}
+	})
+
+	return nil
+}
+
+func formatDate(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+var productExportHeader = []string{"id", "sku", "name", "material", "color", "type", "size", "quantity", "reserved", "available", "price", "date", "remark"}
+
+func productExportRecords(p *types.Product) [][]string {
+	return [][]string{{
+		p.ID.Hex(), p.SKU, p.Name, p.Material, p.Color, p.Type, p.Size,
+		strconv.Itoa(p.Quantity), strconv.Itoa(p.Reserved), strconv.Itoa(p.Available), formatFloat(p.Price), formatDate(p.Date), p.Remark,
+	}}
+}
+
+var productParentExportHeader = []string{"id", "code", "name", "type", "colors", "sizes", "materials", "skuTemplate", "price", "remark"}
+
+func productParentExportRecords(p *types.ProductParent) [][]string {
+	return [][]string{{
+		p.ID.Hex(), p.Code, p.Name, p.Type, strings.Join(p.Colors, ","), strings.Join(p.Sizes, ","),
+		strings.Join(p.Materials, ","), p.SKUTemplate, formatFloat(p.Price), p.Remark,
+	}}
+}
+
+var materialExportHeader = []string{"id", "name", "color", "type", "size", "unit", "quantity", "latestPrice", "remarks"}
+
+func materialExportRecords(m *types.Material) [][]string {
+	return [][]string{{
+		m.ID.Hex(), m.Name, m.Color, m.Type, m.Size, m.Unit,
+		formatFloat(m.Quantity), formatFloat(latestPrice(m.PriceHistory)), m.Remarks,
+	}}
+}
+
+var partyExportHeader = []string{"id", "company", "name", "phone", "address", "taxIdNumber", "roles"}
+
+func partyExportRecords(p *types.Party) [][]string {
+	return [][]string{{p.ID.Hex(), p.Company, p.Name, p.Phone, p.Address, p.TaxIdNumber, strings.Join(p.Roles, ",")}}
+}
+
+var processingItemExportHeader = []string{"id", "name", "quantity", "price", "workerId", "workerName", "startDate", "endDate", "sku", "remarks"}
+
+func processingItemExportRecords(p *types.ProcessingItem) [][]string {
+	return [][]string{{
+		p.ID.Hex(), p.Name, strconv.Itoa(p.Quantity), formatFloat(p.Price), p.WorkerID.Hex(), p.WorkerName,
+		formatDate(p.StartDate), formatDate(p.EndDate), p.SKU, p.Remarks,
+	}}
+}
+
+var orderExportHeader = []string{
+	"orderId", "customerId", "customerName", "orderDate", "deliveryDate", "paymentDate", "status", "shippingAddress", "totalAmount",
+	"productId", "sku", "productName", "unitPrice", "quantity", "lineTotal",
+}
+
+// orderExportRecords flattens an order into one row per line item, repeating the order columns.
+func orderExportRecords(o *types.Order) [][]string {
+	order := []string{
+		o.ID.Hex(), o.CustomerID.Hex(), o.CustomerName, formatDate(o.OrderDate), formatDate(o.DeliveryDate),
+		formatDate(o.PaymentDate), o.Status, o.ShippingAddress, formatFloat(o.TotalAmount),
+	}
+	if len(o.OrderItems) == 0 {
+		return [][]string{append(order, "", "", "", "", "", "")}
+	}
+
+	records := make([][]string, len(o.OrderItems))
+	for i, item := range o.OrderItems {
+		records[i] = append(append([]string{}, order...),
+			item.Product.ID.Hex(), item.Product.SKU, item.Product.Name, formatFloat(item.Product.UnitPrice),
+			strconv.Itoa(item.Quantity), formatFloat(item.TotalPrice),
+		)
+	}
+	return records
+}
+
+var materialOrderExportHeader = []string{
+	"materialOrderId", "sellerId", "sellerName", "orderDate", "deliveryDate", "paymentDate", "status", "totalAmount",
+	"materialId", "materialName", "color", "size", "unitPrice", "quantity", "unit", "baseQuantity", "lineTotal", "lotNumber",
+}
+
+// materialOrderExportRecords flattens a material order into one row per line item.
+func materialOrderExportRecords(o *types.MaterialOrder) [][]string {
+	order := []string{
+		o.ID.Hex(), o.SellerID, o.SellerName, formatDate(o.OrderDate), formatDate(o.DeliveryDate),
+		formatDate(o.PaymentDate), o.Status, formatFloat(o.TotalAmount),
+	}
+	if len(o.MaterialOrderItems) == 0 {
+		return [][]string{append(order, "", "", "", "", "", "", "", "", "", "")}
+	}
+
+	records := make([][]string, len(o.MaterialOrderItems))
+	for i, item := range o.MaterialOrderItems {
+		records[i] = append(append([]string{}, order...),
+			item.Material.MaterialID.Hex(), item.Material.Name, item.Material.Color, item.Material.Size,
+			formatFloat(item.Material.Price), formatFloat(item.Quantity), item.Unit, formatFloat(item.StockQuantity()), formatFloat(item.TotalPrice), item.LotNumber,
+		)
+	}
+	return records
+}
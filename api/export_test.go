@@ -0,0 +1,52 @@
+package api
+
+import (
+	"bytes"
+	"encoding/csv"
+	"reflect"
+	"testing"
+)
+
+func TestEscapeFormula(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"", ""},
+		{"plain text", "plain text"},
+		{"=SUM(A1:A2)", "'=SUM(A1:A2)"},
+		{"+cmd", "'+cmd"},
+		{"-cmd", "'-cmd"},
+		{"@SUM(A1)", "'@SUM(A1)"},
+		{"-5", "-5"},
+		{"+2.5", "+2.5"},
+		{"-", "'-"},
+		{"a=b", "a=b"},
+		{"'=x", "'=x"},
+	}
+
+	for _, tt := range tests {
+		if got := escapeFormula(tt.value); got != tt.want {
+			t.Errorf("escapeFormula(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestSafeTableWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := safeTableWriter{csvTableWriter{csv.NewWriter(&buf)}}
+	record := []string{"=1+1", "-3", "name"}
+	if err := w.Write(record); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"=1+1", "-3", "name"}; !reflect.DeepEqual(record, want) {
+		t.Errorf("Write changed its argument to %q", record)
+	}
+	if got, want := buf.String(), "'=1+1,-3,name\n"; got != want {
+		t.Errorf("wrote %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,124 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/johnson7543/ims/db"
+	"github.com/johnson7543/ims/types"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	// HeaderIdempotencyKey is the request header that makes a POST safe to retry.
+	HeaderIdempotencyKey = "Idempotency-Key"
+	// HeaderIdempotentReplayed marks a response replayed from an earlier request with the same key.
+	HeaderIdempotentReplayed = "Idempotent-Replayed"
+
+	// IdempotencyTTLEnvName sets how many hours a stored response is replayed for.
+	IdempotencyTTLEnvName = "IDEMPOTENCY_TTL_HOURS"
+
+	defaultIdempotencyTTLHours = 24
+	maxIdempotencyKeyLength    = 255
+)
+
+// IdempotencyTTL returns how long the responses to idempotent requests are kept.
+func IdempotencyTTL() time.Duration {
+	hours, err := strconv.Atoi(os.Getenv(IdempotencyTTLEnvName))
+	if err != nil || hours <= 0 {
+		hours = defaultIdempotencyTTLHours
+	}
+	return time.Duration(hours) * time.Hour
+}
+
+// Idempotency honors the Idempotency-Key header on POST requests. The first response to a key
+// is stored and replayed for retries, a retry with a different payload is refused with 422, and
+// a retry that arrives while the first request is still running is refused with 409. Failed
+// requests (5xx) release their key so they can be retried.
+func Idempotency(store db.IdempotencyStore) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		key := c.Get(HeaderIdempotencyKey)
+		if c.Method() != fiber.MethodPost || key == "" {
+			return c.Next()
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			return NewError(http.StatusBadRequest, "Idempotency-Key must be at most 255 characters")
+		}
+		user, err := getAuthUser(c)
+		if err != nil {
+			return ErrUnAuthorized()
+		}
+
+		record, started, err := store.BeginIdempotentRequest(c.Context(), &types.IdempotencyRecord{
+			ID:          user.ID.Hex() + ":" + key,
+			Key:         key,
+			Method:      c.Method(),
+			Path:        c.OriginalURL(),
+			RequestHash: requestHash(c),
+			CreatedAt:   time.Now(),
+		})
+		if err != nil {
+			return err
+		}
+		if !started {
+			return replay(c, record)
+		}
+
+		completed := false
+		defer func() {
+			if !completed {
+				// The handler panicked or the error could not be rendered.
+				if err := store.ReleaseIdempotencyKey(c.Context(), record.ID); err != nil {
+					log.Printf("request %s: release idempotency key: %v", requestID(c), err)
+				}
+			}
+		}()
+
+		if err := c.Next(); err != nil {
+			if err := c.App().Config().ErrorHandler(c, err); err != nil {
+				return err
+			}
+		}
+
+		resp := c.Response()
+		if resp.StatusCode() >= http.StatusInternalServerError {
+			return nil
+		}
+		body := append([]byte(nil), resp.Body()...)
+		if err := store.CompleteIdempotentRequest(c.Context(), record.ID, resp.StatusCode(), string(resp.Header.ContentType()), body); err != nil {
+			log.Printf("request %s: store idempotent response: %v", requestID(c), err)
+			return nil
+		}
+		completed = true
+		return nil
+	}
+}
+
+// replay answers a retry with the response stored for the first request with its key.
+func replay(c *fiber.Ctx, record *types.IdempotencyRecord) error {
+	if record.Method != c.Method() || record.Path != c.OriginalURL() || record.RequestHash != requestHash(c) {
+		return newProblem(http.StatusUnprocessableEntity, CodeIdempotencyKeyReused, "the Idempotency-Key was already used for a different request")
+	}
+	if !record.Completed {
+		return newProblem(http.StatusConflict, CodeIdempotencyInProgress, "a request with this Idempotency-Key is still being processed")
+	}
+
+	c.Set(HeaderIdempotentReplayed, "true")
+	c.Set(fiber.HeaderContentType, record.ContentType)
+	return c.Status(record.Status).Send(record.Body)
+}
+
+// requestHash fingerprints the payload of a request, so a key reused for a different request
+// is caught.
+func requestHash(c *fiber.Ctx) string {
+	h := sha256.New()
+	h.Write([]byte(c.Method() + " " + c.OriginalURL() + "\n"))
+	h.Write(c.Body())
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,203 @@
+package api
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/johnson7543/ims/types"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// memoryIdempotencyStore keeps idempotency records in a map, claiming keys the way the MongoDB
+// store does with its unique _id.
+type memoryIdempotencyStore struct {
+	mu      sync.Mutex
+	records map[string]types.IdempotencyRecord
+}
+
+func newMemoryIdempotencyStore() *memoryIdempotencyStore {
+	return &memoryIdempotencyStore{records: map[string]types.IdempotencyRecord{}}
+}
+
+func (s *memoryIdempotencyStore) EnsureIdempotencyTTL(context.Context, time.Duration) error {
+	return nil
+}
+
+func (s *memoryIdempotencyStore) BeginIdempotentRequest(_ context.Context, record *types.IdempotencyRecord) (*types.IdempotencyRecord, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if existing, ok := s.records[record.ID]; ok {
+		return &existing, false, nil
+	}
+	s.records[record.ID] = *record
+	return record, true, nil
+}
+
+func (s *memoryIdempotencyStore) CompleteIdempotentRequest(_ context.Context, id string, status int, contentType string, body []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	record := s.records[id]
+	record.Completed = true
+	record.Status = status
+	record.ContentType = contentType
+	record.Body = body
+	s.records[id] = record
+	return nil
+}
+
+func (s *memoryIdempotencyStore) ReleaseIdempotencyKey(_ context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.records, id)
+	return nil
+}
+
+type idempotencyTest struct {
+	store *memoryIdempotencyStore
+	app   *fiber.App
+	calls int
+}
+
+// newIdempotencyTest serves POST /orders, which answers 201 with the number of times it ran,
+// and POST /fail, which always fails with a 500.
+func newIdempotencyTest() *idempotencyTest {
+	it := &idempotencyTest{store: newMemoryIdempotencyStore()}
+	it.app = fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+	it.app.Use(func(c *fiber.Ctx) error {
+		id, err := primitive.ObjectIDFromHex(c.Get("X-Test-User"))
+		if err != nil {
+			return ErrUnAuthorized()
+		}
+		c.Context().SetUserValue("user", &types.User{ID: id})
+		return c.Next()
+	})
+	it.app.Use(Idempotency(it.store))
+	it.app.Post("/orders", func(c *fiber.Ctx) error {
+		it.calls++
+		return c.Status(fiber.StatusCreated).JSON(fiber.Map{"call": it.calls})
+	})
+	it.app.Post("/fail", func(c *fiber.Ctx) error {
+		it.calls++
+		return NewError(fiber.StatusInternalServerError, "boom")
+	})
+	return it
+}
+
+const (
+	aliceID = "64b7f0c2a1b2c3d4e5f60001"
+	bobID   = "64b7f0c2a1b2c3d4e5f60002"
+)
+
+func (it *idempotencyTest) post(t *testing.T, user, path, key, body string) (int, string, string) {
+	t.Helper()
+	req := httptest.NewRequest(fiber.MethodPost, path, strings.NewReader(body))
+	req.Header.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	req.Header.Set("X-Test-User", user)
+	if key != "" {
+		req.Header.Set(HeaderIdempotencyKey, key)
+	}
+	resp, err := it.app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, resp.Header.Get(HeaderIdempotentReplayed), string(data)
+}
+
+func TestIdempotencyReplaysTheFirstResponse(t *testing.T) {
+	it := newIdempotencyTest()
+
+	status, replayed, first := it.post(t, aliceID, "/orders", "k1", `{"a":1}`)
+	if status != fiber.StatusCreated || replayed != "" {
+		t.Fatalf("first request: status %d, replayed %q", status, replayed)
+	}
+	status, replayed, retry := it.post(t, aliceID, "/orders", "k1", `{"a":1}`)
+	if status != fiber.StatusCreated || replayed != "true" || retry != first {
+		t.Fatalf("retry: status %d, replayed %q, body %s, want 201, true, %s", status, replayed, retry, first)
+	}
+	if it.calls != 1 {
+		t.Errorf("handler ran %d times, want 1", it.calls)
+	}
+}
+
+func TestIdempotencyRunsRequestsThatDontShareAKey(t *testing.T) {
+	it := newIdempotencyTest()
+
+	it.post(t, aliceID, "/orders", "", `{"a":1}`)
+	it.post(t, aliceID, "/orders", "", `{"a":1}`)
+	it.post(t, aliceID, "/orders", "k1", `{"a":1}`)
+	it.post(t, bobID, "/orders", "k1", `{"a":1}`)
+	if it.calls != 4 {
+		t.Errorf("handler ran %d times, want 4", it.calls)
+	}
+}
+
+func TestIdempotencyRefusesAKeyReusedForAnotherRequest(t *testing.T) {
+	it := newIdempotencyTest()
+
+	it.post(t, aliceID, "/orders", "k1", `{"a":1}`)
+	if status, _, _ := it.post(t, aliceID, "/orders", "k1", `{"a":2}`); status != fiber.StatusUnprocessableEntity {
+		t.Errorf("different body: status %d, want 422", status)
+	}
+	if status, _, _ := it.post(t, aliceID, "/fail", "k1", `{"a":1}`); status != fiber.StatusUnprocessableEntity {
+		t.Errorf("different path: status %d, want 422", status)
+	}
+	if it.calls != 1 {
+		t.Errorf("handler ran %d times, want 1", it.calls)
+	}
+}
+
+func TestIdempotencyRefusesARetryOfARunningRequest(t *testing.T) {
+	it := newIdempotencyTest()
+	it.post(t, aliceID, "/orders", "k1", `{"a":1}`)
+
+	// Put the record back the way it is while the first request is still running.
+	record := it.store.records[aliceID+":k1"]
+	record.Completed = false
+	it.store.records[aliceID+":k1"] = record
+
+	if status, _, _ := it.post(t, aliceID, "/orders", "k1", `{"a":1}`); status != fiber.StatusConflict {
+		t.Errorf("status %d, want 409", status)
+	}
+	if it.calls != 1 {
+		t.Errorf("handler ran %d times, want 1", it.calls)
+	}
+}
+
+func TestIdempotencyReleasesTheKeyOfAFailedRequest(t *testing.T) {
+	it := newIdempotencyTest()
+
+	for i := 0; i < 2; i++ {
+		if status, replayed, _ := it.post(t, aliceID, "/fail", "k1", `{}`); status != fiber.StatusInternalServerError || replayed != "" {
+			t.Fatalf("attempt %d: status %d, replayed %q, want 500 and no replay", i+1, status, replayed)
+		}
+	}
+	if it.calls != 2 {
+		t.Errorf("handler ran %d times, want 2", it.calls)
+	}
+	if len(it.store.records) != 0 {
+		t.Errorf("%d records left, want none", len(it.store.records))
+	}
+}
+
+func TestIdempotencyRejectsLongKeys(t *testing.T) {
+	it := newIdempotencyTest()
+
+	if status, _, _ := it.post(t, aliceID, "/orders", strings.Repeat("k", maxIdempotencyKeyLength+1), `{}`); status != fiber.StatusBadRequest {
+		t.Errorf("status %d, want 400", status)
+	}
+	if it.calls != 0 {
+		t.Errorf("handler ran %d times, want 0", it.calls)
+	}
+}
@@ -0,0 +1,268 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/johnson7543/ims/db"
+	"github.com/johnson7543/ims/types"
+	"github.com/johnson7543/ims/xlsx"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// importSyncRowLimit is the largest file processed within the request. Bigger files are
+// imported in the background and followed through the job status endpoint.
+const importSyncRowLimit = 200
+
+type ImportHandler struct {
+	store *db.Store
+}
+
+func NewImportHandler(store *db.Store) *ImportHandler {
+	return &ImportHandler{
+		store: store,
+	}
+}
+
+// HandleImport imports products, materials or parties from a CSV or XLSX file.
+//
+// @Summary Import spreadsheet
+// @Description Imports a CSV or XLSX file. The first row holds column headers, matched to fields by name or through the mapping parameter.
+// @Description Products are upserted by SKU, parties by tax ID number, materials by ID or by name, color, type and size.
+// @Description Importing customers, sellers, buyers or workers adds that role to every imported party.
+// @Description Files over 200 rows are imported in the background; poll the returned job.
+// @Tags Import
+// @Accept multipart/form-data
+// @Produce json
+// @Param entity path string true "products, materials, parties, customers, sellers, buyers or workers"
+// @Param file formData file true "CSV or XLSX file"
+// @Param mapping formData string false "JSON object mapping column headers to field names"
+// @Param dryRun formData bool false "Validate every row without writing"
+// @Success 200 {object} types.ImportJob
+// @Success 202 {object} types.ImportJob
+// @Router /import/{entity} [post]
+func (h *ImportHandler) HandleImport(c *fiber.Ctx) error {
+	entity := c.Params("entity")
+	importer, ok := newEntityImporter(h.store, entity)
+	if !ok {
+		return NewError(fiber.StatusBadRequest, fmt.Sprintf("Unsupported import entity %q", entity))
+	}
+
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		return NewError(fiber.StatusBadRequest, "A file is required")
+	}
+
+	mapping := map[string]string{}
+	if raw := c.FormValue("mapping"); raw != "" {
+		if err := json.Unmarshal([]byte(raw), &mapping); err != nil {
+			return NewError(fiber.StatusBadRequest, "Invalid column mapping, expected a JSON object")
+		}
+	}
+
+	format := strings.ToLower(strings.TrimPrefix(filepath.Ext(fileHeader.Filename), "."))
+	if format != "csv" && format != "xlsx" {
+		return NewError(fiber.StatusBadRequest, "Only .csv and .xlsx files can be imported")
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return err
+	}
+
+	rows, unknown, err := readImportRows(data, format, mapping, importer.fields())
+	if err != nil {
+		return NewError(fiber.StatusBadRequest, err.Error())
+	}
+
+	job := &types.ImportJob{
+		Entity:    entity,
+		FileName:  fileHeader.Filename,
+		Format:    format,
+		DryRun:    c.FormValue("dryRun") == "true",
+		Mapping:   mapping,
+		Status:    types.ImportJobPending,
+		TotalRows: len(rows),
+		Errors:    []types.ImportRowError{},
+		CreatedAt: time.Now(),
+	}
+	if len(unknown) > 0 {
+		job.Message = "Ignored columns: " + strings.Join(unknown, ", ")
+	}
+	if user, err := getAuthUser(c); err == nil {
+		job.CreatedBy = user.ID
+	}
+
+	job, err = h.store.ImportJob.InsertImportJob(c.Context(), job)
+	if err != nil {
+		return err
+	}
+
+	if len(rows) > importSyncRowLimit {
+		// The response gets a copy taken before the import starts changing job.
+		accepted := *job
+		go h.runImport(context.Background(), job, importer, rows)
+		return c.Status(fiber.StatusAccepted).JSON(&accepted)
+	}
+
+	h.runImport(c.Context(), job, importer, rows)
+	return c.JSON(job)
+}
+
+// HandleGetImportJob returns the status of an import job.
+//
+// @Summary Get import job
+// @Description Returns the progress, counts and row errors of an import job.
+// @Tags Import
+// @Produce json
+// @Param id path string true "Import job ID"
+// @Success 200 {object} types.ImportJob
+// @Router /import/jobs/{id} [get]
+func (h *ImportHandler) HandleGetImportJob(c *fiber.Ctx) error {
+	jobID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return NewError(fiber.StatusBadRequest, "Invalid import job ID")
+	}
+
+	job, err := h.store.ImportJob.GetImportJob(c.Context(), jobID)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return NewError(fiber.StatusNotFound, "Import job not found")
+		}
+		return err
+	}
+
+	return c.JSON(job)
+}
+
+// runImport processes every row, recording created, updated and failed counts on the job.
+// A row with validation errors is skipped; a database error stops the import.
+func (h *ImportHandler) runImport(ctx context.Context, job *types.ImportJob, importer entityImporter, rows []*importRow) {
+	job.Status = types.ImportJobRunning
+	if err := h.store.ImportJob.UpdateImportJob(ctx, job); err != nil {
+		log.Printf("import job %s: %v", job.ID.Hex(), err)
+	}
+
+	job.Status = types.ImportJobCompleted
+	for _, row := range rows {
+		created, err := importer.importRow(ctx, row, job.DryRun)
+		if err != nil {
+			job.Status = types.ImportJobFailed
+			job.Message = fmt.Sprintf("Row %d: %s", row.num, err.Error())
+			break
+		}
+
+		switch {
+		case len(row.errs) > 0:
+			job.Failed++
+			job.Errors = append(job.Errors, row.errs...)
+		case created:
+			job.Created++
+		default:
+			job.Updated++
+		}
+	}
+	job.FinishedAt = time.Now()
+
+	if err := h.store.ImportJob.UpdateImportJob(ctx, job); err != nil {
+		log.Printf("import job %s: %v", job.ID.Hex(), err)
+	}
+}
+
+type recordReader interface {
+	Read() ([]string, error)
+}
+
+// readImportRows maps the columns of the file onto entity fields. A column maps to a field when
+// the mapping names it, or when its header matches the field name ignoring case, spaces and
+// underscores. Columns that match nothing are returned so the caller can report them.
+func readImportRows(data []byte, format string, mapping map[string]string, fields []string) ([]*importRow, []string, error) {
+	var reader recordReader
+	switch format {
+	case "csv":
+		r := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))))
+		r.FieldsPerRecord = -1
+		reader = r
+	case "xlsx":
+		r, err := xlsx.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return nil, nil, fmt.Errorf("Unable to read the spreadsheet: %s", err.Error())
+		}
+		defer r.Close()
+		reader = r
+	}
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, nil, fmt.Errorf("The file has no header row")
+	}
+
+	known := map[string]string{}
+	for _, f := range fields {
+		known[normalizeColumn(f)] = f
+	}
+
+	columns := make([]string, len(header))
+	unknown := []string{}
+	for i, h := range header {
+		name := strings.TrimSpace(h)
+		if mapped, ok := mapping[name]; ok {
+			name = mapped
+		}
+		if field, ok := known[normalizeColumn(name)]; ok {
+			columns[i] = field
+		} else if name != "" {
+			unknown = append(unknown, strings.TrimSpace(h))
+		}
+	}
+
+	rows := []*importRow{}
+	for num := 2; ; num++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("Unable to read row %d: %s", num, err.Error())
+		}
+
+		row := &importRow{num: num, values: map[string]string{}}
+		blank := true
+		for i, value := range record {
+			if i >= len(columns) || columns[i] == "" {
+				continue
+			}
+			row.values[columns[i]] = value
+			if strings.TrimSpace(value) != "" {
+				blank = false
+			}
+		}
+		if !blank {
+			rows = append(rows, row)
+		}
+	}
+
+	return rows, unknown, nil
+}
+
+func normalizeColumn(s string) string {
+	s = strings.ToLower(strings.TrimSpace(s))
+	return strings.NewReplacer(" ", "", "_", "", "-", "").Replace(s)
+}
@@ -0,0 +1,449 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/johnson7543/ims/db"
+	"github.com/johnson7543/ims/types"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// importRow is one spreadsheet row with its cells keyed by entity field name.
+type importRow struct {
+	num    int
+	values map[string]string
+	errs   []types.ImportRowError
+}
+
+func (r *importRow) has(field string) bool {
+	_, ok := r.values[field]
+	return ok
+}
+
+func (r *importRow) str(field string) string {
+	return strings.TrimSpace(r.values[field])
+}
+
+func (r *importRow) fail(field, format string, args ...any) {
+	r.errs = append(r.errs, types.ImportRowError{
+		Row:     r.num,
+		Field:   field,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+func (r *importRow) required(field string) string {
+	v := r.str(field)
+	if v == "" {
+		r.fail(field, "%s is required", field)
+	}
+	return v
+}
+
+func (r *importRow) int(field string) int {
+	v := r.str(field)
+	if v == "" {
+		return 0
+	}
+	// Spreadsheets store every number as a float, so accept "12.0" for integer columns.
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || f != float64(int(f)) {
+		r.fail(field, "%s must be a whole number", field)
+		return 0
+	}
+	if f < 0 {
+		r.fail(field, "%s cannot be negative", field)
+		return 0
+	}
+	return int(f)
+}
+
+func (r *importRow) float(field string) float64 {
+	v := r.str(field)
+	if v == "" {
+		return 0
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		r.fail(field, "%s must be a number", field)
+		return 0
+	}
+	if f < 0 {
+		r.fail(field, "%s cannot be negative", field)
+		return 0
+	}
+	return f
+}
+
+func (r *importRow) date(field string) time.Time {
+	v := r.str(field)
+	if v == "" {
+		return time.Time{}
+	}
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02", "2006/01/02"} {
+		if t, err := time.Parse(layout, v); err == nil {
+			return t
+		}
+	}
+	r.fail(field, "%s must be a date formatted as YYYY-MM-DD or RFC3339", field)
+	return time.Time{}
+}
+
+func (r *importRow) objectID(field string) primitive.ObjectID {
+	v := r.str(field)
+	if v == "" {
+		return primitive.NilObjectID
+	}
+	id, err := primitive.ObjectIDFromHex(v)
+	if err != nil {
+		r.fail(field, "%s is not a valid ID", field)
+	}
+	return id
+}
+
+// entityImporter validates a row and creates or updates the record it describes.
+// With dryRun set it only reports what it would do. A dry run keeps the records it would have
+// written, so a later row for the same record counts as an update as it would for real.
+type entityImporter interface {
+	fields() []string
+	importRow(ctx context.Context, row *importRow, dryRun bool) (created bool, err error)
+}
+
+func newEntityImporter(store *db.Store, entity string) (entityImporter, bool) {
+	switch entity {
+	case "products":
+		return &productImporter{store: store, planned: map[string]*types.Product{}}, true
+	case "materials":
+		return &materialImporter{store: store, planned: map[string]*types.Material{}}, true
+	case "parties":
+		return &partyImporter{store: store, planned: map[string]*types.Party{}}, true
+	case "customers":
+		return &partyImporter{store: store, role: types.RoleCustomer, planned: map[string]*types.Party{}}, true
+	case "sellers":
+		return &partyImporter{store: store, role: types.RoleSeller, planned: map[string]*types.Party{}}, true
+	case "buyers":
+		return &partyImporter{store: store, role: types.RoleBuyer, planned: map[string]*types.Party{}}, true
+	case "workers":
+		return &partyImporter{store: store, role: types.RoleWorker, planned: map[string]*types.Party{}}, true
+	}
+	return nil, false
+}
+
+// productImporter upserts products by SKU.
+type productImporter struct {
+	store *db.Store
+	// planned holds the products a dry run would have written, by SKU.
+	planned map[string]*types.Product
+}
+
+func (i *productImporter) fields() []string {
+	return []string{"sku", "name", "material", "color", "type", "size", "quantity", "price", "date", "remark"}
+}
+
+func (i *productImporter) importRow(ctx context.Context, row *importRow, dryRun bool) (bool, error) {
+	sku := row.required("sku")
+	quantity := row.int("quantity")
+	price := row.float("price")
+	date := row.date("date")
+	if len(row.errs) > 0 {
+		return false, nil
+	}
+
+	existing, err := i.store.Product.GetProducts(ctx, bson.M{"sku": bson.M{"$eq": sku}})
+	if err != nil {
+		return false, err
+	}
+	if planned, ok := i.planned[sku]; ok {
+		product := *planned
+		existing = []*types.Product{&product}
+	}
+
+	product := &types.Product{SKU: sku}
+	if len(existing) > 0 {
+		product = existing[0]
+	} else if row.str("name") == "" {
+		row.fail("name", "name is required for a new product")
+		return false, nil
+	}
+
+	before := *product
+	setString(row, "name", &product.Name)
+	setString(row, "material", &product.Material)
+	setString(row, "color", &product.Color)
+	setString(row, "type", &product.Type)
+	setString(row, "size", &product.Size)
+	setString(row, "remark", &product.Remark)
+	if ok, err := checkRowLookups(ctx, i.store.Lookup, row,
+		lookupValue{field: "color", kind: types.LookupColor, value: &product.Color, current: before.Color},
+		lookupValue{field: "type", kind: types.LookupType, value: &product.Type, current: before.Type},
+		lookupValue{field: "size", kind: types.LookupSize, value: &product.Size, current: before.Size},
+	); !ok {
+		return false, err
+	}
+	if row.has("quantity") && quantity != product.Quantity {
+		if len(existing) > 0 {
+			row.fail("quantity", "%s", readOnlyQuantity)
+			return false, nil
+		}
+		product.Quantity = quantity
+	}
+	if row.has("price") {
+		product.Price = price
+	}
+	if row.has("date") {
+		product.Date = date
+	}
+
+	if dryRun {
+		i.planned[sku] = product
+		return len(existing) == 0, nil
+	}
+	if len(existing) > 0 {
+		_, err = i.store.Product.UpdateProduct(ctx, product.ID, product, product.Version)
+		return false, err
+	}
+	_, err = i.store.Product.InsertProduct(ctx, product)
+	return true, err
+}
+
+// materialImporter upserts materials by id, or by name, color, type and size when no id is given.
+type materialImporter struct {
+	store *db.Store
+	// planned holds the materials a dry run would have written, by materialKey.
+	planned map[string]*types.Material
+}
+
+func (i *materialImporter) fields() []string {
+	return []string{"id", "name", "color", "type", "size", "unit", "quantity", "price", "remarks"}
+}
+
+func (i *materialImporter) importRow(ctx context.Context, row *importRow, dryRun bool) (bool, error) {
+	id := row.objectID("id")
+	quantity := row.float("quantity")
+	price := row.float("price")
+	if id.IsZero() {
+		row.required("name")
+	}
+	if len(row.errs) > 0 {
+		return false, nil
+	}
+
+	filter := bson.M{"_id": id}
+	if id.IsZero() {
+		filter = bson.M{
+			"name":  bson.M{"$eq": row.str("name")},
+			"color": bson.M{"$eq": row.str("color")},
+			"type":  bson.M{"$eq": row.str("type")},
+			"size":  bson.M{"$eq": row.str("size")},
+		}
+	}
+
+	existing, err := i.store.Material.GetMaterials(ctx, filter)
+	if err != nil {
+		return false, err
+	}
+	key := materialKey(id, row)
+	if planned, ok := i.planned[key]; ok {
+		material := *planned
+		existing = []*types.Material{&material}
+	}
+	if !id.IsZero() && len(existing) == 0 {
+		row.fail("id", "material %s does not exist", id.Hex())
+		return false, nil
+	}
+
+	material := &types.Material{PriceHistory: []types.PriceHistoryEntry{}}
+	if len(existing) > 0 {
+		material = existing[0]
+	}
+
+	before := *material
+	setString(row, "name", &material.Name)
+	setString(row, "color", &material.Color)
+	setString(row, "type", &material.Type)
+	setString(row, "size", &material.Size)
+	setString(row, "unit", &material.Unit)
+	setString(row, "remarks", &material.Remarks)
+	if ok, err := checkRowLookups(ctx, i.store.Lookup, row,
+		lookupValue{field: "color", kind: types.LookupColor, value: &material.Color, current: before.Color},
+		lookupValue{field: "type", kind: types.LookupType, value: &material.Type, current: before.Type},
+		lookupValue{field: "size", kind: types.LookupSize, value: &material.Size, current: before.Size},
+		lookupValue{field: "unit", kind: types.LookupUnit, value: &material.Unit, current: before.Unit},
+	); !ok {
+		return false, err
+	}
+	if material.Unit != before.Unit && before.Quantity != 0 {
+		row.fail("unit", "the base unit cannot change from %s while the material has %g in stock", before.Unit, before.Quantity)
+		return false, nil
+	}
+	for _, u := range material.Units {
+		if u.Unit == material.Unit {
+			row.fail("unit", "%s is one of the material's other units, not a base unit", material.Unit)
+			return false, nil
+		}
+	}
+	if row.has("quantity") && quantity != material.Quantity {
+		if len(existing) > 0 {
+			row.fail("quantity", "%s", readOnlyQuantity)
+			return false, nil
+		}
+		material.Quantity = quantity
+	}
+	var prices []types.PriceHistoryEntry
+	if row.str("price") != "" && price != latestPrice(material.PriceHistory) {
+		prices = append(prices, types.PriceHistoryEntry{
+			Price:     price,
+			UpdatedAt: time.Now(),
+		})
+	}
+
+	if dryRun {
+		i.planned[key] = material
+		return len(existing) == 0, nil
+	}
+	if len(existing) > 0 {
+		// Only the new price is sent; the store appends it to the recorded history.
+		material.PriceHistory = prices
+		_, err = i.store.Material.UpdateMaterial(ctx, material.ID, material, material.Version)
+		return false, err
+	}
+	material.PriceHistory = append(material.PriceHistory, prices...)
+	_, err = i.store.Material.InsertMaterial(ctx, material)
+	return true, err
+}
+
+// materialKey names the material a row refers to, by its id or else by its name, color, type
+// and size.
+func materialKey(id primitive.ObjectID, row *importRow) string {
+	if !id.IsZero() {
+		return id.Hex()
+	}
+	return strings.Join([]string{row.str("name"), row.str("color"), row.str("type"), row.str("size")}, "\x00")
+}
+
+// partyImporter upserts parties by tax ID number. Importing customers, sellers, buyers or
+// workers gives every imported party that role, keeping the roles it already holds; importing
+// parties reads the roles from a comma separated roles column.
+type partyImporter struct {
+	store *db.Store
+	role  string
+	// planned holds the parties a dry run would have written, by tax ID number.
+	planned map[string]*types.Party
+}
+
+func (i *partyImporter) fields() []string {
+	fields := []string{"company", "name", "phone", "address", "taxIdNumber"}
+	if i.role == "" {
+		fields = append(fields, "roles")
+	}
+	return fields
+}
+
+func (i *partyImporter) importRow(ctx context.Context, row *importRow, dryRun bool) (bool, error) {
+	taxID := row.str("taxIdNumber")
+
+	var roles []string
+	if i.role == "" {
+		for _, role := range strings.Split(row.str("roles"), ",") {
+			if role = strings.ToLower(strings.TrimSpace(role)); role == "" {
+				continue
+			}
+			if !types.IsValidPartyRole(role) {
+				row.fail("roles", "%s is not a valid role", role)
+				continue
+			}
+			roles = append(roles, role)
+		}
+	}
+
+	if taxID != "" && !types.IsValidTaxIdNumber(taxID) {
+		row.fail("taxIdNumber", "%s is not a valid unified business number", taxID)
+	}
+
+	party := &types.Party{Roles: []string{}, Contacts: []types.PartyContact{}, Addresses: []types.PartyAddress{}}
+	found := false
+	if taxID != "" && len(row.errs) == 0 {
+		existing, err := i.store.Party.GetParties(ctx, bson.M{"taxIdNumber": bson.M{"$eq": taxID}})
+		if err != nil {
+			return false, err
+		}
+		if len(existing) > 0 {
+			party = existing[0]
+			found = true
+		}
+		if planned, ok := i.planned[taxID]; ok {
+			copied := *planned
+			party, found = &copied, true
+		}
+	}
+
+	if !found && row.str("name") == "" && row.str("company") == "" {
+		row.fail("name", "name or company is required")
+	}
+	if !found && i.role == "" && len(roles) == 0 {
+		row.fail("roles", "roles is required for a new party")
+	}
+	if len(row.errs) > 0 {
+		return false, nil
+	}
+
+	setString(row, "company", &party.Company)
+	setString(row, "name", &party.Name)
+	setString(row, "phone", &party.Phone)
+	setString(row, "address", &party.Address)
+	setString(row, "taxIdNumber", &party.TaxIdNumber)
+	if i.role != "" && !party.HasRole(i.role) {
+		party.Roles = append(party.Roles, i.role)
+	}
+	if len(roles) > 0 {
+		party.Roles = roles
+	}
+
+	if dryRun {
+		if taxID != "" {
+			i.planned[taxID] = party
+		}
+		return !found, nil
+	}
+	if found {
+		_, err := i.store.Party.UpdateParty(ctx, party.ID, party, party.Version)
+		return false, err
+	}
+	_, err := i.store.Party.InsertParty(ctx, party)
+	return true, err
+}
+
+// checkRowLookups checks the lookup values of a row like checkLookups, reporting the values
+// naming no active lookup as row errors.
+func checkRowLookups(ctx context.Context, lookups db.LookupStore, row *importRow, values ...lookupValue) (bool, error) {
+	err := checkLookups(ctx, lookups, values...)
+	var fields ValidationError
+	if !errors.As(err, &fields) {
+		return err == nil, err
+	}
+
+	names := make([]string, 0, len(fields))
+	for field := range fields {
+		names = append(names, field)
+	}
+	sort.Strings(names)
+	for _, field := range names {
+		row.fail(field, "%s", fields[field])
+	}
+	return false, nil
+}
+
+// setString overwrites dst only when the spreadsheet has a column for the field.
+func setString(row *importRow, field string, dst *string) {
+	if row.has(field) {
+		*dst = row.str(field)
+	}
+}
@@ -0,0 +1,420 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/johnson7543/ims/barcode"
+	"github.com/johnson7543/ims/db"
+	"github.com/johnson7543/ims/pdf"
+	"github.com/johnson7543/ims/types"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	mimePDF = "application/pdf"
+	mimeSVG = "image/svg+xml"
+)
+
+// Label sheets are A4 with three columns of eight 70 x 37 mm labels, the common layout of
+// self-adhesive label paper.
+const (
+	labelColumns = 3
+	labelRows    = 8
+	labelWidth   = pdf.A4Width / labelColumns
+	labelHeight  = pdf.A4Height / labelRows
+	labelPadding = 8.0
+	// maxLabels keeps a single request from producing an unreasonably large document.
+	maxLabels = 2400
+)
+
+type PrintLabelsParams struct {
+	Items []LabelItemParams `json:"items" validate:"required"`
+	// Symbology is code128 or qr, code128 when left out.
+	Symbology string `json:"symbology" validate:"enumfold=symbology"`
+	// Skip leaves the first labels of the sheet blank, so a partly used sheet can be reused.
+	Skip int `json:"skip" validate:"min=0,max=23"`
+}
+
+type LabelItemParams struct {
+	Kind string `json:"kind" validate:"required,enum=labelKind"`
+	ID   string `json:"id" validate:"required,objectid"`
+	// Copies is how many labels to print, one when left out.
+	Copies int `json:"copies" validate:"min=0"`
+}
+
+// copies is how many labels of the item to print.
+func (p LabelItemParams) copies() int {
+	if p.Copies < 1 {
+		return 1
+	}
+	return p.Copies
+}
+
+func (p PrintLabelsParams) validate() error {
+	errs := fieldErrors(p)
+	total := 0
+	for _, item := range p.Items {
+		total += item.copies()
+	}
+	if total > maxLabels {
+		errs["items"] = fmt.Sprintf("cannot print more than %d labels at once", maxLabels)
+	}
+	return errs.err()
+}
+
+// label is what one printed label shows.
+type label struct {
+	title string
+	code  string
+}
+
+type LabelHandler struct {
+	store *db.Store
+}
+
+func NewLabelHandler(store *db.Store) *LabelHandler {
+	return &LabelHandler{
+		store: store,
+	}
+}
+
+// HandleGetProductBarcode renders the barcode of a product.
+//
+// @Summary Get product barcode
+// @Description Renders the product's SKU, or its ID when it has no SKU, as a Code 128 or QR barcode.
+// @Tags Label
+// @Produce png
+// @Produce image/svg+xml
+// @Param id path string true "Product ID"
+// @Param symbology query string false "code128 (default) or qr"
+// @Param format query string false "png (default) or svg"
+// @Param scale query int false "Size of a module in pixels, 2 by default"
+// @Param height query int false "Bar height of Code 128 in modules, 50 by default"
+// @Success 200 {file} binary
+// @Router /product/{id}/barcode [get]
+func (h *LabelHandler) HandleGetProductBarcode(c *fiber.Ctx) error {
+	product, err := findRecord(c, "Product", h.store.Product.GetProduct)
+	if err != nil {
+		return err
+	}
+	return sendBarcode(c, productLabel(product).code)
+}
+
+// HandleGetMaterialBarcode renders the barcode of a material.
+//
+// @Summary Get material barcode
+// @Description Renders the material's ID as a Code 128 or QR barcode.
+// @Tags Label
+// @Produce png
+// @Produce image/svg+xml
+// @Param id path string true "Material ID"
+// @Param symbology query string false "code128 (default) or qr"
+// @Param format query string false "png (default) or svg"
+// @Param scale query int false "Size of a module in pixels, 2 by default"
+// @Param height query int false "Bar height of Code 128 in modules, 50 by default"
+// @Success 200 {file} binary
+// @Router /material/{id}/barcode [get]
+func (h *LabelHandler) HandleGetMaterialBarcode(c *fiber.Ctx) error {
+	material, err := findRecord(c, "Material", h.store.Material.GetMaterial)
+	if err != nil {
+		return err
+	}
+	return sendBarcode(c, materialLabel(material).code)
+}
+
+// HandleGetOrderBarcode renders the barcode of an order.
+//
+// @Summary Get order barcode
+// @Description Renders the order's ID as a Code 128 or QR barcode.
+// @Tags Label
+// @Produce png
+// @Produce image/svg+xml
+// @Param id path string true "Order ID"
+// @Param symbology query string false "code128 (default) or qr"
+// @Param format query string false "png (default) or svg"
+// @Param scale query int false "Size of a module in pixels, 2 by default"
+// @Param height query int false "Bar height of Code 128 in modules, 50 by default"
+// @Success 200 {file} binary
+// @Router /order/{id}/barcode [get]
+func (h *LabelHandler) HandleGetOrderBarcode(c *fiber.Ctx) error {
+	order, err := findRecord(c, "Order", h.store.Order.GetOrder)
+	if err != nil {
+		return err
+	}
+	return sendBarcode(c, orderLabel(order).code)
+}
+
+// HandlePrintLabels renders a sheet of labels as PDF.
+//
+// @Summary Print labels
+// @Description Renders labels for products, materials and orders as an A4 PDF of 3 x 8 labels, each with the name and a Code 128 or QR barcode of the code the scan endpoint resolves. Names are printed in Latin characters only.
+// @Tags Label
+// @Accept json
+// @Produce application/pdf
+// @Param body body PrintLabelsParams true "Labels to print"
+// @Success 200 {file} binary
+// @Router /label [post]
+func (h *LabelHandler) HandlePrintLabels(c *fiber.Ctx) error {
+	var params PrintLabelsParams
+	if err := c.BodyParser(&params); err != nil {
+		return err
+	}
+
+	if err := params.validate(); err != nil {
+		return err
+	}
+
+	symbology := strings.ToLower(params.Symbology)
+	labels := []label{}
+	for i, item := range params.Items {
+		l, err := h.label(c.Context(), item)
+		if err != nil {
+			return err
+		}
+		if l == nil {
+			return ValidationError{fmt.Sprintf("items[%d].id", i): fmt.Sprintf("%s %s does not exist", item.Kind, item.ID)}
+		}
+		for n := 0; n < item.copies(); n++ {
+			labels = append(labels, *l)
+		}
+	}
+
+	doc := pdf.New(pdf.A4Width, pdf.A4Height)
+	var page *pdf.Page
+	for i, l := range labels {
+		slot := (i + params.Skip) % (labelColumns * labelRows)
+		if page == nil || slot == 0 {
+			page = doc.AddPage()
+		}
+		x := float64(slot%labelColumns) * labelWidth
+		y := float64(slot/labelColumns) * labelHeight
+		if err := drawLabel(page, x, y, l, symbology); err != nil {
+			return err
+		}
+	}
+
+	var buf bytes.Buffer
+	if _, err := doc.WriteTo(&buf); err != nil {
+		return err
+	}
+
+	c.Set(fiber.HeaderContentType, mimePDF)
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`inline; filename="labels_%s.pdf"`, time.Now().Format("20060102")))
+	return c.Send(buf.Bytes())
+}
+
+// label looks up what the label of a record shows. It returns nil when the record does not
+// exist.
+func (h *LabelHandler) label(ctx context.Context, item LabelItemParams) (*label, error) {
+	objID, err := primitive.ObjectIDFromHex(item.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	var l label
+	switch item.Kind {
+	case types.LabelProduct:
+		var product *types.Product
+		if product, err = h.store.Product.GetProduct(ctx, objID); err == nil {
+			l = productLabel(product)
+		}
+	case types.LabelMaterial:
+		var material *types.Material
+		if material, err = h.store.Material.GetMaterial(ctx, objID); err == nil {
+			l = materialLabel(material)
+		}
+	case types.LabelOrder:
+		var order *types.Order
+		if order, err = h.store.Order.GetOrder(ctx, objID); err == nil {
+			l = orderLabel(order)
+		}
+	}
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+// HandleScan resolves a scanned code.
+//
+// @Summary Scan a code
+// @Description Resolves a scanned barcode to the record it identifies: a product by SKU, or a product, material or order by ID.
+// @Tags Label
+// @Produce json
+// @Param code path string true "Scanned code"
+// @Success 200 {object} types.ScanResult
+// @Router /scan/{code} [get]
+func (h *LabelHandler) HandleScan(c *fiber.Ctx) error {
+	code := strings.TrimSpace(c.Params("code"))
+	if code == "" {
+		return NewError(fiber.StatusBadRequest, "Code is required")
+	}
+
+	products, err := h.store.Product.GetProducts(c.Context(), bson.M{"sku": bson.M{"$eq": code}})
+	if err != nil {
+		return err
+	}
+	if len(products) > 0 {
+		return c.JSON(types.ScanResult{Code: code, Kind: types.LabelProduct, ID: products[0].ID, Record: products[0]})
+	}
+
+	objID, err := primitive.ObjectIDFromHex(code)
+	if err != nil {
+		return NewError(fiber.StatusNotFound, "No record matches the code")
+	}
+
+	result := types.ScanResult{Code: code, ID: objID}
+	if result.Record, err = h.store.Product.GetProduct(c.Context(), objID); err == nil {
+		result.Kind = types.LabelProduct
+	} else if !errors.Is(err, mongo.ErrNoDocuments) {
+		return err
+	} else if result.Record, err = h.store.Material.GetMaterial(c.Context(), objID); err == nil {
+		result.Kind = types.LabelMaterial
+	} else if !errors.Is(err, mongo.ErrNoDocuments) {
+		return err
+	} else if result.Record, err = h.store.Order.GetOrder(c.Context(), objID); err == nil {
+		result.Kind = types.LabelOrder
+	} else if !errors.Is(err, mongo.ErrNoDocuments) {
+		return err
+	} else {
+		return NewError(fiber.StatusNotFound, "No record matches the code")
+	}
+
+	return c.JSON(result)
+}
+
+func productLabel(p *types.Product) label {
+	code := p.SKU
+	if code == "" {
+		code = p.ID.Hex()
+	}
+	title := p.Name
+	for _, attr := range []string{p.Color, p.Size} {
+		if attr != "" {
+			title += " " + attr
+		}
+	}
+	return label{title: title, code: code}
+}
+
+func materialLabel(m *types.Material) label {
+	title := m.Name
+	for _, attr := range []string{m.Color, m.Size} {
+		if attr != "" {
+			title += " " + attr
+		}
+	}
+	return label{title: title, code: m.ID.Hex()}
+}
+
+func orderLabel(o *types.Order) label {
+	return label{title: o.CustomerName, code: o.ID.Hex()}
+}
+
+// encodeBarcode encodes value in the symbology, Code 128 when it is empty.
+func encodeBarcode(symbology, value string) (*barcode.Symbol, error) {
+	switch strings.ToLower(symbology) {
+	case "", types.SymbologyCode128:
+		return barcode.Code128(value)
+	case types.SymbologyQR:
+		return barcode.QR(value)
+	}
+	return nil, ValidationError{"symbology": fmt.Sprintf("should be one of %s", strings.Join(types.Symbologies, ", "))}
+}
+
+// sendBarcode answers with value rendered as the symbology, format and size the query asks for.
+func sendBarcode(c *fiber.Ctx, value string) error {
+	symbol, err := encodeBarcode(c.Query("symbology"), value)
+	if err != nil {
+		return err
+	}
+
+	opts := barcode.Options{Scale: c.QueryInt("scale"), BarHeight: c.QueryInt("height")}
+	if opts.Scale > 20 || opts.BarHeight > 500 {
+		return NewError(fiber.StatusBadRequest, "Barcode size is too large")
+	}
+
+	var buf bytes.Buffer
+	switch strings.ToLower(c.Query("format", "png")) {
+	case "png":
+		c.Set(fiber.HeaderContentType, "image/png")
+		err = barcode.WritePNG(&buf, symbol, opts)
+	case "svg":
+		c.Set(fiber.HeaderContentType, mimeSVG)
+		err = barcode.WriteSVG(&buf, symbol, opts)
+	default:
+		return NewError(fiber.StatusBadRequest, "Format should be png or svg")
+	}
+	if err != nil {
+		return err
+	}
+
+	return c.Send(buf.Bytes())
+}
+
+// drawLabel draws a label with its top left corner at x, y. Code 128 labels have the title
+// above the bars and the code below; QR labels have the symbol on the left and the text beside
+// it.
+func drawLabel(page *pdf.Page, x, y float64, l label, symbology string) error {
+	symbol, err := encodeBarcode(symbology, l.code)
+	if err != nil {
+		return err
+	}
+
+	const titleSize, codeSize = 9.0, 7.0
+	x, y = x+labelPadding, y+labelPadding
+	width, height := labelWidth-2*labelPadding, labelHeight-2*labelPadding
+
+	if symbol.Linear() {
+		page.Text(x, y+titleSize, titleSize, pdf.Truncate(titleSize, width, l.title))
+		module := width / float64(symbol.Width+2*symbol.Quiet)
+		barTop := y + titleSize + 4
+		barHeight := height - titleSize - codeSize - 8
+		drawSymbol(page, symbol, x+float64(symbol.Quiet)*module, barTop, module, barHeight)
+		page.Text(x+float64(symbol.Quiet)*module, y+height, codeSize, l.code)
+		return nil
+	}
+
+	module := height / float64(symbol.Width+2*symbol.Quiet)
+	side := module * float64(symbol.Width+2*symbol.Quiet)
+	drawSymbol(page, symbol, x+float64(symbol.Quiet)*module, y+float64(symbol.Quiet)*module, module, 0)
+	textX, textWidth := x+side+4, width-side-4
+	page.Text(textX, y+titleSize+4, titleSize, pdf.Truncate(titleSize, textWidth, l.title))
+	page.Text(textX, y+titleSize+codeSize+10, codeSize, pdf.Truncate(codeSize, textWidth, l.code))
+	return nil
+}
+
+// drawSymbol draws the dark modules of a symbol with its top left module at x, y. The bars of
+// linear codes are barHeight high.
+func drawSymbol(page *pdf.Page, s *barcode.Symbol, x, y, module, barHeight float64) {
+	for row := 0; row < s.Height; row++ {
+		for col := 0; col < s.Width; {
+			if !s.Dark(col, row) {
+				col++
+				continue
+			}
+			run := 1
+			for col+run < s.Width && s.Dark(col+run, row) {
+				run++
+			}
+			h := module
+			if s.Linear() {
+				h = barHeight
+			}
+			page.Rect(x+float64(col)*module, y+float64(row)*module, float64(run)*module, h)
+			col += run
+		}
+	}
+}
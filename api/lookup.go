@@ -0,0 +1,85 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/johnson7543/ims/db"
+	"github.com/johnson7543/ims/types"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// lookupValue is a request field whose value has to be one of the lookups of kind.
+type lookupValue struct {
+	field string
+	kind  string
+	value *string
+	// current is the value the record holds already. It is accepted as it is, so records
+	// written before the kind was curated can still be edited.
+	current string
+}
+
+// listLookups makes a lookupValue of every element of a list field.
+func listLookups(field, kind string, values, current []string) []lookupValue {
+	list := make([]lookupValue, len(values))
+	for i := range values {
+		list[i] = lookupValue{field: fmt.Sprintf("%s[%d]", field, i), kind: kind, value: &values[i]}
+		if contains(current, values[i], false) {
+			list[i].current = values[i]
+		}
+	}
+	return list
+}
+
+// checkLookups replaces every value with the code of the lookup it names by code or name,
+// ignoring case, and returns a ValidationError for the values naming no active lookup. Kinds
+// without any lookups are not curated yet and take any value.
+func checkLookups(ctx context.Context, store db.LookupStore, values ...lookupValue) error {
+	byKind := map[string][]*types.Lookup{}
+	errs := ValidationError{}
+	for _, v := range values {
+		if *v.value == "" || *v.value == v.current {
+			continue
+		}
+
+		lookups, ok := byKind[v.kind]
+		if !ok {
+			var err error
+			if lookups, err = store.GetLookups(ctx, bson.M{"kind": v.kind}); err != nil {
+				return err
+			}
+			byKind[v.kind] = lookups
+		}
+		if len(lookups) == 0 {
+			continue
+		}
+
+		lookup := findLookup(lookups, *v.value)
+		switch {
+		case lookup == nil:
+			errs[v.field] = fmt.Sprintf("%q is not a known %s", *v.value, v.kind)
+		case !lookup.Active:
+			errs[v.field] = fmt.Sprintf("%s %s is no longer in use", v.kind, lookup.Code)
+		default:
+			*v.value = lookup.Code
+		}
+	}
+	return errs.err()
+}
+
+// findLookup returns the lookup named by value, preferring a match on the code.
+func findLookup(lookups []*types.Lookup, value string) *types.Lookup {
+	for _, l := range lookups {
+		if strings.EqualFold(l.Code, value) {
+			return l
+		}
+	}
+	for _, l := range lookups {
+		if l.Matches(value) {
+			return l
+		}
+	}
+	return nil
+}
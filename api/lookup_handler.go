@@ -0,0 +1,410 @@
+package api
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/johnson7543/ims/db"
+	"github.com/johnson7543/ims/types"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type InsertLookupParams struct {
+	Code      string `json:"code" validate:"required"`
+	NameZh    string `json:"nameZh"`
+	NameEn    string `json:"nameEn"`
+	SortOrder int    `json:"sortOrder"`
+	// Active defaults to true.
+	Active *bool `json:"active"`
+}
+
+func (p InsertLookupParams) validate() error {
+	return validateStruct(p)
+}
+
+type UpdateLookupParams struct {
+	Code      string `json:"code" validate:"required"`
+	NameZh    string `json:"nameZh"`
+	NameEn    string `json:"nameEn"`
+	SortOrder int    `json:"sortOrder"`
+	Active    bool   `json:"active"`
+}
+
+func (p *UpdateLookupParams) validate() error {
+	return validateStruct(p)
+}
+
+type MergeLookupParams struct {
+	// From lists the values to fold into To, as they are stored on records.
+	From []string `json:"from" validate:"required"`
+	To   string   `json:"to" validate:"required"`
+}
+
+func (p MergeLookupParams) validate() error {
+	return validateStruct(p)
+}
+
+type LookupHandler struct {
+	store *db.Store
+}
+
+func NewLookupHandler(store *db.Store) *LookupHandler {
+	return &LookupHandler{
+		store: store,
+	}
+}
+
+// lookupKind reads the kind path parameter.
+func lookupKind(c *fiber.Ctx) (string, error) {
+	kind := c.Params("kind")
+	if !contains(types.LookupKinds, kind, false) {
+		return "", NewError(fiber.StatusNotFound, fmt.Sprintf("Unknown lookup kind %s", kind))
+	}
+	return kind, nil
+}
+
+// findLookupRecord reads the lookup of the id path parameter, which has to be of the kind in
+// the path.
+func (h *LookupHandler) findLookupRecord(c *fiber.Ctx, kind string) (*types.Lookup, error) {
+	lookup, err := findRecord(c, "Lookup", h.store.Lookup.GetLookup)
+	if err != nil {
+		return nil, err
+	}
+	if lookup.Kind != kind {
+		return nil, NewError(fiber.StatusNotFound, "Lookup not found")
+	}
+	return lookup, nil
+}
+
+// codeInUse reports whether another lookup of kind, archived ones included, has code.
+func (h *LookupHandler) codeInUse(c *fiber.Ctx, kind, code string, id primitive.ObjectID) error {
+	filter := db.IncludeArchived(bson.M{"kind": kind, "code": code, "_id": bson.M{"$ne": id}})
+	lookups, err := h.store.Lookup.GetLookups(c.Context(), filter)
+	if err != nil {
+		return err
+	}
+	if len(lookups) > 0 {
+		return NewError(fiber.StatusConflict, fmt.Sprintf("The %s code %s is already in use", kind, code))
+	}
+	return nil
+}
+
+// HandleGetLookups lists the lookups of a kind.
+//
+// @Summary Get lookups
+// @Description Lists the curated colors, types, sizes or units in their sort order. Inactive lookups are left out unless includeInactive is true.
+// @Tags Lookup
+// @Param kind path string true "color, type, size or unit"
+// @Param includeInactive query string false "true to list inactive lookups too"
+// @Param includeArchived query string false "true to include archived records, only to list just the archived ones"
+// @Produce json
+// @Success 200 {array} types.Lookup
+// @Router /lookup/{kind} [get]
+func (h *LookupHandler) HandleGetLookups(c *fiber.Ctx) error {
+	kind, err := lookupKind(c)
+	if err != nil {
+		return err
+	}
+
+	filter := bson.M{"kind": kind}
+	if c.Query("includeInactive") != "true" {
+		filter["active"] = true
+	}
+	if err := archivedFilter(c, filter); err != nil {
+		return err
+	}
+
+	lookups, err := h.store.Lookup.GetLookups(c.Context(), filter)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(lookups)
+}
+
+// HandleInsertLookup adds a lookup.
+//
+// @Summary Insert lookup
+// @Description Adds a color, type, size or unit. Once a kind has lookups, product and material writes only accept its active codes or names, and store the code. Admins only.
+// @Tags Lookup
+// @Accept json
+// @Produce json
+// @Param kind path string true "color, type, size or unit"
+// @Param body body InsertLookupParams true "Lookup"
+// @Success 200 {object} types.Lookup
+// @Router /admin/lookup/{kind} [post]
+func (h *LookupHandler) HandleInsertLookup(c *fiber.Ctx) error {
+	kind, err := lookupKind(c)
+	if err != nil {
+		return err
+	}
+
+	var params InsertLookupParams
+	if err := c.BodyParser(&params); err != nil {
+		return err
+	}
+
+	if err := params.validate(); err != nil {
+		return err
+	}
+
+	if err := h.codeInUse(c, kind, params.Code, primitive.NilObjectID); err != nil {
+		return err
+	}
+
+	lookup := types.Lookup{
+		Kind:      kind,
+		Code:      params.Code,
+		NameZh:    params.NameZh,
+		NameEn:    params.NameEn,
+		SortOrder: params.SortOrder,
+		Active:    params.Active == nil || *params.Active,
+	}
+
+	inserted, err := h.store.Lookup.InsertLookup(c.Context(), &lookup)
+	if err != nil {
+		return err
+	}
+
+	setETag(c, inserted.Version)
+	return c.JSON(inserted)
+}
+
+// HandleUpdateLookup changes a lookup.
+//
+// @Summary Update lookup
+// @Description Changes a lookup. The body is a JSON merge patch. A new code is written to every product, material and parent product using the old one. Admins only.
+// @Tags Lookup
+// @Accept json,application/merge-patch+json
+// @Produce json
+// @Param kind path string true "color, type, size or unit"
+// @Param id path string true "Lookup ID"
+// @Param If-Match header string true "ETag of the lookup as last read"
+// @Param body body UpdateLookupParams true "Fields to change"
+// @Success 200 {object} fiber.Map
+// @Failure 412 {object} Error
+// @Failure 428 {object} Error
+// @Router /admin/lookup/{kind}/{id} [patch]
+func (h *LookupHandler) HandleUpdateLookup(c *fiber.Ctx) error {
+	kind, err := lookupKind(c)
+	if err != nil {
+		return err
+	}
+
+	version, err := ifMatchVersion(c)
+	if err != nil {
+		return err
+	}
+
+	current, err := h.findLookupRecord(c, kind)
+	if err != nil {
+		return err
+	}
+	if current.Version != version {
+		return versionMismatch("Lookup")
+	}
+
+	var params UpdateLookupParams
+	if err := bindMergePatch(c, current, &params); err != nil {
+		return err
+	}
+
+	if err := params.validate(); err != nil {
+		return err
+	}
+
+	if params.Code != current.Code {
+		if err := h.codeInUse(c, kind, params.Code, current.ID); err != nil {
+			return err
+		}
+	}
+
+	updated := types.Lookup{
+		Code:      params.Code,
+		NameZh:    params.NameZh,
+		NameEn:    params.NameEn,
+		SortOrder: params.SortOrder,
+		Active:    params.Active,
+	}
+
+	updateCount, err := h.store.Lookup.UpdateLookup(c.Context(), current.ID, &updated, version)
+	if err != nil {
+		return updateError("Lookup", err)
+	}
+	if updateCount == 0 {
+		return NewError(fiber.StatusNotFound, "Lookup not found or not updated")
+	}
+
+	if params.Code != current.Code {
+		opts, err := deleteOptions(c)
+		if err != nil {
+			return err
+		}
+		if _, err := h.store.Lookup.MergeLookupValues(c.Context(), kind, []string{current.Code}, params.Code, opts); err != nil {
+			return err
+		}
+	}
+
+	setETag(c, version+1)
+
+	return c.JSON(fiber.Map{
+		"message": "Lookup updated successfully",
+	})
+}
+
+// HandleDeleteLookup archives a lookup.
+//
+// @Summary Delete lookup
+// @Description Archives a lookup. Lookups whose code live records still use are not archived; the response is 409 listing them. Merge the values or deactivate the lookup instead. Admins only.
+// @Tags Lookup
+// @Param kind path string true "color, type, size or unit"
+// @Param id path string true "Lookup ID"
+// @Produce json
+// @Success 200 {object} fiber.Map
+// @Router /admin/lookup/{kind}/{id} [delete]
+func (h *LookupHandler) HandleDeleteLookup(c *fiber.Ctx) error {
+	kind, err := lookupKind(c)
+	if err != nil {
+		return err
+	}
+
+	lookup, err := h.findLookupRecord(c, kind)
+	if err != nil {
+		return err
+	}
+
+	opts, err := deleteOptions(c)
+	if err != nil {
+		return err
+	}
+	if opts.Mode == db.DeleteCascade {
+		return NewError(fiber.StatusBadRequest, "Lookups cannot be deleted with mode cascade, merge the values instead")
+	}
+
+	deleteCount, err := h.store.Lookup.DeleteLookup(c.Context(), lookup.ID, opts)
+	if err != nil {
+		return deleteError(c, "Lookup", err)
+	}
+	if deleteCount == 0 {
+		return NewError(fiber.StatusNotFound, "Lookup not found")
+	}
+
+	return c.JSON(fiber.Map{
+		"message": deletedMessage("Lookup"),
+	})
+}
+
+// HandleRestoreLookup restores an archived lookup.
+//
+// @Summary Restore lookup
+// @Description Brings back an archived lookup. Admins only.
+// @Tags Lookup
+// @Param kind path string true "color, type, size or unit"
+// @Param id path string true "Lookup ID"
+// @Produce json
+// @Success 200 {object} fiber.Map
+// @Router /admin/lookup/{kind}/{id}/restore [post]
+func (h *LookupHandler) HandleRestoreLookup(c *fiber.Ctx) error {
+	kind, err := lookupKind(c)
+	if err != nil {
+		return err
+	}
+	// The lookup to restore is archived, so it is only found when asking for archived ones.
+	getArchived := func(ctx context.Context, id primitive.ObjectID) (*types.Lookup, error) {
+		return h.store.Lookup.GetLookup(db.WithArchived(ctx), id)
+	}
+	lookup, err := findRecord(c, "Lookup", getArchived)
+	if err != nil {
+		return err
+	}
+	if lookup.Kind != kind {
+		return NewError(fiber.StatusNotFound, "Lookup not found")
+	}
+
+	return restoreRecord(c, "Lookup", h.store.Lookup.RestoreLookup)
+}
+
+// HandleGetUnmatchedLookupValues lists the values records hold that no lookup names.
+//
+// @Summary Get unmatched values
+// @Description Lists the values of a kind held by products, materials and parent products (units also on material conversions and material usage) that match no lookup by code or name, such as misspellings to merge. Admins only.
+// @Tags Lookup
+// @Param kind path string true "color, type, size or unit"
+// @Produce json
+// @Success 200 {array} string
+// @Router /admin/lookup/{kind}/unmatched [get]
+func (h *LookupHandler) HandleGetUnmatchedLookupValues(c *fiber.Ctx) error {
+	kind, err := lookupKind(c)
+	if err != nil {
+		return err
+	}
+
+	values, err := h.store.Lookup.GetLookupValuesInUse(c.Context(), kind)
+	if err != nil {
+		return err
+	}
+	lookups, err := h.store.Lookup.GetLookups(c.Context(), bson.M{"kind": kind})
+	if err != nil {
+		return err
+	}
+
+	unmatched := []string{}
+	for _, value := range values {
+		if findLookup(lookups, value) == nil {
+			unmatched = append(unmatched, value)
+		}
+	}
+
+	return c.JSON(unmatched)
+}
+
+// HandleMergeLookupValues folds values into a canonical lookup.
+//
+// @Summary Merge values
+// @Description Rewrites the from values on every product, material and parent product to the code of the lookup named by to, and archives the lookups of the from values. Order and material order snapshots keep the values they were recorded with. Admins only.
+// @Tags Lookup
+// @Accept json
+// @Produce json
+// @Param kind path string true "color, type, size or unit"
+// @Param body body MergeLookupParams true "Values to merge"
+// @Success 200 {object} types.LookupMergeResult
+// @Router /admin/lookup/{kind}/merge [post]
+func (h *LookupHandler) HandleMergeLookupValues(c *fiber.Ctx) error {
+	kind, err := lookupKind(c)
+	if err != nil {
+		return err
+	}
+
+	var params MergeLookupParams
+	if err := c.BodyParser(&params); err != nil {
+		return err
+	}
+
+	if err := params.validate(); err != nil {
+		return err
+	}
+
+	lookups, err := h.store.Lookup.GetLookups(c.Context(), bson.M{"kind": kind})
+	if err != nil {
+		return err
+	}
+	to := findLookup(lookups, params.To)
+	if to == nil || !to.Active {
+		return ValidationError{"to": fmt.Sprintf("%q is not an active %s", params.To, kind)}
+	}
+
+	opts, err := deleteOptions(c)
+	if err != nil {
+		return err
+	}
+
+	result, err := h.store.Lookup.MergeLookupValues(c.Context(), kind, params.From, to.Code, opts)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(result)
+}
@@ -0,0 +1,358 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/johnson7543/ims/db"
+	"github.com/johnson7543/ims/types"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type ConsumeMaterialParams struct {
+	// Quantity is how much to consume, in Unit. It can be left out when Lots are given.
+	Quantity float64 `json:"quantity" validate:"min=0"`
+	// Unit is one of the material's units, the base unit when empty.
+	Unit string `json:"unit"`
+	// Lots picks the lots to draw from. Without them the oldest lots are drawn first.
+	Lots []ConsumeLotParams `json:"lots"`
+	// SingleLot draws the whole quantity from the oldest lot holding enough, so lots that
+	// must not be mixed, like dye lots, are not.
+	SingleLot        bool   `json:"singleLot"`
+	ProductID        string `json:"productId" validate:"objectid"`
+	OrderID          string `json:"orderId" validate:"objectid"`
+	ProcessingItemID string `json:"processingItemId" validate:"objectid"`
+	// WarehouseID and Bin pick the location the material is taken from. The default warehouse
+	// is used when they are left out.
+	WarehouseID string `json:"warehouseId" validate:"objectid"`
+	Bin         string `json:"bin"`
+	ConsumedAt  string `json:"consumedAt" validate:"date"`
+	Remark      string `json:"remark"`
+}
+
+type ConsumeLotParams struct {
+	LotNumber string  `json:"lotNumber" validate:"required"`
+	Quantity  float64 `json:"quantity" validate:"gt=0"`
+}
+
+func (p ConsumeMaterialParams) validate() error {
+	if err := validateStruct(p); err != nil {
+		return err
+	}
+	switch {
+	case len(p.Lots) == 0 && p.Quantity == 0:
+		return ValidationError{"quantity": "is required when no lots are given"}
+	case len(p.Lots) > 0 && p.SingleLot:
+		return ValidationError{"singleLot": "cannot be combined with lots"}
+	}
+	return nil
+}
+
+type LotHandler struct {
+	store *db.Store
+}
+
+func NewLotHandler(store *db.Store) *LotHandler {
+	return &LotHandler{
+		store: store,
+	}
+}
+
+// HandleGetLots retrieves a list of material lots based on query parameters.
+//
+// @Summary Get lots
+// @Description Lists material lots, oldest first. Used up and canceled lots are left out unless includeEmpty is true.
+// @Tags Lot
+// @Param materialId query string false "Material ID"
+// @Param lotNumber query string false "Lot number"
+// @Param materialOrderId query string false "Material order the lot was received with"
+// @Param includeEmpty query string false "true to list used up and canceled lots too"
+// @Produce json
+// @Success 200 {array} types.MaterialLot
+// @Router /lot [get]
+func (h *LotHandler) HandleGetLots(c *fiber.Ctx) error {
+	filter := bson.M{}
+
+	for _, field := range []string{"materialId", "materialOrderId"} {
+		if id := c.Query(field); id != "" {
+			objID, err := primitive.ObjectIDFromHex(id)
+			if err != nil {
+				return NewError(fiber.StatusBadRequest, fmt.Sprintf("Invalid %s", field))
+			}
+			filter[field] = objID
+		}
+	}
+	if lotNumber := c.Query("lotNumber"); lotNumber != "" {
+		filter["lotNumber"] = lotNumber
+	}
+	if c.Query("includeEmpty") != "true" {
+		filter["remaining"] = bson.M{"$gt": 0}
+		filter["canceled"] = bson.M{"$ne": true}
+	}
+
+	lots, err := h.store.Lot.GetLots(c.Context(), filter)
+	if err != nil {
+		return err
+	}
+
+	if len(lots) == 0 {
+		return NewError(fiber.StatusNotFound, "No Matches data found")
+	}
+
+	return c.JSON(lots)
+}
+
+// HandleGetLot retrieves a lot by ID.
+// @Summary Get lot
+// @Description Get a material lot by ID.
+// @Tags Lot
+// @Produce json
+// @Param id path string true "Lot ID"
+// @Success 200 {object} types.MaterialLot
+// @Router /lot/{id} [get]
+func (h *LotHandler) HandleGetLot(c *fiber.Ctx) error {
+	lot, err := findRecord(c, "Lot", h.store.Lot.GetLot)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(lot)
+}
+
+// HandleTraceLot shows where a lot came from and what used it.
+//
+// @Summary Trace lot
+// @Description Shows the material order a lot was received with, the consumptions that drew from it and the products and orders they were for, archived ones included.
+// @Tags Lot
+// @Produce json
+// @Param id path string true "Lot ID"
+// @Success 200 {object} types.LotTrace
+// @Router /lot/{id}/trace [get]
+func (h *LotHandler) HandleTraceLot(c *fiber.Ctx) error {
+	lot, err := findRecord(c, "Lot", h.store.Lot.GetLot)
+	if err != nil {
+		return err
+	}
+
+	trace := types.LotTrace{Lot: lot, Products: []*types.Product{}, Orders: []*types.Order{}}
+
+	if lot.MaterialOrderID != nil {
+		// A trace reaches back to the receiving order even after it was archived.
+		order, err := h.store.MaterialOrder.GetMaterialOrder(db.WithArchived(c.Context()), *lot.MaterialOrderID)
+		if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+			return err
+		}
+		trace.MaterialOrder = order
+	}
+
+	trace.Consumptions, err = h.store.Lot.GetConsumptions(c.Context(), bson.M{"draws.lotId": lot.ID})
+	if err != nil {
+		return err
+	}
+
+	productIDs, orderIDs := bson.A{}, bson.A{}
+	for _, consumption := range trace.Consumptions {
+		if consumption.ProductID != nil {
+			productIDs = append(productIDs, *consumption.ProductID)
+		}
+		if consumption.OrderID != nil {
+			orderIDs = append(orderIDs, *consumption.OrderID)
+		}
+	}
+	if len(productIDs) > 0 {
+		trace.Products, err = h.store.Product.GetProducts(c.Context(), db.IncludeArchived(bson.M{"_id": bson.M{"$in": productIDs}}))
+		if err != nil {
+			return err
+		}
+	}
+	if len(orderIDs) > 0 {
+		trace.Orders, err = h.store.Order.GetOrders(c.Context(), db.IncludeArchived(bson.M{"_id": bson.M{"$in": orderIDs}}))
+		if err != nil {
+			return err
+		}
+	}
+
+	return c.JSON(trace)
+}
+
+// HandleGetConsumptions retrieves a list of material consumptions based on query parameters.
+//
+// @Summary Get material consumptions
+// @Description Lists material consumptions with the lots they drew from, newest first.
+// @Tags Lot
+// @Param materialId query string false "Material ID"
+// @Param lotId query string false "Lot ID"
+// @Param productId query string false "Product ID"
+// @Param orderId query string false "Order ID"
+// @Param processingItemId query string false "Processing item ID"
+// @Produce json
+// @Success 200 {array} types.MaterialConsumption
+// @Router /materialConsumption [get]
+func (h *LotHandler) HandleGetConsumptions(c *fiber.Ctx) error {
+	filter := bson.M{}
+
+	fields := map[string]string{
+		"materialId":       "materialId",
+		"lotId":            "draws.lotId",
+		"productId":        "productId",
+		"orderId":          "orderId",
+		"processingItemId": "processingItemId",
+	}
+	for query, field := range fields {
+		if id := c.Query(query); id != "" {
+			objID, err := primitive.ObjectIDFromHex(id)
+			if err != nil {
+				return NewError(fiber.StatusBadRequest, fmt.Sprintf("Invalid %s", query))
+			}
+			filter[field] = objID
+		}
+	}
+
+	consumptions, err := h.store.Lot.GetConsumptions(c.Context(), filter)
+	if err != nil {
+		return err
+	}
+
+	if len(consumptions) == 0 {
+		return NewError(fiber.StatusNotFound, "No Matches data found")
+	}
+
+	return c.JSON(consumptions)
+}
+
+// HandleConsumeMaterial takes material out of stock to make products.
+//
+// @Summary Consume material
+// @Description Takes material out of stock, drawing from the given lots or else from the oldest lots first; singleLot keeps the whole quantity to one lot. Stock received before lots were tracked is drawn last. Either everything is taken or, when the lots, the location or the material hold too little, nothing is and the response is 409.
+// @Tags Lot
+// @Accept json
+// @Produce json
+// @Param id path string true "Material ID"
+// @Param body body ConsumeMaterialParams true "What to consume"
+// @Success 200 {object} types.MaterialConsumption
+// @Router /material/{id}/consume [post]
+func (h *LotHandler) HandleConsumeMaterial(c *fiber.Ctx) error {
+	material, err := findRecord(c, "Material", h.store.Material.GetMaterial)
+	if err != nil {
+		return err
+	}
+	if material.DeletedAt != nil {
+		return NewError(fiber.StatusNotFound, "Material not found")
+	}
+
+	var params ConsumeMaterialParams
+	if err := c.BodyParser(&params); err != nil {
+		return err
+	}
+
+	if err := params.validate(); err != nil {
+		return err
+	}
+
+	user, err := getAuthUser(c)
+	if err != nil {
+		return err
+	}
+
+	factor, ok := material.UnitFactor(params.Unit)
+	if !ok {
+		return ValidationError{"unit": fmt.Sprintf("%s is not a unit of material %s", params.Unit, material.Name)}
+	}
+
+	consumption := types.MaterialConsumption{
+		MaterialID: material.ID,
+		Quantity:   params.Quantity * factor,
+		Draws:      []types.LotDraw{},
+		Remark:     params.Remark,
+		ConsumedAt: time.Now(),
+		CreatedBy:  user.ID,
+	}
+
+	if len(params.Lots) > 0 {
+		consumption.Quantity = 0
+		for _, lot := range params.Lots {
+			draw := types.LotDraw{LotNumber: strings.TrimSpace(lot.LotNumber), Quantity: lot.Quantity * factor}
+			consumption.Draws = append(consumption.Draws, draw)
+			consumption.Quantity += draw.Quantity
+		}
+		if params.Quantity != 0 && math.Abs(params.Quantity*factor-consumption.Quantity) > 1e-9 {
+			return ValidationError{"quantity": "should be the sum of the lot quantities"}
+		}
+	}
+
+	if params.ConsumedAt != "" {
+		consumedAt, err := time.Parse(time.RFC3339Nano, params.ConsumedAt)
+		if err != nil {
+			return NewError(fiber.StatusBadRequest, "Invalid consumed date format")
+		}
+		consumption.ConsumedAt = consumedAt
+	}
+
+	if consumption.ProductID, err = h.relatedID(c.Context(), "productId", params.ProductID); err != nil {
+		return err
+	}
+	if consumption.OrderID, err = h.relatedID(c.Context(), "orderId", params.OrderID); err != nil {
+		return err
+	}
+	if consumption.ProcessingItemID, err = h.relatedID(c.Context(), "processingItemId", params.ProcessingItemID); err != nil {
+		return err
+	}
+
+	consumption.Location, err = stockLocation(c.Context(), h.store.Warehouse, "", params.WarehouseID, params.Bin)
+	if err != nil {
+		return err
+	}
+
+	inserted, err := h.store.Lot.ConsumeMaterial(c.Context(), &consumption, params.SingleLot)
+	if errors.Is(err, db.ErrInsufficientStock) {
+		switch {
+		case len(params.Lots) > 0:
+			return ErrInsufficientStock(fmt.Sprintf("The lots of material %s hold less than requested", material.Name))
+		case params.SingleLot:
+			return ErrInsufficientStock(fmt.Sprintf("No lot of material %s holds %g", material.Name, consumption.Quantity))
+		case consumption.Location != nil:
+			return ErrInsufficientStock(fmt.Sprintf("Material %s has less than %g at %s", material.Name, consumption.Quantity, locationName(*consumption.Location)))
+		}
+		return ErrInsufficientStock(fmt.Sprintf("Material %s has less than %g in stock", material.Name, consumption.Quantity))
+	}
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(inserted)
+}
+
+// relatedID checks that the product, order or processing item a consumption is for exists.
+func (h *LotHandler) relatedID(ctx context.Context, field, id string) (*primitive.ObjectID, error) {
+	if id == "" {
+		return nil, nil
+	}
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ValidationError{field: fmt.Sprintf("%q is not a valid ID", id)}
+	}
+
+	switch field {
+	case "productId":
+		_, err = h.store.Product.GetProduct(ctx, objID)
+	case "orderId":
+		_, err = h.store.Order.GetOrder(ctx, objID)
+	case "processingItemId":
+		_, err = h.store.ProcessingItem.GetProcessingItem(ctx, objID)
+	}
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ValidationError{field: fmt.Sprintf("%s does not exist", id)}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &objID, nil
+}
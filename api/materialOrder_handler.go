@@ -1,6 +1,8 @@
 package api
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"strings"
 	"time"
@@ -15,50 +17,59 @@ import (
 )
 
 type InsertMaterialOrderParams struct {
-	ID                 string                          `json:"id,omitempty"`
-	SellerID           string                          `json:"sellerID"`
-	SellerName         string                          `json:"sellerName"`
-	OrderDate          string                          `json:"orderDate"`
-	DeliveryDate       string                          `json:"deliveryDate"`
-	PaymentDate        string                          `json:"paymentDate"`
-	TotalAmount        float64                         `json:"totalAmount"`
-	Status             string                          `json:"status"`
+	ID           string  `json:"id,omitempty"`
+	SellerID     string  `json:"sellerID" validate:"required,objectid"`
+	SellerName   string  `json:"sellerName" validate:"required"`
+	OrderDate    string  `json:"orderDate" validate:"required,date"`
+	DeliveryDate string  `json:"deliveryDate" validate:"date"`
+	PaymentDate  string  `json:"paymentDate" validate:"date"`
+	TotalAmount  float64 `json:"totalAmount" validate:"min=0"`
+	Status       string  `json:"status" validate:"enumfold=materialOrderStatus"`
+	// WarehouseID and Bin pick the location the materials are received at. The default
+	// warehouse is used when they are left out.
+	WarehouseID        string                          `json:"warehouseId" validate:"objectid"`
+	Bin                string                          `json:"bin"`
 	MaterialOrderItems []InsertMaterialOrderItemParams `json:"materialOrderItems"`
 }
 
 type InsertMaterialOrderItemParams struct {
-	Material   InsertMaterialOrderMaterialParams `json:"material"`
-	Quantity   int                               `json:"quantity"`
-	TotalPrice float64                           `json:"totalPrice"`
+	Material InsertMaterialOrderMaterialParams `json:"material"`
+	Quantity float64                           `json:"quantity" validate:"gt=0"`
+	// Unit is the unit the line is bought in, one of the material's units. The material's base
+	// unit is used when it is empty.
+	Unit       string  `json:"unit"`
+	TotalPrice float64 `json:"totalPrice" validate:"min=0"`
+	// LotNumber is the supplier's lot or batch number, such as the dye lot of a fabric.
+	LotNumber string `json:"lotNumber"`
 }
 
 type InsertMaterialOrderMaterialParams struct {
-	MaterialID string  `json:"id,omitempty"`
-	Name       string  `json:"name"`
-	Price      float64 `json:"price"`
+	MaterialID string  `json:"id,omitempty" validate:"objectid"`
+	Name       string  `json:"name" validate:"required"`
+	Price      float64 `json:"price" validate:"min=0"`
 	Color      string  `json:"color"`
 	Size       string  `json:"size"`
-	Quantity   int     `json:"quantity"`
+	Quantity   float64 `json:"quantity" validate:"min=0"`
 	Remarks    string  `json:"remarks"`
 }
 
 func (p InsertMaterialOrderParams) validate() error {
-	return nil
+	return validateStruct(p)
 }
 
 type UpdateMaterialOrderParams struct {
 	ID           string  `json:"id,omitempty"`
-	SellerID     string  `json:"sellerID"`
-	SellerName   string  `json:"sellerName"`
-	OrderDate    string  `json:"orderDate"`
-	DeliveryDate string  `json:"deliveryDate"`
-	PaymentDate  string  `json:"paymentDate"`
-	TotalAmount  float64 `json:"totalAmount"`
-	Status       string
+	SellerID     string  `json:"sellerID" validate:"required,objectid"`
+	SellerName   string  `json:"sellerName" validate:"required"`
+	OrderDate    string  `json:"orderDate" validate:"required,date"`
+	DeliveryDate string  `json:"deliveryDate" validate:"date"`
+	PaymentDate  string  `json:"paymentDate" validate:"date"`
+	TotalAmount  float64 `json:"totalAmount" validate:"min=0"`
+	Status       string  `json:"status" validate:"enumfold=materialOrderStatus"`
 }
 
 func (p UpdateMaterialOrderParams) validate() error {
-	return nil
+	return validateStruct(p)
 }
 
 type MaterialOrderHandler struct {
@@ -79,7 +90,11 @@ func NewMaterialOrderHandler(store *db.Store) *MaterialOrderHandler {
 // @Param id query string false "Material Order ID"
 // @Param sellerId query string false "Seller ID"
 // @Param status query string false "Material Order status"
+// @Param includeArchived query string false "true to include archived records, only to list just the archived ones"
+// @Param format query string false "Response format: json (default), csv or xlsx. The Accept header is used when omitted"
 // @Produce json
+// @Produce text/csv
+// @Produce application/vnd.openxmlformats-officedocument.spreadsheetml.sheet
 // @Success 200 {array} types.MaterialOrder
 // @Router /materialOrder [get]
 func (h *MaterialOrderHandler) HandleGetMaterialOrders(c *fiber.Ctx) error {
@@ -92,9 +107,7 @@ func (h *MaterialOrderHandler) HandleGetMaterialOrders(c *fiber.Ctx) error {
 	if id != "" {
 		objID, err := primitive.ObjectIDFromHex(id)
 		if err != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "Invalid material order ID",
-			})
+			return NewError(fiber.StatusBadRequest, "Invalid material order ID")
 		}
 		filter["_id"] = objID
 	}
@@ -102,9 +115,7 @@ func (h *MaterialOrderHandler) HandleGetMaterialOrders(c *fiber.Ctx) error {
 	if sellerID != "" {
 		objID, err := primitive.ObjectIDFromHex(sellerID)
 		if err != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "Invalid seller ID",
-			})
+			return NewError(fiber.StatusBadRequest, "Invalid seller ID")
 		}
 		filter["sellerId"] = objID
 	}
@@ -113,20 +124,50 @@ func (h *MaterialOrderHandler) HandleGetMaterialOrders(c *fiber.Ctx) error {
 		filter["status"] = status
 	}
 
+	if err := archivedFilter(c, filter); err != nil {
+		return err
+	}
+
+	if format := exportFormat(c); format != "" {
+		return streamExport(c, "material_orders", format, filter, h.store.MaterialOrder.IterateMaterialOrders, materialOrderExportHeader, materialOrderExportRecords)
+	}
+
 	materialOrders, err := h.store.MaterialOrder.GetMaterialOrders(c.Context(), filter)
 	if err != nil {
 		return err
 	}
 
 	if len(materialOrders) == 0 {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-			"error": "No matching data found",
-		})
+		return NewError(fiber.StatusNotFound, "No matching data found")
+	}
+
+	if len(materialOrders) == 1 {
+		setETag(c, materialOrders[0].Version)
 	}
 
 	return c.JSON(materialOrders)
 }
 
+// HandleGetMaterialOrder retrieves a material order by ID.
+// @Summary Get material order
+// @Description Get a material order by ID. The ETag header carries its version, to be sent back as If-Match when updating it.
+// @Tags MaterialOrder
+// @Produce json
+// @Param id path string true "Material Order ID"
+// @Param includeArchived query string false "true to find the record even if archived"
+// @Success 200 {object} types.MaterialOrder
+// @Header 200 {string} ETag "Version of the material order"
+// @Router /materialOrder/{id} [get]
+func (h *MaterialOrderHandler) HandleGetMaterialOrder(c *fiber.Ctx) error {
+	materialOrder, err := findRecord(c, "Material Order", h.store.MaterialOrder.GetMaterialOrder)
+	if err != nil {
+		return err
+	}
+
+	setETag(c, materialOrder.Version)
+	return c.JSON(materialOrder)
+}
+
 // HandleInsertMaterialOrder inserts a new material order.
 //
 // @Summary Insert material order
@@ -149,62 +190,84 @@ func (h *MaterialOrderHandler) HandleInsertMaterialOrder(c *fiber.Ctx) error {
 
 	orderDateParsed, err := time.Parse(time.RFC3339Nano, params.OrderDate)
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Invalid order date format",
-		})
+		return NewError(fiber.StatusBadRequest, "Invalid order date format")
+	}
+
+	location, err := stockLocation(c.Context(), h.store.Warehouse, "", params.WarehouseID, params.Bin)
+	if err != nil {
+		return err
 	}
 
 	materialOrderItems := make([]types.MaterialOrderItem, len(params.MaterialOrderItems))
 	for i, item := range params.MaterialOrderItems {
-		materialID, err := primitive.ObjectIDFromHex(item.Material.MaterialID)
+		line, err := materialOrderItem(c.Context(), h.store.Material, fmt.Sprintf("materialOrderItems[%d]", i), item)
 		if err != nil {
 			return err
 		}
-		material := types.MaterialOrderMaterial{
-			MaterialID: materialID,
-			Name:       item.Material.Name,
-			Price:      item.Material.Price,
-			Color:      item.Material.Color,
-			Size:       item.Material.Size,
-			Remarks:    item.Material.Remarks,
-		}
+		materialOrderItems[i] = line
+	}
 
-		materialOrderItems[i] = types.MaterialOrderItem{
-			Material:   material,
-			Quantity:   item.Quantity,
-			TotalPrice: item.TotalPrice,
+	completed := strings.EqualFold(params.Status, "completed")
+	if completed {
+		if err := h.checkLotNumbers(c.Context(), "materialOrderItems", materialOrderItems); err != nil {
+			return err
 		}
+	}
+
+	lots := []*types.MaterialLot{}
+	for i, item := range params.MaterialOrderItems {
+		materialID := materialOrderItems[i].Material.MaterialID
 
 		// update materail information
-		if strings.EqualFold(params.Status, "completed") {
+		if completed {
 			m, err := h.store.Material.GetMaterial(c.Context(), materialID)
 			if err != nil {
 				if err != mongo.ErrNoDocuments {
 					return err
 				}
 
-				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-					"error": fmt.Sprintf("Material %s doesn't exist, please create the material first.", materialID),
-				})
+				return NewError(fiber.StatusBadRequest, fmt.Sprintf("Material %s doesn't exist, please create the material first.", materialID))
 			} else {
+				// Stock and prices are kept per base unit of the material.
+				factor, _ := m.UnitFactor(item.Unit)
+
 				material := *m // make a copy
 				material.Name = item.Material.Name
 				material.Color = item.Material.Color
 				material.Size = item.Material.Size
-				material.Quantity += item.Material.Quantity
 				material.Remarks = item.Material.Remarks
 
 				priceHistoryEntry := types.PriceHistoryEntry{
-					Price:     item.Material.Price,
+					Price:     item.Material.Price / factor,
 					UpdatedAt: orderDateParsed,
 				}
 
-				material.PriceHistory = append(material.PriceHistory, priceHistoryEntry)
+				material.PriceHistory = []types.PriceHistoryEntry{priceHistoryEntry}
 
-				_, err = h.store.Material.UpdateMaterial(c.Context(), materialID, &material)
+				_, err = h.store.Material.UpdateMaterial(c.Context(), materialID, &material, m.Version)
+				if errors.Is(err, db.ErrVersionConflict) {
+					return NewError(fiber.StatusConflict, fmt.Sprintf("Material %s was changed while the order was saved, please retry.", materialID.Hex()))
+				}
 				if err != nil {
 					return err
 				}
+
+				// The material arrives at the receiving location, as a lot of its own.
+				if received := item.Material.Quantity * factor; received > 0 {
+					if location != nil {
+						if err := h.store.Stock.AddStock(c.Context(), types.StockMaterial, materialID, *location, received); err != nil {
+							return err
+						}
+					} else if _, err := h.store.Material.IncreaseMaterialQuantity(c.Context(), materialID, received); err != nil {
+						return err
+					}
+					lots = append(lots, &types.MaterialLot{
+						MaterialID: materialID,
+						LotNumber:  materialOrderItems[i].LotNumber,
+						ReceivedAt: time.Now(),
+						Quantity:   received,
+					})
+				}
 			}
 
 		}
@@ -218,14 +281,13 @@ func (h *MaterialOrderHandler) HandleInsertMaterialOrder(c *fiber.Ctx) error {
 		TotalAmount:        params.TotalAmount,
 		Status:             params.Status,
 		MaterialOrderItems: materialOrderItems,
+		Location:           location,
 	}
 
 	if params.DeliveryDate != "" {
 		deliveryDateParsed, err := time.Parse(time.RFC3339Nano, params.DeliveryDate)
 		if err != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "Invalid delivery date format",
-			})
+			return NewError(fiber.StatusBadRequest, "Invalid delivery date format")
 		}
 		materialOrder.DeliveryDate = deliveryDateParsed
 	}
@@ -233,9 +295,7 @@ func (h *MaterialOrderHandler) HandleInsertMaterialOrder(c *fiber.Ctx) error {
 	if params.PaymentDate != "" {
 		paymentDateParsed, err := time.Parse(time.RFC3339Nano, params.PaymentDate)
 		if err != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "Invalid payment date format",
-			})
+			return NewError(fiber.StatusBadRequest, "Invalid payment date format")
 		}
 		materialOrder.PaymentDate = paymentDateParsed
 	}
@@ -245,6 +305,13 @@ func (h *MaterialOrderHandler) HandleInsertMaterialOrder(c *fiber.Ctx) error {
 		return err
 	}
 
+	for _, lot := range lots {
+		lot.MaterialOrderID = &inserted.ID
+	}
+	if err := h.store.Lot.ReceiveLots(c.Context(), lots); err != nil {
+		return err
+	}
+
 	return c.JSON(fiber.Map{
 		"message": fmt.Sprintf("MaterialOrder inserted successfully, ID: %s, TotalAmount: %f", inserted.ID.Hex(), inserted.TotalAmount),
 	})
@@ -252,23 +319,40 @@ func (h *MaterialOrderHandler) HandleInsertMaterialOrder(c *fiber.Ctx) error {
 
 // HandleUpdateMaterialOrder updates an existing material order in the system.
 // @Summary Update material order
-// @Description Update an existing material order in the system.
+// @Description Update an existing material order in the system. The body is a JSON merge patch: fields left out keep their value and null clears a field.
 // @Tags MaterialOrder
-// @Accept json
+// @Accept json,application/merge-patch+json
 // @Produce json
 // @Param id path string true "Material Order ID"
-// @Param body body UpdateMaterialOrderParams true "Updated material order details"
+// @Param If-Match header string true "ETag of the material order as last read"
+// @Param body body UpdateMaterialOrderParams true "Fields to change"
 // @Success 200 {object} fiber.Map
+// @Failure 412 {object} fiber.Map
+// @Failure 428 {object} fiber.Map
 // @Router /materialOrder/{id} [patch]
 func (h *MaterialOrderHandler) HandleUpdateMaterialOrder(c *fiber.Ctx) error {
 	id := c.Params("id")
 	materialOrderID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return NewError(fiber.StatusBadRequest, "Invalid material order ID")
+	}
+
+	version, err := ifMatchVersion(c)
 	if err != nil {
 		return err
 	}
 
+	current, err := findRecord(c, "Material Order", h.store.MaterialOrder.GetMaterialOrder)
+	if err != nil {
+		return err
+	}
+	// Stock is adjusted before the order is saved, so a stale update must stop here.
+	if current.Version != version {
+		return versionMismatch("Material Order")
+	}
+
 	var params UpdateMaterialOrderParams
-	if err := c.BodyParser(&params); err != nil {
+	if err := bindMergePatch(c, current, &params); err != nil {
 		return err
 	}
 
@@ -278,69 +362,67 @@ func (h *MaterialOrderHandler) HandleUpdateMaterialOrder(c *fiber.Ctx) error {
 
 	orderDateParsed, err := time.Parse(time.RFC3339Nano, params.OrderDate)
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Invalid order date format",
-		})
+		return NewError(fiber.StatusBadRequest, "Invalid order date format")
 	}
 
-	deliveryDateParsed, err := time.Parse(time.RFC3339Nano, params.DeliveryDate)
-	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Invalid order date format",
-		})
-	}
-
-	paymentDateParsed, err := time.Parse(time.RFC3339Nano, params.PaymentDate)
-	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Invalid order date format",
-		})
+	// The delivery and payment dates can be cleared with null, leaving them unset.
+	var deliveryDateParsed, paymentDateParsed time.Time
+	if params.DeliveryDate != "" {
+		if deliveryDateParsed, err = time.Parse(time.RFC3339Nano, params.DeliveryDate); err != nil {
+			return NewError(fiber.StatusBadRequest, "Invalid delivery date format")
+		}
 	}
-
-	mo, err := h.store.MaterialOrder.GetMaterialOrder(c.Context(), materialOrderID)
-	if err != nil {
-		if err != mongo.ErrNoDocuments {
-			return err
+	if params.PaymentDate != "" {
+		if paymentDateParsed, err = time.Parse(time.RFC3339Nano, params.PaymentDate); err != nil {
+			return NewError(fiber.StatusBadRequest, "Invalid payment date format")
 		}
-
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Material order doesn't exist.",
-		})
 	}
 
 	// update materail information if the status changed into completed status
-	if !strings.EqualFold(mo.Status, "completed") && strings.EqualFold(params.Status, "completed") {
+	if !strings.EqualFold(current.Status, "completed") && strings.EqualFold(params.Status, "completed") {
+		if err := h.checkLotNumbers(c.Context(), "materialOrderItems", current.MaterialOrderItems); err != nil {
+			return err
+		}
 		// update all material items in the material order
-		for _, item := range mo.MaterialOrderItems {
-			updatedCount, err := h.store.Material.IncreaseMaterialQuantity(c.Context(), item.Material.MaterialID, item.Quantity)
-			if err != nil {
-				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-					"error": fmt.Sprintf("Failed to increase material %s by %d, %s", item.Material.MaterialID, item.Quantity, err.Error()),
-				})
-			}
-
-			if updatedCount == 0 {
-				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-					"error": fmt.Sprintf("Failed to increase material %s by %d", item.Material.MaterialID, item.Quantity),
-				})
-			}
+		if err := h.receiveMaterials(c.Context(), current.Location, current.MaterialOrderItems); err != nil {
+			return err
+		}
+		if err := h.store.Lot.ReceiveLots(c.Context(), receivedLots(current.ID, current.MaterialOrderItems)); err != nil {
+			return err
 		}
 	}
 
 	// Decrease material amount if the status changed into canceled status
-	if strings.EqualFold(mo.Status, "completed") && strings.EqualFold(params.Status, "canceled") {
-		for _, item := range mo.MaterialOrderItems {
-			updatedCount, err := h.store.Material.DecreaseMaterialQuantity(c.Context(), item.Material.MaterialID, item.Quantity)
+	if strings.EqualFold(current.Status, "completed") && strings.EqualFold(params.Status, "canceled") {
+		_, err := h.store.Lot.CancelReceivedLots(c.Context(), current.ID)
+		if errors.Is(err, db.ErrLotConsumed) {
+			return NewError(fiber.StatusConflict, fmt.Sprintf("The order cannot be canceled, %s", err.Error()))
+		}
+		if err != nil {
+			return err
+		}
+		for _, item := range current.MaterialOrderItems {
+			if current.Location != nil {
+				err := h.store.Stock.TakeStock(c.Context(), types.StockMaterial, item.Material.MaterialID, *current.Location, item.StockQuantity())
+				if errors.Is(err, db.ErrInsufficientStock) {
+					return ErrInsufficientStock(fmt.Sprintf("Material %s has less than %g at %s, the order cannot be canceled", item.Material.MaterialID.Hex(), item.StockQuantity(), locationName(*current.Location)))
+				}
+				if err != nil {
+					return NewError(fiber.StatusInternalServerError, fmt.Sprintf("Failed to decrease material %s by %g, %s", item.Material.MaterialID, item.StockQuantity(), err.Error()))
+				}
+				continue
+			}
+
+			updatedCount, err := h.store.Material.DecreaseMaterialQuantity(c.Context(), item.Material.MaterialID, item.StockQuantity())
+			if errors.Is(err, db.ErrInsufficientStock) {
+				return ErrInsufficientStock(fmt.Sprintf("Material %s has less than %g in stock, the order cannot be canceled", item.Material.MaterialID.Hex(), item.StockQuantity()))
+			}
 			if err != nil {
-				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-					"error": fmt.Sprintf("Failed to decrease material %s by %d, %s", item.Material.MaterialID, item.Quantity, err.Error()),
-				})
+				return NewError(fiber.StatusInternalServerError, fmt.Sprintf("Failed to decrease material %s by %g, %s", item.Material.MaterialID, item.StockQuantity(), err.Error()))
 			}
 
 			if updatedCount == 0 {
-				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-					"error": fmt.Sprintf("Failed to decrease material %s by %d", item.Material.MaterialID, item.Quantity),
-				})
+				return NewError(fiber.StatusInternalServerError, fmt.Sprintf("Failed to decrease material %s by %g", item.Material.MaterialID, item.StockQuantity()))
 			}
 		}
 	}
@@ -355,17 +437,16 @@ func (h *MaterialOrderHandler) HandleUpdateMaterialOrder(c *fiber.Ctx) error {
 		Status:       params.Status,
 	}
 
-	updateCount, err := h.store.MaterialOrder.UpdateMaterialOrder(c.Context(), materialOrderID, &updatedMaterialOrder)
+	updateCount, err := h.store.MaterialOrder.UpdateMaterialOrder(c.Context(), materialOrderID, &updatedMaterialOrder, version)
 	if err != nil {
-		return err
+		return updateError("Material Order", err)
 	}
 
 	if updateCount == 0 {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-			"error": "Material Order not found or not updated",
-		})
+		return NewError(fiber.StatusNotFound, "Material Order not found or not updated")
 	}
 
+	setETag(c, version+1)
 	return c.JSON(fiber.Map{
 		"message": "Material Order updated successfully",
 	})
@@ -374,9 +455,11 @@ func (h *MaterialOrderHandler) HandleUpdateMaterialOrder(c *fiber.Ctx) error {
 // HandleDeleteMaterialOrder deletes a material order by ID.
 //
 // @Summary Delete material order
-// @Description Deletes a material order by ID.
+// @Description Archives a material order by ID. Archived material orders are hidden from lists and can be restored until they are purged.
+// @Description Material orders with supplier returns are not archived; the response is 409 listing the references. Admins can pass mode=archive to archive the order regardless, but it is not purged while they exist.
 // @Tags MaterialOrder
 // @Param id path string true "Material Order ID"
+// @Param mode query string false "archive, admins only"
 // @Produce json
 // @Success 200 {object} fiber.Map
 // @Router /materialOrder/{id} [delete]
@@ -385,74 +468,84 @@ func (h *MaterialOrderHandler) HandleDeleteMaterialOrder(c *fiber.Ctx) error {
 
 	objID, err := primitive.ObjectIDFromHex(materialOrderID)
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Invalid material order ID",
-		})
+		return NewError(fiber.StatusBadRequest, "Invalid material order ID")
 	}
 
-	deleteCount, err := h.store.MaterialOrder.DeleteMaterialOrder(c.Context(), objID)
+	opts, err := deleteOptions(c)
+	if err != nil {
+		return err
+	}
+
+	deleteCount, err := h.store.MaterialOrder.DeleteMaterialOrder(c.Context(), objID, opts)
 	if err != nil {
 		return err
 	}
 	if deleteCount == 0 {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-			"error": "Material Order not found",
-		})
+		return NewError(fiber.StatusNotFound, "Material Order not found")
 	}
 
 	return c.JSON(fiber.Map{
-		"message": "Material Order deleted successfully",
+		"message": deletedMessage("Material Order"),
 	})
 }
 
+// HandleRestoreMaterialOrder restores an archived material order.
+//
+// @Summary Restore material order
+// @Description Brings back an archived material order.
+// @Tags MaterialOrder
+// @Param id path string true "Material order ID"
+// @Produce json
+// @Success 200 {object} fiber.Map
+// @Router /materialOrder/{id}/restore [post]
+func (h *MaterialOrderHandler) HandleRestoreMaterialOrder(c *fiber.Ctx) error {
+	return restoreRecord(c, "Material order", h.store.MaterialOrder.RestoreMaterialOrder)
+}
+
 func (h *MaterialOrderHandler) HandleInsertMaterialOrderItemsToOrder(c *fiber.Ctx) error {
 	id := c.Params("id")
 	materialOrderID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
-		return err
+		return NewError(fiber.StatusBadRequest, "Invalid material order ID")
 	}
 
-	materialOrder, err := h.store.MaterialOrder.GetMaterialOrders(c.Context(), bson.M{"_id": materialOrderID})
+	materialOrder, err := h.store.MaterialOrder.GetMaterialOrder(c.Context(), materialOrderID)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return NewError(fiber.StatusNotFound, "Material Order not found")
+	}
 	if err != nil {
 		return err
 	}
 
-	if strings.EqualFold(materialOrder[0].Status, "canceled") {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Cannot insert items to a already canceled material order.",
-		})
+	if strings.EqualFold(materialOrder.Status, "canceled") {
+		return NewError(fiber.StatusBadRequest, "Cannot insert items to a already canceled material order.")
 	}
+	completed := strings.EqualFold(materialOrder.Status, "completed")
 
 	var params []InsertMaterialOrderItemParams
 	if err := c.BodyParser(&params); err != nil {
 		return err
 	}
 
+	if err := validateStruct(params); err != nil {
+		return err
+	}
+
 	newTotalAmount := 0.0
 	materialOrderItems := make([]types.MaterialOrderItem, len(params))
 	for i, item := range params {
-		materialID, err := primitive.ObjectIDFromHex(item.Material.MaterialID)
+		line, err := materialOrderItem(c.Context(), h.store.Material, fmt.Sprintf("[%d]", i), item)
 		if err != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "Invalid material ID",
-			})
-		}
-
-		material := types.MaterialOrderMaterial{
-			MaterialID: materialID,
-			Name:       item.Material.Name,
-			Price:      item.Material.Price,
-			Color:      item.Material.Color,
-			Size:       item.Material.Size,
-			Remarks:    item.Material.Remarks,
+			return err
 		}
+		materialOrderItems[i] = line
+		newTotalAmount = newTotalAmount + item.TotalPrice
+	}
 
-		materialOrderItems[i] = types.MaterialOrderItem{
-			Material:   material,
-			Quantity:   item.Quantity,
-			TotalPrice: item.TotalPrice,
+	if completed {
+		if err := h.checkLotNumbers(c.Context(), "", materialOrderItems); err != nil {
+			return err
 		}
-		newTotalAmount = newTotalAmount + item.TotalPrice
 	}
 
 	// Inset items into material order
@@ -466,32 +559,21 @@ func (h *MaterialOrderHandler) HandleInsertMaterialOrderItemsToOrder(c *fiber.Ct
 	}
 
 	if updateCount == 0 {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-			"error": "Material Order not found or not updated",
-		})
+		return NewError(fiber.StatusNotFound, "Material Order not found or not updated")
 	}
 
 	// Increase material quantities after successfully inserting the material order items to a Completed material order
-	if strings.EqualFold(materialOrder[0].Status, "completed") {
-		for _, item := range materialOrderItems {
-			updatedCount, err := h.store.Material.IncreaseMaterialQuantity(c.Context(), item.Material.MaterialID, item.Quantity)
-			if err != nil {
-				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-					"error": fmt.Sprintf("Failed to increase material %s by %d, %s", item.Material.MaterialID, item.Quantity, err.Error()),
-				})
-			}
-
-			if updatedCount == 0 {
-				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-					"error": fmt.Sprintf("Failed to iecrease material %s by %d, material not found or not updated.", item.Material.MaterialID, item.Quantity),
-				})
-			}
-
+	if completed {
+		if err := h.receiveMaterials(c.Context(), materialOrder.Location, materialOrderItems); err != nil {
+			return err
+		}
+		if err := h.store.Lot.ReceiveLots(c.Context(), receivedLots(materialOrderID, materialOrderItems)); err != nil {
+			return err
 		}
 	}
 
 	// Update total amount in the material order
-	newTotalAmount = materialOrder[0].TotalAmount + newTotalAmount
+	newTotalAmount = materialOrder.TotalAmount + newTotalAmount
 	updatedMaterialOrderTotalAmount := types.MaterialOrder{
 		TotalAmount: newTotalAmount,
 	}
@@ -506,3 +588,70 @@ func (h *MaterialOrderHandler) HandleInsertMaterialOrderItemsToOrder(c *fiber.Ct
 	})
 
 }
+
+// receiveMaterials adds the materials of items to stock, at the receiving location when there
+// is one.
+func (h *MaterialOrderHandler) receiveMaterials(ctx context.Context, location *types.StockLocation, items []types.MaterialOrderItem) error {
+	for _, item := range items {
+		if location != nil {
+			if err := h.store.Stock.AddStock(ctx, types.StockMaterial, item.Material.MaterialID, *location, item.StockQuantity()); err != nil {
+				return NewError(fiber.StatusInternalServerError, fmt.Sprintf("Failed to increase material %s by %g, %s", item.Material.MaterialID, item.StockQuantity(), err.Error()))
+			}
+			continue
+		}
+
+		updatedCount, err := h.store.Material.IncreaseMaterialQuantity(ctx, item.Material.MaterialID, item.StockQuantity())
+		if err != nil {
+			return NewError(fiber.StatusInternalServerError, fmt.Sprintf("Failed to increase material %s by %g, %s", item.Material.MaterialID, item.StockQuantity(), err.Error()))
+		}
+
+		if updatedCount == 0 {
+			return NewError(fiber.StatusInternalServerError, fmt.Sprintf("Failed to increase material %s by %g, material not found or not updated.", item.Material.MaterialID, item.StockQuantity()))
+		}
+	}
+	return nil
+}
+
+// checkLotNumbers makes sure the lot numbers of items are new for their materials before
+// they are received. field is the path of items in the request, for error messages.
+func (h *MaterialOrderHandler) checkLotNumbers(ctx context.Context, field string, items []types.MaterialOrderItem) error {
+	errs := ValidationError{}
+	seen := map[string]bool{}
+	for i, item := range items {
+		if item.LotNumber == "" {
+			continue
+		}
+		path := fmt.Sprintf("%s[%d].lotNumber", field, i)
+
+		key := item.Material.MaterialID.Hex() + "\x00" + item.LotNumber
+		if seen[key] {
+			errs[path] = fmt.Sprintf("lot %s is received twice", item.LotNumber)
+			continue
+		}
+		seen[key] = true
+
+		lots, err := h.store.Lot.GetLots(ctx, bson.M{"materialId": item.Material.MaterialID, "lotNumber": item.LotNumber})
+		if err != nil {
+			return err
+		}
+		if len(lots) > 0 {
+			errs[path] = fmt.Sprintf("lot %s of material %s was already received", item.LotNumber, item.Material.Name)
+		}
+	}
+	return errs.err()
+}
+
+// receivedLots are the lots the items of a material order are received as.
+func receivedLots(materialOrderID primitive.ObjectID, items []types.MaterialOrderItem) []*types.MaterialLot {
+	lots := make([]*types.MaterialLot, 0, len(items))
+	for _, item := range items {
+		lots = append(lots, &types.MaterialLot{
+			MaterialID:      item.Material.MaterialID,
+			LotNumber:       item.LotNumber,
+			MaterialOrderID: &materialOrderID,
+			ReceivedAt:      time.Now(),
+			Quantity:        item.StockQuantity(),
+		})
+	}
+	return lots
+}
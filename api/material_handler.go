@@ -13,43 +13,47 @@ import (
 )
 
 type InsertMaterialParams struct {
-	Name         string                    `json:"name"`
+	Name         string                    `json:"name" validate:"required"`
 	Color        string                    `json:"color"`
 	Type         string                    `json:"type"`
 	Size         string                    `json:"size"`
-	Quantity     int                       `json:"quantity"`
+	Unit         string                    `json:"unit"`
+	Quantity     float64                   `json:"quantity" validate:"min=0"`
+	Units        []UnitConversionParams    `json:"units"`
 	Remarks      string                    `json:"remarks"`
 	PriceHistory []InsertPriceHistoryEntry `json:"price_history"`
 }
 
 type InsertPriceHistoryEntry struct {
-	Price     float64 `json:"price"`
-	UpdatedAt string  `json:"updated_at"`
+	Price     float64 `json:"price" validate:"min=0"`
+	UpdatedAt string  `json:"updated_at" validate:"required,date"`
 }
 
 func (p InsertMaterialParams) validate() error {
-
-	return nil
+	return validateStruct(p)
 }
 
 type UpdateMaterialParams struct {
-	Name         string                    `json:"name"`
-	Color        string                    `json:"color"`
-	Type         string                    `json:"type"`
-	Size         string                    `json:"size"`
-	Quantity     int                       `json:"quantity"`
-	Remarks      string                    `json:"remarks"`
+	Name    string                 `json:"name" validate:"required"`
+	Color   string                 `json:"color"`
+	Type    string                 `json:"type"`
+	Size    string                 `json:"size"`
+	Unit    string                 `json:"unit"`
+	Units   []UnitConversionParams `json:"units"`
+	Remarks string                 `json:"remarks"`
+	// PriceHistory lists new prices to record. They are appended to the stored history.
 	PriceHistory []UpdatePriceHistoryEntry `json:"price_history"`
+	// Quantity is read-only, like that of products.
+	Quantity float64 `json:"quantity"`
 }
 
 type UpdatePriceHistoryEntry struct {
-	Price     float64 `json:"price"`
-	UpdatedAt string  `json:"updated_at"`
+	Price     float64 `json:"price" validate:"min=0"`
+	UpdatedAt string  `json:"updated_at" validate:"required,date"`
 }
 
 func (p UpdateMaterialParams) validate() error {
-
-	return nil
+	return validateStruct(p)
 }
 
 type MaterialHandler struct {
@@ -66,7 +70,11 @@ func NewMaterialHandler(store *db.Store) *MaterialHandler {
 // @Summary Get materials
 // @Description Get a list of materials based on the provided filters.
 // @Tags Material
+// @Param includeArchived query string false "true to include archived records, only to list just the archived ones"
+// @Param format query string false "Response format: json (default), csv or xlsx. The Accept header is used when omitted"
 // @Produce json
+// @Produce text/csv
+// @Produce application/vnd.openxmlformats-officedocument.spreadsheetml.sheet
 // @Param id query string false "Material ID (optional)"
 // @Param name query string false "Material name (optional)"
 // @Param color query string false "Material color (optional)"
@@ -88,9 +96,7 @@ func (h *MaterialHandler) HandleGetMaterials(c *fiber.Ctx) error {
 	if id != "" {
 		objID, err := primitive.ObjectIDFromHex(id)
 		if err != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "Invalid worker ID",
-			})
+			return NewError(fiber.StatusBadRequest, "Invalid worker ID")
 		}
 		filter["_id"] = objID
 	}
@@ -110,20 +116,50 @@ func (h *MaterialHandler) HandleGetMaterials(c *fiber.Ctx) error {
 		filter["remarks"] = remarks
 	}
 
+	if err := archivedFilter(c, filter); err != nil {
+		return err
+	}
+
+	if format := exportFormat(c); format != "" {
+		return streamExport(c, "materials", format, filter, h.store.Material.IterateMaterials, materialExportHeader, materialExportRecords)
+	}
+
 	materials, err := h.store.Material.GetMaterials(c.Context(), filter)
 	if err != nil {
 		return err
 	}
 
 	if len(materials) == 0 {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-			"error": "No Matches data found",
-		})
+		return NewError(fiber.StatusNotFound, "No Matches data found")
+	}
+
+	if len(materials) == 1 {
+		setETag(c, materials[0].Version)
 	}
 
 	return c.JSON(materials)
 }
 
+// HandleGetMaterial retrieves a material by ID.
+// @Summary Get material
+// @Description Get a material by ID. The ETag header carries its version, to be sent back as If-Match when updating it.
+// @Tags Material
+// @Produce json
+// @Param id path string true "Material ID"
+// @Param includeArchived query string false "true to find the record even if archived"
+// @Success 200 {object} types.Material
+// @Header 200 {string} ETag "Version of the material"
+// @Router /material/{id} [get]
+func (h *MaterialHandler) HandleGetMaterial(c *fiber.Ctx) error {
+	material, err := findRecord(c, "Material", h.store.Material.GetMaterial)
+	if err != nil {
+		return err
+	}
+
+	setETag(c, material.Version)
+	return c.JSON(material)
+}
+
 // HandleInsertMaterial inserts a new material into the system.
 // @Summary Insert material
 // @Description Insert a new material into the system.
@@ -143,6 +179,20 @@ func (h *MaterialHandler) HandleInsertMaterial(c *fiber.Ctx) error {
 		return err
 	}
 
+	lookups := append([]lookupValue{
+		{field: "color", kind: types.LookupColor, value: &params.Color},
+		{field: "type", kind: types.LookupType, value: &params.Type},
+		{field: "size", kind: types.LookupSize, value: &params.Size},
+	}, unitLookups(&params.Unit, params.Units, nil)...)
+	if err := checkLookups(c.Context(), h.store.Lookup, lookups...); err != nil {
+		return err
+	}
+
+	units, err := materialUnits(params.Unit, params.Units)
+	if err != nil {
+		return err
+	}
+
 	var insertedPriceHistory []types.PriceHistoryEntry
 
 	if len(params.PriceHistory) > 0 {
@@ -163,7 +213,9 @@ func (h *MaterialHandler) HandleInsertMaterial(c *fiber.Ctx) error {
 		Color:        params.Color,
 		Type:         params.Type,
 		Size:         params.Size,
+		Unit:         params.Unit,
 		Quantity:     params.Quantity,
+		Units:        units,
 		Remarks:      params.Remarks,
 		PriceHistory: insertedPriceHistory,
 	}
@@ -180,29 +232,70 @@ func (h *MaterialHandler) HandleInsertMaterial(c *fiber.Ctx) error {
 
 // HandleUpdateMaterial updates an existing material in the system.
 // @Summary Update material
-// @Description Update an existing material in the system.
+// @Description Update an existing material in the system. Entries in price_history are appended to the stored history. The body is a JSON merge patch: fields left out keep their value and null clears a field. The quantity cannot be changed here; stock changes through stocktakes and stock adjustments.
 // @Tags Material
-// @Accept json
+// @Accept json,application/merge-patch+json
 // @Produce json
 // @Param id path string true "Material ID"
-// @Param body body UpdateMaterialParams true "Updated material details"
+// @Param If-Match header string true "ETag of the material as last read"
+// @Param body body UpdateMaterialParams true "Fields to change"
 // @Success 200 {object} fiber.Map
+// @Failure 412 {object} fiber.Map
+// @Failure 428 {object} fiber.Map
 // @Router /material/{id} [patch]
 func (h *MaterialHandler) HandleUpdateMaterial(c *fiber.Ctx) error {
 	id := c.Params("id")
 	materialID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return NewError(fiber.StatusBadRequest, "Invalid material ID")
+	}
+
+	version, err := ifMatchVersion(c)
+	if err != nil {
+		return err
+	}
+
+	current, err := findRecord(c, "Material", h.store.Material.GetMaterial)
 	if err != nil {
 		return err
 	}
+	if current.Version != version {
+		return versionMismatch("Material")
+	}
+	// The price history is append-only, so the patch lists only the prices to add.
+	base := *current
+	base.PriceHistory = nil
 
 	var params UpdateMaterialParams
-	if err := c.BodyParser(&params); err != nil {
+	if err := bindMergePatch(c, &base, &params); err != nil {
 		return err
 	}
 
 	if err := params.validate(); err != nil {
 		return err
 	}
+	if params.Quantity != current.Quantity {
+		return ValidationError{"quantity": readOnlyQuantity}
+	}
+
+	lookups := append([]lookupValue{
+		{field: "color", kind: types.LookupColor, value: &params.Color, current: current.Color},
+		{field: "type", kind: types.LookupType, value: &params.Type, current: current.Type},
+		{field: "size", kind: types.LookupSize, value: &params.Size, current: current.Size},
+	}, unitLookups(&params.Unit, params.Units, current)...)
+	if err := checkLookups(c.Context(), h.store.Lookup, lookups...); err != nil {
+		return err
+	}
+
+	// The stock and the price history count the base unit, so it is fixed once there is stock.
+	if params.Unit != current.Unit && current.Quantity != 0 {
+		return ValidationError{"unit": fmt.Sprintf("the base unit cannot change from %s while the material has %g in stock", current.Unit, current.Quantity)}
+	}
+
+	units, err := materialUnits(params.Unit, params.Units)
+	if err != nil {
+		return err
+	}
 
 	var updatedPriceHistory []types.PriceHistoryEntry
 
@@ -224,21 +317,21 @@ func (h *MaterialHandler) HandleUpdateMaterial(c *fiber.Ctx) error {
 		Color:        params.Color,
 		Type:         params.Type,
 		Size:         params.Size,
-		Quantity:     params.Quantity,
+		Unit:         params.Unit,
+		Units:        units,
 		Remarks:      params.Remarks,
 		PriceHistory: updatedPriceHistory,
 	}
 
-	updateCount, err := h.store.Material.UpdateMaterial(c.Context(), materialID, &updatedMaterial)
+	updateCount, err := h.store.Material.UpdateMaterial(c.Context(), materialID, &updatedMaterial, version)
 	if err != nil {
-		return err
+		return updateError("Material", err)
 	}
 
 	if updateCount == 0 {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-			"error": "Material not found or not updated",
-		})
+		return NewError(fiber.StatusNotFound, "Material not found or not updated")
 	}
+	setETag(c, version+1)
 	return c.JSON(fiber.Map{
 		"message": "Material updaated successfully",
 	})
@@ -246,9 +339,11 @@ func (h *MaterialHandler) HandleUpdateMaterial(c *fiber.Ctx) error {
 
 // HandleDeleteMaterial deletes a material from the system.
 // @Summary Delete material
-// @Description Delete a material from the system.
+// @Description Archives a material, hiding it from lists until it is restored or purged. Materials still used by material orders or product bills of materials are not archived; the response is 409 listing the references.
+// @Description Admins can pass mode=cascade to archive those material orders and remove the material from the products, or mode=archive to archive the material regardless.
 // @Tags Material
 // @Param id path string true "Material ID"
+// @Param mode query string false "cascade or archive, admins only"
 // @Success 200 {object} fiber.Map
 // @Router /material/{id} [delete]
 func (h *MaterialHandler) HandleDeleteMaterial(c *fiber.Ctx) error {
@@ -256,25 +351,39 @@ func (h *MaterialHandler) HandleDeleteMaterial(c *fiber.Ctx) error {
 
 	objID, err := primitive.ObjectIDFromHex(materialID)
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Invalid material ID",
-		})
+		return NewError(fiber.StatusBadRequest, "Invalid material ID")
 	}
 
-	deleteCount, err := h.store.Material.DeleteMaterial(c.Context(), objID)
+	opts, err := deleteOptions(c)
 	if err != nil {
 		return err
 	}
+
+	deleteCount, err := h.store.Material.DeleteMaterial(c.Context(), objID, opts)
+	if err != nil {
+		return deleteError(c, "Material", err)
+	}
 	if deleteCount == 0 {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-			"error": "Material not found",
-		})
+		return NewError(fiber.StatusNotFound, "Material not found")
 	}
 	return c.JSON(fiber.Map{
-		"message": "Material deleted successfully",
+		"message": deletedMessage("Material"),
 	})
 }
 
+// HandleRestoreMaterial restores an archived material.
+//
+// @Summary Restore material
+// @Description Brings back an archived material.
+// @Tags Material
+// @Param id path string true "Material ID"
+// @Produce json
+// @Success 200 {object} fiber.Map
+// @Router /material/{id}/restore [post]
+func (h *MaterialHandler) HandleRestoreMaterial(c *fiber.Ctx) error {
+	return restoreRecord(c, "Material", h.store.Material.RestoreMaterial)
+}
+
 // HandleGetMaterialColors retrieves a list of unique material colors.
 // @Summary Get material colors
 // @Description Get a list of unique material colors.
@@ -287,9 +396,7 @@ func (h *MaterialHandler) HandleGetMaterialColors(c *fiber.Ctx) error {
 	materialType := c.Query("type")
 	colors, err := h.store.Material.GetMaterialColors(c.Context(), materialType)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to retrieve material colors",
-		})
+		return NewError(fiber.StatusInternalServerError, "Failed to retrieve material colors")
 	}
 
 	return c.JSON(colors)
@@ -305,9 +412,7 @@ func (h *MaterialHandler) HandleGetMaterialColors(c *fiber.Ctx) error {
 func (h *MaterialHandler) HandleGetMaterialTypes(c *fiber.Ctx) error {
 	types, err := h.store.Material.GetMaterialTypes(c.Context())
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to retrieve material types",
-		})
+		return NewError(fiber.StatusInternalServerError, "Failed to retrieve material types")
 	}
 
 	return c.JSON(types)
@@ -325,9 +430,7 @@ func (h *MaterialHandler) HandleGetMaterialSizes(c *fiber.Ctx) error {
 	materialType := c.Query("type")
 	sizes, err := h.store.Material.GetMaterialSizes(c.Context(), materialType)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to retrieve material sizes",
-		})
+		return NewError(fiber.StatusInternalServerError, "Failed to retrieve material sizes")
 	}
 
 	return c.JSON(sizes)
@@ -1,6 +1,8 @@
 package api
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"strings"
 	"time"
@@ -11,50 +13,95 @@ import (
 	"github.com/gofiber/fiber/v2"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 type InsertOrderParams struct {
-	CustomerID      string                  `json:"customerId"`
-	CustomerName    string                  `json:"customerName"`
-	OrderDate       string                  `json:"orderDate"`
-	DeliveryDate    string                  `json:"deliveryDate"`
-	PaymentDate     string                  `json:"paymentDate"`
-	TotalAmount     float64                 `json:"totalAmount"`
-	Status          string                  `json:"status"`
-	ShippingAddress string                  `json:"shippingAddress"`
-	OrderItems      []InsertOrderItemParams `json:"orderItems"`
+	CustomerID      string  `json:"customerId" validate:"required,objectid"`
+	CustomerName    string  `json:"customerName" validate:"required"`
+	OrderDate       string  `json:"orderDate" validate:"required,date"`
+	DeliveryDate    string  `json:"deliveryDate" validate:"date"`
+	PaymentDate     string  `json:"paymentDate" validate:"date"`
+	TotalAmount     float64 `json:"totalAmount" validate:"min=0"`
+	Status          string  `json:"status" validate:"enumfold=orderStatus"`
+	ShippingAddress string  `json:"shippingAddress"`
+	// ShippingAddressID picks one of the customer's shipping addresses. When neither it nor
+	// ShippingAddress is given, the customer's default shipping address is used.
+	ShippingAddressID string `json:"shippingAddressId" validate:"objectid"`
+	// WarehouseID and Bin pick the location the products are taken from. The default warehouse
+	// is used when they are left out.
+	WarehouseID string                  `json:"warehouseId" validate:"objectid"`
+	Bin         string                  `json:"bin"`
+	OrderItems  []InsertOrderItemParams `json:"orderItems"`
 }
 
 type InsertOrderItemParams struct {
 	Product    InsertOrderProductParams `json:"product"`
-	Quantity   int                      `json:"quantity"`
-	TotalPrice float64                  `json:"totalPrice"`
+	Quantity   int                      `json:"quantity" validate:"gt=0"`
+	TotalPrice float64                  `json:"totalPrice" validate:"min=0"`
 }
 
 type InsertOrderProductParams struct {
-	ID        string  `json:"id"`
+	ID        string  `json:"id" validate:"required,objectid"`
 	SKU       string  `json:"sku"`
-	Name      string  `json:"name"`
-	UnitPrice float64 `json:"unitPrice"`
+	Name      string  `json:"name" validate:"required"`
+	UnitPrice float64 `json:"unitPrice" validate:"min=0"`
 }
 
 func (p InsertOrderParams) validate() error {
+	if err := validateStruct(p); err != nil {
+		return err
+	}
+	// Orders may be entered as shipped when their stock went out before they were recorded,
+	// but only shipments leave an order partially shipped.
+	if strings.EqualFold(p.Status, types.OrderPartiallyShipped) {
+		return ValidationError{"status": "is set by shipments"}
+	}
 	return nil
 }
 
 type UpdateOrderParams struct {
-	CustomerID      string  `json:"customerId"`
-	CustomerName    string  `json:"customerName"`
-	OrderDate       string  `json:"orderDate"`
-	DeliveryDate    string  `json:"deliveryDate"`
-	PaymentDate     string  `json:"paymentDate"`
-	TotalAmount     float64 `json:"totalAmount"`
-	Status          string  `json:"status"`
+	CustomerID      string  `json:"customerId" validate:"required,objectid"`
+	CustomerName    string  `json:"customerName" validate:"required"`
+	OrderDate       string  `json:"orderDate" validate:"required,date"`
+	DeliveryDate    string  `json:"deliveryDate" validate:"date"`
+	PaymentDate     string  `json:"paymentDate" validate:"date"`
+	TotalAmount     float64 `json:"totalAmount" validate:"min=0"`
+	Status          string  `json:"status" validate:"enumfold=orderStatus"`
 	ShippingAddress string  `json:"shippingAddress"`
+	// ShippingAddressID picks one of the customer's shipping addresses and refreshes the snapshot.
+	ShippingAddressID string `json:"shippingAddressId" validate:"objectid"`
 }
 
 func (p UpdateOrderParams) validate() error {
-	return nil
+	return validateStruct(p)
+}
+
+// checkStatusChange rejects status changes only shipments may make. Shipments move an order
+// to partially shipped and shipped while taking its stock, so an order can't be set to either
+// by hand, can't be delivered or completed before it shipped and can't go back to open once
+// anything went out. Canceled orders stay canceled, as their stock was given back.
+func checkStatusChange(from, to string) error {
+	if strings.EqualFold(from, to) {
+		return nil
+	}
+
+	var message string
+	switch {
+	case strings.EqualFold(from, types.OrderCanceled):
+		message = "a canceled order can't be reopened"
+	case strings.EqualFold(to, types.OrderCanceled):
+		return nil
+	case strings.EqualFold(to, types.OrderPartiallyShipped) || strings.EqualFold(to, types.OrderShipped):
+		message = "is set by shipments"
+	case types.OrderShippedStatus(to) && !types.OrderShippedStatus(from):
+		message = "only shipped orders can be delivered or completed"
+	case !types.OrderShippedStatus(to) && (strings.EqualFold(from, types.OrderPartiallyShipped) || types.OrderShippedStatus(from)):
+		message = "can't go back to an open status once the order has shipped"
+	default:
+		return nil
+	}
+	return ValidationError{"status": message}
 }
 
 type OrderHandler struct {
@@ -75,7 +122,11 @@ func NewOrderHandler(store *db.Store) *OrderHandler {
 // @Param id query string false "Order ID"
 // @Param customerId query string false "Customer ID"
 // @Param status query string false "Order status"
+// @Param includeArchived query string false "true to include archived records, only to list just the archived ones"
+// @Param format query string false "Response format: json (default), csv or xlsx. The Accept header is used when omitted"
 // @Produce json
+// @Produce text/csv
+// @Produce application/vnd.openxmlformats-officedocument.spreadsheetml.sheet
 // @Success 200 {array} types.Order
 // @Router /order [get]
 func (h *OrderHandler) HandleGetOrders(c *fiber.Ctx) error {
@@ -88,9 +139,7 @@ func (h *OrderHandler) HandleGetOrders(c *fiber.Ctx) error {
 	if id != "" {
 		objID, err := primitive.ObjectIDFromHex(id)
 		if err != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "Invalid order ID",
-			})
+			return NewError(fiber.StatusBadRequest, "Invalid order ID")
 		}
 		filter["_id"] = objID
 	}
@@ -98,9 +147,7 @@ func (h *OrderHandler) HandleGetOrders(c *fiber.Ctx) error {
 	if customerID != "" {
 		objID, err := primitive.ObjectIDFromHex(customerID)
 		if err != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "Invalid customer ID",
-			})
+			return NewError(fiber.StatusBadRequest, "Invalid customer ID")
 		}
 		filter["customerId"] = objID
 	}
@@ -109,24 +156,54 @@ func (h *OrderHandler) HandleGetOrders(c *fiber.Ctx) error {
 		filter["status"] = status
 	}
 
+	if err := archivedFilter(c, filter); err != nil {
+		return err
+	}
+
+	if format := exportFormat(c); format != "" {
+		return streamExport(c, "orders", format, filter, h.store.Order.IterateOrders, orderExportHeader, orderExportRecords)
+	}
+
 	orders, err := h.store.Order.GetOrders(c.Context(), filter)
 	if err != nil {
 		return err
 	}
 
 	if len(orders) == 0 {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-			"error": "No Matches data found",
-		})
+		return NewError(fiber.StatusNotFound, "No Matches data found")
+	}
+
+	if len(orders) == 1 {
+		setETag(c, orders[0].Version)
 	}
 
 	return c.JSON(orders)
 }
 
+// HandleGetOrder retrieves a order by ID.
+// @Summary Get order
+// @Description Get a order by ID. The ETag header carries its version, to be sent back as If-Match when updating it.
+// @Tags Order
+// @Produce json
+// @Param id path string true "Order ID"
+// @Param includeArchived query string false "true to find the record even if archived"
+// @Success 200 {object} types.Order
+// @Header 200 {string} ETag "Version of the order"
+// @Router /order/{id} [get]
+func (h *OrderHandler) HandleGetOrder(c *fiber.Ctx) error {
+	order, err := findRecord(c, "Order", h.store.Order.GetOrder)
+	if err != nil {
+		return err
+	}
+
+	setETag(c, order.Version)
+	return c.JSON(order)
+}
+
 // HandleInsertOrder inserts a new order.
 //
 // @Summary Insert order
-// @Description Inserts a new order.
+// @Description Inserts a new order. The products of open orders are reserved until the order ships, when they are taken out of stock; orders inserted as shipped, delivered or completed take them at once.
 // @Tags Order
 // @Accept json
 // @Produce json
@@ -145,25 +222,19 @@ func (h *OrderHandler) HandleInsertOrder(c *fiber.Ctx) error {
 
 	customerID, err := primitive.ObjectIDFromHex(params.CustomerID)
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Invalid customer ID",
-		})
+		return NewError(fiber.StatusBadRequest, "Invalid customer ID")
 	}
 
 	orderDateParsed, err := time.Parse(time.RFC3339Nano, params.OrderDate)
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Invalid order date format",
-		})
+		return NewError(fiber.StatusBadRequest, "Invalid order date format")
 	}
 
 	orderItems := make([]types.OrderItem, len(params.OrderItems))
 	for i, item := range params.OrderItems {
 		productID, err := primitive.ObjectIDFromHex(item.Product.ID)
 		if err != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "Invalid product ID",
-			})
+			return NewError(fiber.StatusBadRequest, "Invalid product ID")
 		}
 
 		orderItems[i] = types.OrderItem{
@@ -188,12 +259,18 @@ func (h *OrderHandler) HandleInsertOrder(c *fiber.Ctx) error {
 		OrderItems:      orderItems,
 	}
 
+	if params.ShippingAddressID != "" || params.ShippingAddress == "" {
+		address, err := h.shippingAddress(c, customerID, params.ShippingAddressID)
+		if err != nil {
+			return NewError(fiber.StatusBadRequest, err.Error())
+		}
+		setShippingAddress(&order, address)
+	}
+
 	if params.DeliveryDate != "" {
 		deliveryDateParsed, err := time.Parse(time.RFC3339Nano, params.DeliveryDate)
 		if err != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "Invalid delivery date format",
-			})
+			return NewError(fiber.StatusBadRequest, "Invalid delivery date format")
 		}
 		order.DeliveryDate = deliveryDateParsed
 	}
@@ -201,33 +278,31 @@ func (h *OrderHandler) HandleInsertOrder(c *fiber.Ctx) error {
 	if params.PaymentDate != "" {
 		paymentDateParsed, err := time.Parse(time.RFC3339Nano, params.PaymentDate)
 		if err != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "Invalid payment date format",
-			})
+			return NewError(fiber.StatusBadRequest, "Invalid payment date format")
 		}
 		order.PaymentDate = paymentDateParsed
 	}
 
-	inserted, err := h.store.Order.InsertOrder(c.Context(), &order)
+	location, err := stockLocation(c.Context(), h.store.Warehouse, "", params.WarehouseID, params.Bin)
 	if err != nil {
 		return err
 	}
+	order.Location = location
 
-	// Decrease product quantities after successfully inserting the order
-	for _, item := range orderItems {
-		updatedCount, err := h.store.Product.DecreaseProductQuantity(c.Context(), item.Product.ID, item.Quantity)
-		if err != nil {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"error": fmt.Sprintf("Failed to decrease product %s by %d, %s", item.Product.ID, item.Quantity, err.Error()),
-			})
-		}
-
-		if updatedCount == 0 {
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"error": fmt.Sprintf("Failed to decrease product %s by %d", item.Product.ID, item.Quantity),
-			})
+	// Open orders reserve their products until they ship; orders entered as shipped take them
+	// at once and canceled ones leave stock alone.
+	if !strings.EqualFold(order.Status, types.OrderCanceled) {
+		if err := h.checkStock(c.Context(), orderItems, location); err != nil {
+			return err
 		}
+	}
 
+	inserted, err := h.store.Order.PlaceOrder(c.Context(), &order)
+	if errors.Is(err, db.ErrInsufficientStock) {
+		return ErrInsufficientStock(err.Error())
+	}
+	if err != nil {
+		return err
 	}
 
 	return c.JSON(fiber.Map{
@@ -237,56 +312,70 @@ func (h *OrderHandler) HandleInsertOrder(c *fiber.Ctx) error {
 
 // HandleUpdateOrder updates an existing order in the system.
 // @Summary Update order
-// @Description Update an existing order in the system.
+// @Description Update an existing order in the system. The body is a JSON merge patch: fields left out keep their value and null clears a field. Partially shipped and shipped are set by shipments, which take the reserved stock; a shipped order can then be marked delivered or completed. Canceling an order releases the reservation or returns what was taken, and a canceled order can't be reopened.
 // @Tags Order
-// @Accept json
+// @Accept json,application/merge-patch+json
 // @Produce json
 // @Param id path string true "Order ID"
-// @Param body body UpdateOrderParams true "Updated order details"
+// @Param If-Match header string true "ETag of the order as last read"
+// @Param body body UpdateOrderParams true "Fields to change"
 // @Success 200 {object} fiber.Map
+// @Failure 412 {object} fiber.Map
+// @Failure 428 {object} fiber.Map
 // @Router /order/{id} [patch]
 func (h *OrderHandler) HandleUpdateOrder(c *fiber.Ctx) error {
 	id := c.Params("id")
 	orderID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return NewError(fiber.StatusBadRequest, "Invalid order ID")
+	}
+
+	version, err := ifMatchVersion(c)
 	if err != nil {
 		return err
 	}
 
+	current, err := findRecord(c, "Order", h.store.Order.GetOrder)
+	if err != nil {
+		return err
+	}
+	if current.Version != version {
+		return versionMismatch("Order")
+	}
+
 	var params UpdateOrderParams
-	if err := c.BodyParser(&params); err != nil {
+	if err := bindMergePatch(c, current, &params); err != nil {
 		return err
 	}
 
 	if err := params.validate(); err != nil {
 		return err
 	}
+	if err := checkStatusChange(current.Status, params.Status); err != nil {
+		return err
+	}
 
 	customerID, err := primitive.ObjectIDFromHex(params.CustomerID)
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Invalid customer ID",
-		})
+		return NewError(fiber.StatusBadRequest, "Invalid customer ID")
 	}
 
 	orderDateParsed, err := time.Parse(time.RFC3339Nano, params.OrderDate)
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Invalid order date format",
-		})
+		return NewError(fiber.StatusBadRequest, "Invalid order date format")
 	}
 
-	deliveryDateParsed, err := time.Parse(time.RFC3339Nano, params.DeliveryDate)
-	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Invalid order date format",
-		})
+	// The delivery and payment dates can be cleared with null, leaving them unset.
+	var deliveryDateParsed, paymentDateParsed time.Time
+	if params.DeliveryDate != "" {
+		if deliveryDateParsed, err = time.Parse(time.RFC3339Nano, params.DeliveryDate); err != nil {
+			return NewError(fiber.StatusBadRequest, "Invalid delivery date format")
+		}
 	}
-
-	paymentDateParsed, err := time.Parse(time.RFC3339Nano, params.PaymentDate)
-	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Invalid order date format",
-		})
+	if params.PaymentDate != "" {
+		if paymentDateParsed, err = time.Parse(time.RFC3339Nano, params.PaymentDate); err != nil {
+			return NewError(fiber.StatusBadRequest, "Invalid payment date format")
+		}
 	}
 
 	updatedOrder := types.Order{
@@ -300,61 +389,34 @@ func (h *OrderHandler) HandleUpdateOrder(c *fiber.Ctx) error {
 		ShippingAddress: params.ShippingAddress,
 	}
 
-	if params.DeliveryDate != "" {
-		deliveryDateParsed, err := time.Parse(time.RFC3339Nano, params.DeliveryDate)
+	switch {
+	case params.ShippingAddressID != "" && params.ShippingAddressID != current.ShippingAddressID.Hex():
+		address, err := h.shippingAddress(c, customerID, params.ShippingAddressID)
 		if err != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "Invalid delivery date format",
-			})
+			return NewError(fiber.StatusBadRequest, err.Error())
 		}
-		updatedOrder.DeliveryDate = deliveryDateParsed
+		setShippingAddress(&updatedOrder, address)
+	case params.ShippingAddress == current.ShippingAddress && customerID == current.CustomerID:
+		// The address is unchanged, keep the stored reference and snapshot.
+		updatedOrder.ShippingAddressID = current.ShippingAddressID
+		updatedOrder.ShippingAddressSnapshot = current.ShippingAddressSnapshot
 	}
 
-	if params.PaymentDate != "" {
-		paymentDateParsed, err := time.Parse(time.RFC3339Nano, params.PaymentDate)
-		if err != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "Invalid payment date format",
-			})
-		}
-		updatedOrder.PaymentDate = paymentDateParsed
-	}
-
-	// Caching the original order before updating
-	existingOrder, err := h.store.Order.GetOrders(c.Context(), bson.M{"_id": orderID})
-	if err != nil {
-		return err
+	// Canceling an order gives back its stock in the same transaction as the update, so only
+	// one of two concurrent cancellations restocks.
+	updateCount, err := h.store.Order.UpdateOrder(c.Context(), orderID, &updatedOrder, version)
+	if errors.Is(err, db.ErrInsufficientStock) {
+		return ErrInsufficientStock(err.Error())
 	}
-
-	updateCount, err := h.store.Order.UpdateOrder(c.Context(), orderID, &updatedOrder)
 	if err != nil {
-		return err
+		return updateError("Order", err)
 	}
 
 	if updateCount == 0 {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-			"error": "Order not found or not updated",
-		})
-	}
-
-	// Update product quantities if order status is changed to canceled
-	if !strings.EqualFold(existingOrder[0].Status, "canceled") && strings.EqualFold(params.Status, "canceled") {
-		for _, item := range existingOrder[0].OrderItems {
-			updatedCount, err := h.store.Product.IncreaseProductQuantity(c.Context(), item.Product.ID, item.Quantity)
-			if err != nil {
-				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-					"error": fmt.Sprintf("Failed to increase product %s by %d, %s", item.Product.ID, item.Quantity, err.Error()),
-				})
-			}
-
-			if updatedCount == 0 {
-				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-					"error": fmt.Sprintf("Failed to increase product %s by %d", item.Product.ID, item.Quantity),
-				})
-			}
-		}
+		return NewError(fiber.StatusNotFound, "Order not found or not updated")
 	}
 
+	setETag(c, version+1)
 	return c.JSON(fiber.Map{
 		"message": "Order updated successfully",
 	})
@@ -363,9 +425,11 @@ func (h *OrderHandler) HandleUpdateOrder(c *fiber.Ctx) error {
 // HandleDeleteOrder deletes an order by ID.
 //
 // @Summary Delete order
-// @Description Deletes an order by ID.
+// @Description Archives an order by ID. An open order releases the stock it reserved. Archived orders are hidden from lists and can be restored until they are purged.
+// @Description Orders with shipments, customer returns or credit notes are not archived; the response is 409 listing the references. Admins can pass mode=archive to archive the order regardless, but it is not purged while they exist.
 // @Tags Order
 // @Param id path string true "Order ID"
+// @Param mode query string false "archive, admins only"
 // @Produce json
 // @Success 200 {object} fiber.Map
 // @Router /order/{id} [delete]
@@ -374,31 +438,45 @@ func (h *OrderHandler) HandleDeleteOrder(c *fiber.Ctx) error {
 
 	objID, err := primitive.ObjectIDFromHex(orderID)
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Invalid order ID",
-		})
+		return NewError(fiber.StatusBadRequest, "Invalid order ID")
 	}
 
-	deleteCount, err := h.store.Order.DeleteOrder(c.Context(), objID)
+	opts, err := deleteOptions(c)
+	if err != nil {
+		return err
+	}
+
+	deleteCount, err := h.store.Order.DeleteOrder(c.Context(), objID, opts)
 	if err != nil {
 		return err
 	}
 	if deleteCount == 0 {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-			"error": "Material not found",
-		})
+		return NewError(fiber.StatusNotFound, "Order not found")
 	}
 
 	return c.JSON(fiber.Map{
-		"message": "Order deleted successfully",
+		"message": deletedMessage("Order"),
 	})
 }
 
+// HandleRestoreOrder restores an archived order.
+//
+// @Summary Restore order
+// @Description Brings back an archived order. An open order reserves what it has left to ship again, failing with 409 when too little is in stock.
+// @Tags Order
+// @Param id path string true "Order ID"
+// @Produce json
+// @Success 200 {object} fiber.Map
+// @Router /order/{id}/restore [post]
+func (h *OrderHandler) HandleRestoreOrder(c *fiber.Ctx) error {
+	return restoreRecord(c, "Order", h.store.Order.RestoreOrder)
+}
+
 func (h *OrderHandler) HandleInsertOrderItemsToOrder(c *fiber.Ctx) error {
 	id := c.Params("id")
 	orderID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
-		return err
+		return NewError(fiber.StatusBadRequest, "Invalid order ID")
 	}
 
 	var params []InsertOrderItemParams
@@ -406,15 +484,20 @@ func (h *OrderHandler) HandleInsertOrderItemsToOrder(c *fiber.Ctx) error {
 		return err
 	}
 
-	order, err := h.store.Order.GetOrders(c.Context(), bson.M{"_id": orderID})
+	if err := validateStruct(params); err != nil {
+		return err
+	}
+
+	order, err := h.store.Order.GetOrder(c.Context(), orderID)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return NewError(fiber.StatusNotFound, "Order not found")
+	}
 	if err != nil {
 		return err
 	}
 
-	if strings.EqualFold(order[0].Status, "canceled") {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Cannot insert items to a already canceled order.",
-		})
+	if strings.EqualFold(order.Status, "canceled") {
+		return NewError(fiber.StatusBadRequest, "Cannot insert items to a already canceled order.")
 	}
 
 	newTotalAmount := 0.0
@@ -422,9 +505,7 @@ func (h *OrderHandler) HandleInsertOrderItemsToOrder(c *fiber.Ctx) error {
 	for i, item := range params {
 		productID, err := primitive.ObjectIDFromHex(item.Product.ID)
 		if err != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "Invalid product ID",
-			})
+			return NewError(fiber.StatusBadRequest, "Invalid product ID")
 		}
 
 		orderItems[i] = types.OrderItem{
@@ -441,54 +522,106 @@ func (h *OrderHandler) HandleInsertOrderItemsToOrder(c *fiber.Ctx) error {
 		newTotalAmount = newTotalAmount + item.TotalPrice
 	}
 
-	// Inset items into order
+	if err := h.checkStock(c.Context(), orderItems, order.Location); err != nil {
+		return err
+	}
+
+	// Items added to a shipped order are taken from stock, the others reserved until it ships.
+	// The total amount grows with them.
 	updatedOrder := types.Order{
 		OrderItems: orderItems,
 	}
 
 	updateCount, err := h.store.Order.InsertOrderItems(c.Context(), orderID, &updatedOrder)
+	if errors.Is(err, db.ErrInsufficientStock) {
+		return ErrInsufficientStock(err.Error())
+	}
 	if err != nil {
 		return err
 	}
 
 	if updateCount == 0 {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-			"error": "Order not found or not updated",
-		})
-	}
-
-	// Decrease product quantities after successfully inserting the order items to a non-canceled order
-	if !strings.EqualFold(order[0].Status, "canceled") {
-		for _, item := range orderItems {
-			updatedCount, err := h.store.Product.DecreaseProductQuantity(c.Context(), item.Product.ID, item.Quantity)
-			if err != nil {
-				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-					"error": fmt.Sprintf("Failed to decrease product %s by %d, %s", item.Product.ID, item.Quantity, err.Error()),
-				})
-			}
-
-			if updatedCount == 0 {
-				return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-					"error": fmt.Sprintf("Failed to decrease product %s by %d, product not found or not updated.", item.Product.ID, item.Quantity),
-				})
-			}
+		return NewError(fiber.StatusNotFound, "Order not found or not updated")
+	}
+	newTotalAmount = order.TotalAmount + newTotalAmount
 
+	return c.JSON(fiber.Map{
+		"message": fmt.Sprintf("Inserted Order items into order id: %s successfully, new total amount: %f", orderID.Hex(), newTotalAmount),
+	})
+
+}
+
+// shippingAddress looks up a shipping address of the customer. Without an address ID it returns
+// the customer's default shipping address, or nil when the customer has none on file.
+func (h *OrderHandler) shippingAddress(c *fiber.Ctx, customerID primitive.ObjectID, addressID string) (*types.PartyAddress, error) {
+	customer, err := h.store.Party.GetParty(c.Context(), customerID)
+	if err == mongo.ErrNoDocuments {
+		if addressID == "" {
+			return nil, nil
 		}
+		return nil, fmt.Errorf("Customer %s not found", customerID.Hex())
+	}
+	if err != nil {
+		return nil, err
 	}
 
-	// Update total amount in the order
-	newTotalAmount = order[0].TotalAmount + newTotalAmount
-	updatedOrderTotalAmount := types.Order{
-		TotalAmount: newTotalAmount,
+	if addressID == "" {
+		return customer.DefaultAddress(types.AddressShipping), nil
 	}
 
-	_, err = h.store.Order.UpdateOrderTotalAmount(c.Context(), orderID, &updatedOrderTotalAmount)
+	objID, err := primitive.ObjectIDFromHex(addressID)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("Invalid shipping address ID")
+	}
+	address := customer.FindAddress(objID)
+	if address == nil || address.Label != types.AddressShipping {
+		return nil, fmt.Errorf("Customer %s has no shipping address %s", customerID.Hex(), addressID)
 	}
+	return address, nil
+}
 
-	return c.JSON(fiber.Map{
-		"message": fmt.Sprintf("Inserted Order items into order id: %s successfully, new total amount: %f", orderID.Hex(), newTotalAmount),
-	})
+// setShippingAddress points the order at the address and stores a copy of it.
+func setShippingAddress(order *types.Order, address *types.PartyAddress) {
+	if address == nil {
+		return
+	}
+	snapshot := *address
+	order.ShippingAddressID = address.ID
+	order.ShippingAddressSnapshot = &snapshot
+	order.ShippingAddress = address.Address
+}
+
+// checkStock makes sure every product of items has enough available, that is in stock and not
+// reserved for other orders, before an order reserves or takes it, so an order is not saved
+// when its stock cannot be had. With a location the stock has to be there.
+func (h *OrderHandler) checkStock(ctx context.Context, items []types.OrderItem, location *types.StockLocation) error {
+	needed := map[primitive.ObjectID]int{}
+	for _, item := range items {
+		needed[item.Product.ID] += item.Quantity
+	}
+
+	for id, quantity := range needed {
+		product, err := h.store.Product.GetProduct(ctx, id)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return NewError(fiber.StatusNotFound, fmt.Sprintf("Product %s not found", id.Hex()))
+		}
+		if err != nil {
+			return err
+		}
+		if product.Available < quantity {
+			return ErrInsufficientStock(fmt.Sprintf("Product %s has %d available, %d ordered", product.Name, product.Available, quantity))
+		}
 
+		if location == nil {
+			continue
+		}
+		held, err := stockAt(ctx, h.store.Stock, types.StockProduct, id, *location)
+		if err != nil {
+			return err
+		}
+		if held < float64(quantity) {
+			return ErrInsufficientStock(fmt.Sprintf("Product %s has %g available at %s, %d ordered", product.Name, held, locationName(*location), quantity))
+		}
+	}
+	return nil
 }
@@ -0,0 +1,38 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/johnson7543/ims/types"
+)
+
+func TestCheckStatusChange(t *testing.T) {
+	tests := []struct {
+		from, to string
+		ok       bool
+	}{
+		{types.OrderPending, types.OrderPending, true},
+		{types.OrderPending, types.OrderProcessing, true},
+		{types.OrderProcessing, types.OrderPending, true},
+		{types.OrderPending, types.OrderCanceled, true},
+		{types.OrderShipped, types.OrderCanceled, true},
+		{types.OrderShipped, types.OrderDelivered, true},
+		{types.OrderDelivered, types.OrderCompleted, true},
+		{types.OrderCompleted, types.OrderDelivered, true},
+		{types.OrderShipped, "Shipped", true},
+		{types.OrderCanceled, types.OrderPending, false},
+		{types.OrderPending, types.OrderShipped, false},
+		{types.OrderProcessing, types.OrderPartiallyShipped, false},
+		{types.OrderPending, types.OrderDelivered, false},
+		{types.OrderPartiallyShipped, types.OrderCompleted, false},
+		{types.OrderPartiallyShipped, types.OrderProcessing, false},
+		{types.OrderDelivered, types.OrderPending, false},
+	}
+
+	for _, tt := range tests {
+		err := checkStatusChange(tt.from, tt.to)
+		if (err == nil) != tt.ok {
+			t.Errorf("checkStatusChange(%q, %q) = %v, want ok %v", tt.from, tt.to, err, tt.ok)
+		}
+	}
+}
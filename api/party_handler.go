@@ -0,0 +1,488 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/johnson7543/ims/db"
+	"github.com/johnson7543/ims/types"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type InsertPartyParams struct {
+	Company     string               `json:"company"`
+	Name        string               `json:"name"`
+	Phone       string               `json:"phone"`
+	Address     string               `json:"address"`
+	TaxIdNumber string               `json:"taxIdNumber" validate:"taxid"`
+	Roles       []string             `json:"roles" validate:"enum=role"`
+	Contacts    []PartyContactParams `json:"contacts"`
+	Addresses   []PartyAddressParams `json:"addresses"`
+}
+
+func (p InsertPartyParams) validate() error {
+	return validateParty(p, p.Addresses)
+}
+
+type UpdatePartyParams struct {
+	Company     string               `json:"company" form:"company"`
+	Name        string               `json:"name" form:"name"`
+	Phone       string               `json:"phone" form:"phone"`
+	Address     string               `json:"address" form:"address"`
+	TaxIdNumber string               `json:"taxIdNumber" form:"taxIdNumber" validate:"taxid"`
+	Roles       []string             `json:"roles" form:"roles" validate:"enum=role"`
+	Contacts    []PartyContactParams `json:"contacts" form:"contacts"`
+	Addresses   []PartyAddressParams `json:"addresses" form:"addresses"`
+}
+
+func (p *UpdatePartyParams) validate() error {
+	return validateParty(p, p.Addresses)
+}
+
+type PartyContactParams struct {
+	Name  string `json:"name" validate:"required"`
+	Phone string `json:"phone"`
+	Email string `json:"email" validate:"email"`
+	Role  string `json:"role"`
+}
+
+// PartyAddressParams is a labeled address. Leave id empty to add an address; send the id of a
+// stored address to keep it, so orders referring to it stay linked.
+type PartyAddressParams struct {
+	ID        string `json:"id" validate:"objectid"`
+	Label     string `json:"label" validate:"required,enum=addressLabel"`
+	Recipient string `json:"recipient"`
+	Phone     string `json:"phone"`
+	Address   string `json:"address" validate:"required"`
+	IsDefault bool   `json:"isDefault"`
+}
+
+// validateParty checks the validate tags of params and that each address label has at most
+// one default address.
+func validateParty(params any, addresses []PartyAddressParams) error {
+	errs := fieldErrors(params)
+	defaults := map[string]bool{}
+	for i, address := range addresses {
+		if !address.IsDefault {
+			continue
+		}
+		if defaults[address.Label] {
+			errs[fmt.Sprintf("addresses[%d].isDefault", i)] = fmt.Sprintf("only one %s address can be the default", address.Label)
+		}
+		defaults[address.Label] = true
+	}
+	return errs.err()
+}
+
+func partyContacts(params []PartyContactParams) []types.PartyContact {
+	contacts := make([]types.PartyContact, len(params))
+	for i, p := range params {
+		contacts[i] = types.PartyContact{
+			Name:  p.Name,
+			Phone: p.Phone,
+			Email: p.Email,
+			Role:  p.Role,
+		}
+	}
+	return contacts
+}
+
+// partyAddresses converts validated address params, giving new addresses an ID.
+func partyAddresses(params []PartyAddressParams) []types.PartyAddress {
+	addresses := make([]types.PartyAddress, len(params))
+	for i, p := range params {
+		id, err := primitive.ObjectIDFromHex(p.ID)
+		if err != nil {
+			id = primitive.NewObjectID()
+		}
+		addresses[i] = types.PartyAddress{
+			ID:        id,
+			Label:     p.Label,
+			Recipient: p.Recipient,
+			Phone:     p.Phone,
+			Address:   p.Address,
+			IsDefault: p.IsDefault,
+		}
+	}
+	return addresses
+}
+
+// roleLabel names the entity a route works on in response messages.
+func roleLabel(role string) string {
+	if role == "" {
+		return "Party"
+	}
+	return strings.ToUpper(role[:1]) + role[1:]
+}
+
+// PartyHandler serves the /party routes and the /customer, /buyer, /seller and /worker
+// routes, which are views of the parties holding that role. Every handler is built for a
+// role; an empty role means the unfiltered /party routes.
+type PartyHandler struct {
+	store *db.Store
+}
+
+func NewPartyHandler(store *db.Store) *PartyHandler {
+	return &PartyHandler{
+		store: store,
+	}
+}
+
+// HandleGetParties retrieves a list of parties based on query parameters.
+//
+// @Summary Get parties
+// @Description Retrieves a list of parties based on query parameters. The customer, buyer, seller and worker routes only return parties holding that role.
+// @Tags Party
+// @Param id query string false "Party ID"
+// @Param company query string false "Company name"
+// @Param name query string false "Contact name"
+// @Param phone query string false "Phone number"
+// @Param address query string false "Address"
+// @Param taxIdNumber query string false "Tax ID number"
+// @Param role query string false "Role, only on /party: customer, buyer, seller or worker"
+// @Param includeArchived query string false "true to include archived records, only to list just the archived ones"
+// @Param format query string false "Response format: json (default), csv or xlsx. The Accept header is used when omitted"
+// @Produce json
+// @Produce text/csv
+// @Produce application/vnd.openxmlformats-officedocument.spreadsheetml.sheet
+// @Success 200 {array} types.Party
+// @Router /party [get]
+// @Router /customer [get]
+// @Router /buyer [get]
+// @Router /seller [get]
+// @Router /worker [get]
+func (h *PartyHandler) HandleGetParties(role string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id := c.Query("id")
+		company := c.Query("company")
+		name := c.Query("name")
+		phone := c.Query("phone")
+		address := c.Query("address")
+		taxIdNumber := c.Query("taxIdNumber")
+
+		filter := bson.M{}
+
+		if id != "" {
+			objID, err := primitive.ObjectIDFromHex(id)
+			if err != nil {
+				return NewError(fiber.StatusBadRequest, fmt.Sprintf("Invalid %s ID", strings.ToLower(roleLabel(role))))
+			}
+			filter["_id"] = objID
+		}
+		if company != "" {
+			filter["company"] = company
+		}
+		if name != "" {
+			filter["name"] = name
+		}
+		if phone != "" {
+			filter["phone"] = phone
+		}
+		if address != "" {
+			filter["address"] = address
+		}
+		if taxIdNumber != "" {
+			filter["taxIdNumber"] = taxIdNumber
+		}
+
+		filterRole := role
+		if filterRole == "" {
+			filterRole = c.Query("role")
+		}
+		if filterRole != "" {
+			filter["roles"] = bson.M{"$eq": filterRole}
+		}
+
+		if err := archivedFilter(c, filter); err != nil {
+			return err
+		}
+
+		if format := exportFormat(c); format != "" {
+			name := "parties"
+			if role != "" {
+				name = role + "s"
+			}
+			return streamExport(c, name, format, filter, h.store.Party.IterateParties, partyExportHeader, partyExportRecords)
+		}
+
+		parties, err := h.store.Party.GetParties(c.Context(), filter)
+		if err != nil {
+			return err
+		}
+
+		if len(parties) == 0 {
+			return NewError(fiber.StatusNotFound, "No Matches data found")
+		}
+
+		if len(parties) == 1 {
+			setETag(c, parties[0].Version)
+		}
+
+		return c.JSON(parties)
+	}
+}
+
+// HandleGetParty retrieves a party by ID.
+// @Summary Get party
+// @Description Get a party by ID. The ETag header carries its version, to be sent back as If-Match when updating it. The customer, buyer, seller and worker routes only find parties holding that role.
+// @Tags Party
+// @Produce json
+// @Param id path string true "Party ID"
+// @Param includeArchived query string false "true to find the record even if archived"
+// @Success 200 {object} types.Party
+// @Header 200 {string} ETag "Version of the party"
+// @Router /party/{id} [get]
+// @Router /customer/{id} [get]
+// @Router /buyer/{id} [get]
+// @Router /seller/{id} [get]
+// @Router /worker/{id} [get]
+func (h *PartyHandler) HandleGetParty(role string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		party, err := findRecord(c, roleLabel(role), h.store.Party.GetParty)
+		if err != nil {
+			return err
+		}
+		if role != "" && !party.HasRole(role) {
+			return NewError(fiber.StatusNotFound, fmt.Sprintf("%s not found", roleLabel(role)))
+		}
+
+		setETag(c, party.Version)
+		return c.JSON(party)
+	}
+}
+
+// HandleInsertParty inserts a new party.
+//
+// @Summary Insert party
+// @Description Inserts a new party. Posting to the customer, buyer, seller or worker route gives the party that role;
+// @Description when a party with the same tax ID number already exists, the role is added to it instead.
+// @Description A tax ID number must be a unified business number (統一編號) with a valid checksum.
+// @Tags Party
+// @Accept json
+// @Produce json
+// @Param party body InsertPartyParams true "Party information"
+// @Success 200 {object} fiber.Map
+// @Router /party [post]
+// @Router /customer [post]
+// @Router /buyer [post]
+// @Router /seller [post]
+// @Router /worker [post]
+func (h *PartyHandler) HandleInsertParty(role string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var params InsertPartyParams
+		if err := c.BodyParser(&params); err != nil {
+			return err
+		}
+
+		if err := params.validate(); err != nil {
+			return err
+		}
+
+		roles := params.Roles
+		if role != "" {
+			roles = []string{role}
+		}
+		if len(roles) == 0 {
+			return NewError(fiber.StatusBadRequest, "A party needs at least one role")
+		}
+
+		if role != "" && params.TaxIdNumber != "" {
+			existing, err := h.store.Party.GetPartyByTaxIdNumber(c.Context(), params.TaxIdNumber)
+			if err != nil && err != mongo.ErrNoDocuments {
+				return err
+			}
+			if existing != nil {
+				if _, err := h.store.Party.AddPartyRole(c.Context(), existing.ID, role); err != nil {
+					return err
+				}
+				return c.JSON(fiber.Map{
+					"message": fmt.Sprintf("%s role added to existing party, ID: %s, Name: %s", roleLabel(role), existing.ID.Hex(), existing.Name),
+				})
+			}
+		}
+
+		party := types.Party{
+			Company:     params.Company,
+			Name:        params.Name,
+			Phone:       params.Phone,
+			Address:     params.Address,
+			TaxIdNumber: params.TaxIdNumber,
+			Roles:       roles,
+			Contacts:    partyContacts(params.Contacts),
+			Addresses:   partyAddresses(params.Addresses),
+		}
+
+		inserted, err := h.store.Party.InsertParty(c.Context(), &party)
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(fiber.Map{
+			"message": fmt.Sprintf("%s inserted successfully, ID: %s, Name: %s", roleLabel(role), inserted.ID.Hex(), inserted.Name),
+		})
+	}
+}
+
+// HandleUpdateParty updates an existing party in the system.
+// @Summary Update party
+// @Description Update an existing party in the system. Roles can only be changed through the party route. The body is a JSON merge patch: fields left out keep their value and null clears a field.
+// @Tags Party
+// @Accept json,application/merge-patch+json
+// @Produce json
+// @Param id path string true "Party ID"
+// @Param If-Match header string true "ETag of the party as last read"
+// @Param body body UpdatePartyParams true "Fields to change"
+// @Success 200 {object} fiber.Map
+// @Failure 412 {object} fiber.Map
+// @Failure 428 {object} fiber.Map
+// @Router /party/{id} [patch]
+// @Router /customer/{id} [patch]
+// @Router /buyer/{id} [patch]
+// @Router /seller/{id} [patch]
+// @Router /worker/{id} [patch]
+func (h *PartyHandler) HandleUpdateParty(role string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		id := c.Params("id")
+		partyID, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			return NewError(fiber.StatusBadRequest, "Invalid party ID")
+		}
+
+		version, err := ifMatchVersion(c)
+		if err != nil {
+			return err
+		}
+
+		existing, err := h.store.Party.GetParty(c.Context(), partyID)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return err
+		}
+		if existing == nil || (role != "" && !existing.HasRole(role)) {
+			return NewError(fiber.StatusNotFound, fmt.Sprintf("%s not found", roleLabel(role)))
+		}
+		if existing.Version != version {
+			return versionMismatch(roleLabel(role))
+		}
+
+		var params UpdatePartyParams
+		if err := bindMergePatch(c, existing, &params); err != nil {
+			return err
+		}
+
+		if err := params.validate(); err != nil {
+			return err
+		}
+
+		// Roles are only changed through the party route, where the patch can't leave none.
+		roles := existing.Roles
+		if role == "" {
+			if len(params.Roles) == 0 {
+				return ValidationError{"roles": "a party needs at least one role"}
+			}
+			roles = params.Roles
+		}
+
+		updatedParty := types.Party{
+			Company:     params.Company,
+			Name:        params.Name,
+			Phone:       params.Phone,
+			Address:     params.Address,
+			TaxIdNumber: params.TaxIdNumber,
+			Roles:       roles,
+			Contacts:    partyContacts(params.Contacts),
+			Addresses:   partyAddresses(params.Addresses),
+		}
+
+		if _, err := h.store.Party.UpdateParty(c.Context(), partyID, &updatedParty, version); err != nil {
+			return updateError(roleLabel(role), err)
+		}
+
+		setETag(c, version+1)
+
+		return c.JSON(fiber.Map{
+			"message": fmt.Sprintf("%s updated successfully", roleLabel(role)),
+		})
+	}
+}
+
+// HandleDeleteParty deletes a party by ID.
+//
+// @Summary Delete party
+// @Description Deleting through the customer, buyer, seller or worker route removes that role and archives the party once it has no roles left.
+// @Description Deleting through the party route archives the party with all its roles. Archived parties are hidden from lists and can be restored until they are purged.
+// @Description Parties still referenced by orders, processing items or material orders are not deleted; the response is 409 listing the references.
+// @Description Admins can pass mode=cascade to archive the referencing documents as well, or mode=archive to archive the party regardless.
+// @Tags Party
+// @Param id path string true "Party ID"
+// @Param mode query string false "cascade or archive, admins only"
+// @Produce json
+// @Success 200 {object} fiber.Map
+// @Router /party/{id} [delete]
+// @Router /customer/{id} [delete]
+// @Router /buyer/{id} [delete]
+// @Router /seller/{id} [delete]
+// @Router /worker/{id} [delete]
+func (h *PartyHandler) HandleDeleteParty(role string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		partyID := c.Params("id")
+
+		objID, err := primitive.ObjectIDFromHex(partyID)
+		if err != nil {
+			return NewError(fiber.StatusBadRequest, fmt.Sprintf("Invalid %s ID", strings.ToLower(roleLabel(role))))
+		}
+
+		opts, err := deleteOptions(c)
+		if err != nil {
+			return err
+		}
+
+		if role != "" {
+			party, err := h.store.Party.GetParty(c.Context(), objID)
+			if err != nil && err != mongo.ErrNoDocuments {
+				return err
+			}
+			if party == nil || !party.HasRole(role) {
+				return NewError(fiber.StatusNotFound, fmt.Sprintf("%s not found", roleLabel(role)))
+			}
+
+			// Removing the last role deletes the party, which is checked against every reference below.
+			if len(party.Roles) > 1 {
+				if _, err := h.store.Party.RemovePartyRole(c.Context(), objID, role, opts); err != nil {
+					return deleteError(c, roleLabel(role), err)
+				}
+				return c.JSON(fiber.Map{
+					"message": fmt.Sprintf("%s role removed, the party keeps its other roles", roleLabel(role)),
+				})
+			}
+		}
+
+		deleteCount, err := h.store.Party.DeleteParty(c.Context(), objID, opts)
+		if err != nil {
+			return deleteError(c, roleLabel(role), err)
+		}
+		if deleteCount == 0 {
+			return NewError(fiber.StatusNotFound, fmt.Sprintf("%s not found", roleLabel(role)))
+		}
+
+		return c.JSON(fiber.Map{
+			"message": deletedMessage(roleLabel(role)),
+		})
+	}
+}
+
+// HandleRestoreParty restores an archived party with the roles it had.
+//
+// @Summary Restore party
+// @Description Brings back an archived party with the roles it had.
+// @Tags Party
+// @Param id path string true "Party ID"
+// @Produce json
+// @Success 200 {object} fiber.Map
+// @Router /party/{id}/restore [post]
+func (h *PartyHandler) HandleRestoreParty(c *fiber.Ctx) error {
+	return restoreRecord(c, "Party", h.store.Party.RestoreParty)
+}
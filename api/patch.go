@@ -0,0 +1,84 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// mimeMergePatch is the media type of a JSON merge patch (RFC 7396).
+const mimeMergePatch = "application/merge-patch+json"
+
+// bindMergePatch fills params with the current record and then applies the request body to it
+// as a JSON merge patch: fields the body leaves out keep their stored value, fields set to null
+// are cleared and nested objects are merged. params must use the JSON names of the record;
+// read-only fields such as id and version are dropped because params has no place for them.
+func bindMergePatch(c *fiber.Ctx, current, params any) error {
+	contentType := strings.ToLower(strings.TrimSpace(strings.Split(string(c.Request().Header.ContentType()), ";")[0]))
+	if contentType != mimeMergePatch && contentType != fiber.MIMEApplicationJSON {
+		return NewError(fiber.StatusUnsupportedMediaType, fmt.Sprintf("PATCH expects %s", mimeMergePatch))
+	}
+
+	var patch any
+	if err := json.Unmarshal(c.Body(), &patch); err != nil {
+		return NewError(fiber.StatusBadRequest, "Invalid JSON merge patch")
+	}
+	if _, ok := patch.(map[string]any); !ok {
+		return NewError(fiber.StatusBadRequest, "JSON merge patch must be an object")
+	}
+
+	doc, err := json.Marshal(current)
+	if err != nil {
+		return err
+	}
+	var target any
+	if err := json.Unmarshal(doc, &target); err != nil {
+		return err
+	}
+
+	merged, err := json.Marshal(mergePatch(target, patch))
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(merged, params); err != nil {
+		return NewError(fiber.StatusBadRequest, fmt.Sprintf("Invalid merge patch: %v", err))
+	}
+	return nil
+}
+
+// mergePatch applies patch to target following RFC 7396. Keys are matched case-insensitively
+// when there is no exact match, like encoding/json does when decoding into a struct.
+func mergePatch(target, patch any) any {
+	patchObj, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+	targetObj, ok := target.(map[string]any)
+	if !ok {
+		targetObj = map[string]any{}
+	}
+
+	for key, value := range patchObj {
+		key = matchKey(targetObj, key)
+		if value == nil {
+			delete(targetObj, key)
+			continue
+		}
+		targetObj[key] = mergePatch(targetObj[key], value)
+	}
+	return targetObj
+}
+
+func matchKey(obj map[string]any, key string) string {
+	if _, ok := obj[key]; ok {
+		return key
+	}
+	for k := range obj {
+		if strings.EqualFold(k, key) {
+			return k
+		}
+	}
+	return key
+}
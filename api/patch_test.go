@@ -0,0 +1,104 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestMergePatch(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		patch  string
+		want   string
+	}{
+		{"replaces a field", `{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{"adds a field", `{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{"null removes a field", `{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{"null on a missing field", `{"a":"b"}`, `{"c":null}`, `{"a":"b"}`},
+		{"arrays are replaced", `{"a":["b"]}`, `{"a":["c","d"]}`, `{"a":["c","d"]}`},
+		{"empty array is kept", `{"a":["b"]}`, `{"a":[]}`, `{"a":[]}`},
+		{"objects are merged", `{"a":{"b":"c","d":"e"}}`, `{"a":{"b":"f"}}`, `{"a":{"b":"f","d":"e"}}`},
+		{"null inside an object", `{"a":{"b":"c","d":"e"}}`, `{"a":{"b":null}}`, `{"a":{"d":"e"}}`},
+		{"object replaces a value", `{"a":"b"}`, `{"a":{"c":"d"}}`, `{"a":{"c":"d"}}`},
+		{"value replaces an object", `{"a":{"b":"c"}}`, `{"a":1}`, `{"a":1}`},
+		{"keys match ignoring case", `{"customerName":"a"}`, `{"CustomerName":"b"}`, `{"customerName":"b"}`},
+		{"exact key wins", `{"name":"a","Name":"b"}`, `{"Name":"c"}`, `{"name":"a","Name":"c"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var target, patch, want any
+			mustUnmarshal(t, tt.target, &target)
+			mustUnmarshal(t, tt.patch, &patch)
+			mustUnmarshal(t, tt.want, &want)
+
+			if got := mergePatch(target, patch); !reflect.DeepEqual(got, want) {
+				t.Errorf("mergePatch(%s, %s) = %v, want %v", tt.target, tt.patch, got, want)
+			}
+		})
+	}
+}
+
+func mustUnmarshal(t *testing.T, s string, v any) {
+	t.Helper()
+	if err := json.Unmarshal([]byte(s), v); err != nil {
+		t.Fatalf("unmarshal %s: %v", s, err)
+	}
+}
+
+func TestBindMergePatch(t *testing.T) {
+	type record struct {
+		Name   string   `json:"name"`
+		Remark string   `json:"remark"`
+		Roles  []string `json:"roles"`
+	}
+	current := record{Name: "Acme", Remark: "old", Roles: []string{"customer"}}
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		wantStatus  int
+		want        record
+	}{
+		{"fields left out keep their value", mimeMergePatch, `{"name":"Beta"}`, fiber.StatusOK, record{Name: "Beta", Remark: "old", Roles: []string{"customer"}}},
+		{"null clears a field", mimeMergePatch, `{"remark":null}`, fiber.StatusOK, record{Name: "Acme", Roles: []string{"customer"}}},
+		{"empty array is applied", fiber.MIMEApplicationJSON, `{"roles":[]}`, fiber.StatusOK, record{Name: "Acme", Remark: "old", Roles: []string{}}},
+		{"other media types are refused", fiber.MIMETextPlain, `{"name":"Beta"}`, fiber.StatusUnsupportedMediaType, record{}},
+		{"malformed JSON", mimeMergePatch, `{"name":`, fiber.StatusBadRequest, record{}},
+		{"patch must be an object", mimeMergePatch, `["name"]`, fiber.StatusBadRequest, record{}},
+		{"wrong value type", mimeMergePatch, `{"name":1}`, fiber.StatusBadRequest, record{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got record
+			app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+			app.Patch("/", func(c *fiber.Ctx) error {
+				if err := bindMergePatch(c, current, &got); err != nil {
+					return err
+				}
+				return c.SendStatus(fiber.StatusOK)
+			})
+
+			req := httptest.NewRequest(fiber.MethodPatch, "/", strings.NewReader(tt.body))
+			req.Header.Set(fiber.HeaderContentType, tt.contentType)
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if tt.wantStatus == fiber.StatusOK && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("params = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -14,38 +14,35 @@ import (
 )
 
 type InsertProcessingItemParams struct {
-	Name       string  `json:"name"`
-	Quantity   int     `json:"quantity"`
-	Price      float64 `json:"price"`
-	WorkerID   string  `json:"workerId"`
+	Name       string  `json:"name" validate:"required"`
+	Quantity   int     `json:"quantity" validate:"gt=0"`
+	Price      float64 `json:"price" validate:"min=0"`
+	WorkerID   string  `json:"workerId" validate:"required,objectid"`
 	WorkerName string  `json:"workerName"`
-	StartDate  string  `json:"startDate"`
-	EndDate    string  `json:"endDate"`
+	StartDate  string  `json:"startDate" validate:"date"`
+	EndDate    string  `json:"endDate" validate:"date"`
 	SKU        string  `json:"sku"`
 	Remarks    string  `json:"remarks"`
 }
 
 func (p InsertProcessingItemParams) validate() error {
-	// Add validation logic if needed
-	return nil
+	return validateStruct(p)
 }
 
 type UpdateProcessingItemParams struct {
-	Name       string  `json:"name"`
-	Quantity   int     `json:"quantity"`
-	Price      float64 `json:"price"`
-	WorkerID   string  `json:"workerId"`
+	Name       string  `json:"name" validate:"required"`
+	Quantity   int     `json:"quantity" validate:"gt=0"`
+	Price      float64 `json:"price" validate:"min=0"`
+	WorkerID   string  `json:"workerId" validate:"required,objectid"`
 	WorkerName string  `json:"workerName"`
-	StartDate  string  `json:"startDate"`
-	EndDate    string  `json:"endDate"`
+	StartDate  string  `json:"startDate" validate:"date"`
+	EndDate    string  `json:"endDate" validate:"date"`
 	SKU        string  `json:"sku"`
 	Remarks    string  `json:"remarks"`
 }
 
 func (p *UpdateProcessingItemParams) validate() error {
-	// You can add validation logic here if needed.
-	// For example, validate that the date formats are correct.
-	return nil
+	return validateStruct(p)
 }
 
 type ProcessingItemHandler struct {
@@ -72,7 +69,11 @@ func NewProcessingItemHandler(store *db.Store) *ProcessingItemHandler {
 // @Param endDate query string false "End date (format: YYYY-MM-DD)"
 // @Param sku query string false "Product ID"
 // @Param remarks query string false "Remarks"
+// @Param includeArchived query string false "true to include archived records, only to list just the archived ones"
+// @Param format query string false "Response format: json (default), csv or xlsx. The Accept header is used when omitted"
 // @Produce json
+// @Produce text/csv
+// @Produce application/vnd.openxmlformats-officedocument.spreadsheetml.sheet
 // @Success 200 {array} types.ProcessingItem
 // @Router /processingItem [get]
 func (h *ProcessingItemHandler) HandleGetProcessingItems(c *fiber.Ctx) error {
@@ -92,9 +93,7 @@ func (h *ProcessingItemHandler) HandleGetProcessingItems(c *fiber.Ctx) error {
 	if id != "" {
 		objID, err := primitive.ObjectIDFromHex(id)
 		if err != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "Invalid processing item ID",
-			})
+			return NewError(fiber.StatusBadRequest, "Invalid processing item ID")
 		}
 		filter["_id"] = objID
 	}
@@ -104,27 +103,21 @@ func (h *ProcessingItemHandler) HandleGetProcessingItems(c *fiber.Ctx) error {
 	if quantity != "" {
 		quantity, err := strconv.Atoi(quantity)
 		if err != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "Invalid quantity value",
-			})
+			return NewError(fiber.StatusBadRequest, "Invalid quantity value")
 		}
 		filter["quantity"] = quantity
 	}
 	if price != "" {
 		price, err := strconv.ParseFloat(price, 64)
 		if err != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "Invalid price value",
-			})
+			return NewError(fiber.StatusBadRequest, "Invalid price value")
 		}
 		filter["price"] = price
 	}
 	if workerID != "" {
 		objID, err := primitive.ObjectIDFromHex(workerID)
 		if err != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "Invalid worker ID",
-			})
+			return NewError(fiber.StatusBadRequest, "Invalid worker ID")
 		}
 		filter["workerId"] = objID
 	}
@@ -134,22 +127,16 @@ func (h *ProcessingItemHandler) HandleGetProcessingItems(c *fiber.Ctx) error {
 	if startDate != "" {
 		startDateParsed, err := time.Parse(time.RFC3339Nano, startDate)
 		if err != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "Invalid start date format",
-			})
+			return NewError(fiber.StatusBadRequest, "Invalid start date format")
 		}
 		filter["startDate"] = startDateParsed
 		if endDate != "" {
 			endDateParsed, err := time.Parse(time.RFC3339Nano, endDate)
 			if err != nil {
-				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-					"error": "Invalid end date format",
-				})
+				return NewError(fiber.StatusBadRequest, "Invalid end date format")
 			}
 			if startDateParsed.After(endDateParsed) {
-				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-					"error": "Start date cannot be after end date",
-				})
+				return NewError(fiber.StatusBadRequest, "Start date cannot be after end date")
 			}
 			filter["endDate"] = endDateParsed
 		}
@@ -161,20 +148,50 @@ func (h *ProcessingItemHandler) HandleGetProcessingItems(c *fiber.Ctx) error {
 		filter["remarks"] = remarks
 	}
 
+	if err := archivedFilter(c, filter); err != nil {
+		return err
+	}
+
+	if format := exportFormat(c); format != "" {
+		return streamExport(c, "processing_items", format, filter, h.store.ProcessingItem.IterateProcessingItems, processingItemExportHeader, processingItemExportRecords)
+	}
+
 	processingItems, err := h.store.ProcessingItem.GetProcessingItems(c.Context(), filter)
 	if err != nil {
 		return err
 	}
 
 	if len(processingItems) == 0 {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-			"error": "No Matches data found",
-		})
+		return NewError(fiber.StatusNotFound, "No Matches data found")
+	}
+
+	if len(processingItems) == 1 {
+		setETag(c, processingItems[0].Version)
 	}
 
 	return c.JSON(processingItems)
 }
 
+// HandleGetProcessingItem retrieves a processing item by ID.
+// @Summary Get processing item
+// @Description Get a processing item by ID. The ETag header carries its version, to be sent back as If-Match when updating it.
+// @Tags Processing Item
+// @Produce json
+// @Param id path string true "Processing Item ID"
+// @Param includeArchived query string false "true to find the record even if archived"
+// @Success 200 {object} types.ProcessingItem
+// @Header 200 {string} ETag "Version of the processing item"
+// @Router /processingItem/{id} [get]
+func (h *ProcessingItemHandler) HandleGetProcessingItem(c *fiber.Ctx) error {
+	processingItem, err := findRecord(c, "Processing Item", h.store.ProcessingItem.GetProcessingItem)
+	if err != nil {
+		return err
+	}
+
+	setETag(c, processingItem.Version)
+	return c.JSON(processingItem)
+}
+
 // HandleInsertProcessingItem inserts a new processing item.
 //
 // @Summary Insert processing item
@@ -197,9 +214,7 @@ func (h *ProcessingItemHandler) HandleInsertProcessingItem(c *fiber.Ctx) error {
 
 	workerID, err := primitive.ObjectIDFromHex(params.WorkerID)
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Invalid worker ID",
-		})
+		return NewError(fiber.StatusBadRequest, "Invalid worker ID")
 	}
 
 	processingItem := types.ProcessingItem{
@@ -215,9 +230,7 @@ func (h *ProcessingItemHandler) HandleInsertProcessingItem(c *fiber.Ctx) error {
 	if params.StartDate != "" {
 		startDateParsed, err := time.Parse(time.RFC3339Nano, params.StartDate)
 		if err != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "Invalid start date format",
-			})
+			return NewError(fiber.StatusBadRequest, "Invalid start date format")
 		}
 		processingItem.StartDate = startDateParsed
 	}
@@ -225,9 +238,7 @@ func (h *ProcessingItemHandler) HandleInsertProcessingItem(c *fiber.Ctx) error {
 	if params.EndDate != "" {
 		endDateParsed, err := time.Parse(time.RFC3339Nano, params.EndDate)
 		if err != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "Invalid end date format",
-			})
+			return NewError(fiber.StatusBadRequest, "Invalid end date format")
 		}
 		processingItem.EndDate = endDateParsed
 	}
@@ -245,25 +256,39 @@ func (h *ProcessingItemHandler) HandleInsertProcessingItem(c *fiber.Ctx) error {
 // HandleUpdateProcessingItem updates an existing processing item in the system.
 //
 // @Summary Update processing item
-// @Description Update an existing processing item in the system.
+// @Description Update an existing processing item in the system. The body is a JSON merge patch: fields left out keep their value and null clears a field.
 // @Tags Processing Item
-// @Accept json
+// @Accept json,application/merge-patch+json
 // @Produce json
 // @Param id path string true "Processing Item ID"
-// @Param body body UpdateProcessingItemParams true "Updated processing item details"
+// @Param If-Match header string true "ETag of the processing item as last read"
+// @Param body body UpdateProcessingItemParams true "Fields to change"
 // @Success 200 {object} fiber.Map
+// @Failure 412 {object} fiber.Map
+// @Failure 428 {object} fiber.Map
 // @Router /processingItem/{id} [patch]
 func (h *ProcessingItemHandler) HandleUpdateProcessingItem(c *fiber.Ctx) error {
 	id := c.Params("id")
 	processingItemID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Invalid Processing Item ID",
-		})
+		return NewError(fiber.StatusBadRequest, "Invalid Processing Item ID")
+	}
+
+	version, err := ifMatchVersion(c)
+	if err != nil {
+		return err
+	}
+
+	current, err := findRecord(c, "Processing Item", h.store.ProcessingItem.GetProcessingItem)
+	if err != nil {
+		return err
+	}
+	if current.Version != version {
+		return versionMismatch("Processing Item")
 	}
 
 	var params UpdateProcessingItemParams
-	if err := c.BodyParser(&params); err != nil {
+	if err := bindMergePatch(c, current, &params); err != nil {
 		return err
 	}
 
@@ -273,9 +298,7 @@ func (h *ProcessingItemHandler) HandleUpdateProcessingItem(c *fiber.Ctx) error {
 
 	workerID, err := primitive.ObjectIDFromHex(params.WorkerID)
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Invalid worker ID",
-		})
+		return NewError(fiber.StatusBadRequest, "Invalid worker ID")
 	}
 
 	updatedProcessingItem := types.ProcessingItem{
@@ -291,9 +314,7 @@ func (h *ProcessingItemHandler) HandleUpdateProcessingItem(c *fiber.Ctx) error {
 	if params.StartDate != "" {
 		startDateParsed, err := time.Parse(time.RFC3339Nano, params.StartDate)
 		if err != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "Invalid start date format",
-			})
+			return NewError(fiber.StatusBadRequest, "Invalid start date format")
 		}
 		updatedProcessingItem.StartDate = startDateParsed
 	}
@@ -301,24 +322,22 @@ func (h *ProcessingItemHandler) HandleUpdateProcessingItem(c *fiber.Ctx) error {
 	if params.EndDate != "" {
 		endDateParsed, err := time.Parse(time.RFC3339Nano, params.EndDate)
 		if err != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "Invalid end date format",
-			})
+			return NewError(fiber.StatusBadRequest, "Invalid end date format")
 		}
 		updatedProcessingItem.EndDate = endDateParsed
 	}
 
-	updateCount, err := h.store.ProcessingItem.UpdateProcessingItem(c.Context(), processingItemID, &updatedProcessingItem)
+	updateCount, err := h.store.ProcessingItem.UpdateProcessingItem(c.Context(), processingItemID, &updatedProcessingItem, version)
 	if err != nil {
-		return err
+		return updateError("Processing Item", err)
 	}
 
 	if updateCount == 0 {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-			"error": "Processing Item not found or not updated",
-		})
+		return NewError(fiber.StatusNotFound, "Processing Item not found or not updated")
 	}
 
+	setETag(c, version+1)
+
 	return c.JSON(fiber.Map{
 		"message": "Processing Item updated successfully",
 	})
@@ -327,7 +346,7 @@ func (h *ProcessingItemHandler) HandleUpdateProcessingItem(c *fiber.Ctx) error {
 // HandleDeleteProcessingItem deletes a processing item by ID.
 //
 // @Summary Delete processing item
-// @Description Deletes a processing item by ID.
+// @Description Archives a processing item by ID. Archived processing items are hidden from lists and can be restored until they are purged.
 // @Tags Processing Item
 // @Param id path string true "Processing item ID"
 // @Produce json
@@ -338,22 +357,36 @@ func (h *ProcessingItemHandler) HandleDeleteProcessingItem(c *fiber.Ctx) error {
 
 	objID, err := primitive.ObjectIDFromHex(processingItemID)
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Invalid processing item ID",
-		})
+		return NewError(fiber.StatusBadRequest, "Invalid processing item ID")
+	}
+
+	opts, err := deleteOptions(c)
+	if err != nil {
+		return err
 	}
 
-	deleteCount, err := h.store.ProcessingItem.DeleteProcessingItem(c.Context(), objID)
+	deleteCount, err := h.store.ProcessingItem.DeleteProcessingItem(c.Context(), objID, opts)
 	if err != nil {
 		return err
 	}
 	if deleteCount == 0 {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-			"error": "Material not found",
-		})
+		return NewError(fiber.StatusNotFound, "Processing item not found")
 	}
 
 	return c.JSON(fiber.Map{
-		"message": "Processing item deleted successfully",
+		"message": deletedMessage("Processing item"),
 	})
 }
+
+// HandleRestoreProcessingItem restores an archived processing item.
+//
+// @Summary Restore processing item
+// @Description Brings back an archived processing item.
+// @Tags Processing Item
+// @Param id path string true "Processing item ID"
+// @Produce json
+// @Success 200 {object} fiber.Map
+// @Router /processingItem/{id}/restore [post]
+func (h *ProcessingItemHandler) HandleRestoreProcessingItem(c *fiber.Ctx) error {
+	return restoreRecord(c, "Processing item", h.store.ProcessingItem.RestoreProcessingItem)
+}
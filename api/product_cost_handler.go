@@ -0,0 +1,312 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/johnson7543/ims/db"
+	"github.com/johnson7543/ims/types"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type ProductCostHandler struct {
+	store *db.Store
+}
+
+func NewProductCostHandler(store *db.Store) *ProductCostHandler {
+	return &ProductCostHandler{
+		store: store,
+	}
+}
+
+// HandleGetProductCosts retrieves the stored cost roll-ups.
+//
+// @Summary Get product costs
+// @Description Retrieves the rolled-up unit cost stored per SKU.
+// @Tags ProductCost
+// @Param sku query string false "Product SKU"
+// @Produce json
+// @Success 200 {array} types.ProductCost
+// @Router /productCost [get]
+func (h *ProductCostHandler) HandleGetProductCosts(c *fiber.Ctx) error {
+	filter := bson.M{}
+	if sku := c.Query("sku"); sku != "" {
+		filter["sku"] = sku
+	}
+
+	costs, err := h.store.ProductCost.GetProductCosts(c.Context(), filter)
+	if err != nil {
+		return err
+	}
+
+	if len(costs) == 0 {
+		return NewError(fiber.StatusNotFound, "No Matches data found")
+	}
+
+	return c.JSON(costs)
+}
+
+// HandleRollUpProductCosts recalculates and stores the unit cost of products.
+//
+// @Summary Roll up product costs
+// @Description Recalculates material and labor cost per SKU. Rolls up every product when no SKU is given. Materials that are missing or archived cost nothing and are listed in the warnings.
+// @Tags ProductCost
+// @Param sku query string false "Product SKU"
+// @Produce json
+// @Success 200 {array} types.ProductCost
+// @Router /productCost/rollup [post]
+func (h *ProductCostHandler) HandleRollUpProductCosts(c *fiber.Ctx) error {
+	filter := bson.M{}
+	if sku := c.Query("sku"); sku != "" {
+		filter["sku"] = bson.M{"$eq": sku}
+	}
+
+	products, err := h.store.Product.GetProducts(c.Context(), filter)
+	if err != nil {
+		return err
+	}
+
+	if len(products) == 0 {
+		return NewError(fiber.StatusNotFound, "No Matches data found")
+	}
+
+	materialPrices := map[primitive.ObjectID]*types.Material{}
+	costs := make([]*types.ProductCost, 0, len(products))
+	for _, product := range products {
+		cost, err := h.rollUpProductCost(c, product, materialPrices)
+		if err != nil {
+			return err
+		}
+
+		stored, err := h.store.ProductCost.UpsertProductCost(c.Context(), cost)
+		if err != nil {
+			return err
+		}
+		costs = append(costs, stored)
+	}
+
+	return c.JSON(costs)
+}
+
+// HandleGetProductMargins reports the margin of every product's sell price over its rolled-up cost.
+//
+// @Summary Get product margins
+// @Description Compares Product.Price against the rolled-up unit cost per SKU.
+// @Tags ProductCost
+// @Param sku query string false "Product SKU"
+// @Produce json
+// @Success 200 {array} types.ProductMargin
+// @Router /productCost/margin/product [get]
+func (h *ProductCostHandler) HandleGetProductMargins(c *fiber.Ctx) error {
+	filter := bson.M{}
+	if sku := c.Query("sku"); sku != "" {
+		filter["sku"] = bson.M{"$eq": sku}
+	}
+
+	products, err := h.store.Product.GetProducts(c.Context(), filter)
+	if err != nil {
+		return err
+	}
+
+	costs, err := h.costsBySKU(c)
+	if err != nil {
+		return err
+	}
+
+	margins := make([]types.ProductMargin, 0, len(products))
+	for _, product := range products {
+		margin := types.ProductMargin{
+			SKU:         product.SKU,
+			ProductName: product.Name,
+			Price:       product.Price,
+		}
+		if cost, ok := costs[product.SKU]; ok {
+			margin.CostAvailable = true
+			margin.UnitCost = cost.UnitCost
+			margin.Margin = roundMoney(product.Price - cost.UnitCost)
+			margin.MarginPercent = marginPercent(product.Price, cost.UnitCost)
+		}
+		margins = append(margins, margin)
+	}
+
+	return c.JSON(margins)
+}
+
+// HandleGetOrderLineMargins reports the margin of each order line over the rolled-up cost.
+//
+// @Summary Get order line margins
+// @Description Compares OrderItem unit prices against the rolled-up unit cost per SKU.
+// @Tags ProductCost
+// @Param id query string false "Order ID"
+// @Param customerId query string false "Customer ID"
+// @Produce json
+// @Success 200 {array} types.OrderLineMargin
+// @Router /productCost/margin/order [get]
+func (h *ProductCostHandler) HandleGetOrderLineMargins(c *fiber.Ctx) error {
+	filter := bson.M{}
+	if id := c.Query("id"); id != "" {
+		objID, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			return NewError(fiber.StatusBadRequest, "Invalid order ID")
+		}
+		filter["_id"] = objID
+	}
+	if customerID := c.Query("customerId"); customerID != "" {
+		objID, err := primitive.ObjectIDFromHex(customerID)
+		if err != nil {
+			return NewError(fiber.StatusBadRequest, "Invalid customer ID")
+		}
+		filter["customerId"] = objID
+	}
+
+	orders, err := h.store.Order.GetOrders(c.Context(), filter)
+	if err != nil {
+		return err
+	}
+
+	costs, err := h.costsBySKU(c)
+	if err != nil {
+		return err
+	}
+
+	margins := []types.OrderLineMargin{}
+	for _, order := range orders {
+		for _, item := range order.OrderItems {
+			margin := types.OrderLineMargin{
+				OrderID:      order.ID,
+				CustomerName: order.CustomerName,
+				OrderDate:    order.OrderDate,
+				SKU:          item.Product.SKU,
+				ProductName:  item.Product.Name,
+				Quantity:     item.Quantity,
+				UnitPrice:    item.Product.UnitPrice,
+			}
+			if cost, ok := costs[item.Product.SKU]; ok {
+				margin.CostAvailable = true
+				margin.UnitCost = cost.UnitCost
+				margin.Margin = roundMoney(item.Product.UnitPrice - cost.UnitCost)
+				margin.MarginPercent = marginPercent(item.Product.UnitPrice, cost.UnitCost)
+				margin.TotalMargin = roundMoney(margin.Margin * float64(item.Quantity))
+			}
+			margins = append(margins, margin)
+		}
+	}
+
+	return c.JSON(margins)
+}
+
+// rollUpProductCost combines the latest purchase price of every material the product uses
+// with the quantity-weighted average labor price of its processing items. Materials that were
+// deleted or archived are costed at zero and reported in the warnings. materials caches the
+// materials looked up, holding nil for missing ones.
+func (h *ProductCostHandler) rollUpProductCost(c *fiber.Ctx, product *types.Product, materials map[primitive.ObjectID]*types.Material) (*types.ProductCost, error) {
+	cost := &types.ProductCost{
+		SKU:          product.SKU,
+		ProductID:    product.ID,
+		ProductName:  product.Name,
+		CalculatedAt: time.Now(),
+	}
+
+	for _, usage := range product.MaterialUsage {
+		material, ok := materials[usage.MaterialID]
+		if !ok {
+			m, err := h.store.Material.GetMaterial(c.Context(), usage.MaterialID)
+			if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+				return nil, err
+			}
+			material = m
+			materials[usage.MaterialID] = material
+		}
+		if material == nil {
+			cost.MaterialLines = append(cost.MaterialLines, types.MaterialCostLine{
+				MaterialID: usage.MaterialID,
+				Quantity:   usage.Quantity,
+				Unit:       usage.Unit,
+				Missing:    true,
+			})
+			cost.Warnings = append(cost.Warnings, fmt.Sprintf("Material %s is missing or archived, its cost is left out", usage.MaterialID.Hex()))
+			continue
+		}
+
+		// Prices are per base unit, so the usage is converted into it.
+		quantity, ok := material.ToBase(usage.Quantity, usage.Unit)
+		if !ok {
+			return nil, NewError(fiber.StatusUnprocessableEntity, fmt.Sprintf("Product %s uses material %s in %s, which is not one of its units", product.SKU, material.Name, usage.Unit))
+		}
+
+		unitPrice := latestPrice(material.PriceHistory)
+		line := types.MaterialCostLine{
+			MaterialID:   material.ID,
+			MaterialName: material.Name,
+			Quantity:     quantity,
+			Unit:         material.Unit,
+			UnitPrice:    unitPrice,
+			Cost:         roundMoney(unitPrice * quantity),
+		}
+		cost.MaterialLines = append(cost.MaterialLines, line)
+		cost.MaterialCost += line.Cost
+	}
+
+	processingItems, err := h.store.ProcessingItem.GetProcessingItems(c.Context(), bson.M{"sku": bson.M{"$eq": product.SKU}})
+	if err != nil {
+		return nil, err
+	}
+
+	var laborTotal float64
+	var laborQuantity int
+	for _, item := range processingItems {
+		laborTotal += item.Price * float64(item.Quantity)
+		laborQuantity += item.Quantity
+	}
+	if laborQuantity > 0 {
+		cost.LaborCost = roundMoney(laborTotal / float64(laborQuantity))
+	}
+
+	cost.MaterialCost = roundMoney(cost.MaterialCost)
+	cost.UnitCost = roundMoney(cost.MaterialCost + cost.LaborCost)
+
+	return cost, nil
+}
+
+func (h *ProductCostHandler) costsBySKU(c *fiber.Ctx) (map[string]*types.ProductCost, error) {
+	costs, err := h.store.ProductCost.GetProductCosts(c.Context(), bson.M{})
+	if err != nil {
+		return nil, err
+	}
+
+	bySKU := make(map[string]*types.ProductCost, len(costs))
+	for _, cost := range costs {
+		bySKU[cost.SKU] = cost
+	}
+	return bySKU, nil
+}
+
+func latestPrice(history []types.PriceHistoryEntry) float64 {
+	var latest *types.PriceHistoryEntry
+	for i := range history {
+		if latest == nil || history[i].UpdatedAt.After(latest.UpdatedAt) {
+			latest = &history[i]
+		}
+	}
+	if latest == nil {
+		return 0
+	}
+	return latest.Price
+}
+
+func marginPercent(price, cost float64) float64 {
+	if price == 0 {
+		return 0
+	}
+	return roundMoney((price - cost) / price * 100)
+}
+
+func roundMoney(v float64) float64 {
+	return math.Round(v*100) / 100
+}
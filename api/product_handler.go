@@ -1,6 +1,8 @@
 package api
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"strconv"
 	"time"
@@ -11,40 +13,123 @@ import (
 	"github.com/gofiber/fiber/v2"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 type InsertProductParams struct {
-	SKU      string  `json:"sku"`
-	Name     string  `json:"name"`
-	Material string  `json:"material"`
-	Color    string  `json:"color"`
-	Type     string  `json:"type"`
-	Size     string  `json:"size"`
-	Quantity int     `json:"quantity"`
-	Price    float64 `json:"price"`
-	Date     string  `json:"date"`
-	Remark   string  `json:"remark"`
+	SKU           string                `json:"sku"`
+	ParentID      string                `json:"parentId" validate:"objectid"`
+	Name          string                `json:"name" validate:"required"`
+	Material      string                `json:"material"`
+	Color         string                `json:"color"`
+	Type          string                `json:"type"`
+	Size          string                `json:"size"`
+	Quantity      int                   `json:"quantity" validate:"min=0"`
+	Price         float64               `json:"price" validate:"min=0"`
+	Date          string                `json:"date" validate:"date"`
+	Remark        string                `json:"remark"`
+	MaterialUsage []MaterialUsageParams `json:"materialUsage"`
+}
+
+type MaterialUsageParams struct {
+	MaterialID string  `json:"materialId" validate:"required,objectid"`
+	Quantity   float64 `json:"quantity" validate:"gt=0"`
+	// Unit is one of the material's units, its base unit when empty.
+	Unit string `json:"unit"`
 }
 
 func (p InsertProductParams) validate() error {
-	return nil
+	return validateStruct(p)
 }
 
 type UpdateProductParams struct {
-	SKU      string  `json:"sku"`
-	Name     string  `json:"name"`
-	Material string  `json:"material"`
-	Color    string  `json:"color"`
-	Type     string  `json:"type"`
-	Size     string  `json:"size"`
-	Quantity int     `json:"quantity"`
-	Price    float64 `json:"price"`
-	Date     string  `json:"date"`
-	Remark   string  `json:"remark"`
+	SKU           string                `json:"sku"`
+	ParentID      string                `json:"parentId" validate:"objectid"`
+	Name          string                `json:"name" validate:"required"`
+	Material      string                `json:"material"`
+	Color         string                `json:"color"`
+	Type          string                `json:"type"`
+	Size          string                `json:"size"`
+	Price         float64               `json:"price" validate:"min=0"`
+	Date          string                `json:"date" validate:"date"`
+	Remark        string                `json:"remark"`
+	MaterialUsage []MaterialUsageParams `json:"materialUsage"`
+	// Quantity is read-only: stock changes through stocktakes and stock adjustments, which the
+	// stock ledger records.
+	Quantity int `json:"quantity"`
 }
 
 func (p *UpdateProductParams) validate() error {
-	return nil
+	return validateStruct(p)
+}
+
+func parseMaterialUsage(params []MaterialUsageParams) ([]types.MaterialUsage, error) {
+	usage := make([]types.MaterialUsage, len(params))
+	for i, u := range params {
+		materialID, err := primitive.ObjectIDFromHex(u.MaterialID)
+		if err != nil {
+			return nil, err
+		}
+		usage[i] = types.MaterialUsage{
+			MaterialID: materialID,
+			Quantity:   u.Quantity,
+			Unit:       u.Unit,
+		}
+	}
+	return usage, nil
+}
+
+// checkUsageUnits makes sure the units of a bill of materials are units of their materials.
+func (h *ProductHandler) checkUsageUnits(ctx context.Context, usage []types.MaterialUsage) error {
+	errs := ValidationError{}
+	for i, u := range usage {
+		if u.Unit == "" {
+			continue
+		}
+		material, err := h.store.Material.GetMaterial(ctx, u.MaterialID)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			errs[fmt.Sprintf("materialUsage[%d].materialId", i)] = fmt.Sprintf("material %s does not exist", u.MaterialID.Hex())
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if _, ok := material.UnitFactor(u.Unit); !ok {
+			errs[fmt.Sprintf("materialUsage[%d].unit", i)] = fmt.Sprintf("%s is not a unit of material %s", u.Unit, material.Name)
+		}
+	}
+	return errs.err()
+}
+
+// productParent looks up the parent product a product is a variant of. An empty id means the
+// product has no parent.
+func (h *ProductHandler) productParent(ctx context.Context, id string) (*types.ProductParent, error) {
+	if id == "" {
+		return nil, nil
+	}
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, NewError(fiber.StatusBadRequest, "Invalid parent product ID")
+	}
+	parent, err := h.store.ProductParent.GetProductParent(ctx, objID)
+	if errors.Is(err, mongo.ErrNoDocuments) || (err == nil && parent.DeletedAt != nil) {
+		return nil, NewError(fiber.StatusNotFound, "Parent product not found")
+	}
+	return parent, err
+}
+
+// parentQuery reads the parentId query parameter that narrows a query to the variants of a
+// parent product.
+func parentQuery(c *fiber.Ctx) (primitive.ObjectID, error) {
+	parentID := c.Query("parentId")
+	if parentID == "" {
+		return primitive.NilObjectID, nil
+	}
+	objID, err := primitive.ObjectIDFromHex(parentID)
+	if err != nil {
+		return primitive.NilObjectID, NewError(fiber.StatusBadRequest, "Invalid parent product ID")
+	}
+	return objID, nil
 }
 
 type ProductHandler struct {
@@ -60,10 +145,11 @@ func NewProductHandler(store *db.Store) *ProductHandler {
 // HandleGetProducts retrieves a list of products based on query parameters.
 //
 // @Summary Get products
-// @Description Retrieves a list of products based on query parameters.
+// @Description Retrieves a list of products based on query parameters. Each product carries its quantity on hand, the part of it reserved for orders that have not shipped and what is available to sell.
 // @Tags Product
 // @Param id query string false "Product ID"
 // @Param sku query string false "Product SKU"
+// @Param parentId query string false "Parent product ID, to list its variants"
 // @Param name query string false "Product name"
 // @Param material query string false "Material"
 // @Param color query string false "Color"
@@ -72,7 +158,11 @@ func NewProductHandler(store *db.Store) *ProductHandler {
 // @Param price query string false "Price"
 // @Param date query string false "Date (format: YYYY-MM-DD)"
 // @Param remark query string false "Remark"
+// @Param includeArchived query string false "true to include archived records, only to list just the archived ones"
+// @Param format query string false "Response format: json (default), csv or xlsx. The Accept header is used when omitted"
 // @Produce json
+// @Produce text/csv
+// @Produce application/vnd.openxmlformats-officedocument.spreadsheetml.sheet
 // @Success 200 {array} types.Product
 // @Router /product [get]
 func (h *ProductHandler) HandleGetProducts(c *fiber.Ctx) error {
@@ -92,15 +182,20 @@ func (h *ProductHandler) HandleGetProducts(c *fiber.Ctx) error {
 	if id != "" {
 		objID, err := primitive.ObjectIDFromHex(id)
 		if err != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "Invalid product ID",
-			})
+			return NewError(fiber.StatusBadRequest, "Invalid product ID")
 		}
 		filter["_id"] = objID
 	}
 	if sku != "" {
 		filter["sku"] = sku
 	}
+	parentID, err := parentQuery(c)
+	if err != nil {
+		return err
+	}
+	if !parentID.IsZero() {
+		filter["parentId"] = parentID
+	}
 	if name != "" {
 		filter["name"] = name
 	}
@@ -116,18 +211,14 @@ func (h *ProductHandler) HandleGetProducts(c *fiber.Ctx) error {
 	if quantity != "" {
 		quantity, err := strconv.Atoi(quantity)
 		if err != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "Invalid quantity value",
-			})
+			return NewError(fiber.StatusBadRequest, "Invalid quantity value")
 		}
 		filter["quantity"] = quantity
 	}
 	if price != "" {
 		price, err := strconv.ParseFloat(price, 64)
 		if err != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "Invalid price value",
-			})
+			return NewError(fiber.StatusBadRequest, "Invalid price value")
 		}
 		filter["price"] = price
 	}
@@ -138,24 +229,54 @@ func (h *ProductHandler) HandleGetProducts(c *fiber.Ctx) error {
 		filter["remark"] = remark
 	}
 
+	if err := archivedFilter(c, filter); err != nil {
+		return err
+	}
+
+	if format := exportFormat(c); format != "" {
+		return streamExport(c, "products", format, filter, h.store.Product.IterateProducts, productExportHeader, productExportRecords)
+	}
+
 	products, err := h.store.Product.GetProducts(c.Context(), filter)
 	if err != nil {
 		return err
 	}
 
 	if len(products) == 0 {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-			"error": "No Matches data found",
-		})
+		return NewError(fiber.StatusNotFound, "No Matches data found")
+	}
+
+	if len(products) == 1 {
+		setETag(c, products[0].Version)
 	}
 
 	return c.JSON(products)
 }
 
+// HandleGetProduct retrieves a product by ID.
+// @Summary Get product
+// @Description Get a product by ID, with its quantity on hand, reserved and available. The ETag header carries its version, to be sent back as If-Match when updating it.
+// @Tags Product
+// @Produce json
+// @Param id path string true "Product ID"
+// @Param includeArchived query string false "true to find the record even if archived"
+// @Success 200 {object} types.Product
+// @Header 200 {string} ETag "Version of the product"
+// @Router /product/{id} [get]
+func (h *ProductHandler) HandleGetProduct(c *fiber.Ctx) error {
+	product, err := findRecord(c, "Product", h.store.Product.GetProduct)
+	if err != nil {
+		return err
+	}
+
+	setETag(c, product.Version)
+	return c.JSON(product)
+}
+
 // HandleInsertProduct inserts a new product.
 //
 // @Summary Insert product
-// @Description Inserts a new product.
+// @Description Inserts a new product. Without a SKU one is generated from the SKU template of the parent product, or the default template for products without a parent.
 // @Tags Product
 // @Accept json
 // @Produce json
@@ -172,22 +293,37 @@ func (h *ProductHandler) HandleInsertProduct(c *fiber.Ctx) error {
 		return err
 	}
 
-	if params.SKU == "" {
-		for {
-			// Generate SKU based on product name, color, size, material
-			unixTimeString := strconv.FormatInt(time.Now().Unix(), 10)
-			params.SKU = fmt.Sprintf("%s-%s-%s-%s-%s", params.Name, params.Color, params.Size, params.Material, unixTimeString)
+	err := checkLookups(c.Context(), h.store.Lookup,
+		lookupValue{field: "color", kind: types.LookupColor, value: &params.Color},
+		lookupValue{field: "type", kind: types.LookupType, value: &params.Type},
+		lookupValue{field: "size", kind: types.LookupSize, value: &params.Size},
+	)
+	if err != nil {
+		return err
+	}
 
-			skuExists, err := h.store.Product.CheckExistedSKU(c.Context(), params.SKU)
-			if err != nil {
-				return err
-			}
+	parent, err := h.productParent(c.Context(), params.ParentID)
+	if err != nil {
+		return err
+	}
 
-			if !skuExists {
-				break
-			}
+	if params.SKU == "" {
+		fields := skuFields{
+			Name:     params.Name,
+			Type:     params.Type,
+			Color:    params.Color,
+			Size:     params.Size,
+			Material: params.Material,
+		}
+		template, scope := "", "product"
+		if parent != nil {
+			fields.Code = parent.Code
+			template, scope = parent.SKUTemplate, parent.ID.Hex()
+		}
 
-			// If SKU already exists, regenerate
+		params.SKU, err = generateSKU(c.Context(), h.store.Product, template, scope, fields)
+		if err != nil {
+			return err
 		}
 	} else {
 		// SKU was created by user
@@ -197,30 +333,36 @@ func (h *ProductHandler) HandleInsertProduct(c *fiber.Ctx) error {
 		}
 
 		if skuExists {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "SKU is already in use by others",
-			})
+			return NewError(fiber.StatusBadRequest, "SKU is already in use by others")
 		}
 	}
 
+	materialUsage, err := parseMaterialUsage(params.MaterialUsage)
+	if err != nil {
+		return NewError(fiber.StatusBadRequest, "Invalid material ID in material usage")
+	}
+	if err := h.checkUsageUnits(c.Context(), materialUsage); err != nil {
+		return err
+	}
+
 	product := types.Product{
-		SKU:      params.SKU,
-		Name:     params.Name,
-		Material: params.Material,
-		Color:    params.Color,
-		Type:     params.Type,
-		Size:     params.Size,
-		Quantity: params.Quantity,
-		Price:    params.Price,
-		Remark:   params.Remark,
+		SKU:           params.SKU,
+		ParentID:      parentObjectID(parent),
+		Name:          params.Name,
+		Material:      params.Material,
+		Color:         params.Color,
+		Type:          params.Type,
+		Size:          params.Size,
+		Quantity:      params.Quantity,
+		Price:         params.Price,
+		Remark:        params.Remark,
+		MaterialUsage: materialUsage,
 	}
 
 	if params.Date != "" {
 		dateParsed, err := time.Parse(time.RFC3339Nano, params.Date)
 		if err != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "Invalid date format",
-			})
+			return NewError(fiber.StatusBadRequest, "Invalid date format")
 		}
 		product.Date = dateParsed
 	}
@@ -237,29 +379,57 @@ func (h *ProductHandler) HandleInsertProduct(c *fiber.Ctx) error {
 
 // HandleUpdateProduct updates an existing product in the system.
 // @Summary Update product
-// @Description Update an existing product in the system.
+// @Description Update an existing product in the system. The body is a JSON merge patch: fields left out keep their value and null clears a field. The quantity cannot be changed here; stock changes through stocktakes and stock adjustments.
 // @Tags Product
-// @Accept json
+// @Accept json,application/merge-patch+json
 // @Produce json
 // @Param id path string true "Product ID"
-// @Param body body UpdateProductParams true "Updated product details"
+// @Param If-Match header string true "ETag of the product as last read"
+// @Param body body UpdateProductParams true "Fields to change"
 // @Success 200 {object} fiber.Map
+// @Failure 412 {object} fiber.Map
+// @Failure 428 {object} fiber.Map
 // @Router /product/{id} [patch]
 func (h *ProductHandler) HandleUpdateProduct(c *fiber.Ctx) error {
 	id := c.Params("id")
 	productID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return NewError(fiber.StatusBadRequest, "Invalid product ID")
+	}
+
+	version, err := ifMatchVersion(c)
 	if err != nil {
 		return err
 	}
 
+	current, err := findRecord(c, "Product", h.store.Product.GetProduct)
+	if err != nil {
+		return err
+	}
+	if current.Version != version {
+		return versionMismatch("Product")
+	}
+
 	var params UpdateProductParams
-	if err := c.BodyParser(&params); err != nil {
+	if err := bindMergePatch(c, current, &params); err != nil {
 		return err
 	}
 
 	if err := params.validate(); err != nil {
 		return err
 	}
+	if params.Quantity != current.Quantity {
+		return ValidationError{"quantity": readOnlyQuantity}
+	}
+
+	err = checkLookups(c.Context(), h.store.Lookup,
+		lookupValue{field: "color", kind: types.LookupColor, value: &params.Color, current: current.Color},
+		lookupValue{field: "type", kind: types.LookupType, value: &params.Type, current: current.Type},
+		lookupValue{field: "size", kind: types.LookupSize, value: &params.Size, current: current.Size},
+	)
+	if err != nil {
+		return err
+	}
 
 	skuDuplicated, err := h.store.Product.CheckDuplicateSKU(c.Context(), params.SKU, productID)
 	if err != nil {
@@ -267,44 +437,59 @@ func (h *ProductHandler) HandleUpdateProduct(c *fiber.Ctx) error {
 	}
 
 	if skuDuplicated {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "SKU is already in use by others",
-		})
+		return NewError(fiber.StatusBadRequest, "SKU is already in use by others")
+	}
+
+	materialUsage, err := parseMaterialUsage(params.MaterialUsage)
+	if err != nil {
+		return NewError(fiber.StatusBadRequest, "Invalid material ID in material usage")
+	}
+	if err := h.checkUsageUnits(c.Context(), materialUsage); err != nil {
+		return err
+	}
+
+	// Only a new parent has to exist, so variants of an archived parent can still be edited.
+	parentID := current.ParentID
+	if current.ParentID == nil || params.ParentID != current.ParentID.Hex() {
+		parent, err := h.productParent(c.Context(), params.ParentID)
+		if err != nil {
+			return err
+		}
+		parentID = parentObjectID(parent)
 	}
 
 	updatedProduct := types.Product{
-		SKU:      params.SKU,
-		Name:     params.Name,
-		Material: params.Material,
-		Color:    params.Color,
-		Type:     params.Type,
-		Size:     params.Size,
-		Quantity: params.Quantity,
-		Price:    params.Price,
-		Remark:   params.Remark,
+		SKU:           params.SKU,
+		ParentID:      parentID,
+		Name:          params.Name,
+		Material:      params.Material,
+		Color:         params.Color,
+		Type:          params.Type,
+		Size:          params.Size,
+		Price:         params.Price,
+		Remark:        params.Remark,
+		MaterialUsage: materialUsage,
 	}
 
 	if params.Date != "" {
 		dateParsed, err := time.Parse(time.RFC3339Nano, params.Date)
 		if err != nil {
-			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-				"error": "Invalid date format",
-			})
+			return NewError(fiber.StatusBadRequest, "Invalid date format")
 		}
 		updatedProduct.Date = dateParsed
 	}
 
-	updateCount, err := h.store.Product.UpdateProduct(c.Context(), productID, &updatedProduct)
+	updateCount, err := h.store.Product.UpdateProduct(c.Context(), productID, &updatedProduct, version)
 	if err != nil {
-		return err
+		return updateError("Product", err)
 	}
 
 	if updateCount == 0 {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-			"error": "Product not found or not updated",
-		})
+		return NewError(fiber.StatusNotFound, "Product not found or not updated")
 	}
 
+	setETag(c, version+1)
+
 	return c.JSON(fiber.Map{
 		"message": "Product updated successfully",
 	})
@@ -313,9 +498,11 @@ func (h *ProductHandler) HandleUpdateProduct(c *fiber.Ctx) error {
 // HandleDeleteProduct deletes a product by ID.
 //
 // @Summary Delete product
-// @Description Deletes a product by ID.
+// @Description Archives a product by ID, hiding it from lists until it is restored or purged. Products still on orders or processing items are not archived; the response is 409 listing the references.
+// @Description Admins can pass mode=cascade to archive those documents as well, or mode=archive to archive the product regardless.
 // @Tags Product
 // @Param id path string true "Product ID"
+// @Param mode query string false "cascade or archive, admins only"
 // @Produce json
 // @Success 200 {object} fiber.Map
 // @Router /product/{id} [delete]
@@ -324,41 +511,58 @@ func (h *ProductHandler) HandleDeleteProduct(c *fiber.Ctx) error {
 
 	objID, err := primitive.ObjectIDFromHex(productID)
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Invalid product ID",
-		})
+		return NewError(fiber.StatusBadRequest, "Invalid product ID")
 	}
 
-	deleteCount, err := h.store.Product.DeleteProduct(c.Context(), objID)
+	opts, err := deleteOptions(c)
 	if err != nil {
 		return err
 	}
+
+	deleteCount, err := h.store.Product.DeleteProduct(c.Context(), objID, opts)
+	if err != nil {
+		return deleteError(c, "Product", err)
+	}
 	if deleteCount == 0 {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
-			"error": "Material not found",
-		})
+		return NewError(fiber.StatusNotFound, "Product not found")
 	}
 
 	return c.JSON(fiber.Map{
-		"message": "Product deleted successfully",
+		"message": deletedMessage("Product"),
 	})
 }
 
+// HandleRestoreProduct restores an archived product.
+//
+// @Summary Restore product
+// @Description Brings back an archived product.
+// @Tags Product
+// @Param id path string true "Product ID"
+// @Produce json
+// @Success 200 {object} fiber.Map
+// @Router /product/{id}/restore [post]
+func (h *ProductHandler) HandleRestoreProduct(c *fiber.Ctx) error {
+	return restoreRecord(c, "Product", h.store.Product.RestoreProduct)
+}
+
 // HandleGetProductColors retrieves a list of unique product colors.
 // @Summary Get product colors
 // @Description Get a list of unique product colors.
 // @Tags Product
 // @Produce json
 // @Param type path string true "Type"
+// @Param parentId query string false "Parent product ID, to list the colors of its variants"
 // @Success 200 {array} string
 // @Router /product/colors [get]
 func (h *ProductHandler) HandleGetProductColors(c *fiber.Ctx) error {
 	productType := c.Query("type")
-	colors, err := h.store.Product.GetProductColors(c.Context(), productType)
+	parentID, err := parentQuery(c)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to retrieve product colors",
-		})
+		return err
+	}
+	colors, err := h.store.Product.GetProductColors(c.Context(), productType, parentID)
+	if err != nil {
+		return NewError(fiber.StatusInternalServerError, "Failed to retrieve product colors")
 	}
 
 	return c.JSON(colors)
@@ -369,14 +573,17 @@ func (h *ProductHandler) HandleGetProductColors(c *fiber.Ctx) error {
 // @Description Get a list of unique product types.
 // @Tags Product
 // @Produce json
+// @Param parentId query string false "Parent product ID, to list the types of its variants"
 // @Success 200 {array} string
 // @Router /product/types [get]
 func (h *ProductHandler) HandleGetProductTypes(c *fiber.Ctx) error {
-	types, err := h.store.Product.GetProductTypes(c.Context())
+	parentID, err := parentQuery(c)
+	if err != nil {
+		return err
+	}
+	types, err := h.store.Product.GetProductTypes(c.Context(), parentID)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to retrieve product types",
-		})
+		return NewError(fiber.StatusInternalServerError, "Failed to retrieve product types")
 	}
 
 	return c.JSON(types)
@@ -388,16 +595,26 @@ func (h *ProductHandler) HandleGetProductTypes(c *fiber.Ctx) error {
 // @Tags Product
 // @Produce json
 // @Param type path string true "Type"
+// @Param parentId query string false "Parent product ID, to list the sizes of its variants"
 // @Success 200 {array} string
 // @Router /product/sizes [get]
 func (h *ProductHandler) HandleGetProductSizes(c *fiber.Ctx) error {
 	productType := c.Query("type")
-	sizes, err := h.store.Product.GetProductSizes(c.Context(), productType)
+	parentID, err := parentQuery(c)
+	if err != nil {
+		return err
+	}
+	sizes, err := h.store.Product.GetProductSizes(c.Context(), productType, parentID)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to retrieve product sizes",
-		})
+		return NewError(fiber.StatusInternalServerError, "Failed to retrieve product sizes")
 	}
 
 	return c.JSON(sizes)
 }
+
+func parentObjectID(parent *types.ProductParent) *primitive.ObjectID {
+	if parent == nil {
+		return nil
+	}
+	return &parent.ID
+}
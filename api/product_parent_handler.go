@@ -0,0 +1,396 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/johnson7543/ims/db"
+	"github.com/johnson7543/ims/types"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type InsertProductParentParams struct {
+	Code        string   `json:"code"`
+	Name        string   `json:"name" validate:"required"`
+	Type        string   `json:"type"`
+	Colors      []string `json:"colors"`
+	Sizes       []string `json:"sizes"`
+	Materials   []string `json:"materials"`
+	SKUTemplate string   `json:"skuTemplate" validate:"skutemplate"`
+	Price       float64  `json:"price" validate:"min=0"`
+	Remark      string   `json:"remark"`
+}
+
+func (p InsertProductParentParams) validate() error {
+	return validateStruct(p)
+}
+
+type UpdateProductParentParams struct {
+	Code        string   `json:"code"`
+	Name        string   `json:"name" validate:"required"`
+	Type        string   `json:"type"`
+	Colors      []string `json:"colors"`
+	Sizes       []string `json:"sizes"`
+	Materials   []string `json:"materials"`
+	SKUTemplate string   `json:"skuTemplate" validate:"skutemplate"`
+	Price       float64  `json:"price" validate:"min=0"`
+	Remark      string   `json:"remark"`
+}
+
+func (p *UpdateProductParentParams) validate() error {
+	return validateStruct(p)
+}
+
+type ProductParentHandler struct {
+	store *db.Store
+}
+
+func NewProductParentHandler(store *db.Store) *ProductParentHandler {
+	return &ProductParentHandler{
+		store: store,
+	}
+}
+
+// HandleGetProductParents retrieves a list of parent products based on query parameters.
+//
+// @Summary Get parent products
+// @Description Retrieves a list of parent products based on query parameters.
+// @Tags ProductParent
+// @Param id query string false "Parent product ID"
+// @Param code query string false "Code"
+// @Param name query string false "Name"
+// @Param type query string false "Type"
+// @Param includeArchived query string false "true to include archived records, only to list just the archived ones"
+// @Param format query string false "Response format: json (default), csv or xlsx. The Accept header is used when omitted"
+// @Produce json
+// @Produce text/csv
+// @Produce application/vnd.openxmlformats-officedocument.spreadsheetml.sheet
+// @Success 200 {array} types.ProductParent
+// @Router /productParent [get]
+func (h *ProductParentHandler) HandleGetProductParents(c *fiber.Ctx) error {
+	filter := bson.M{}
+
+	if id := c.Query("id"); id != "" {
+		objID, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			return NewError(fiber.StatusBadRequest, "Invalid parent product ID")
+		}
+		filter["_id"] = objID
+	}
+	for _, field := range []string{"code", "name", "type"} {
+		if value := c.Query(field); value != "" {
+			filter[field] = value
+		}
+	}
+
+	if err := archivedFilter(c, filter); err != nil {
+		return err
+	}
+
+	if format := exportFormat(c); format != "" {
+		return streamExport(c, "productParents", format, filter, h.store.ProductParent.IterateProductParents, productParentExportHeader, productParentExportRecords)
+	}
+
+	parents, err := h.store.ProductParent.GetProductParents(c.Context(), filter)
+	if err != nil {
+		return err
+	}
+
+	if len(parents) == 0 {
+		return NewError(fiber.StatusNotFound, "No Matches data found")
+	}
+
+	if len(parents) == 1 {
+		setETag(c, parents[0].Version)
+	}
+
+	return c.JSON(parents)
+}
+
+// HandleGetProductParent retrieves a parent product by ID.
+// @Summary Get parent product
+// @Description Get a parent product by ID. The ETag header carries its version, to be sent back as If-Match when updating it.
+// @Tags ProductParent
+// @Produce json
+// @Param id path string true "Parent product ID"
+// @Param includeArchived query string false "true to find the record even if archived"
+// @Success 200 {object} types.ProductParent
+// @Header 200 {string} ETag "Version of the parent product"
+// @Router /productParent/{id} [get]
+func (h *ProductParentHandler) HandleGetProductParent(c *fiber.Ctx) error {
+	parent, err := findRecord(c, "Parent product", h.store.ProductParent.GetProductParent)
+	if err != nil {
+		return err
+	}
+
+	setETag(c, parent.Version)
+	return c.JSON(parent)
+}
+
+// HandleInsertProductParent inserts a new parent product.
+//
+// @Summary Insert parent product
+// @Description Inserts a parent product whose colors, sizes and materials span its variant matrix. The SKU template may use {code}, {name}, {type}, {color}, {size}, {material} and {seq}, with a width as in {seq:4}.
+// @Tags ProductParent
+// @Accept json
+// @Produce json
+// @Param body body InsertProductParentParams true "Parent product information"
+// @Success 200 {object} fiber.Map
+// @Router /productParent [post]
+func (h *ProductParentHandler) HandleInsertProductParent(c *fiber.Ctx) error {
+	var params InsertProductParentParams
+	if err := c.BodyParser(&params); err != nil {
+		return err
+	}
+
+	if err := params.validate(); err != nil {
+		return err
+	}
+
+	params.Colors, params.Sizes = variantAxis(params.Colors), variantAxis(params.Sizes)
+	lookups := append(listLookups("colors", types.LookupColor, params.Colors, nil), listLookups("sizes", types.LookupSize, params.Sizes, nil)...)
+	lookups = append(lookups, lookupValue{field: "type", kind: types.LookupType, value: &params.Type})
+	if err := checkLookups(c.Context(), h.store.Lookup, lookups...); err != nil {
+		return err
+	}
+
+	parent := types.ProductParent{
+		Code:        params.Code,
+		Name:        params.Name,
+		Type:        params.Type,
+		Colors:      variantAxis(params.Colors),
+		Sizes:       variantAxis(params.Sizes),
+		Materials:   variantAxis(params.Materials),
+		SKUTemplate: params.SKUTemplate,
+		Price:       params.Price,
+		Remark:      params.Remark,
+	}
+
+	inserted, err := h.store.ProductParent.InsertProductParent(c.Context(), &parent)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(fiber.Map{
+		"message": fmt.Sprintf("Parent product inserted successfully, ID: %s, Name: %s", inserted.ID.Hex(), inserted.Name),
+	})
+}
+
+// HandleUpdateProductParent updates an existing parent product.
+// @Summary Update parent product
+// @Description Update a parent product. The body is a JSON merge patch: fields left out keep their value and null clears a field. Existing variants are not changed; generate variants to add the new combinations.
+// @Tags ProductParent
+// @Accept json,application/merge-patch+json
+// @Produce json
+// @Param id path string true "Parent product ID"
+// @Param If-Match header string true "ETag of the parent product as last read"
+// @Param body body UpdateProductParentParams true "Fields to change"
+// @Success 200 {object} fiber.Map
+// @Failure 412 {object} Error
+// @Failure 428 {object} Error
+// @Router /productParent/{id} [patch]
+func (h *ProductParentHandler) HandleUpdateProductParent(c *fiber.Ctx) error {
+	version, err := ifMatchVersion(c)
+	if err != nil {
+		return err
+	}
+
+	current, err := findRecord(c, "Parent product", h.store.ProductParent.GetProductParent)
+	if err != nil {
+		return err
+	}
+	if current.Version != version {
+		return versionMismatch("Parent product")
+	}
+
+	var params UpdateProductParentParams
+	if err := bindMergePatch(c, current, &params); err != nil {
+		return err
+	}
+
+	if err := params.validate(); err != nil {
+		return err
+	}
+
+	params.Colors, params.Sizes = variantAxis(params.Colors), variantAxis(params.Sizes)
+	lookups := append(listLookups("colors", types.LookupColor, params.Colors, current.Colors), listLookups("sizes", types.LookupSize, params.Sizes, current.Sizes)...)
+	lookups = append(lookups, lookupValue{field: "type", kind: types.LookupType, value: &params.Type, current: current.Type})
+	if err := checkLookups(c.Context(), h.store.Lookup, lookups...); err != nil {
+		return err
+	}
+
+	updated := types.ProductParent{
+		Code:        params.Code,
+		Name:        params.Name,
+		Type:        params.Type,
+		Colors:      variantAxis(params.Colors),
+		Sizes:       variantAxis(params.Sizes),
+		Materials:   variantAxis(params.Materials),
+		SKUTemplate: params.SKUTemplate,
+		Price:       params.Price,
+		Remark:      params.Remark,
+	}
+
+	updateCount, err := h.store.ProductParent.UpdateProductParent(c.Context(), current.ID, &updated, version)
+	if err != nil {
+		return updateError("Parent product", err)
+	}
+
+	if updateCount == 0 {
+		return NewError(fiber.StatusNotFound, "Parent product not found or not updated")
+	}
+
+	setETag(c, version+1)
+
+	return c.JSON(fiber.Map{
+		"message": "Parent product updated successfully",
+	})
+}
+
+// HandleDeleteProductParent deletes a parent product by ID.
+//
+// @Summary Delete parent product
+// @Description Archives a parent product. Parents with live variants are not archived; the response is 409 listing them.
+// @Description Admins can pass mode=cascade to archive the variants as well, or mode=archive to archive the parent regardless.
+// @Tags ProductParent
+// @Param id path string true "Parent product ID"
+// @Param mode query string false "cascade or archive, admins only"
+// @Produce json
+// @Success 200 {object} fiber.Map
+// @Router /productParent/{id} [delete]
+func (h *ProductParentHandler) HandleDeleteProductParent(c *fiber.Ctx) error {
+	objID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return NewError(fiber.StatusBadRequest, "Invalid parent product ID")
+	}
+
+	opts, err := deleteOptions(c)
+	if err != nil {
+		return err
+	}
+
+	deleteCount, err := h.store.ProductParent.DeleteProductParent(c.Context(), objID, opts)
+	if err != nil {
+		return deleteError(c, "Parent product", err)
+	}
+	if deleteCount == 0 {
+		return NewError(fiber.StatusNotFound, "Parent product not found")
+	}
+
+	return c.JSON(fiber.Map{
+		"message": deletedMessage("Parent product"),
+	})
+}
+
+// HandleRestoreProductParent restores an archived parent product.
+//
+// @Summary Restore parent product
+// @Description Brings back an archived parent product.
+// @Tags ProductParent
+// @Param id path string true "Parent product ID"
+// @Produce json
+// @Success 200 {object} fiber.Map
+// @Router /productParent/{id}/restore [post]
+func (h *ProductParentHandler) HandleRestoreProductParent(c *fiber.Ctx) error {
+	return restoreRecord(c, "Parent product", h.store.ProductParent.RestoreProductParent)
+}
+
+// HandleGenerateVariants creates the missing variants of a parent product.
+//
+// @Summary Generate variants
+// @Description Creates a product for every color × size × material combination of the parent that has no variant yet, archived variants included. New variants take the parent's name, type and price, start with no stock and get a SKU from the parent's template.
+// @Tags ProductParent
+// @Param id path string true "Parent product ID"
+// @Produce json
+// @Success 200 {object} types.VariantResult
+// @Router /productParent/{id}/variants [post]
+func (h *ProductParentHandler) HandleGenerateVariants(c *fiber.Ctx) error {
+	parent, err := findRecord(c, "Parent product", h.store.ProductParent.GetProductParent)
+	if err != nil {
+		return err
+	}
+	if parent.DeletedAt != nil {
+		return NewError(fiber.StatusNotFound, "Parent product not found")
+	}
+
+	variants, err := h.store.Product.GetProducts(c.Context(), db.IncludeArchived(bson.M{"parentId": parent.ID}))
+	if err != nil {
+		return err
+	}
+	existing := map[string]bool{}
+	for _, v := range variants {
+		existing[variantKey(v.Color, v.Size, v.Material)] = true
+	}
+
+	result := types.VariantResult{Created: []*types.Product{}}
+	for _, color := range matrixAxis(parent.Colors) {
+		for _, size := range matrixAxis(parent.Sizes) {
+			for _, material := range matrixAxis(parent.Materials) {
+				if existing[variantKey(color, size, material)] {
+					result.Existing++
+					continue
+				}
+
+				sku, err := generateSKU(c.Context(), h.store.Product, parent.SKUTemplate, parent.ID.Hex(), skuFields{
+					Code:     parent.Code,
+					Name:     parent.Name,
+					Type:     parent.Type,
+					Color:    color,
+					Size:     size,
+					Material: material,
+				})
+				if err != nil {
+					return err
+				}
+
+				product, err := h.store.Product.InsertProduct(c.Context(), &types.Product{
+					SKU:           sku,
+					ParentID:      &parent.ID,
+					Name:          parent.Name,
+					Material:      material,
+					Color:         color,
+					Type:          parent.Type,
+					Size:          size,
+					Price:         parent.Price,
+					Date:          time.Now(),
+					MaterialUsage: []types.MaterialUsage{},
+				})
+				if err != nil {
+					return err
+				}
+				result.Created = append(result.Created, product)
+			}
+		}
+	}
+
+	return c.JSON(result)
+}
+
+// variantAxis trims the values of one dimension of the variant matrix and drops blanks and
+// duplicates, keeping the order they were given in.
+func variantAxis(values []string) []string {
+	axis := []string{}
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v != "" && !contains(axis, v, true) {
+			axis = append(axis, v)
+		}
+	}
+	return axis
+}
+
+// matrixAxis returns the values to combine for a dimension; a dimension without values still
+// takes part once, with an empty value.
+func matrixAxis(values []string) []string {
+	if len(values) == 0 {
+		return []string{""}
+	}
+	return values
+}
+
+func variantKey(color, size, material string) string {
+	return strings.ToLower(color + "\x00" + size + "\x00" + material)
+}
@@ -0,0 +1,35 @@
+package api
+
+import (
+	"log"
+	"runtime/debug"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/recover"
+	"github.com/gofiber/fiber/v2/middleware/requestid"
+)
+
+// requestIDKey is where the request ID middleware keeps the ID of the current request.
+const requestIDKey = "requestid"
+
+// RequestID gives every request an ID, taken from the X-Request-ID header when the client
+// sends one, and echoes it in the response so errors can be matched with the logs.
+func RequestID() fiber.Handler {
+	return requestid.New(requestid.Config{ContextKey: requestIDKey})
+}
+
+func requestID(c *fiber.Ctx) string {
+	id, _ := c.Locals(requestIDKey).(string)
+	return id
+}
+
+// Recover turns a panic in a handler into a 500 problem and logs the stack trace together
+// with the request ID.
+func Recover() fiber.Handler {
+	return recover.New(recover.Config{
+		EnableStackTrace: true,
+		StackTraceHandler: func(c *fiber.Ctx, e interface{}) {
+			log.Printf("request %s: panic serving %s %s: %v\n%s", requestID(c), c.Method(), c.Path(), e, debug.Stack())
+		},
+	})
+}
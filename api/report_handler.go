@@ -0,0 +1,368 @@
+package api
+
+import (
+	"encoding/csv"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/johnson7543/ims/db"
+	"github.com/johnson7543/ims/types"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const defaultTopSellingLimit = 10
+
+type ReportHandler struct {
+	store *db.Store
+}
+
+func NewReportHandler(store *db.Store) *ReportHandler {
+	return &ReportHandler{
+		store: store,
+	}
+}
+
+// parseReportRange reads the from, to and tz query parameters shared by every report.
+func parseReportRange(c *fiber.Ctx) (types.ReportRange, error) {
+	rng := types.ReportRange{
+		Timezone: c.Query("tz"),
+	}
+
+	if from := c.Query("from"); from != "" {
+		fromParsed, err := time.Parse(time.RFC3339Nano, from)
+		if err != nil {
+			return rng, fmt.Errorf("invalid from date format")
+		}
+		rng.From = fromParsed
+	}
+
+	if to := c.Query("to"); to != "" {
+		toParsed, err := time.Parse(time.RFC3339Nano, to)
+		if err != nil {
+			return rng, fmt.Errorf("invalid to date format")
+		}
+		rng.To = toParsed
+	}
+
+	if !rng.From.IsZero() && !rng.To.IsZero() && rng.From.After(rng.To) {
+		return rng, fmt.Errorf("from date cannot be after to date")
+	}
+
+	if rng.Timezone != "" {
+		if _, err := time.LoadLocation(rng.Timezone); err != nil {
+			return rng, fmt.Errorf("invalid timezone")
+		}
+	}
+
+	return rng, nil
+}
+
+// respondReport writes rows as JSON, or as a CSV attachment when format=csv is requested.
+func respondReport[T any](c *fiber.Ctx, name string, rows []*T, header []string, record func(*T) []string) error {
+	if c.Query("format") != "csv" {
+		return c.JSON(rows)
+	}
+
+	c.Set(fiber.HeaderContentType, "text/csv; charset=utf-8")
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="%s.csv"`, name))
+
+	w := safeTableWriter{csvTableWriter{csv.NewWriter(c.Response().BodyWriter())}}
+	if err := w.Write(header); err != nil {
+		return err
+	}
+	for _, row := range rows {
+		if err := w.Write(record(row)); err != nil {
+			return err
+		}
+	}
+	return w.Flush()
+}
+
+func formatAmount(v float64) string {
+	return strconv.FormatFloat(v, 'f', 2, 64)
+}
+
+func reportRangeError(c *fiber.Ctx, err error) error {
+	return NewError(fiber.StatusBadRequest, err.Error())
+}
+
+// HandleGetSalesByCustomer reports order totals grouped by customer.
+//
+// @Summary Sales by customer
+// @Description Sums non-canceled orders per customer within the date range.
+// @Tags Report
+// @Param from query string false "From order date (RFC3339)"
+// @Param to query string false "To order date (RFC3339)"
+// @Param format query string false "Output format: json (default) or csv"
+// @Produce json
+// @Produce text/csv
+// @Success 200 {array} types.SalesByCustomer
+// @Router /report/sales/customer [get]
+func (h *ReportHandler) HandleGetSalesByCustomer(c *fiber.Ctx) error {
+	rng, err := parseReportRange(c)
+	if err != nil {
+		return reportRangeError(c, err)
+	}
+
+	rows, err := h.store.Report.GetSalesByCustomer(c.Context(), rng)
+	if err != nil {
+		return err
+	}
+
+	header := []string{"customerId", "customerName", "orderCount", "totalAmount"}
+	return respondReport(c, "sales_by_customer", rows, header, func(r *types.SalesByCustomer) []string {
+		return []string{r.CustomerID.Hex(), r.CustomerName, strconv.Itoa(r.OrderCount), formatAmount(r.TotalAmount)}
+	})
+}
+
+// HandleGetSalesByProduct reports sold quantities and revenue grouped by product.
+//
+// @Summary Sales by product
+// @Description Sums non-canceled order lines per product within the date range.
+// @Tags Report
+// @Param from query string false "From order date (RFC3339)"
+// @Param to query string false "To order date (RFC3339)"
+// @Param format query string false "Output format: json (default) or csv"
+// @Produce json
+// @Produce text/csv
+// @Success 200 {array} types.SalesByProduct
+// @Router /report/sales/product [get]
+func (h *ReportHandler) HandleGetSalesByProduct(c *fiber.Ctx) error {
+	rng, err := parseReportRange(c)
+	if err != nil {
+		return reportRangeError(c, err)
+	}
+
+	rows, err := h.store.Report.GetSalesByProduct(c.Context(), rng)
+	if err != nil {
+		return err
+	}
+
+	return respondReport(c, "sales_by_product", rows, salesByProductHeader, salesByProductRecord)
+}
+
+// HandleGetSalesByMonth reports order totals grouped by month.
+//
+// @Summary Sales by month
+// @Description Sums non-canceled orders per month within the date range.
+// @Tags Report
+// @Param from query string false "From order date (RFC3339)"
+// @Param to query string false "To order date (RFC3339)"
+// @Param tz query string false "Timezone used for month boundaries, e.g. Asia/Taipei"
+// @Param format query string false "Output format: json (default) or csv"
+// @Produce json
+// @Produce text/csv
+// @Success 200 {array} types.MonthlyTotal
+// @Router /report/sales/month [get]
+func (h *ReportHandler) HandleGetSalesByMonth(c *fiber.Ctx) error {
+	rng, err := parseReportRange(c)
+	if err != nil {
+		return reportRangeError(c, err)
+	}
+
+	rows, err := h.store.Report.GetSalesByMonth(c.Context(), rng)
+	if err != nil {
+		return err
+	}
+
+	return respondReport(c, "sales_by_month", rows, monthlyTotalHeader, monthlyTotalRecord)
+}
+
+// HandleGetTopSellingProducts reports the best selling products.
+//
+// @Summary Top selling products
+// @Description Ranks products by quantity sold or revenue within the date range.
+// @Tags Report
+// @Param from query string false "From order date (RFC3339)"
+// @Param to query string false "To order date (RFC3339)"
+// @Param sortBy query string false "quantity or revenue (default)"
+// @Param limit query int false "Number of products (default 10)"
+// @Param format query string false "Output format: json (default) or csv"
+// @Produce json
+// @Produce text/csv
+// @Success 200 {array} types.SalesByProduct
+// @Router /report/sales/top [get]
+func (h *ReportHandler) HandleGetTopSellingProducts(c *fiber.Ctx) error {
+	rng, err := parseReportRange(c)
+	if err != nil {
+		return reportRangeError(c, err)
+	}
+
+	sortBy := "totalAmount"
+	switch c.Query("sortBy") {
+	case "", "revenue":
+	case "quantity":
+		sortBy = "quantity"
+	default:
+		return NewError(fiber.StatusBadRequest, "sortBy must be quantity or revenue")
+	}
+
+	limit := c.QueryInt("limit", defaultTopSellingLimit)
+	if limit <= 0 {
+		return NewError(fiber.StatusBadRequest, "Invalid limit value")
+	}
+
+	rows, err := h.store.Report.GetTopSellingProducts(c.Context(), rng, sortBy, int64(limit))
+	if err != nil {
+		return err
+	}
+
+	return respondReport(c, "top_selling_products", rows, salesByProductHeader, salesByProductRecord)
+}
+
+// HandleGetOrderCountsByStatus reports the number of orders in each status.
+//
+// @Summary Order counts by status
+// @Description Counts orders per status within the date range.
+// @Tags Report
+// @Param from query string false "From order date (RFC3339)"
+// @Param to query string false "To order date (RFC3339)"
+// @Param format query string false "Output format: json (default) or csv"
+// @Produce json
+// @Produce text/csv
+// @Success 200 {array} types.StatusCount
+// @Router /report/orders/status [get]
+func (h *ReportHandler) HandleGetOrderCountsByStatus(c *fiber.Ctx) error {
+	rng, err := parseReportRange(c)
+	if err != nil {
+		return reportRangeError(c, err)
+	}
+
+	rows, err := h.store.Report.GetOrderCountsByStatus(c.Context(), rng)
+	if err != nil {
+		return err
+	}
+
+	return respondReport(c, "order_counts_by_status", rows, statusCountHeader, statusCountRecord)
+}
+
+// HandleGetPurchasesBySeller reports material order totals grouped by seller.
+//
+// @Summary Purchases by seller
+// @Description Sums non-canceled material orders per seller within the date range.
+// @Tags Report
+// @Param from query string false "From order date (RFC3339)"
+// @Param to query string false "To order date (RFC3339)"
+// @Param format query string false "Output format: json (default) or csv"
+// @Produce json
+// @Produce text/csv
+// @Success 200 {array} types.PurchasesBySeller
+// @Router /report/purchases/seller [get]
+func (h *ReportHandler) HandleGetPurchasesBySeller(c *fiber.Ctx) error {
+	rng, err := parseReportRange(c)
+	if err != nil {
+		return reportRangeError(c, err)
+	}
+
+	rows, err := h.store.Report.GetPurchasesBySeller(c.Context(), rng)
+	if err != nil {
+		return err
+	}
+
+	header := []string{"sellerId", "sellerName", "orderCount", "totalAmount"}
+	return respondReport(c, "purchases_by_seller", rows, header, func(r *types.PurchasesBySeller) []string {
+		return []string{r.SellerID, r.SellerName, strconv.Itoa(r.OrderCount), formatAmount(r.TotalAmount)}
+	})
+}
+
+// HandleGetPurchasesByMaterial reports purchased quantities and amounts grouped by material.
+//
+// @Summary Purchases by material
+// @Description Sums non-canceled material order lines per material within the date range.
+// @Tags Report
+// @Param from query string false "From order date (RFC3339)"
+// @Param to query string false "To order date (RFC3339)"
+// @Param format query string false "Output format: json (default) or csv"
+// @Produce json
+// @Produce text/csv
+// @Success 200 {array} types.PurchasesByMaterial
+// @Router /report/purchases/material [get]
+func (h *ReportHandler) HandleGetPurchasesByMaterial(c *fiber.Ctx) error {
+	rng, err := parseReportRange(c)
+	if err != nil {
+		return reportRangeError(c, err)
+	}
+
+	rows, err := h.store.Report.GetPurchasesByMaterial(c.Context(), rng)
+	if err != nil {
+		return err
+	}
+
+	header := []string{"materialId", "materialName", "quantity", "totalAmount"}
+	return respondReport(c, "purchases_by_material", rows, header, func(r *types.PurchasesByMaterial) []string {
+		return []string{r.MaterialID.Hex(), r.MaterialName, formatFloat(r.Quantity), formatAmount(r.TotalAmount)}
+	})
+}
+
+// HandleGetPurchasesByMonth reports material order totals grouped by month.
+//
+// @Summary Purchases by month
+// @Description Sums non-canceled material orders per month within the date range.
+// @Tags Report
+// @Param from query string false "From order date (RFC3339)"
+// @Param to query string false "To order date (RFC3339)"
+// @Param tz query string false "Timezone used for month boundaries, e.g. Asia/Taipei"
+// @Param format query string false "Output format: json (default) or csv"
+// @Produce json
+// @Produce text/csv
+// @Success 200 {array} types.MonthlyTotal
+// @Router /report/purchases/month [get]
+func (h *ReportHandler) HandleGetPurchasesByMonth(c *fiber.Ctx) error {
+	rng, err := parseReportRange(c)
+	if err != nil {
+		return reportRangeError(c, err)
+	}
+
+	rows, err := h.store.Report.GetPurchasesByMonth(c.Context(), rng)
+	if err != nil {
+		return err
+	}
+
+	return respondReport(c, "purchases_by_month", rows, monthlyTotalHeader, monthlyTotalRecord)
+}
+
+// HandleGetMaterialOrderCountsByStatus reports the number of material orders in each status.
+//
+// @Summary Material order counts by status
+// @Description Counts material orders per status within the date range.
+// @Tags Report
+// @Param from query string false "From order date (RFC3339)"
+// @Param to query string false "To order date (RFC3339)"
+// @Param format query string false "Output format: json (default) or csv"
+// @Produce json
+// @Produce text/csv
+// @Success 200 {array} types.StatusCount
+// @Router /report/materialOrders/status [get]
+func (h *ReportHandler) HandleGetMaterialOrderCountsByStatus(c *fiber.Ctx) error {
+	rng, err := parseReportRange(c)
+	if err != nil {
+		return reportRangeError(c, err)
+	}
+
+	rows, err := h.store.Report.GetMaterialOrderCountsByStatus(c.Context(), rng)
+	if err != nil {
+		return err
+	}
+
+	return respondReport(c, "material_order_counts_by_status", rows, statusCountHeader, statusCountRecord)
+}
+
+var salesByProductHeader = []string{"productId", "sku", "productName", "quantity", "totalAmount"}
+
+func salesByProductRecord(r *types.SalesByProduct) []string {
+	return []string{r.ProductID.Hex(), r.SKU, r.ProductName, strconv.Itoa(r.Quantity), formatAmount(r.TotalAmount)}
+}
+
+var monthlyTotalHeader = []string{"month", "orderCount", "totalAmount"}
+
+func monthlyTotalRecord(r *types.MonthlyTotal) []string {
+	return []string{r.Month, strconv.Itoa(r.OrderCount), formatAmount(r.TotalAmount)}
+}
+
+var statusCountHeader = []string{"status", "count", "totalAmount"}
+
+func statusCountRecord(r *types.StatusCount) []string {
+	return []string{r.Status, strconv.Itoa(r.Count), formatAmount(r.TotalAmount)}
+}
@@ -0,0 +1,397 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/johnson7543/ims/db"
+	"github.com/johnson7543/ims/pdf"
+	"github.com/johnson7543/ims/types"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type InsertShipmentParams struct {
+	OrderID        string `json:"orderId" validate:"required,objectid"`
+	Carrier        string `json:"carrier"`
+	TrackingNumber string `json:"trackingNumber"`
+	// ShipDate defaults to now.
+	ShipDate string `json:"shipDate" validate:"date"`
+	// Lines are the order lines shipped. Everything left to ship goes when it is empty.
+	Lines  []ShipmentLineParams `json:"lines"`
+	Remark string               `json:"remark"`
+}
+
+type ShipmentLineParams struct {
+	// Line is the index of the line in the order's items, starting at 0.
+	Line     int `json:"line" validate:"min=0"`
+	Quantity int `json:"quantity" validate:"required,gt=0"`
+}
+
+func (p InsertShipmentParams) validate() error {
+	return validateStruct(p)
+}
+
+type UpdateShipmentParams struct {
+	Carrier        string `json:"carrier"`
+	TrackingNumber string `json:"trackingNumber"`
+	ShipDate       string `json:"shipDate" validate:"required,date"`
+	Remark         string `json:"remark"`
+}
+
+func (p UpdateShipmentParams) validate() error {
+	return validateStruct(p)
+}
+
+type ShipmentHandler struct {
+	store *db.Store
+}
+
+func NewShipmentHandler(store *db.Store) *ShipmentHandler {
+	return &ShipmentHandler{
+		store: store,
+	}
+}
+
+// HandleGetShipments lists shipments.
+//
+// @Summary Get shipments
+// @Description Lists shipments, latest ship date first.
+// @Tags Shipment
+// @Param orderId query string false "Order shipped"
+// @Param carrier query string false "Carrier"
+// @Param trackingNumber query string false "Tracking number"
+// @Produce json
+// @Success 200 {array} types.Shipment
+// @Router /shipment [get]
+func (h *ShipmentHandler) HandleGetShipments(c *fiber.Ctx) error {
+	filter := bson.M{}
+
+	if id := c.Query("orderId"); id != "" {
+		objID, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			return NewError(fiber.StatusBadRequest, "Invalid order ID")
+		}
+		filter["orderId"] = objID
+	}
+	if carrier := c.Query("carrier"); carrier != "" {
+		filter["carrier"] = carrier
+	}
+	if tracking := c.Query("trackingNumber"); tracking != "" {
+		filter["trackingNumber"] = tracking
+	}
+
+	shipments, err := h.store.Shipment.GetShipments(c.Context(), filter)
+	if err != nil {
+		return err
+	}
+
+	if len(shipments) == 0 {
+		return NewError(fiber.StatusNotFound, "No Matches data found")
+	}
+
+	return c.JSON(shipments)
+}
+
+// HandleGetShipment retrieves a shipment by ID.
+//
+// @Summary Get shipment
+// @Description Get a shipment by ID. The ETag header carries its version, to be sent back as If-Match when updating it.
+// @Tags Shipment
+// @Produce json
+// @Param id path string true "Shipment ID"
+// @Success 200 {object} types.Shipment
+// @Header 200 {string} ETag "Version of the shipment"
+// @Router /shipment/{id} [get]
+func (h *ShipmentHandler) HandleGetShipment(c *fiber.Ctx) error {
+	shipment, err := findRecord(c, "Shipment", h.store.Shipment.GetShipment)
+	if err != nil {
+		return err
+	}
+
+	setETag(c, shipment.Version)
+	return c.JSON(shipment)
+}
+
+// HandleInsertShipment records a shipment.
+//
+// @Summary Ship order lines
+// @Description Records a shipment of some or all of what is left to ship of an order. The stock reserved for the lines is taken, and the order becomes partially_shipped, or shipped once every line has gone out. Without lines everything left to ship goes.
+// @Tags Shipment
+// @Accept json
+// @Produce json
+// @Param body body InsertShipmentParams true "Shipment"
+// @Success 200 {object} types.Shipment
+// @Failure 409 {object} Error
+// @Router /shipment [post]
+func (h *ShipmentHandler) HandleInsertShipment(c *fiber.Ctx) error {
+	var params InsertShipmentParams
+	if err := c.BodyParser(&params); err != nil {
+		return err
+	}
+
+	if err := params.validate(); err != nil {
+		return err
+	}
+
+	orderID, _ := primitive.ObjectIDFromHex(params.OrderID)
+	order, err := h.store.Order.GetOrder(c.Context(), orderID)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return ValidationError{"orderId": fmt.Sprintf("%s does not exist", params.OrderID)}
+	}
+	if err != nil {
+		return err
+	}
+	if strings.EqualFold(order.Status, types.OrderCanceled) {
+		return NewError(fiber.StatusConflict, "Canceled orders cannot be shipped")
+	}
+
+	lines, err := shipmentLines(order, params.Lines)
+	if err != nil {
+		return err
+	}
+
+	user, err := getAuthUser(c)
+	if err != nil {
+		return err
+	}
+
+	shipment := types.Shipment{
+		OrderID:        orderID,
+		Carrier:        params.Carrier,
+		TrackingNumber: params.TrackingNumber,
+		ShipDate:       time.Now(),
+		Lines:          lines,
+		Remark:         params.Remark,
+		CreatedBy:      user.ID,
+		CreatedAt:      time.Now(),
+	}
+	if params.ShipDate != "" {
+		shipment.ShipDate, _ = time.Parse(time.RFC3339Nano, params.ShipDate)
+	}
+
+	inserted, err := h.store.Shipment.InsertShipment(c.Context(), &shipment)
+	if err != nil {
+		return err
+	}
+
+	setETag(c, inserted.Version)
+	return c.JSON(inserted)
+}
+
+// HandleUpdateShipment updates the carrier, tracking number, ship date or remark of a shipment.
+//
+// @Summary Update shipment
+// @Description Update the carrier, tracking number, ship date or remark of a shipment. Its lines cannot change. The body is a JSON merge patch: fields left out keep their value and null clears a field.
+// @Tags Shipment
+// @Accept json,application/merge-patch+json
+// @Produce json
+// @Param id path string true "Shipment ID"
+// @Param If-Match header string true "ETag of the shipment as last read"
+// @Param body body UpdateShipmentParams true "Fields to change"
+// @Success 200 {object} fiber.Map
+// @Failure 412 {object} Error
+// @Failure 428 {object} Error
+// @Router /shipment/{id} [patch]
+func (h *ShipmentHandler) HandleUpdateShipment(c *fiber.Ctx) error {
+	version, err := ifMatchVersion(c)
+	if err != nil {
+		return err
+	}
+
+	current, err := findRecord(c, "Shipment", h.store.Shipment.GetShipment)
+	if err != nil {
+		return err
+	}
+	if current.Version != version {
+		return versionMismatch("Shipment")
+	}
+
+	var params UpdateShipmentParams
+	if err := bindMergePatch(c, current, &params); err != nil {
+		return err
+	}
+
+	if err := params.validate(); err != nil {
+		return err
+	}
+
+	shipDate, _ := time.Parse(time.RFC3339Nano, params.ShipDate)
+	updated := types.Shipment{
+		Carrier:        params.Carrier,
+		TrackingNumber: params.TrackingNumber,
+		ShipDate:       shipDate,
+		Remark:         params.Remark,
+	}
+
+	updateCount, err := h.store.Shipment.UpdateShipment(c.Context(), current.ID, &updated, version)
+	if err != nil {
+		return updateError("Shipment", err)
+	}
+
+	if updateCount == 0 {
+		return NewError(fiber.StatusNotFound, "Shipment not found or not updated")
+	}
+
+	setETag(c, version+1)
+	return c.JSON(fiber.Map{
+		"message": "Shipment updated successfully",
+	})
+}
+
+// HandleGetDeliveryNote prints the delivery note of a shipment.
+//
+// @Summary Print delivery note
+// @Description Returns the delivery note of a shipment as an A4 PDF, listing what went out with the order, customer, address, carrier and tracking number, and space for the recipient to sign.
+// @Tags Shipment
+// @Produce application/pdf
+// @Param id path string true "Shipment ID"
+// @Success 200 {file} file
+// @Router /shipment/{id}/deliveryNote [get]
+func (h *ShipmentHandler) HandleGetDeliveryNote(c *fiber.Ctx) error {
+	shipment, err := findRecord(c, "Shipment", h.store.Shipment.GetShipment)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if _, err := deliveryNote(shipment).WriteTo(&buf); err != nil {
+		return err
+	}
+
+	c.Set(fiber.HeaderContentType, mimePDF)
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`inline; filename="delivery_note_%s.pdf"`, shipment.Number))
+	return c.Send(buf.Bytes())
+}
+
+// shipmentLines checks the lines asked for against what is left to ship of the order. Without
+// lines every line with something left to ship goes in full.
+func shipmentLines(order *types.Order, params []ShipmentLineParams) ([]types.ShipmentLine, error) {
+	lines := []types.ShipmentLine{}
+	if len(params) == 0 {
+		for i, item := range order.OrderItems {
+			if item.Outstanding() > 0 {
+				lines = append(lines, types.ShipmentLine{Line: i, Quantity: item.Outstanding()})
+			}
+		}
+		if len(lines) == 0 {
+			return nil, ValidationError{"lines": "the order has nothing left to ship"}
+		}
+		return lines, nil
+	}
+
+	errs := ValidationError{}
+	shipping := map[int]int{}
+	for i, line := range params {
+		if line.Line >= len(order.OrderItems) {
+			errs[fmt.Sprintf("lines[%d].line", i)] = fmt.Sprintf("the order has %d lines", len(order.OrderItems))
+			continue
+		}
+		shipping[line.Line] += line.Quantity
+		if left := order.OrderItems[line.Line].Outstanding(); shipping[line.Line] > left {
+			errs[fmt.Sprintf("lines[%d].quantity", i)] = fmt.Sprintf("only %d left to ship", left)
+			continue
+		}
+		lines = append(lines, types.ShipmentLine{Line: line.Line, Quantity: line.Quantity})
+	}
+	if err := errs.err(); err != nil {
+		return nil, err
+	}
+	return lines, nil
+}
+
+// Layout of the delivery note, in points.
+const (
+	noteMargin    = 50.0
+	noteRowHeight = 18.0
+	noteTextSize  = 10.0
+)
+
+// deliveryNote lays out the delivery note of a shipment, continuing the lines over as many
+// pages as they need.
+func deliveryNote(shipment *types.Shipment) *pdf.Document {
+	doc := pdf.New(pdf.A4Width, pdf.A4Height)
+	right := pdf.A4Width - noteMargin
+
+	page := doc.AddPage()
+	page.Text(noteMargin, noteMargin+18, 18, "Delivery note")
+	rightText(page, right, noteMargin+18, 12, shipment.Number)
+
+	y := noteMargin + 50
+	details := [][2]string{
+		{"Ship date", shipment.ShipDate.Format("2006-01-02")},
+		{"Order", shipment.OrderID.Hex()},
+		{"Customer", shipment.CustomerName},
+		{"Carrier", shipment.Carrier},
+		{"Tracking number", shipment.TrackingNumber},
+	}
+	for _, detail := range details {
+		page.Text(noteMargin, y, noteTextSize, detail[0])
+		page.Text(noteMargin+100, y, noteTextSize, pdf.Truncate(noteTextSize, right-noteMargin-100, detail[1]))
+		y += noteRowHeight
+	}
+	page.Text(noteMargin, y, noteTextSize, "Ship to")
+	for _, line := range strings.Split(shipment.ShippingAddress, "\n") {
+		page.Text(noteMargin+100, y, noteTextSize, pdf.Truncate(noteTextSize, right-noteMargin-100, strings.TrimSpace(line)))
+		y += noteRowHeight
+	}
+
+	y += noteRowHeight
+	y = noteHeader(page, y)
+	total := 0
+	for _, line := range shipment.Lines {
+		if y > pdf.A4Height-noteMargin-noteRowHeight {
+			page = doc.AddPage()
+			y = noteHeader(page, noteMargin+noteRowHeight)
+		}
+		page.Text(noteMargin, y, noteTextSize, strconv.Itoa(line.Line+1))
+		page.Text(noteMargin+30, y, noteTextSize, pdf.Truncate(noteTextSize, 105, line.SKU))
+		page.Text(noteMargin+140, y, noteTextSize, pdf.Truncate(noteTextSize, right-noteMargin-270, line.Name))
+		rightText(page, right-70, y, noteTextSize, strconv.Itoa(line.Ordered))
+		rightText(page, right, y, noteTextSize, strconv.Itoa(line.Quantity))
+		total += line.Quantity
+		y += noteRowHeight
+	}
+	page.Line(noteMargin, y-noteRowHeight+5, right, y-noteRowHeight+5)
+	page.Text(noteMargin, y, noteTextSize, "Total pieces")
+	rightText(page, right, y, noteTextSize, strconv.Itoa(total))
+
+	// The remark and the signature keep together at the foot of the last page.
+	if y > pdf.A4Height-noteMargin-4*noteRowHeight {
+		page = doc.AddPage()
+	}
+	foot := pdf.A4Height - noteMargin - noteRowHeight
+	if shipment.Remark != "" {
+		page.Text(noteMargin, foot-2*noteRowHeight, noteTextSize, pdf.Truncate(noteTextSize, right-noteMargin, shipment.Remark))
+	}
+	page.Text(noteMargin, foot, noteTextSize, "Received by")
+	page.Line(noteMargin+65, foot+2, noteMargin+240, foot+2)
+	page.Text(noteMargin+270, foot, noteTextSize, "Date")
+	page.Line(noteMargin+300, foot+2, right, foot+2)
+	return doc
+}
+
+// noteHeader writes the column headings of the delivery note lines at y and returns where the
+// first line goes.
+func noteHeader(page *pdf.Page, y float64) float64 {
+	right := pdf.A4Width - noteMargin
+	page.Text(noteMargin, y, noteTextSize, "#")
+	page.Text(noteMargin+30, y, noteTextSize, "SKU")
+	page.Text(noteMargin+140, y, noteTextSize, "Product")
+	rightText(page, right-70, y, noteTextSize, "Ordered")
+	rightText(page, right, y, noteTextSize, "Shipped")
+	page.Line(noteMargin, y+5, right, y+5)
+	return y + noteRowHeight + 2
+}
+
+// rightText writes text ending at x.
+func rightText(page *pdf.Page, x, y, size float64, text string) {
+	page.Text(x-pdf.TextWidth(size, text), y, size, text)
+}
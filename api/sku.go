@@ -0,0 +1,133 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/johnson7543/ims/db"
+)
+
+const (
+	// SKUTemplateEnvName sets the template of the SKUs generated for products without a parent,
+	// and for parents without a template of their own.
+	SKUTemplateEnvName = "SKU_TEMPLATE"
+
+	defaultSKUTemplate = "{name}-{color}-{size}-{material}-{seq}"
+
+	// skuAttempts bounds the sequence numbers tried when generated SKUs clash with SKUs that
+	// were typed in by hand.
+	skuAttempts = 100
+)
+
+// skuPlaceholder matches the {field} and {seq:width} placeholders of a SKU template.
+var skuPlaceholder = regexp.MustCompile(`\{(\w+)(?::(\d+))?\}`)
+
+var skuFieldNames = []string{"code", "name", "type", "color", "size", "material", "seq"}
+
+// skuFields are the values a SKU template can refer to.
+type skuFields struct {
+	Code     string
+	Name     string
+	Type     string
+	Color    string
+	Size     string
+	Material string
+}
+
+func (f skuFields) value(name string) string {
+	switch name {
+	case "code":
+		return f.Code
+	case "name":
+		return f.Name
+	case "type":
+		return f.Type
+	case "color":
+		return f.Color
+	case "size":
+		return f.Size
+	case "material":
+		return f.Material
+	}
+	return ""
+}
+
+// skuTemplate returns the template to generate SKUs with: the parent's own, or the
+// configured default.
+func skuTemplate(template string) string {
+	if template != "" {
+		return template
+	}
+	if template := os.Getenv(SKUTemplateEnvName); template != "" {
+		return template
+	}
+	return defaultSKUTemplate
+}
+
+// checkSKUTemplate reports unknown placeholders and widths of a template.
+func checkSKUTemplate(template string) error {
+	for _, m := range skuPlaceholder.FindAllStringSubmatch(template, -1) {
+		if !contains(skuFieldNames, m[1], false) {
+			return fmt.Errorf("unknown placeholder {%s}, expected one of %s", m[1], strings.Join(skuFieldNames, ", "))
+		}
+		if m[2] != "" && m[1] != "seq" {
+			return fmt.Errorf("only {seq} takes a width, got {%s:%s}", m[1], m[2])
+		}
+		if width, _ := strconv.Atoi(m[2]); width > 12 {
+			return fmt.Errorf("{seq:%s} is too wide, 12 digits at most", m[2])
+		}
+	}
+	return nil
+}
+
+// renderSKU fills in a template. {code}, {name}, {type}, {color}, {size} and {material} are
+// replaced by the product's values with spaces turned into dashes, and {seq} by the sequence
+// number, zero padded to the width given as {seq:4}. Dashes left doubled by empty values are
+// collapsed.
+func renderSKU(template string, fields skuFields, seq int64) string {
+	sku := skuPlaceholder.ReplaceAllStringFunc(template, func(p string) string {
+		m := skuPlaceholder.FindStringSubmatch(p)
+		if m[1] == "seq" {
+			width, _ := strconv.Atoi(m[2])
+			return fmt.Sprintf("%0*d", width, seq)
+		}
+		return strings.Join(strings.Fields(fields.value(m[1])), "-")
+	})
+	for strings.Contains(sku, "--") {
+		sku = strings.ReplaceAll(sku, "--", "-")
+	}
+	return strings.Trim(sku, "-")
+}
+
+// generateSKU renders template with the next sequence number of scope until the SKU is not
+// in use. Templates without {seq} get a single try.
+func generateSKU(ctx context.Context, products db.ProductStore, template, scope string, fields skuFields) (string, error) {
+	template = skuTemplate(template)
+	for i := 0; i < skuAttempts; i++ {
+		var seq int64
+		if strings.Contains(template, "{seq") {
+			var err error
+			if seq, err = products.NextSKUSequence(ctx, scope); err != nil {
+				return "", err
+			}
+		}
+
+		sku := renderSKU(template, fields, seq)
+		exists, err := products.CheckExistedSKU(ctx, sku)
+		if err != nil {
+			return "", err
+		}
+		if !exists {
+			return sku, nil
+		}
+		if !strings.Contains(template, "{seq") {
+			return "", NewError(http.StatusConflict, fmt.Sprintf("SKU %s generated from template %s is already in use, add {seq} to the template", sku, template))
+		}
+	}
+	return "", NewError(http.StatusConflict, "no free SKU found for template "+template)
+}
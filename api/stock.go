@@ -0,0 +1,417 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/johnson7543/ims/db"
+	"github.com/johnson7543/ims/types"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// readOnlyQuantity explains why the stock of a product or material cannot be edited directly.
+const readOnlyQuantity = "is read-only, change stock with a stocktake or a stock adjustment"
+
+type InsertStockTransferParams struct {
+	// FromWarehouseID is left out to place stock that is not at any location yet.
+	FromWarehouseID string                          `json:"fromWarehouseId" validate:"objectid"`
+	FromBin         string                          `json:"fromBin"`
+	ToWarehouseID   string                          `json:"toWarehouseId" validate:"required,objectid"`
+	ToBin           string                          `json:"toBin"`
+	TransferDate    string                          `json:"transferDate" validate:"date"`
+	Remark          string                          `json:"remark"`
+	Items           []InsertStockTransferItemParams `json:"items" validate:"required"`
+}
+
+type InsertStockTransferItemParams struct {
+	ItemKind string  `json:"itemKind" validate:"required,enum=stockItemKind"`
+	ItemID   string  `json:"itemId" validate:"required,objectid"`
+	Quantity float64 `json:"quantity" validate:"gt=0"`
+}
+
+func (p InsertStockTransferParams) validate() error {
+	return validateStruct(p)
+}
+
+type StockHandler struct {
+	store *db.Store
+}
+
+func NewStockHandler(store *db.Store) *StockHandler {
+	return &StockHandler{
+		store: store,
+	}
+}
+
+// stockLocation resolves the warehouse and bin a request names. With neither it returns the
+// location of the default warehouse, or nil when no warehouse is the default. The bin is
+// returned as the warehouse spells it.
+func stockLocation(ctx context.Context, warehouses db.WarehouseStore, field, warehouseID, bin string) (*types.StockLocation, error) {
+	bin = strings.TrimSpace(bin)
+	var warehouse *types.Warehouse
+	if warehouseID == "" {
+		if bin != "" {
+			return nil, ValidationError{joinPath(field, "bin"): "needs a warehouse"}
+		}
+		w, err := warehouses.GetDefaultWarehouse(ctx)
+		if err != nil || w == nil {
+			return nil, err
+		}
+		warehouse = w
+	} else {
+		id, err := primitive.ObjectIDFromHex(warehouseID)
+		if err != nil {
+			return nil, ValidationError{joinPath(field, "warehouseId"): fmt.Sprintf("%q is not a valid ID", warehouseID)}
+		}
+		warehouse, err = warehouses.GetWarehouse(ctx, id)
+		if errors.Is(err, mongo.ErrNoDocuments) || (err == nil && warehouse.DeletedAt != nil) {
+			return nil, ValidationError{joinPath(field, "warehouseId"): fmt.Sprintf("Warehouse %s not found", warehouseID)}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	loc := &types.StockLocation{WarehouseID: warehouse.ID}
+	if bin != "" {
+		found := warehouse.FindBin(bin)
+		if found == nil {
+			return nil, ValidationError{joinPath(field, "bin"): fmt.Sprintf("Warehouse %s has no bin %s", warehouse.Code, bin)}
+		}
+		loc.Bin = found.Code
+	}
+	return loc, nil
+}
+
+// locationName describes a location in messages.
+func locationName(loc types.StockLocation) string {
+	if loc.Bin == "" {
+		return "warehouse " + loc.WarehouseID.Hex()
+	}
+	return fmt.Sprintf("bin %s of warehouse %s", loc.Bin, loc.WarehouseID.Hex())
+}
+
+// stockAt returns how much of an item the location holds that is not reserved for orders.
+func stockAt(ctx context.Context, stock db.StockStore, kind string, itemID primitive.ObjectID, loc types.StockLocation) (float64, error) {
+	levels, err := stock.GetStockLevels(ctx, bson.M{"itemKind": kind, "itemId": itemID, "warehouseId": loc.WarehouseID, "bin": loc.Bin})
+	if err != nil {
+		return 0, err
+	}
+	total := 0.0
+	for _, level := range levels {
+		total += level.Quantity - level.Reserved
+	}
+	return total, nil
+}
+
+// stockItemName returns the name of a product or material, or a 404 when there is none.
+func (h *StockHandler) stockItemName(ctx context.Context, kind string, itemID primitive.ObjectID) (string, error) {
+	var (
+		name string
+		err  error
+	)
+	if kind == types.StockMaterial {
+		var material *types.Material
+		if material, err = h.store.Material.GetMaterial(ctx, itemID); err == nil {
+			name = material.Name
+		}
+	} else {
+		var product *types.Product
+		if product, err = h.store.Product.GetProduct(ctx, itemID); err == nil {
+			name = product.Name
+		}
+	}
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return "", NewError(fiber.StatusNotFound, fmt.Sprintf("%s %s not found", kind, itemID.Hex()))
+	}
+	return name, err
+}
+
+// HandleGetStockLevels lists stock per location.
+//
+// @Summary Get stock levels
+// @Description Lists how much of each product and material every warehouse and bin holds. Stock not placed at a location is not listed; see /stock/{kind}/{id}.
+// @Tags Stock
+// @Param itemKind query string false "product or material"
+// @Param itemId query string false "Product or material ID"
+// @Param warehouseId query string false "Warehouse ID"
+// @Param bin query string false "Bin code, empty for stock held by the warehouse as a whole"
+// @Produce json
+// @Success 200 {array} types.StockLevel
+// @Router /stock [get]
+func (h *StockHandler) HandleGetStockLevels(c *fiber.Ctx) error {
+	filter := bson.M{}
+
+	if kind := c.Query("itemKind"); kind != "" {
+		if !contains(types.StockItemKinds, kind, false) {
+			return NewError(fiber.StatusBadRequest, "Invalid item kind")
+		}
+		filter["itemKind"] = kind
+	}
+	for _, field := range []string{"itemId", "warehouseId"} {
+		if id := c.Query(field); id != "" {
+			objID, err := primitive.ObjectIDFromHex(id)
+			if err != nil {
+				return NewError(fiber.StatusBadRequest, fmt.Sprintf("Invalid %s", field))
+			}
+			filter[field] = objID
+		}
+	}
+	if c.Context().QueryArgs().Has("bin") {
+		filter["bin"] = c.Query("bin")
+	}
+
+	levels, err := h.store.Stock.GetStockLevels(c.Context(), filter)
+	if err != nil {
+		return err
+	}
+
+	if len(levels) == 0 {
+		return NewError(fiber.StatusNotFound, "No Matches data found")
+	}
+
+	return c.JSON(levels)
+}
+
+// HandleGetStockBreakdown shows where the stock of a product or material is.
+//
+// @Summary Get stock of an item
+// @Description Shows the total stock of a product or material, how much every location holds and how much is not placed at any location.
+// @Tags Stock
+// @Param kind path string true "product or material"
+// @Param id path string true "Product or material ID"
+// @Produce json
+// @Success 200 {object} types.StockBreakdown
+// @Router /stock/{kind}/{id} [get]
+func (h *StockHandler) HandleGetStockBreakdown(c *fiber.Ctx) error {
+	kind := c.Params("kind")
+	if !contains(types.StockItemKinds, kind, false) {
+		return NewError(fiber.StatusNotFound, fmt.Sprintf("Unknown item kind %s", kind))
+	}
+	itemID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return NewError(fiber.StatusBadRequest, fmt.Sprintf("Invalid %s ID", kind))
+	}
+
+	breakdown, err := h.store.Stock.GetStockBreakdown(c.Context(), kind, itemID)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return NewError(fiber.StatusNotFound, fmt.Sprintf("%s %s not found", kind, itemID.Hex()))
+	}
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(breakdown)
+}
+
+// HandleGetStockTransfers lists stock transfers.
+//
+// @Summary Get stock transfers
+// @Description Lists stock transfers, newest first.
+// @Tags Stock
+// @Param warehouseId query string false "Warehouse the stock left or arrived at"
+// @Param itemId query string false "Product or material ID"
+// @Produce json
+// @Success 200 {array} types.StockTransfer
+// @Router /stockTransfer [get]
+func (h *StockHandler) HandleGetStockTransfers(c *fiber.Ctx) error {
+	filter := bson.M{}
+
+	if id := c.Query("warehouseId"); id != "" {
+		objID, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			return NewError(fiber.StatusBadRequest, "Invalid warehouse ID")
+		}
+		filter["$or"] = bson.A{bson.M{"from.warehouseId": objID}, bson.M{"to.warehouseId": objID}}
+	}
+	if id := c.Query("itemId"); id != "" {
+		objID, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			return NewError(fiber.StatusBadRequest, "Invalid item ID")
+		}
+		filter["items.itemId"] = objID
+	}
+
+	transfers, err := h.store.Stock.GetStockTransfers(c.Context(), filter)
+	if err != nil {
+		return err
+	}
+
+	if len(transfers) == 0 {
+		return NewError(fiber.StatusNotFound, "No Matches data found")
+	}
+
+	return c.JSON(transfers)
+}
+
+// HandleGetStockTransfer retrieves a stock transfer by ID.
+// @Summary Get stock transfer
+// @Description Get a stock transfer by ID.
+// @Tags Stock
+// @Produce json
+// @Param id path string true "Stock transfer ID"
+// @Success 200 {object} types.StockTransfer
+// @Router /stockTransfer/{id} [get]
+func (h *StockHandler) HandleGetStockTransfer(c *fiber.Ctx) error {
+	transfer, err := findRecord(c, "Stock transfer", h.store.Stock.GetStockTransfer)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(transfer)
+}
+
+// HandleInsertStockTransfer moves stock between locations.
+//
+// @Summary Insert stock transfer
+// @Description Moves products and materials from one warehouse or bin to another. Either every item moves or, when a location holds too little of one, none does and the response is 409. Without a source the transfer places stock that is not at any location yet, such as stock counted before warehouses were set up. Product quantities are whole pieces; material quantities are in the material's base unit.
+// @Tags Stock
+// @Accept json
+// @Produce json
+// @Param body body InsertStockTransferParams true "Stock transfer"
+// @Success 200 {object} types.StockTransfer
+// @Router /stockTransfer [post]
+func (h *StockHandler) HandleInsertStockTransfer(c *fiber.Ctx) error {
+	var params InsertStockTransferParams
+	if err := c.BodyParser(&params); err != nil {
+		return err
+	}
+
+	if err := params.validate(); err != nil {
+		return err
+	}
+
+	user, err := getAuthUser(c)
+	if err != nil {
+		return err
+	}
+
+	to, err := stockLocation(c.Context(), h.store.Warehouse, "", params.ToWarehouseID, params.ToBin)
+	if err != nil {
+		return err
+	}
+	transfer := types.StockTransfer{
+		To:           *to,
+		TransferDate: time.Now(),
+		Remark:       params.Remark,
+		CreatedBy:    user.ID,
+		CreatedAt:    time.Now(),
+	}
+
+	if params.FromWarehouseID != "" || params.FromBin != "" {
+		from, err := stockLocation(c.Context(), h.store.Warehouse, "from", params.FromWarehouseID, params.FromBin)
+		if err != nil {
+			return err
+		}
+		if *from == *to {
+			return ValidationError{"toBin": "is the location the stock is taken from"}
+		}
+		transfer.From = from
+	}
+
+	if params.TransferDate != "" {
+		transferDate, err := time.Parse(time.RFC3339Nano, params.TransferDate)
+		if err != nil {
+			return NewError(fiber.StatusBadRequest, "Invalid transfer date format")
+		}
+		transfer.TransferDate = transferDate
+	}
+
+	errs := ValidationError{}
+	for i, item := range params.Items {
+		itemID, _ := primitive.ObjectIDFromHex(item.ItemID)
+		if item.ItemKind == types.StockProduct && item.Quantity != float64(int(item.Quantity)) {
+			errs[fmt.Sprintf("items[%d].quantity", i)] = "should be a whole number of pieces"
+			continue
+		}
+
+		name, err := h.stockItemName(c.Context(), item.ItemKind, itemID)
+		if err != nil {
+			return err
+		}
+		transfer.Items = append(transfer.Items, types.StockTransferItem{
+			ItemKind: item.ItemKind,
+			ItemID:   itemID,
+			Name:     name,
+			Quantity: item.Quantity,
+		})
+	}
+	if err := errs.err(); err != nil {
+		return err
+	}
+
+	inserted, err := h.store.Stock.TransferStock(c.Context(), &transfer)
+	if errors.Is(err, db.ErrInsufficientStock) {
+		if transfer.From == nil {
+			return ErrInsufficientStock("Not enough of the items is unassigned to place it")
+		}
+		return ErrInsufficientStock(fmt.Sprintf("The %s holds less than the transfer moves", locationName(*transfer.From)))
+	}
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(inserted)
+}
+
+// HandleGetStockMovements lists the stock ledger.
+//
+// @Summary Get stock movements
+// @Description Lists the adjustments recorded in the stock ledger, newest first, each with its reason and the document that made it.
+// @Tags Stock
+// @Param itemKind query string false "product or material"
+// @Param itemId query string false "Product or material ID"
+// @Param warehouseId query string false "Warehouse ID"
+// @Param reason query string false "Reason"
+// @Param source query string false "Kind of document: stocktake, return or supplier_return"
+// @Param sourceId query string false "ID of the document"
+// @Produce json
+// @Success 200 {array} types.StockMovement
+// @Router /stockMovement [get]
+func (h *StockHandler) HandleGetStockMovements(c *fiber.Ctx) error {
+	filter := bson.M{}
+
+	if kind := c.Query("itemKind"); kind != "" {
+		if !contains(types.StockItemKinds, kind, false) {
+			return NewError(fiber.StatusBadRequest, "Invalid item kind")
+		}
+		filter["itemKind"] = kind
+	}
+	for _, query := range []string{"reason", "source"} {
+		if value := c.Query(query); value != "" {
+			filter[query] = value
+		}
+	}
+	fields := map[string]string{
+		"itemId":      "itemId",
+		"warehouseId": "location.warehouseId",
+		"sourceId":    "sourceId",
+	}
+	for query, field := range fields {
+		if id := c.Query(query); id != "" {
+			objID, err := primitive.ObjectIDFromHex(id)
+			if err != nil {
+				return NewError(fiber.StatusBadRequest, fmt.Sprintf("Invalid %s", query))
+			}
+			filter[field] = objID
+		}
+	}
+
+	movements, err := h.store.Stock.GetStockMovements(c.Context(), filter)
+	if err != nil {
+		return err
+	}
+
+	if len(movements) == 0 {
+		return NewError(fiber.StatusNotFound, "No Matches data found")
+	}
+
+	return c.JSON(movements)
+}
@@ -0,0 +1,450 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/johnson7543/ims/db"
+	"github.com/johnson7543/ims/types"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type InsertStocktakeParams struct {
+	// ItemKind limits the count to products or materials; both are counted when it is left out.
+	ItemKind string `json:"itemKind" validate:"enum=stockItemKind"`
+	// WarehouseID and Bin pick the location counted. Without them the item totals are counted.
+	WarehouseID string `json:"warehouseId" validate:"objectid"`
+	Bin         string `json:"bin"`
+	// ItemIDs limits the count to these products and materials. At a location they are
+	// counted along with the items held there.
+	ItemIDs []string `json:"itemIds"`
+	Remark  string   `json:"remark"`
+}
+
+func (p InsertStocktakeParams) validate() error {
+	errs := fieldErrors(p)
+	for i, id := range p.ItemIDs {
+		if !primitive.IsValidObjectID(id) {
+			errs[fmt.Sprintf("itemIds[%d]", i)] = fmt.Sprintf("%q is not a valid ID", id)
+		}
+	}
+	return errs.err()
+}
+
+type RecordStocktakeCountsParams struct {
+	Counts []StocktakeCountParams `json:"counts" validate:"required"`
+}
+
+type StocktakeCountParams struct {
+	ItemKind string `json:"itemKind" validate:"required,enum=stockItemKind"`
+	ItemID   string `json:"itemId" validate:"required,objectid"`
+	// Quantity is what the counter found, in pieces or the material's base unit. It replaces
+	// the counter's earlier count of the item.
+	Quantity float64 `json:"quantity" validate:"min=0"`
+	Remark   string  `json:"remark"`
+}
+
+func (p RecordStocktakeCountsParams) validate() error {
+	errs := fieldErrors(p)
+	for i, count := range p.Counts {
+		if count.ItemKind == types.StockProduct && count.Quantity != float64(int(count.Quantity)) {
+			errs[fmt.Sprintf("counts[%d].quantity", i)] = "should be a whole number of pieces"
+		}
+	}
+	return errs.err()
+}
+
+type PostStocktakeParams struct {
+	// Reason is recorded on every adjustment without a reason of its own in Lines.
+	Reason string                    `json:"reason" validate:"required,enum=stockReason"`
+	Lines  []PostStocktakeLineParams `json:"lines"`
+	// UncountedAsZero adjusts the items nobody counted to zero. They are left alone otherwise.
+	UncountedAsZero bool `json:"uncountedAsZero"`
+}
+
+type PostStocktakeLineParams struct {
+	ItemID string `json:"itemId" validate:"required,objectid"`
+	Reason string `json:"reason" validate:"required,enum=stockReason"`
+}
+
+func (p PostStocktakeParams) validate() error {
+	return validateStruct(p)
+}
+
+type StocktakeHandler struct {
+	store *db.Store
+}
+
+func NewStocktakeHandler(store *db.Store) *StocktakeHandler {
+	return &StocktakeHandler{
+		store: store,
+	}
+}
+
+// HandleGetStocktakes lists stocktakes.
+//
+// @Summary Get stocktakes
+// @Description Lists stocktakes, newest first, without their lines.
+// @Tags Stocktake
+// @Param status query string false "open, posted or canceled"
+// @Param warehouseId query string false "Warehouse counted"
+// @Produce json
+// @Success 200 {array} types.Stocktake
+// @Router /stocktake [get]
+func (h *StocktakeHandler) HandleGetStocktakes(c *fiber.Ctx) error {
+	filter := bson.M{}
+
+	if status := c.Query("status"); status != "" {
+		if !contains(types.StocktakeStatuses, status, false) {
+			return NewError(fiber.StatusBadRequest, "Invalid status")
+		}
+		filter["status"] = status
+	}
+	if id := c.Query("warehouseId"); id != "" {
+		objID, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			return NewError(fiber.StatusBadRequest, "Invalid warehouse ID")
+		}
+		filter["location.warehouseId"] = objID
+	}
+
+	stocktakes, err := h.store.Stocktake.GetStocktakes(c.Context(), filter)
+	if err != nil {
+		return err
+	}
+
+	if len(stocktakes) == 0 {
+		return NewError(fiber.StatusNotFound, "No Matches data found")
+	}
+
+	return c.JSON(stocktakes)
+}
+
+// HandleGetStocktake retrieves a stocktake by ID.
+//
+// @Summary Get stocktake
+// @Description Get a stocktake with the expected and counted quantity and the variance of every line. The lines query picks the lines to review: variances for counted lines that differ from what was expected, uncounted for lines nobody counted yet. The ETag header carries its version, to be sent back as If-Match when posting it.
+// @Tags Stocktake
+// @Produce json
+// @Param id path string true "Stocktake ID"
+// @Param lines query string false "all (default), variances or uncounted"
+// @Success 200 {object} types.Stocktake
+// @Header 200 {string} ETag "Version of the stocktake"
+// @Router /stocktake/{id} [get]
+func (h *StocktakeHandler) HandleGetStocktake(c *fiber.Ctx) error {
+	stocktake, err := findRecord(c, "Stocktake", h.store.Stocktake.GetStocktake)
+	if err != nil {
+		return err
+	}
+
+	var keep func(types.StocktakeLine) bool
+	switch c.Query("lines", "all") {
+	case "all":
+	case "variances":
+		keep = func(l types.StocktakeLine) bool { return l.Counted != nil && math.Abs(l.Variance) > 1e-9 }
+	case "uncounted":
+		keep = func(l types.StocktakeLine) bool { return l.Counted == nil }
+	default:
+		return NewError(fiber.StatusBadRequest, "Lines should be all, variances or uncounted")
+	}
+	if keep != nil {
+		lines := []types.StocktakeLine{}
+		for _, line := range stocktake.Lines {
+			if keep(line) {
+				lines = append(lines, line)
+			}
+		}
+		stocktake.Lines = lines
+	}
+
+	setETag(c, stocktake.Version)
+	return c.JSON(stocktake)
+}
+
+// HandleInsertStocktake starts a stocktake.
+//
+// @Summary Start stocktake
+// @Description Starts a stocktake of a warehouse or bin, or of the item totals when no location is given, and freezes the quantities expected of every item counted. Stock keeps moving while the count goes on; posting adjusts it by the variances only.
+// @Tags Stocktake
+// @Accept json
+// @Produce json
+// @Param body body InsertStocktakeParams true "What to count"
+// @Success 200 {object} types.Stocktake
+// @Router /stocktake [post]
+func (h *StocktakeHandler) HandleInsertStocktake(c *fiber.Ctx) error {
+	var params InsertStocktakeParams
+	if err := c.BodyParser(&params); err != nil {
+		return err
+	}
+
+	if err := params.validate(); err != nil {
+		return err
+	}
+
+	user, err := getAuthUser(c)
+	if err != nil {
+		return err
+	}
+
+	stocktake := types.Stocktake{
+		ItemKind:  params.ItemKind,
+		Remark:    params.Remark,
+		CreatedBy: user.ID,
+		CreatedAt: time.Now(),
+	}
+
+	if params.WarehouseID != "" || params.Bin != "" {
+		if stocktake.Location, err = stockLocation(c.Context(), h.store.Warehouse, "", params.WarehouseID, params.Bin); err != nil {
+			return err
+		}
+	}
+
+	itemIDs := []primitive.ObjectID{}
+	for i, id := range params.ItemIDs {
+		itemID, _ := primitive.ObjectIDFromHex(id)
+		if _, err := h.itemName(c, params.ItemKind, itemID); err != nil {
+			if errors.Is(err, mongo.ErrNoDocuments) {
+				return ValidationError{fmt.Sprintf("itemIds[%d]", i): fmt.Sprintf("%s does not exist", id)}
+			}
+			return err
+		}
+		itemIDs = append(itemIDs, itemID)
+	}
+
+	inserted, err := h.store.Stocktake.InsertStocktake(c.Context(), &stocktake, itemIDs)
+	if err != nil {
+		return err
+	}
+
+	setETag(c, inserted.Version)
+	return c.JSON(inserted)
+}
+
+// HandleRecordStocktakeCounts records counted quantities.
+//
+// @Summary Record counts
+// @Description Records what the signed in user counted. Several users can count the same stocktake; the count of a line is the sum of the latest count of every counter, so each counts a separate part of the stock. Counting again replaces the user's earlier count. At a location, items found that were not expected are added with an expected quantity of zero.
+// @Tags Stocktake
+// @Accept json
+// @Produce json
+// @Param id path string true "Stocktake ID"
+// @Param body body RecordStocktakeCountsParams true "Counts"
+// @Success 200 {object} types.Stocktake
+// @Router /stocktake/{id}/count [post]
+func (h *StocktakeHandler) HandleRecordStocktakeCounts(c *fiber.Ctx) error {
+	stocktake, err := findRecord(c, "Stocktake", h.store.Stocktake.GetStocktake)
+	if err != nil {
+		return err
+	}
+
+	var params RecordStocktakeCountsParams
+	if err := c.BodyParser(&params); err != nil {
+		return err
+	}
+
+	if err := params.validate(); err != nil {
+		return err
+	}
+
+	user, err := getAuthUser(c)
+	if err != nil {
+		return err
+	}
+
+	// Items missing from the sheet are looked up before the update, which may be retried.
+	names := map[primitive.ObjectID]string{}
+	errs := ValidationError{}
+	for i, count := range params.Counts {
+		itemID, _ := primitive.ObjectIDFromHex(count.ItemID)
+		field := fmt.Sprintf("counts[%d].itemId", i)
+		switch {
+		case stocktake.ItemKind != "" && count.ItemKind != stocktake.ItemKind:
+			errs[field] = fmt.Sprintf("the stocktake counts %ss only", stocktake.ItemKind)
+		case findStocktakeLine(stocktake, count.ItemKind, itemID) != nil:
+		case stocktake.Location == nil:
+			errs[field] = fmt.Sprintf("%s is not part of the stocktake", count.ItemID)
+		default:
+			name, err := h.itemName(c, count.ItemKind, itemID)
+			if errors.Is(err, mongo.ErrNoDocuments) {
+				errs[field] = fmt.Sprintf("%s does not exist", count.ItemID)
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			names[itemID] = name
+		}
+	}
+	if err := errs.err(); err != nil {
+		return err
+	}
+
+	updated, err := h.store.Stocktake.UpdateStocktakeLines(c.Context(), stocktake.ID, func(stocktake *types.Stocktake) error {
+		for _, count := range params.Counts {
+			itemID, _ := primitive.ObjectIDFromHex(count.ItemID)
+			line := findStocktakeLine(stocktake, count.ItemKind, itemID)
+			if line == nil {
+				stocktake.Lines = append(stocktake.Lines, types.StocktakeLine{
+					ItemKind: count.ItemKind,
+					ItemID:   itemID,
+					Name:     names[itemID],
+					Counts:   []types.StocktakeCount{},
+				})
+				line = &stocktake.Lines[len(stocktake.Lines)-1]
+			}
+
+			counts := []types.StocktakeCount{}
+			for _, earlier := range line.Counts {
+				if earlier.CountedBy != user.ID {
+					counts = append(counts, earlier)
+				}
+			}
+			line.Counts = append(counts, types.StocktakeCount{
+				CountedBy: user.ID,
+				Quantity:  count.Quantity,
+				Remark:    count.Remark,
+				CountedAt: time.Now(),
+			})
+			line.Recount()
+		}
+		return nil
+	})
+	if errors.Is(err, db.ErrStocktakeClosed) {
+		return NewError(fiber.StatusConflict, fmt.Sprintf("Stocktake %s is no longer open", stocktake.Number))
+	}
+	if err != nil {
+		return err
+	}
+
+	setETag(c, updated.Version)
+	return c.JSON(updated)
+}
+
+// HandlePostStocktake approves a stocktake and adjusts stock by its variances.
+//
+// @Summary Post stocktake
+// @Description Adjusts stock by the variance of every counted line, recording each adjustment with its reason in the stock ledger, and closes the stocktake. Either every adjustment is made or none is. Requires the If-Match header with the ETag of the stocktake as reviewed, so counts recorded after the review are not posted unseen. Admin only.
+// @Tags Stocktake
+// @Accept json
+// @Produce json
+// @Param id path string true "Stocktake ID"
+// @Param If-Match header string true "ETag of the stocktake as reviewed"
+// @Param body body PostStocktakeParams true "Reasons"
+// @Success 200 {object} types.Stocktake
+// @Router /admin/stocktake/{id}/post [post]
+func (h *StocktakeHandler) HandlePostStocktake(c *fiber.Ctx) error {
+	stocktake, err := findRecord(c, "Stocktake", h.store.Stocktake.GetStocktake)
+	if err != nil {
+		return err
+	}
+
+	version, err := ifMatchVersion(c)
+	if err != nil {
+		return err
+	}
+
+	var params PostStocktakeParams
+	if err := c.BodyParser(&params); err != nil {
+		return err
+	}
+
+	if err := params.validate(); err != nil {
+		return err
+	}
+
+	user, err := getAuthUser(c)
+	if err != nil {
+		return err
+	}
+
+	opts := db.PostStocktakeOptions{
+		Reason:          params.Reason,
+		LineReasons:     map[primitive.ObjectID]string{},
+		UncountedAsZero: params.UncountedAsZero,
+		By:              user.ID,
+	}
+	for i, line := range params.Lines {
+		itemID, _ := primitive.ObjectIDFromHex(line.ItemID)
+		if findStocktakeLine(stocktake, "", itemID) == nil {
+			return ValidationError{fmt.Sprintf("lines[%d].itemId", i): fmt.Sprintf("%s is not part of the stocktake", line.ItemID)}
+		}
+		opts.LineReasons[itemID] = line.Reason
+	}
+
+	posted, err := h.store.Stocktake.PostStocktake(c.Context(), stocktake.ID, version, opts)
+	switch {
+	case errors.Is(err, db.ErrVersionConflict):
+		return versionMismatch("Stocktake")
+	case errors.Is(err, db.ErrStocktakeClosed):
+		return NewError(fiber.StatusConflict, fmt.Sprintf("Stocktake %s is no longer open", stocktake.Number))
+	case errors.Is(err, db.ErrInsufficientStock):
+		return ErrInsufficientStock("Stock taken out since the count leaves too little to adjust; count the items again")
+	case err != nil:
+		return err
+	}
+
+	setETag(c, posted.Version)
+	return c.JSON(posted)
+}
+
+// HandleCancelStocktake abandons a stocktake.
+//
+// @Summary Cancel stocktake
+// @Description Closes an open stocktake without changing stock.
+// @Tags Stocktake
+// @Produce json
+// @Param id path string true "Stocktake ID"
+// @Success 200 {object} map[string]string
+// @Router /stocktake/{id}/cancel [post]
+func (h *StocktakeHandler) HandleCancelStocktake(c *fiber.Ctx) error {
+	stocktake, err := findRecord(c, "Stocktake", h.store.Stocktake.GetStocktake)
+	if err != nil {
+		return err
+	}
+
+	if _, err := h.store.Stocktake.CancelStocktake(c.Context(), stocktake.ID); err != nil {
+		if errors.Is(err, db.ErrStocktakeClosed) {
+			return NewError(fiber.StatusConflict, fmt.Sprintf("Stocktake %s is no longer open", stocktake.Number))
+		}
+		return err
+	}
+
+	return c.JSON(fiber.Map{"message": fmt.Sprintf("Stocktake %s canceled", stocktake.Number)})
+}
+
+// itemName returns the name of the product or material with id, trying both when kind is
+// empty. It returns mongo.ErrNoDocuments when there is none.
+func (h *StocktakeHandler) itemName(c *fiber.Ctx, kind string, id primitive.ObjectID) (string, error) {
+	if kind != types.StockMaterial {
+		product, err := h.store.Product.GetProduct(c.Context(), id)
+		if err == nil {
+			return product.Name, nil
+		}
+		if kind == types.StockProduct || !errors.Is(err, mongo.ErrNoDocuments) {
+			return "", err
+		}
+	}
+	material, err := h.store.Material.GetMaterial(c.Context(), id)
+	if err != nil {
+		return "", err
+	}
+	return material.Name, nil
+}
+
+// findStocktakeLine returns the line counting the item, of any kind when kind is empty.
+func findStocktakeLine(stocktake *types.Stocktake, kind string, itemID primitive.ObjectID) *types.StocktakeLine {
+	for i := range stocktake.Lines {
+		line := &stocktake.Lines[i]
+		if line.ItemID == itemID && (kind == "" || strings.EqualFold(line.ItemKind, kind)) {
+			return line
+		}
+	}
+	return nil
+}
@@ -0,0 +1,390 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/johnson7543/ims/db"
+	"github.com/johnson7543/ims/types"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type InsertSupplierReturnParams struct {
+	MaterialOrderID string `json:"materialOrderId" validate:"required,objectid"`
+	// WarehouseID and Bin pick where the material is taken from and replacements go. The
+	// material order's location is used when they are left out.
+	WarehouseID string                     `json:"warehouseId" validate:"objectid"`
+	Bin         string                     `json:"bin"`
+	Lines       []SupplierReturnLineParams `json:"lines" validate:"required"`
+	Remark      string                     `json:"remark"`
+}
+
+type SupplierReturnLineParams struct {
+	// Line is the index of the line in the material order's items, starting at 0.
+	Line int `json:"line" validate:"min=0"`
+	// Quantity is in the material's base unit.
+	Quantity   float64 `json:"quantity" validate:"gt=0"`
+	Reason     string  `json:"reason" validate:"required,enum=returnReason"`
+	Resolution string  `json:"resolution" validate:"required,enum=supplierReturnResolution"`
+	Remark     string  `json:"remark"`
+}
+
+func (p InsertSupplierReturnParams) validate() error {
+	errs := fieldErrors(p)
+	for i, line := range p.Lines {
+		for _, other := range p.Lines[:i] {
+			if line.Line == other.Line {
+				errs[fmt.Sprintf("lines[%d].line", i)] = fmt.Sprintf("material order line %d is returned twice", line.Line)
+			}
+		}
+	}
+	return errs.err()
+}
+
+type ReceiveReplacementParams struct {
+	Replacements []ReplacementParams `json:"replacements" validate:"required"`
+}
+
+type ReplacementParams struct {
+	// Line is the material order line of the return line replaced.
+	Line     int     `json:"line" validate:"min=0"`
+	Quantity float64 `json:"quantity" validate:"gt=0"`
+	// LotNumber is the lot the replacement is received as; a number is generated when it is
+	// empty.
+	LotNumber  string `json:"lotNumber"`
+	ReceivedAt string `json:"receivedAt" validate:"date"`
+	Remark     string `json:"remark"`
+}
+
+type RefundReceivedParams struct {
+	ReceivedAt string `json:"receivedAt" validate:"date"`
+}
+
+type SupplierReturnHandler struct {
+	store *db.Store
+}
+
+func NewSupplierReturnHandler(store *db.Store) *SupplierReturnHandler {
+	return &SupplierReturnHandler{
+		store: store,
+	}
+}
+
+// HandleGetSupplierReturns lists supplier returns.
+//
+// @Summary Get supplier returns
+// @Description Lists returns of material to sellers, newest first.
+// @Tags SupplierReturn
+// @Param materialOrderId query string false "Material order returned"
+// @Param sellerId query string false "Seller"
+// @Param status query string false "open or closed"
+// @Produce json
+// @Success 200 {array} types.SupplierReturn
+// @Router /supplierReturn [get]
+func (h *SupplierReturnHandler) HandleGetSupplierReturns(c *fiber.Ctx) error {
+	filter := bson.M{}
+
+	if status := c.Query("status"); status != "" {
+		if !contains(types.SupplierReturnStatuses, status, false) {
+			return NewError(fiber.StatusBadRequest, "Invalid status")
+		}
+		filter["status"] = status
+	}
+	if sellerID := c.Query("sellerId"); sellerID != "" {
+		filter["sellerId"] = sellerID
+	}
+	if err := idFilter(c, filter, "materialOrderId"); err != nil {
+		return err
+	}
+
+	returns, err := h.store.SupplierReturn.GetSupplierReturns(c.Context(), filter)
+	if err != nil {
+		return err
+	}
+
+	if len(returns) == 0 {
+		return NewError(fiber.StatusNotFound, "No Matches data found")
+	}
+
+	return c.JSON(returns)
+}
+
+// HandleGetSupplierReturn retrieves a supplier return by ID.
+//
+// @Summary Get supplier return
+// @Description Get a return of material to a seller with its lines and the replacements received. The ETag header carries its version, to be sent back as If-Match when receiving replacements or a refund.
+// @Tags SupplierReturn
+// @Produce json
+// @Param id path string true "Supplier return ID"
+// @Success 200 {object} types.SupplierReturn
+// @Header 200 {string} ETag "Version of the return"
+// @Router /supplierReturn/{id} [get]
+func (h *SupplierReturnHandler) HandleGetSupplierReturn(c *fiber.Ctx) error {
+	ret, err := findRecord(c, "Supplier return", h.store.SupplierReturn.GetSupplierReturn)
+	if err != nil {
+		return err
+	}
+
+	setETag(c, ret.Version)
+	return c.JSON(ret)
+}
+
+// HandleInsertSupplierReturn returns material to its seller.
+//
+// @Summary Return material to seller
+// @Description Sends defective material of a completed material order back to the seller, each line with a quantity in the material's base unit, a reason and a resolution. The material is taken out of the lot the order line was received as and out of stock, with a movement in the stock ledger. Lines resolved by credit are priced at what the order paid for them: while the order is unpaid the credit is taken off what the seller is owed for it, once paid the seller owes it as a refund. Lines resolved by replacement stay open until the seller delivers them again.
+// @Tags SupplierReturn
+// @Accept json
+// @Produce json
+// @Param body body InsertSupplierReturnParams true "Return"
+// @Success 200 {object} types.SupplierReturn
+// @Failure 409 {object} Error
+// @Router /supplierReturn [post]
+func (h *SupplierReturnHandler) HandleInsertSupplierReturn(c *fiber.Ctx) error {
+	var params InsertSupplierReturnParams
+	if err := c.BodyParser(&params); err != nil {
+		return err
+	}
+
+	if err := params.validate(); err != nil {
+		return err
+	}
+
+	orderID, _ := primitive.ObjectIDFromHex(params.MaterialOrderID)
+	order, err := h.store.MaterialOrder.GetMaterialOrder(c.Context(), orderID)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return ValidationError{"materialOrderId": fmt.Sprintf("%s does not exist", params.MaterialOrderID)}
+	}
+	if err != nil {
+		return err
+	}
+	if !strings.EqualFold(order.Status, types.MaterialOrderCompleted) {
+		return NewError(fiber.StatusConflict, "Only material of a completed material order can be returned")
+	}
+
+	errs := ValidationError{}
+	lines := make([]types.SupplierReturnLine, len(params.Lines))
+	for i, line := range params.Lines {
+		if line.Line >= len(order.MaterialOrderItems) {
+			errs[fmt.Sprintf("lines[%d].line", i)] = fmt.Sprintf("the material order has %d lines", len(order.MaterialOrderItems))
+			continue
+		}
+		item := order.MaterialOrderItems[line.Line]
+		if left := item.StockQuantity() - item.Returned; line.Quantity > left {
+			errs[fmt.Sprintf("lines[%d].quantity", i)] = fmt.Sprintf("only %g received and not returned", left)
+			continue
+		}
+		lines[i] = types.SupplierReturnLine{
+			Line:       line.Line,
+			Quantity:   line.Quantity,
+			Reason:     line.Reason,
+			Resolution: line.Resolution,
+			Remark:     line.Remark,
+		}
+	}
+	if err := errs.err(); err != nil {
+		return err
+	}
+
+	user, err := getAuthUser(c)
+	if err != nil {
+		return err
+	}
+
+	ret := types.SupplierReturn{
+		MaterialOrderID: orderID,
+		Lines:           lines,
+		Location:        order.Location,
+		Remark:          params.Remark,
+		CreatedBy:       user.ID,
+		CreatedAt:       time.Now(),
+	}
+	if params.WarehouseID != "" || params.Bin != "" {
+		if ret.Location, err = stockLocation(c.Context(), h.store.Warehouse, "", params.WarehouseID, params.Bin); err != nil {
+			return err
+		}
+	}
+
+	inserted, err := h.store.SupplierReturn.InsertSupplierReturn(c.Context(), &ret)
+	switch {
+	case errors.Is(err, db.ErrVersionConflict):
+		return versionMismatch("Material Order")
+	case errors.Is(err, db.ErrInsufficientStock):
+		return ErrInsufficientStock("The material to return is no longer in stock or in the lot it was received as")
+	case err != nil:
+		return err
+	}
+
+	setETag(c, inserted.Version)
+	return c.JSON(inserted)
+}
+
+// HandleReceiveReplacement records replacement material delivered for a supplier return.
+//
+// @Summary Receive replacement
+// @Description Records replacement deliveries for lines of a return resolved by replacement, for part or all of a line. Each is received as a new lot of the material order and put into stock with a movement in the stock ledger. Either every delivery is recorded or none is. The return is closed once everything to be replaced has been delivered. Requires the If-Match header with the ETag of the return.
+// @Tags SupplierReturn
+// @Accept json
+// @Produce json
+// @Param id path string true "Supplier return ID"
+// @Param If-Match header string true "ETag of the return as last read"
+// @Param body body ReceiveReplacementParams true "Replacements"
+// @Success 200 {object} types.SupplierReturn
+// @Failure 409 {object} Error
+// @Failure 412 {object} Error
+// @Router /supplierReturn/{id}/replacement [post]
+func (h *SupplierReturnHandler) HandleReceiveReplacement(c *fiber.Ctx) error {
+	ret, err := findRecord(c, "Supplier return", h.store.SupplierReturn.GetSupplierReturn)
+	if err != nil {
+		return err
+	}
+
+	version, err := ifMatchVersion(c)
+	if err != nil {
+		return err
+	}
+
+	var params ReceiveReplacementParams
+	if err := c.BodyParser(&params); err != nil {
+		return err
+	}
+
+	if err := validateStruct(params); err != nil {
+		return err
+	}
+
+	user, err := getAuthUser(c)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	errs := ValidationError{}
+	receiving := map[int]float64{}
+	inputs := make([]db.ReplacementInput, len(params.Replacements))
+	for i, replacement := range params.Replacements {
+		line := ret.FindLine(replacement.Line)
+		if line == nil || line.Resolution != types.SupplierReturnReplacement {
+			errs[fmt.Sprintf("replacements[%d].line", i)] = fmt.Sprintf("material order line %d is not being replaced by the return", replacement.Line)
+			continue
+		}
+		receiving[replacement.Line] += replacement.Quantity
+		if left := line.Quantity - line.Replaced; receiving[replacement.Line] > left {
+			errs[fmt.Sprintf("replacements[%d].quantity", i)] = fmt.Sprintf("only %g left to replace", left)
+			continue
+		}
+
+		receivedAt := now
+		if replacement.ReceivedAt != "" {
+			receivedAt, _ = time.Parse(time.RFC3339Nano, replacement.ReceivedAt)
+		}
+		inputs[i] = db.ReplacementInput{
+			Line: replacement.Line,
+			Replacement: types.SupplierReplacement{
+				Quantity:   replacement.Quantity,
+				LotNumber:  replacement.LotNumber,
+				Remark:     replacement.Remark,
+				ReceivedBy: user.ID,
+				ReceivedAt: receivedAt,
+			},
+		}
+	}
+	if err := errs.err(); err != nil {
+		return err
+	}
+
+	received, err := h.store.SupplierReturn.ReceiveReplacement(c.Context(), ret.ID, version, inputs)
+	switch {
+	case errors.Is(err, db.ErrVersionConflict):
+		return versionMismatch("Supplier return")
+	case errors.Is(err, db.ErrSupplierReturnClosed):
+		return NewError(fiber.StatusConflict, fmt.Sprintf("Return %s is closed", ret.Number))
+	case errors.Is(err, db.ErrLotExists):
+		return NewError(fiber.StatusConflict, err.Error())
+	case err != nil:
+		return err
+	}
+
+	setETag(c, received.Version)
+	return c.JSON(received)
+}
+
+// HandleRefundReceived records the refund of a supplier return.
+//
+// @Summary Receive refund
+// @Description Records that the seller paid back the credit of a return of a material order that was already paid, so it no longer counts against the seller's balance. Requires the If-Match header with the ETag of the return.
+// @Tags SupplierReturn
+// @Accept json
+// @Produce json
+// @Param id path string true "Supplier return ID"
+// @Param If-Match header string true "ETag of the return as last read"
+// @Param body body RefundReceivedParams false "When the refund was received, now when left out"
+// @Success 200 {object} map[string]string
+// @Failure 409 {object} Error
+// @Failure 412 {object} Error
+// @Router /supplierReturn/{id}/refund [post]
+func (h *SupplierReturnHandler) HandleRefundReceived(c *fiber.Ctx) error {
+	ret, err := findRecord(c, "Supplier return", h.store.SupplierReturn.GetSupplierReturn)
+	if err != nil {
+		return err
+	}
+
+	version, err := ifMatchVersion(c)
+	if err != nil {
+		return err
+	}
+
+	var params RefundReceivedParams
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&params); err != nil {
+			return err
+		}
+	}
+
+	if err := validateStruct(params); err != nil {
+		return err
+	}
+
+	receivedAt := time.Now()
+	if params.ReceivedAt != "" {
+		receivedAt, _ = time.Parse(time.RFC3339Nano, params.ReceivedAt)
+	}
+
+	updated, err := h.store.SupplierReturn.MarkRefundReceived(c.Context(), ret.ID, version, receivedAt)
+	if errors.Is(err, db.ErrNoRefundDue) {
+		return NewError(fiber.StatusConflict, fmt.Sprintf("Return %s has no refund due", ret.Number))
+	}
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return versionMismatch("Supplier return")
+	}
+
+	setETag(c, version+1)
+	return c.JSON(fiber.Map{"message": fmt.Sprintf("Refund of %.2f for return %s received", ret.Credit, ret.Number)})
+}
+
+// HandleGetSellerBalance reports what is owed between the business and a seller.
+//
+// @Summary Get seller balance
+// @Description Sums the seller's unpaid material orders, less what supplier returns credited on them, and the refunds the seller has yet to pay for returns of paid orders. Balance is what is owed to the seller, negative when the seller owes.
+// @Tags SupplierReturn
+// @Produce json
+// @Param id path string true "Seller ID"
+// @Success 200 {object} types.SellerBalance
+// @Router /seller/{id}/balance [get]
+func (h *SupplierReturnHandler) HandleGetSellerBalance(c *fiber.Ctx) error {
+	balance, err := h.store.SupplierReturn.GetSellerBalance(c.Context(), c.Params("id"))
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(balance)
+}
@@ -0,0 +1,103 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/johnson7543/ims/db"
+	"github.com/johnson7543/ims/types"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type UnitConversionParams struct {
+	Unit string `json:"unit" validate:"required"`
+	// Factor is how many base units one Unit holds.
+	Factor float64 `json:"factor" validate:"gt=0"`
+}
+
+// unitLookups lists the base unit and the conversion units of a material for checkLookups.
+// current is the material as stored, or nil for a new one.
+func unitLookups(unit *string, units []UnitConversionParams, current *types.Material) []lookupValue {
+	values := []lookupValue{{field: "unit", kind: types.LookupUnit, value: unit}}
+	for i := range units {
+		values = append(values, lookupValue{field: fmt.Sprintf("units[%d].unit", i), kind: types.LookupUnit, value: &units[i].Unit})
+	}
+	if current != nil {
+		values[0].current = current.Unit
+		for i := range units {
+			if _, ok := current.UnitFactor(units[i].Unit); ok {
+				values[i+1].current = units[i].Unit
+			}
+		}
+	}
+	return values
+}
+
+// materialUnits checks the conversions of a material with base unit base.
+func materialUnits(base string, params []UnitConversionParams) ([]types.UnitConversion, error) {
+	errs := ValidationError{}
+	units := make([]types.UnitConversion, 0, len(params))
+	seen := map[string]bool{}
+	for i, u := range params {
+		field := fmt.Sprintf("units[%d].unit", i)
+		switch {
+		case base == "":
+			errs["unit"] = "a base unit is required to convert other units into"
+		case u.Unit == base:
+			errs[field] = fmt.Sprintf("%s is the base unit", u.Unit)
+		case seen[u.Unit]:
+			errs[field] = fmt.Sprintf("%s is listed twice", u.Unit)
+		}
+		seen[u.Unit] = true
+		units = append(units, types.UnitConversion{Unit: u.Unit, Factor: u.Factor})
+	}
+	return units, errs.err()
+}
+
+// materialOrderItem turns the params of a material order line into a line, converting its
+// quantity into the base unit of the material. field is the path of the line in the request,
+// for error messages.
+func materialOrderItem(ctx context.Context, materials db.MaterialStore, field string, item InsertMaterialOrderItemParams) (types.MaterialOrderItem, error) {
+	materialID, err := primitive.ObjectIDFromHex(item.Material.MaterialID)
+	if err != nil {
+		return types.MaterialOrderItem{}, ValidationError{field + ".material.id": "a material ID is required"}
+	}
+
+	line := types.MaterialOrderItem{
+		Material: types.MaterialOrderMaterial{
+			MaterialID: materialID,
+			Name:       item.Material.Name,
+			Price:      item.Material.Price,
+			Color:      item.Material.Color,
+			Size:       item.Material.Size,
+			Remarks:    item.Material.Remarks,
+		},
+		Quantity:     item.Quantity,
+		Unit:         item.Unit,
+		BaseQuantity: item.Quantity,
+		TotalPrice:   item.TotalPrice,
+		LotNumber:    strings.TrimSpace(item.LotNumber),
+	}
+	if item.Unit == "" {
+		return line, nil
+	}
+
+	material, err := materials.GetMaterial(ctx, materialID)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return line, ValidationError{field + ".material.id": fmt.Sprintf("material %s does not exist", materialID.Hex())}
+	}
+	if err != nil {
+		return line, err
+	}
+
+	base, ok := material.ToBase(item.Quantity, item.Unit)
+	if !ok {
+		return line, ValidationError{field + ".unit": fmt.Sprintf("%s is not a unit of material %s, add it to the material's units first", item.Unit, material.Name)}
+	}
+	line.BaseQuantity = base
+	return line, nil
+}
@@ -0,0 +1,249 @@
+package api
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/johnson7543/ims/types"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ValidationError lists what is wrong with each failing field of a request, keyed by the
+// field's JSON path, e.g. orderItems[0].quantity. ErrorHandler answers it with 422.
+type ValidationError map[string]string
+
+func (e ValidationError) Error() string {
+	fields := make([]string, 0, len(e))
+	for field := range e {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	return "invalid " + strings.Join(fields, ", ")
+}
+
+// validationEnums are the value lists the enum rule can refer to.
+var validationEnums = map[string][]string{
+	"orderStatus":              types.OrderStatuses,
+	"materialOrderStatus":      types.MaterialOrderStatuses,
+	"role":                     types.PartyRoles,
+	"addressLabel":             types.AddressLabels,
+	"lookupKind":               types.LookupKinds,
+	"stockItemKind":            types.StockItemKinds,
+	"labelKind":                types.LabelKinds,
+	"symbology":                types.Symbologies,
+	"stockReason":              types.StockReasons,
+	"returnReason":             types.ReturnReasons,
+	"returnOutcome":            types.ReturnOutcomes,
+	"supplierReturnResolution": types.SupplierReturnResolutions,
+}
+
+// validateStruct checks params against the rules in its validate tags and returns a
+// ValidationError listing every failing field, or nil. Nested structs and slices of structs
+// are checked too. The rules, separated by commas, are:
+//
+//	required   the value is not empty
+//	min=n      a number is at least n, a string or slice has at least n elements
+//	max=n      a number is at most n, a string or slice has at most n elements
+//	gt=n       a number is greater than n
+//	objectid   a string is a hex ObjectID
+//	date       a string is an RFC 3339 timestamp
+//	email      a string looks like an email address
+//	taxid      a string is a unified business number with a valid checksum
+//	skutemplate  a string is a SKU template, see renderSKU
+//	enum=name  a string, or every string of a slice, is one of validationEnums[name]
+//	enumfold=name  like enum, ignoring case
+//
+// Apart from required, string and slice rules skip empty values.
+func validateStruct(params any) error {
+	return fieldErrors(params).err()
+}
+
+// fieldErrors checks params like validateStruct, for callers that add checks of their own.
+func fieldErrors(params any) ValidationError {
+	errs := ValidationError{}
+	validateValue(reflect.ValueOf(params), "", errs)
+	return errs
+}
+
+// err returns e as an error, or nil when no field failed.
+func (e ValidationError) err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+func validateValue(v reflect.Value, path string, errs ValidationError) {
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return
+		}
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if !field.IsExported() {
+				continue
+			}
+			fieldPath := joinPath(path, jsonName(field))
+			if rules := field.Tag.Get("validate"); rules != "" {
+				if msg := checkRules(v.Field(i), rules); msg != "" {
+					errs[fieldPath] = msg
+					continue
+				}
+			}
+			validateValue(v.Field(i), fieldPath, errs)
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			validateValue(v.Index(i), fmt.Sprintf("%s[%d]", path, i), errs)
+		}
+	}
+}
+
+// checkRules returns what is wrong with v, or "" when it passes every rule.
+func checkRules(v reflect.Value, rules string) string {
+	empty := v.IsZero() || ((v.Kind() == reflect.Slice || v.Kind() == reflect.String) && v.Len() == 0)
+
+	for _, rule := range strings.Split(rules, ",") {
+		name, arg, _ := strings.Cut(strings.TrimSpace(rule), "=")
+		if name == "required" {
+			if empty && v.Kind() != reflect.Bool {
+				return "is required"
+			}
+			continue
+		}
+		if empty && (v.Kind() == reflect.String || v.Kind() == reflect.Slice) {
+			return ""
+		}
+		if msg := checkRule(v, name, arg); msg != "" {
+			return msg
+		}
+	}
+	return ""
+}
+
+func checkRule(v reflect.Value, name, arg string) string {
+	switch name {
+	case "min", "max", "gt":
+		limit, err := strconv.ParseFloat(arg, 64)
+		if err != nil {
+			panic(fmt.Sprintf("validate: bad %s limit %q", name, arg))
+		}
+		n, isNumber := number(v)
+		if !isNumber {
+			n = float64(length(v))
+		}
+		switch {
+		case name == "min" && n < limit:
+			if isNumber {
+				return fmt.Sprintf("should be at least %s", arg)
+			}
+			return fmt.Sprintf("should have at least %s characters or items", arg)
+		case name == "max" && n > limit:
+			if isNumber {
+				return fmt.Sprintf("should be at most %s", arg)
+			}
+			return fmt.Sprintf("should have at most %s characters or items", arg)
+		case name == "gt" && n <= limit:
+			return fmt.Sprintf("should be greater than %s", arg)
+		}
+
+	case "objectid":
+		if !primitive.IsValidObjectID(v.String()) {
+			return fmt.Sprintf("%q is not a valid ID", v.String())
+		}
+
+	case "date":
+		if _, err := time.Parse(time.RFC3339Nano, v.String()); err != nil {
+			return fmt.Sprintf("%q is not an RFC 3339 date, e.g. 2023-09-01T08:00:00Z", v.String())
+		}
+
+	case "email":
+		if !strings.Contains(v.String(), "@") {
+			return fmt.Sprintf("%q is not a valid email", v.String())
+		}
+
+	case "taxid":
+		if !types.IsValidTaxIdNumber(v.String()) {
+			return fmt.Sprintf("%q is not an 8 digit unified business number with a valid checksum", v.String())
+		}
+
+	case "skutemplate":
+		if err := checkSKUTemplate(v.String()); err != nil {
+			return err.Error()
+		}
+
+	case "enum", "enumfold":
+		allowed, ok := validationEnums[arg]
+		if !ok {
+			panic(fmt.Sprintf("validate: unknown enum %q", arg))
+		}
+		values := []string{v.String()}
+		if v.Kind() == reflect.Slice {
+			values = v.Interface().([]string)
+		}
+		for _, value := range values {
+			if !contains(allowed, value, name == "enumfold") {
+				return fmt.Sprintf("%q should be one of %s", value, strings.Join(allowed, ", "))
+			}
+		}
+
+	default:
+		panic(fmt.Sprintf("validate: unknown rule %q", name))
+	}
+	return ""
+}
+
+func number(v reflect.Value) (float64, bool) {
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	}
+	return 0, false
+}
+
+func length(v reflect.Value) int {
+	if v.Kind() == reflect.String {
+		return utf8.RuneCountInString(v.String())
+	}
+	return v.Len()
+}
+
+func contains(values []string, value string, fold bool) bool {
+	for _, v := range values {
+		if v == value || (fold && strings.EqualFold(v, value)) {
+			return true
+		}
+	}
+	return false
+}
+
+func jsonName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "" || name == "-" {
+		return field.Name
+	}
+	return name
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
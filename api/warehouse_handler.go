@@ -0,0 +1,337 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/johnson7543/ims/db"
+	"github.com/johnson7543/ims/types"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type InsertWarehouseParams struct {
+	Code    string      `json:"code" validate:"required"`
+	Name    string      `json:"name" validate:"required"`
+	Address string      `json:"address"`
+	Bins    []BinParams `json:"bins"`
+	Default bool        `json:"default"`
+	Remark  string      `json:"remark"`
+}
+
+type BinParams struct {
+	Code string `json:"code" validate:"required"`
+	Name string `json:"name"`
+}
+
+func (p InsertWarehouseParams) validate() error {
+	if err := validateStruct(p); err != nil {
+		return err
+	}
+	return checkBins(p.Bins)
+}
+
+type UpdateWarehouseParams struct {
+	Code    string      `json:"code" validate:"required"`
+	Name    string      `json:"name" validate:"required"`
+	Address string      `json:"address"`
+	Bins    []BinParams `json:"bins"`
+	Default bool        `json:"default"`
+	Remark  string      `json:"remark"`
+}
+
+func (p *UpdateWarehouseParams) validate() error {
+	if err := validateStruct(p); err != nil {
+		return err
+	}
+	return checkBins(p.Bins)
+}
+
+// checkBins makes sure no two bins share a code.
+func checkBins(bins []BinParams) error {
+	errs := ValidationError{}
+	for i, bin := range bins {
+		for _, other := range bins[:i] {
+			if strings.EqualFold(strings.TrimSpace(bin.Code), strings.TrimSpace(other.Code)) {
+				errs[fmt.Sprintf("bins[%d].code", i)] = fmt.Sprintf("%q is already a bin of the warehouse", bin.Code)
+			}
+		}
+	}
+	return errs.err()
+}
+
+func warehouseBins(params []BinParams) []types.Bin {
+	bins := make([]types.Bin, len(params))
+	for i, p := range params {
+		bins[i] = types.Bin{Code: strings.TrimSpace(p.Code), Name: p.Name}
+	}
+	return bins
+}
+
+type WarehouseHandler struct {
+	store *db.Store
+}
+
+func NewWarehouseHandler(store *db.Store) *WarehouseHandler {
+	return &WarehouseHandler{
+		store: store,
+	}
+}
+
+// HandleGetWarehouses retrieves a list of warehouses based on query parameters.
+//
+// @Summary Get warehouses
+// @Description Retrieves a list of warehouses with their bins.
+// @Tags Warehouse
+// @Param id query string false "Warehouse ID"
+// @Param code query string false "Code"
+// @Param name query string false "Name"
+// @Param includeArchived query string false "true to include archived records, only to list just the archived ones"
+// @Produce json
+// @Success 200 {array} types.Warehouse
+// @Router /warehouse [get]
+func (h *WarehouseHandler) HandleGetWarehouses(c *fiber.Ctx) error {
+	filter := bson.M{}
+
+	if id := c.Query("id"); id != "" {
+		objID, err := primitive.ObjectIDFromHex(id)
+		if err != nil {
+			return NewError(fiber.StatusBadRequest, "Invalid warehouse ID")
+		}
+		filter["_id"] = objID
+	}
+	for _, field := range []string{"code", "name"} {
+		if value := c.Query(field); value != "" {
+			filter[field] = value
+		}
+	}
+
+	if err := archivedFilter(c, filter); err != nil {
+		return err
+	}
+
+	warehouses, err := h.store.Warehouse.GetWarehouses(c.Context(), filter)
+	if err != nil {
+		return err
+	}
+
+	if len(warehouses) == 0 {
+		return NewError(fiber.StatusNotFound, "No Matches data found")
+	}
+
+	if len(warehouses) == 1 {
+		setETag(c, warehouses[0].Version)
+	}
+
+	return c.JSON(warehouses)
+}
+
+// HandleGetWarehouse retrieves a warehouse by ID.
+// @Summary Get warehouse
+// @Description Get a warehouse by ID. The ETag header carries its version, to be sent back as If-Match when updating it.
+// @Tags Warehouse
+// @Produce json
+// @Param id path string true "Warehouse ID"
+// @Param includeArchived query string false "true to find the record even if archived"
+// @Success 200 {object} types.Warehouse
+// @Header 200 {string} ETag "Version of the warehouse"
+// @Router /warehouse/{id} [get]
+func (h *WarehouseHandler) HandleGetWarehouse(c *fiber.Ctx) error {
+	warehouse, err := findRecord(c, "Warehouse", h.store.Warehouse.GetWarehouse)
+	if err != nil {
+		return err
+	}
+
+	setETag(c, warehouse.Version)
+	return c.JSON(warehouse)
+}
+
+// codeInUse reports whether another warehouse, archived ones included, has code.
+func (h *WarehouseHandler) codeInUse(c *fiber.Ctx, code string, id primitive.ObjectID) error {
+	filter := db.IncludeArchived(bson.M{"_id": bson.M{"$ne": id}})
+	warehouses, err := h.store.Warehouse.GetWarehouses(c.Context(), filter)
+	if err != nil {
+		return err
+	}
+	for _, w := range warehouses {
+		if strings.EqualFold(w.Code, code) {
+			return NewError(fiber.StatusConflict, fmt.Sprintf("The warehouse code %s is already in use", code))
+		}
+	}
+	return nil
+}
+
+// HandleInsertWarehouse inserts a new warehouse.
+//
+// @Summary Insert warehouse
+// @Description Inserts a warehouse with its bins. A default warehouse takes over the default from the previous one; orders and material orders that name no location use it.
+// @Tags Warehouse
+// @Accept json
+// @Produce json
+// @Param body body InsertWarehouseParams true "Warehouse information"
+// @Success 200 {object} fiber.Map
+// @Router /warehouse [post]
+func (h *WarehouseHandler) HandleInsertWarehouse(c *fiber.Ctx) error {
+	var params InsertWarehouseParams
+	if err := c.BodyParser(&params); err != nil {
+		return err
+	}
+
+	if err := params.validate(); err != nil {
+		return err
+	}
+
+	if err := h.codeInUse(c, params.Code, primitive.NilObjectID); err != nil {
+		return err
+	}
+
+	warehouse := types.Warehouse{
+		Code:    params.Code,
+		Name:    params.Name,
+		Address: params.Address,
+		Bins:    warehouseBins(params.Bins),
+		Default: params.Default,
+		Remark:  params.Remark,
+	}
+
+	inserted, err := h.store.Warehouse.InsertWarehouse(c.Context(), &warehouse)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(fiber.Map{
+		"message": fmt.Sprintf("Warehouse inserted successfully, ID: %s, Code: %s", inserted.ID.Hex(), inserted.Code),
+	})
+}
+
+// HandleUpdateWarehouse updates an existing warehouse.
+// @Summary Update warehouse
+// @Description Update a warehouse. The body is a JSON merge patch: fields left out keep their value and null clears a field. Bins that still hold stock cannot be removed.
+// @Tags Warehouse
+// @Accept json,application/merge-patch+json
+// @Produce json
+// @Param id path string true "Warehouse ID"
+// @Param If-Match header string true "ETag of the warehouse as last read"
+// @Param body body UpdateWarehouseParams true "Fields to change"
+// @Success 200 {object} fiber.Map
+// @Failure 412 {object} Error
+// @Failure 428 {object} Error
+// @Router /warehouse/{id} [patch]
+func (h *WarehouseHandler) HandleUpdateWarehouse(c *fiber.Ctx) error {
+	version, err := ifMatchVersion(c)
+	if err != nil {
+		return err
+	}
+
+	current, err := findRecord(c, "Warehouse", h.store.Warehouse.GetWarehouse)
+	if err != nil {
+		return err
+	}
+	if current.Version != version {
+		return versionMismatch("Warehouse")
+	}
+
+	var params UpdateWarehouseParams
+	if err := bindMergePatch(c, current, &params); err != nil {
+		return err
+	}
+
+	if err := params.validate(); err != nil {
+		return err
+	}
+
+	if !strings.EqualFold(params.Code, current.Code) {
+		if err := h.codeInUse(c, params.Code, current.ID); err != nil {
+			return err
+		}
+	}
+
+	updated := types.Warehouse{
+		Code:    params.Code,
+		Name:    params.Name,
+		Address: params.Address,
+		Bins:    warehouseBins(params.Bins),
+		Default: params.Default,
+		Remark:  params.Remark,
+	}
+
+	for _, bin := range current.Bins {
+		if updated.FindBin(bin.Code) != nil {
+			continue
+		}
+		levels, err := h.store.Stock.GetStockLevels(c.Context(), bson.M{"warehouseId": current.ID, "bin": bin.Code})
+		if err != nil {
+			return err
+		}
+		if len(levels) > 0 {
+			return NewError(fiber.StatusConflict, fmt.Sprintf("Bin %s still holds stock, transfer it elsewhere before removing the bin", bin.Code))
+		}
+	}
+
+	updateCount, err := h.store.Warehouse.UpdateWarehouse(c.Context(), current.ID, &updated, version)
+	if err != nil {
+		return updateError("Warehouse", err)
+	}
+
+	if updateCount == 0 {
+		return NewError(fiber.StatusNotFound, "Warehouse not found or not updated")
+	}
+
+	setETag(c, version+1)
+
+	return c.JSON(fiber.Map{
+		"message": "Warehouse updated successfully",
+	})
+}
+
+// HandleDeleteWarehouse deletes a warehouse by ID.
+//
+// @Summary Delete warehouse
+// @Description Archives a warehouse. Warehouses still holding stock are not archived; the response is 409 listing the stock levels. Transfer the stock elsewhere first, or, as an admin, pass mode=archive to archive the warehouse regardless.
+// @Tags Warehouse
+// @Param id path string true "Warehouse ID"
+// @Param mode query string false "archive, admins only"
+// @Produce json
+// @Success 200 {object} fiber.Map
+// @Router /warehouse/{id} [delete]
+func (h *WarehouseHandler) HandleDeleteWarehouse(c *fiber.Ctx) error {
+	objID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return NewError(fiber.StatusBadRequest, "Invalid warehouse ID")
+	}
+
+	opts, err := deleteOptions(c)
+	if err != nil {
+		return err
+	}
+	if opts.Mode == db.DeleteCascade {
+		return NewError(fiber.StatusBadRequest, "Warehouses cannot be deleted with mode cascade, transfer their stock instead")
+	}
+
+	deleteCount, err := h.store.Warehouse.DeleteWarehouse(c.Context(), objID, opts)
+	if err != nil {
+		return deleteError(c, "Warehouse", err)
+	}
+	if deleteCount == 0 {
+		return NewError(fiber.StatusNotFound, "Warehouse not found")
+	}
+
+	return c.JSON(fiber.Map{
+		"message": deletedMessage("Warehouse"),
+	})
+}
+
+// HandleRestoreWarehouse restores an archived warehouse.
+//
+// @Summary Restore warehouse
+// @Description Brings back an archived warehouse.
+// @Tags Warehouse
+// @Param id path string true "Warehouse ID"
+// @Produce json
+// @Success 200 {object} fiber.Map
+// @Router /warehouse/{id}/restore [post]
+func (h *WarehouseHandler) HandleRestoreWarehouse(c *fiber.Ctx) error {
+	return restoreRecord(c, "Warehouse", h.store.Warehouse.RestoreWarehouse)
+}
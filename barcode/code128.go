@@ -0,0 +1,125 @@
+package barcode
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrEmpty is returned when encoding an empty value.
+var ErrEmpty = errors.New("barcode: nothing to encode")
+
+// code128Patterns holds the bar and space widths of the Code 128 symbols, bar first. The
+// last one is the stop pattern.
+var code128Patterns = [...]string{
+	"212222", "222122", "222221", "121223", "121322", "131222", "122213", "122312", "132212", "221213",
+	"221312", "231212", "112232", "122132", "122231", "113222", "123122", "123221", "223211", "221132",
+	"221231", "213212", "223112", "312131", "311222", "321122", "321221", "312212", "322112", "322211",
+	"212123", "212321", "232121", "111323", "131123", "131321", "112313", "132113", "132311", "211313",
+	"231113", "231311", "112133", "112331", "132131", "113123", "113321", "133121", "313121", "211331",
+	"231131", "213113", "213311", "213131", "311123", "311321", "331121", "312113", "312311", "332111",
+	"314111", "221411", "431111", "111224", "111422", "121124", "121421", "141122", "141221", "112214",
+	"112412", "122114", "122411", "142112", "142211", "241211", "221114", "413111", "241112", "134111",
+	"111242", "121142", "121241", "114212", "124112", "124211", "411212", "421112", "421211", "212141",
+	"214121", "412121", "111143", "111341", "131141", "114113", "114311", "411113", "411311", "113141",
+	"114131", "311141", "411131", "211412", "211214", "211232", "2331112",
+}
+
+const (
+	code128StartB = 104
+	code128StartC = 105
+	code128CodeB  = 100
+	code128CodeC  = 99
+	code128Stop   = 106
+)
+
+// Code128 encodes printable ASCII as Code 128, switching to code set C for runs of digits.
+func Code128(value string) (*Symbol, error) {
+	if value == "" {
+		return nil, ErrEmpty
+	}
+	for i := 0; i < len(value); i++ {
+		if value[i] < 32 || value[i] > 126 {
+			return nil, fmt.Errorf("barcode: Code 128 cannot encode %q", value[i])
+		}
+	}
+
+	codes := code128Values(value)
+
+	checksum := codes[0]
+	for i, code := range codes[1:] {
+		checksum += code * (i + 1)
+	}
+	codes = append(codes, checksum%103, code128Stop)
+
+	width := 0
+	for _, code := range codes {
+		for _, w := range code128Patterns[code] {
+			width += int(w - '0')
+		}
+	}
+
+	s := newSymbol(width, 1, 10)
+	x := 0
+	for _, code := range codes {
+		for i, w := range code128Patterns[code] {
+			for n := 0; n < int(w-'0'); n++ {
+				s.set(x, 0, i%2 == 0)
+				x++
+			}
+		}
+	}
+	return s, nil
+}
+
+// code128Values picks the code set per stretch of the value and returns the symbol values,
+// start code included.
+func code128Values(value string) []int {
+	digitsAt := func(i int) int {
+		n := 0
+		for i+n < len(value) && value[i+n] >= '0' && value[i+n] <= '9' {
+			n++
+		}
+		return n
+	}
+
+	codes := []int{}
+	setC := false
+	for i := 0; i < len(value); {
+		// Code set C packs two digits per symbol, which pays off for four digits or more, or
+		// a value made of an even number of digits.
+		run := digitsAt(i)
+		useC := run >= 4 || (i == 0 && run == len(value) && run%2 == 0)
+		switch {
+		case i == 0 && useC:
+			codes = append(codes, code128StartC)
+			setC = true
+		case i == 0:
+			codes = append(codes, code128StartB)
+		case useC && !setC:
+			if run%2 == 1 {
+				// An odd run starts with one digit in code set B.
+				codes = append(codes, int(value[i]-32))
+				i++
+				run--
+			}
+			codes = append(codes, code128CodeC)
+			setC = true
+		case !useC && setC && run < 2:
+			codes = append(codes, code128CodeB)
+			setC = false
+		}
+
+		if setC && run >= 2 {
+			codes = append(codes, int(value[i]-'0')*10+int(value[i+1]-'0'))
+			i += 2
+			continue
+		}
+		if setC {
+			codes = append(codes, code128CodeB)
+			setC = false
+		}
+		codes = append(codes, int(value[i]-32))
+		i++
+	}
+	return codes
+}
@@ -0,0 +1,430 @@
+package barcode
+
+import (
+	"fmt"
+)
+
+// qrVersion describes a QR code version at error correction level M.
+type qrVersion struct {
+	// ec is the number of error correction codewords per block.
+	ec int
+	// blocks lists the data codewords of each block.
+	blocks []int
+	// align holds the alignment pattern centers, on both axes.
+	align []int
+}
+
+// qrVersions are the versions 1 to 10 at level M, which hold up to 213 bytes. Labels never
+// need more.
+var qrVersions = [...]qrVersion{
+	{10, []int{16}, nil},
+	{16, []int{28}, []int{6, 18}},
+	{26, []int{44}, []int{6, 22}},
+	{18, []int{32, 32}, []int{6, 26}},
+	{24, []int{43, 43}, []int{6, 30}},
+	{16, []int{27, 27, 27, 27}, []int{6, 34}},
+	{18, []int{31, 31, 31, 31}, []int{6, 22, 38}},
+	{22, []int{38, 38, 39, 39}, []int{6, 24, 42}},
+	{22, []int{36, 36, 36, 37, 37}, []int{6, 26, 46}},
+	{26, []int{43, 43, 43, 43, 44}, []int{6, 28, 50}},
+}
+
+func (v qrVersion) dataCodewords() int {
+	n := 0
+	for _, b := range v.blocks {
+		n += b
+	}
+	return n
+}
+
+// QR encodes value in byte mode as a QR code at error correction level M, picking the
+// smallest version that holds it and the mask with the lowest penalty.
+func QR(value string) (*Symbol, error) {
+	if value == "" {
+		return nil, ErrEmpty
+	}
+
+	for i, v := range qrVersions {
+		version := i + 1
+		countBits := 8
+		if version >= 10 {
+			countBits = 16
+		}
+		if 4+countBits+8*len(value) > 8*v.dataCodewords() {
+			continue
+		}
+
+		var bits qrBits
+		bits.append(0b0100, 4)
+		bits.append(len(value), countBits)
+		for j := 0; j < len(value); j++ {
+			bits.append(int(value[j]), 8)
+		}
+		return newQR(version, v, bits.codewords(v.dataCodewords())), nil
+	}
+
+	return nil, fmt.Errorf("barcode: %d bytes is too long for a QR code label", len(value))
+}
+
+// qrBits is a buffer of bits, most significant first.
+type qrBits struct {
+	data []byte
+	n    int
+}
+
+func (b *qrBits) append(value, count int) {
+	for i := count - 1; i >= 0; i-- {
+		if b.n%8 == 0 {
+			b.data = append(b.data, 0)
+		}
+		if value>>i&1 == 1 {
+			b.data[b.n/8] |= 0x80 >> (b.n % 8)
+		}
+		b.n++
+	}
+}
+
+// codewords ends the bit stream and pads it to capacity codewords.
+func (b *qrBits) codewords(capacity int) []byte {
+	// The terminator is up to four zero bits; the byte is zero padded anyway.
+	data := b.data
+	for pad := byte(0xEC); len(data) < capacity; pad ^= 0xEC ^ 0x11 {
+		data = append(data, pad)
+	}
+	return data
+}
+
+type qrCode struct {
+	*Symbol
+	version  int
+	function []bool
+}
+
+func newQR(version int, v qrVersion, data []byte) *Symbol {
+	size := 17 + 4*version
+	q := &qrCode{Symbol: newSymbol(size, size, 4), version: version, function: make([]bool, size*size)}
+	q.drawFunctionPatterns(v)
+	q.drawCodewords(interleave(v, data))
+
+	best, bestPenalty := 0, -1
+	for mask := 0; mask < 8; mask++ {
+		q.applyMask(mask)
+		q.drawFormatBits(mask)
+		if penalty := q.penalty(); bestPenalty < 0 || penalty < bestPenalty {
+			best, bestPenalty = mask, penalty
+		}
+		q.applyMask(mask)
+	}
+	q.applyMask(best)
+	q.drawFormatBits(best)
+
+	return q.Symbol
+}
+
+func (q *qrCode) setFunction(x, y int, dark bool) {
+	q.set(x, y, dark)
+	q.function[y*q.Width+x] = true
+}
+
+func (q *qrCode) drawFunctionPatterns(v qrVersion) {
+	size := q.Width
+	for i := 0; i < size; i++ {
+		q.setFunction(6, i, i%2 == 0)
+		q.setFunction(i, 6, i%2 == 0)
+	}
+
+	q.drawFinder(3, 3)
+	q.drawFinder(size-4, 3)
+	q.drawFinder(3, size-4)
+
+	last := len(v.align) - 1
+	for i, x := range v.align {
+		for j, y := range v.align {
+			if (i == 0 && j == 0) || (i == 0 && j == last) || (i == last && j == 0) {
+				continue
+			}
+			q.drawAlignment(x, y)
+		}
+	}
+
+	// Reserve the format areas until the mask is known.
+	q.drawFormatBits(0)
+
+	if q.version >= 7 {
+		rem := q.version
+		for i := 0; i < 12; i++ {
+			rem = rem<<1 ^ (rem>>11)*0x1F25
+		}
+		bits := q.version<<12 | rem
+		for i := 0; i < 18; i++ {
+			dark := bits>>i&1 == 1
+			a, b := size-11+i%3, i/3
+			q.setFunction(a, b, dark)
+			q.setFunction(b, a, dark)
+		}
+	}
+}
+
+// drawFinder draws a finder pattern and its separator around the center x, y.
+func (q *qrCode) drawFinder(x, y int) {
+	for dy := -4; dy <= 4; dy++ {
+		for dx := -4; dx <= 4; dx++ {
+			xx, yy := x+dx, y+dy
+			if xx < 0 || xx >= q.Width || yy < 0 || yy >= q.Height {
+				continue
+			}
+			dist := max(abs(dx), abs(dy))
+			q.setFunction(xx, yy, dist != 2 && dist != 4)
+		}
+	}
+}
+
+func (q *qrCode) drawAlignment(x, y int) {
+	for dy := -2; dy <= 2; dy++ {
+		for dx := -2; dx <= 2; dx++ {
+			q.setFunction(x+dx, y+dy, max(abs(dx), abs(dy)) != 1)
+		}
+	}
+}
+
+// drawFormatBits draws both copies of the error correction level and mask, and the dark
+// module.
+func (q *qrCode) drawFormatBits(mask int) {
+	// Level M is 00.
+	data := mask
+	rem := data
+	for i := 0; i < 10; i++ {
+		rem = rem<<1 ^ (rem>>9)*0x537
+	}
+	bits := (data<<10 | rem) ^ 0x5412
+	bit := func(i int) bool { return bits>>i&1 == 1 }
+
+	size := q.Width
+	for i := 0; i <= 5; i++ {
+		q.setFunction(8, i, bit(i))
+	}
+	q.setFunction(8, 7, bit(6))
+	q.setFunction(8, 8, bit(7))
+	q.setFunction(7, 8, bit(8))
+	for i := 9; i < 15; i++ {
+		q.setFunction(14-i, 8, bit(i))
+	}
+
+	for i := 0; i < 8; i++ {
+		q.setFunction(size-1-i, 8, bit(i))
+	}
+	for i := 8; i < 15; i++ {
+		q.setFunction(8, size-15+i, bit(i))
+	}
+	q.setFunction(8, size-8, true)
+}
+
+// drawCodewords fills the data area in the zigzag order, two columns at a time from the
+// bottom right.
+func (q *qrCode) drawCodewords(data []byte) {
+	size := q.Width
+	i := 0
+	for right := size - 1; right >= 1; right -= 2 {
+		if right == 6 {
+			// Skip the vertical timing pattern.
+			right = 5
+		}
+		upward := (right+1)&2 == 0
+		for vert := 0; vert < size; vert++ {
+			y := vert
+			if upward {
+				y = size - 1 - vert
+			}
+			for j := 0; j < 2; j++ {
+				x := right - j
+				if q.function[y*size+x] || i >= len(data)*8 {
+					continue
+				}
+				q.set(x, y, data[i/8]>>(7-i%8)&1 == 1)
+				i++
+			}
+		}
+	}
+}
+
+func (q *qrCode) applyMask(mask int) {
+	for y := 0; y < q.Height; y++ {
+		for x := 0; x < q.Width; x++ {
+			if q.function[y*q.Width+x] {
+				continue
+			}
+			var flip bool
+			switch mask {
+			case 0:
+				flip = (x+y)%2 == 0
+			case 1:
+				flip = y%2 == 0
+			case 2:
+				flip = x%3 == 0
+			case 3:
+				flip = (x+y)%3 == 0
+			case 4:
+				flip = (x/3+y/2)%2 == 0
+			case 5:
+				flip = x*y%2+x*y%3 == 0
+			case 6:
+				flip = (x*y%2+x*y%3)%2 == 0
+			case 7:
+				flip = ((x+y)%2+x*y%3)%2 == 0
+			}
+			if flip {
+				q.set(x, y, !q.Dark(x, y))
+			}
+		}
+	}
+}
+
+// penalty scores the symbol by the four rules of the standard; the mask scoring lowest is
+// easiest to scan.
+func (q *qrCode) penalty() int {
+	size := q.Width
+	penalty := 0
+	finderLike := [2][11]bool{
+		{true, false, true, true, true, false, true, false, false, false, false},
+		{false, false, false, false, true, false, true, true, true, false, true},
+	}
+
+	for _, columns := range []bool{false, true} {
+		at := func(line, i int) bool {
+			if columns {
+				return q.Dark(line, i)
+			}
+			return q.Dark(i, line)
+		}
+		for line := 0; line < size; line++ {
+			run := 1
+			for i := 1; i <= size; i++ {
+				if i < size && at(line, i) == at(line, i-1) {
+					run++
+					continue
+				}
+				if run >= 5 {
+					penalty += 3 + run - 5
+				}
+				run = 1
+			}
+
+			for i := 0; i+11 <= size; i++ {
+				for _, pattern := range finderLike {
+					match := true
+					for k, dark := range pattern {
+						if at(line, i+k) != dark {
+							match = false
+							break
+						}
+					}
+					if match {
+						penalty += 40
+					}
+				}
+			}
+		}
+	}
+
+	dark := 0
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			if q.Dark(x, y) {
+				dark++
+			}
+			if x+1 < size && y+1 < size {
+				c := q.Dark(x, y)
+				if c == q.Dark(x+1, y) && c == q.Dark(x, y+1) && c == q.Dark(x+1, y+1) {
+					penalty += 3
+				}
+			}
+		}
+	}
+	total := size * size
+	k := (abs(dark*20-total*10)+total-1)/total - 1
+	return penalty + k*10
+}
+
+// interleave splits data into the version's blocks, appends the error correction codewords
+// of each, and interleaves them in the order they are placed.
+func interleave(v qrVersion, data []byte) []byte {
+	divisor := rsDivisor(v.ec)
+	blocks := make([][]byte, len(v.blocks))
+	ecBlocks := make([][]byte, len(v.blocks))
+	longest := 0
+	for i, n := range v.blocks {
+		blocks[i], data = data[:n], data[n:]
+		ecBlocks[i] = rsRemainder(blocks[i], divisor)
+		longest = max(longest, n)
+	}
+
+	result := []byte{}
+	for i := 0; i < longest; i++ {
+		for _, block := range blocks {
+			if i < len(block) {
+				result = append(result, block[i])
+			}
+		}
+	}
+	for i := 0; i < v.ec; i++ {
+		for _, ec := range ecBlocks {
+			result = append(result, ec[i])
+		}
+	}
+	return result
+}
+
+// rsDivisor returns the Reed-Solomon generator polynomial of the degree, highest term first
+// and its leading 1 left out.
+func rsDivisor(degree int) []byte {
+	result := make([]byte, degree)
+	result[degree-1] = 1
+	root := byte(1)
+	for i := 0; i < degree; i++ {
+		for j := range result {
+			result[j] = gfMultiply(result[j], root)
+			if j+1 < len(result) {
+				result[j] ^= result[j+1]
+			}
+		}
+		root = gfMultiply(root, 0x02)
+	}
+	return result
+}
+
+func rsRemainder(data, divisor []byte) []byte {
+	result := make([]byte, len(divisor))
+	for _, b := range data {
+		factor := b ^ result[0]
+		copy(result, result[1:])
+		result[len(result)-1] = 0
+		for i, c := range divisor {
+			result[i] ^= gfMultiply(c, factor)
+		}
+	}
+	return result
+}
+
+// gfMultiply multiplies in GF(2^8) modulo x^8 + x^4 + x^3 + x^2 + 1.
+func gfMultiply(x, y byte) byte {
+	z := 0
+	for i := 7; i >= 0; i-- {
+		z = z<<1 ^ (z>>7)*0x11D
+		z ^= int(y>>i&1) * int(x)
+	}
+	return byte(z)
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
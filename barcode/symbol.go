@@ -0,0 +1,141 @@
+// Package barcode encodes the codes the IMS prints on labels, Code 128 and QR, and renders
+// them as PNG or SVG.
+package barcode
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+)
+
+// Symbol is an encoded barcode as a grid of modules. Linear codes such as Code 128 are one
+// module high and are stretched to the bar height when rendered.
+type Symbol struct {
+	Width   int
+	Height  int
+	modules []bool
+	// Quiet is the light margin the symbology needs on each side, in modules.
+	Quiet int
+}
+
+func newSymbol(width, height, quiet int) *Symbol {
+	return &Symbol{Width: width, Height: height, modules: make([]bool, width*height), Quiet: quiet}
+}
+
+// Dark reports whether the module at column x and row y is dark.
+func (s *Symbol) Dark(x, y int) bool {
+	return s.modules[y*s.Width+x]
+}
+
+func (s *Symbol) set(x, y int, dark bool) {
+	s.modules[y*s.Width+x] = dark
+}
+
+// Linear reports whether the symbol is a one-dimensional code.
+func (s *Symbol) Linear() bool {
+	return s.Height == 1
+}
+
+// rows is the number of module rows drawn: barHeight for linear codes, the symbol height
+// otherwise.
+func (s *Symbol) rows(barHeight int) int {
+	if s.Linear() {
+		return barHeight
+	}
+	return s.Height
+}
+
+// Options control how a symbol is rendered.
+type Options struct {
+	// Scale is the size of a module in pixels. It defaults to 2.
+	Scale int
+	// BarHeight is the height of the bars of linear codes, in modules. It defaults to 50.
+	BarHeight int
+}
+
+func (o Options) withDefaults() Options {
+	if o.Scale <= 0 {
+		o.Scale = 2
+	}
+	if o.BarHeight <= 0 {
+		o.BarHeight = 50
+	}
+	return o
+}
+
+// WritePNG renders the symbol with its quiet zone as a black and white PNG.
+func WritePNG(w io.Writer, s *Symbol, opts Options) error {
+	opts = opts.withDefaults()
+	rows := s.rows(opts.BarHeight)
+	quietY := s.Quiet
+	if s.Linear() {
+		quietY = 0
+	}
+
+	width := (s.Width + 2*s.Quiet) * opts.Scale
+	height := (rows + 2*quietY) * opts.Scale
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	for i := range img.Pix {
+		img.Pix[i] = 0xff
+	}
+
+	for y := 0; y < rows; y++ {
+		sy := y
+		if s.Linear() {
+			sy = 0
+		}
+		for x := 0; x < s.Width; x++ {
+			if !s.Dark(x, sy) {
+				continue
+			}
+			for dy := 0; dy < opts.Scale; dy++ {
+				for dx := 0; dx < opts.Scale; dx++ {
+					img.SetGray((x+s.Quiet)*opts.Scale+dx, (y+quietY)*opts.Scale+dy, color.Gray{})
+				}
+			}
+		}
+	}
+
+	return png.Encode(w, img)
+}
+
+// WriteSVG renders the symbol with its quiet zone as SVG, one rect per run of dark modules.
+func WriteSVG(w io.Writer, s *Symbol, opts Options) error {
+	opts = opts.withDefaults()
+	rows := s.rows(opts.BarHeight)
+	quietY := s.Quiet
+	if s.Linear() {
+		quietY = 0
+	}
+	width, height := s.Width+2*s.Quiet, rows+2*quietY
+
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`,
+		width*opts.Scale, height*opts.Scale, width, height)
+	fmt.Fprintf(bw, `<rect width="%d" height="%d" fill="#fff"/>`, width, height)
+
+	for y := 0; y < s.Height; y++ {
+		for x := 0; x < s.Width; {
+			if !s.Dark(x, y) {
+				x++
+				continue
+			}
+			run := 1
+			for x+run < s.Width && s.Dark(x+run, y) {
+				run++
+			}
+			h := 1
+			if s.Linear() {
+				h = rows
+			}
+			fmt.Fprintf(bw, `<rect x="%d" y="%d" width="%d" height="%d"/>`, x+s.Quiet, y+quietY, run, h)
+			x += run
+		}
+	}
+
+	bw.WriteString(`</svg>`)
+	return bw.Flush()
+}
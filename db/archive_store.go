@@ -0,0 +1,102 @@
+package db
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/johnson7543/ims/types"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type ArchiveStore interface {
+	PurgeArchived(ctx context.Context, archivedBefore time.Time) ([]*types.PurgeResult, error)
+}
+
+type MongoArchiveStore struct {
+	client   *mongo.Client
+	database *mongo.Database
+}
+
+func NewMongoArchiveStore(client *mongo.Client) *MongoArchiveStore {
+	dbname := os.Getenv(MongoDBNameEnvName)
+	return &MongoArchiveStore{
+		client:   client,
+		database: client.Database(dbname),
+	}
+}
+
+type archivedDoc struct {
+	ID   primitive.ObjectID `bson:"_id"`
+	SKU  string             `bson:"sku"`
+	Kind string             `bson:"kind"`
+	Code string             `bson:"code"`
+}
+
+// archivable lists the collections that soft delete, with the references that keep an
+// archived document from being purged.
+var archivable = []struct {
+	coll string
+	refs func(doc archivedDoc) []reference
+}{
+	{orderColl, func(doc archivedDoc) []reference { return orderReferences(doc.ID) }},
+	{materialOrderColl, func(doc archivedDoc) []reference { return materialOrderReferences(doc.ID) }},
+	{processingItemColl, nil},
+	{productColl, func(doc archivedDoc) []reference { return productReferences(doc.ID, doc.SKU) }},
+	{productParentColl, func(doc archivedDoc) []reference { return productParentReferences(doc.ID) }},
+	{materialColl, func(doc archivedDoc) []reference { return materialReferences(doc.ID) }},
+	{partyColl, func(doc archivedDoc) []reference { return partyReferences(doc.ID, "") }},
+	{lookupColl, func(doc archivedDoc) []reference { return lookupReferences(doc.Kind, doc.Code) }},
+	{warehouseColl, func(doc archivedDoc) []reference { return warehouseReferences(doc.ID) }},
+}
+
+// PurgeArchived permanently deletes the documents archived before the given time. Documents
+// are purged in dependency order, orders first, so records they referred to can follow.
+func (s *MongoArchiveStore) PurgeArchived(ctx context.Context, archivedBefore time.Time) ([]*types.PurgeResult, error) {
+	results := []*types.PurgeResult{}
+	for _, a := range archivable {
+		coll := s.database.Collection(a.coll)
+		result := &types.PurgeResult{Collection: a.coll}
+
+		cursor, err := coll.Find(ctx, bson.M{archivedField: bson.M{"$lt": archivedBefore}})
+		if err != nil {
+			return nil, err
+		}
+		var docs []archivedDoc
+		if err := cursor.All(ctx, &docs); err != nil {
+			return nil, err
+		}
+
+		for _, doc := range docs {
+			if a.refs != nil {
+				found, err := findReferences(ctx, s.database, a.refs(doc))
+				if err != nil {
+					return nil, err
+				}
+				if len(found) > 0 {
+					result.Skipped++
+					continue
+				}
+			}
+
+			res, err := coll.DeleteOne(ctx, bson.M{"_id": doc.ID, archivedField: bson.M{"$lt": archivedBefore}})
+			if err != nil {
+				return nil, err
+			}
+			result.Purged += res.DeletedCount
+
+			if a.coll == productColl && res.DeletedCount > 0 {
+				// The cost record is derived from the product and goes with it.
+				if _, err := s.database.Collection(productCostColl).DeleteMany(ctx, bson.M{"sku": doc.SKU}); err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		results = append(results, result)
+	}
+	return results, nil
+}
@@ -0,0 +1,393 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+
+	"github.com/johnson7543/ims/types"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	customerReturnColl = "customer_returns"
+	creditNoteColl     = "credit_notes"
+)
+
+var (
+	// ErrOverReturn is returned when a return takes back more of an order line than was
+	// delivered and not returned already, or inspects more of a line than came back.
+	ErrOverReturn = errors.New("return exceeds what can be returned")
+	// ErrReturnClosed is returned when inspecting or canceling a return that is no longer
+	// authorized, or canceling one that was partly inspected.
+	ErrReturnClosed = errors.New("return is no longer open")
+	// ErrReturnNotCredited is returned when crediting a return that is not completed or was
+	// credited already.
+	ErrReturnNotCredited = errors.New("return cannot be credited")
+)
+
+// CustomerReturnStore keeps return authorizations and the credit notes issued for them.
+// Authorizing a return books the quantities against the order lines; inspecting it restocks,
+// scraps or sends the products to rework.
+type CustomerReturnStore interface {
+	GetCustomerReturns(ctx context.Context, filter bson.M) ([]*types.CustomerReturn, error)
+	GetCustomerReturn(context.Context, primitive.ObjectID) (*types.CustomerReturn, error)
+	InsertCustomerReturn(context.Context, *types.CustomerReturn) (*types.CustomerReturn, error)
+	InspectCustomerReturn(ctx context.Context, id primitive.ObjectID, version int64, inspections []ReturnInspectionInput) (*types.CustomerReturn, error)
+	CancelCustomerReturn(ctx context.Context, id primitive.ObjectID) (int64, error)
+	IssueCreditNote(ctx context.Context, returnID primitive.ObjectID, creditNote *types.CreditNote) (*types.CreditNote, error)
+	GetCreditNotes(ctx context.Context, filter bson.M) ([]*types.CreditNote, error)
+	GetCreditNote(context.Context, primitive.ObjectID) (*types.CreditNote, error)
+}
+
+// ReturnInspectionInput is the outcome of inspecting part of a line of a return, which Line
+// picks by the order line it returns. Rework is the processing item to create for reworked
+// products; its name, quantity and SKU are filled in.
+type ReturnInspectionInput struct {
+	Line       int
+	Inspection types.ReturnInspection
+	Rework     *types.ProcessingItem
+}
+
+type MongoCustomerReturnStore struct {
+	client          *mongo.Client
+	database        *mongo.Database
+	coll            *mongo.Collection
+	creditNotes     *mongo.Collection
+	orders          *mongo.Collection
+	processingItems *mongo.Collection
+	stock           *MongoStockStore
+}
+
+func NewMongoCustomerReturnStore(client *mongo.Client) *MongoCustomerReturnStore {
+	dbName := os.Getenv(MongoDBNameEnvName)
+	database := client.Database(dbName)
+	return &MongoCustomerReturnStore{
+		client:          client,
+		database:        database,
+		coll:            database.Collection(customerReturnColl),
+		creditNotes:     database.Collection(creditNoteColl),
+		orders:          database.Collection(orderColl),
+		processingItems: database.Collection(processingItemColl),
+		stock:           NewMongoStockStore(client),
+	}
+}
+
+// GetCustomerReturns returns the returns matching filter, newest first. Unlike the other list
+// queries the filter values match exactly.
+func (s *MongoCustomerReturnStore) GetCustomerReturns(ctx context.Context, filter bson.M) ([]*types.CustomerReturn, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}, {Key: "_id", Value: -1}})
+	resp, err := s.coll.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	returns := []*types.CustomerReturn{}
+	if err := resp.All(ctx, &returns); err != nil {
+		return nil, err
+	}
+
+	return returns, nil
+}
+
+func (s *MongoCustomerReturnStore) GetCustomerReturn(ctx context.Context, id primitive.ObjectID) (*types.CustomerReturn, error) {
+	var ret types.CustomerReturn
+	if err := s.coll.FindOne(ctx, bson.M{"_id": id}).Decode(&ret); err != nil {
+		return nil, err
+	}
+
+	return &ret, nil
+}
+
+// InsertCustomerReturn authorizes a return of the order's lines and books the quantities
+// against them, all or nothing. The customer and product details are copied from the order. It
+// returns mongo.ErrNoDocuments when there is no such order and ErrOverReturn when a line is not
+// in the order or has less left to return.
+func (s *MongoCustomerReturnStore) InsertCustomerReturn(ctx context.Context, ret *types.CustomerReturn) (*types.CustomerReturn, error) {
+	err := withTransaction(ctx, s.client, func(ctx context.Context) error {
+		var order types.Order
+		if err := s.orders.FindOne(ctx, bson.M{"_id": ret.OrderID, archivedField: notArchived}).Decode(&order); err != nil {
+			return err
+		}
+
+		for i := range ret.Lines {
+			line := &ret.Lines[i]
+			if line.Line < 0 || line.Line >= len(order.OrderItems) {
+				return ErrOverReturn
+			}
+			item := &order.OrderItems[line.Line]
+			if item.Returned+line.Quantity > order.Delivered(line.Line) {
+				return ErrOverReturn
+			}
+			item.Returned += line.Quantity
+
+			line.ProductID = item.Product.ID
+			line.SKU = item.Product.SKU
+			line.Name = item.Product.Name
+			line.Inspections = []types.ReturnInspection{}
+		}
+
+		update := bson.M{"$set": bson.M{"orderItems": order.OrderItems}}
+		if _, err := updateVersioned(ctx, s.orders, order.ID, order.Version, update); err != nil {
+			return err
+		}
+
+		seq, err := nextSequence(ctx, s.database, "customerReturn")
+		if err != nil {
+			return err
+		}
+		ret.Number = fmt.Sprintf("RMA%06d", seq)
+		ret.CustomerID = order.CustomerID
+		ret.CustomerName = order.CustomerName
+		ret.Status = types.ReturnAuthorized
+		ret.Version = 1
+
+		resp, err := s.coll.InsertOne(ctx, ret)
+		if err != nil {
+			return err
+		}
+		ret.ID = resp.InsertedID.(primitive.ObjectID)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ret, nil
+}
+
+// InspectCustomerReturn records the outcome of inspecting returned products, all or nothing:
+// restocked products go back into stock through the stock ledger, reworked ones become a
+// processing item and scrapped ones are only recorded. The return is completed once every
+// line is inspected. It returns ErrVersionConflict when the return changed after version,
+// ErrReturnClosed when it is no longer authorized and ErrOverReturn when more of a line is
+// inspected than is left.
+func (s *MongoCustomerReturnStore) InspectCustomerReturn(ctx context.Context, id primitive.ObjectID, version int64, inspections []ReturnInspectionInput) (*types.CustomerReturn, error) {
+	var ret *types.CustomerReturn
+	err := withTransaction(ctx, s.client, func(ctx context.Context) error {
+		var err error
+		if ret, err = s.GetCustomerReturn(ctx, id); err != nil {
+			return err
+		}
+		if ret.Version != version {
+			return ErrVersionConflict
+		}
+		if ret.Status != types.ReturnAuthorized {
+			return ErrReturnClosed
+		}
+
+		for _, input := range inspections {
+			line := ret.FindLine(input.Line)
+			inspection := input.Inspection
+			if line == nil || line.Inspected+inspection.Quantity > line.Quantity {
+				return ErrOverReturn
+			}
+
+			switch inspection.Outcome {
+			case types.ReturnRestock:
+				movement := &types.StockMovement{
+					ItemKind:     types.StockProduct,
+					ItemID:       line.ProductID,
+					Name:         line.Name,
+					Location:     ret.Location,
+					Quantity:     float64(inspection.Quantity),
+					Reason:       line.Reason,
+					Source:       types.StockSourceReturn,
+					SourceID:     ret.ID,
+					SourceNumber: ret.Number,
+					Remark:       inspection.Remark,
+					CreatedBy:    inspection.InspectedBy,
+					CreatedAt:    inspection.InspectedAt,
+				}
+				if err := s.stock.adjust(ctx, movement); err != nil {
+					return err
+				}
+				inspection.MovementID = &movement.ID
+			case types.ReturnRework:
+				item := input.Rework
+				item.Name = fmt.Sprintf("Rework %s: %s", ret.Number, line.Name)
+				item.Quantity = inspection.Quantity
+				item.SKU = line.SKU
+				item.Version = 1
+				resp, err := s.processingItems.InsertOne(ctx, item)
+				if err != nil {
+					return err
+				}
+				itemID := resp.InsertedID.(primitive.ObjectID)
+				inspection.ProcessingItemID = &itemID
+			}
+
+			line.Inspections = append(line.Inspections, inspection)
+			line.Inspected += inspection.Quantity
+		}
+
+		ret.Status = types.ReturnCompleted
+		for _, line := range ret.Lines {
+			if line.Inspected < line.Quantity {
+				ret.Status = types.ReturnAuthorized
+			}
+		}
+
+		update := bson.M{"$set": bson.M{"lines": ret.Lines, "status": ret.Status}}
+		if _, err := updateVersioned(ctx, s.coll, id, version, update); err != nil {
+			return err
+		}
+		ret.Version++
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ret, nil
+}
+
+// CancelCustomerReturn cancels an authorized return before anything of it was inspected and
+// frees its quantities on the order lines. It returns ErrReturnClosed when it is no longer
+// authorized or was partly inspected, and 0 when there is no such return.
+func (s *MongoCustomerReturnStore) CancelCustomerReturn(ctx context.Context, id primitive.ObjectID) (int64, error) {
+	var canceled int64
+	err := withTransaction(ctx, s.client, func(ctx context.Context) error {
+		ret, err := s.GetCustomerReturn(ctx, id)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if ret.Status != types.ReturnAuthorized {
+			return ErrReturnClosed
+		}
+
+		returned := map[int]int{}
+		for _, line := range ret.Lines {
+			if line.Inspected > 0 {
+				return ErrReturnClosed
+			}
+			returned[line.Line] -= line.Quantity
+		}
+		inc := bson.M{versionField: 1}
+		for line, quantity := range returned {
+			inc[fmt.Sprintf("orderItems.%d.returned", line)] = quantity
+		}
+		if _, err := s.orders.UpdateOne(ctx, bson.M{"_id": ret.OrderID}, bson.M{"$inc": inc}); err != nil {
+			return err
+		}
+
+		update := bson.M{"$set": bson.M{"status": types.ReturnCanceled}}
+		if canceled, err = updateVersioned(ctx, s.coll, id, ret.Version, update); err != nil {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return canceled, nil
+}
+
+// IssueCreditNote credits a completed return against its order at the prices the lines were
+// sold for, and adds the amount to what the order was credited. The return, order and product
+// details are filled in. It returns ErrReturnNotCredited when the return is not completed or
+// was credited already.
+func (s *MongoCustomerReturnStore) IssueCreditNote(ctx context.Context, returnID primitive.ObjectID, creditNote *types.CreditNote) (*types.CreditNote, error) {
+	err := withTransaction(ctx, s.client, func(ctx context.Context) error {
+		ret, err := s.GetCustomerReturn(ctx, returnID)
+		if err != nil {
+			return err
+		}
+		if ret.Status != types.ReturnCompleted || ret.CreditNoteID != nil {
+			return ErrReturnNotCredited
+		}
+
+		var order types.Order
+		if err := s.orders.FindOne(ctx, bson.M{"_id": ret.OrderID}).Decode(&order); err != nil {
+			return err
+		}
+
+		creditNote.Lines = make([]types.CreditNoteLine, len(ret.Lines))
+		creditNote.Amount = 0
+		for i, line := range ret.Lines {
+			// The line total carries any discount given on the order, so it sets the price.
+			item := order.OrderItems[line.Line]
+			unitPrice := item.Product.UnitPrice
+			if item.Quantity > 0 {
+				unitPrice = item.TotalPrice / float64(item.Quantity)
+			}
+			amount := math.Round(unitPrice*float64(line.Quantity)*100) / 100
+			creditNote.Lines[i] = types.CreditNoteLine{
+				Line:      line.Line,
+				ProductID: line.ProductID,
+				SKU:       line.SKU,
+				Name:      line.Name,
+				Quantity:  line.Quantity,
+				UnitPrice: unitPrice,
+				Amount:    amount,
+			}
+			creditNote.Amount += amount
+		}
+		creditNote.Amount = math.Round(creditNote.Amount*100) / 100
+
+		seq, err := nextSequence(ctx, s.database, "creditNote")
+		if err != nil {
+			return err
+		}
+		creditNote.Number = fmt.Sprintf("CN%06d", seq)
+		creditNote.OrderID = ret.OrderID
+		creditNote.ReturnID = ret.ID
+		creditNote.ReturnNumber = ret.Number
+		creditNote.CustomerID = ret.CustomerID
+		creditNote.CustomerName = ret.CustomerName
+
+		resp, err := s.creditNotes.InsertOne(ctx, creditNote)
+		if err != nil {
+			return err
+		}
+		creditNote.ID = resp.InsertedID.(primitive.ObjectID)
+
+		update := bson.M{"$set": bson.M{"creditNoteId": creditNote.ID}}
+		if _, err := updateVersioned(ctx, s.coll, ret.ID, ret.Version, update); err != nil {
+			return err
+		}
+		_, err = s.orders.UpdateOne(ctx, bson.M{"_id": ret.OrderID}, bson.M{"$inc": bson.M{"credited": creditNote.Amount, versionField: 1}})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return creditNote, nil
+}
+
+// GetCreditNotes returns the credit notes matching filter, newest first. Unlike the other list
+// queries the filter values match exactly.
+func (s *MongoCustomerReturnStore) GetCreditNotes(ctx context.Context, filter bson.M) ([]*types.CreditNote, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "issuedAt", Value: -1}, {Key: "_id", Value: -1}})
+	resp, err := s.creditNotes.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	creditNotes := []*types.CreditNote{}
+	if err := resp.All(ctx, &creditNotes); err != nil {
+		return nil, err
+	}
+
+	return creditNotes, nil
+}
+
+func (s *MongoCustomerReturnStore) GetCreditNote(ctx context.Context, id primitive.ObjectID) (*types.CreditNote, error) {
+	var creditNote types.CreditNote
+	if err := s.creditNotes.FindOne(ctx, bson.M{"_id": id}).Decode(&creditNote); err != nil {
+		return nil, err
+	}
+
+	return &creditNote, nil
+}
@@ -0,0 +1,254 @@
+package db
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/johnson7543/ims/types"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// DashboardPeriod fixes the instants a dashboard summary is computed against.
+type DashboardPeriod struct {
+	Now               time.Time
+	ThisMonthStart    time.Time
+	LastMonthStart    time.Time
+	LowStockThreshold int
+}
+
+type DashboardStore interface {
+	GetDashboardSummary(context.Context, DashboardPeriod) (*types.DashboardSummary, error)
+}
+
+type MongoDashboardStore struct {
+	client             *mongo.Client
+	orderColl          *mongo.Collection
+	materialOrderColl  *mongo.Collection
+	productColl        *mongo.Collection
+	materialColl       *mongo.Collection
+	processingItemColl *mongo.Collection
+}
+
+func NewMongoDashboardStore(client *mongo.Client) *MongoDashboardStore {
+	dbname := os.Getenv(MongoDBNameEnvName)
+	database := client.Database(dbname)
+	return &MongoDashboardStore{
+		client:             client,
+		orderColl:          database.Collection(orderColl),
+		materialOrderColl:  database.Collection(materialOrderColl),
+		productColl:        database.Collection(productColl),
+		materialColl:       database.Collection(materialColl),
+		processingItemColl: database.Collection(processingItemColl),
+	}
+}
+
+// closedOrderStatus matches orders that need no further work.
+var closedOrderStatus = primitive.Regex{Pattern: "^(canceled|completed|delivered)$", Options: "i"}
+
+// unsetDate matches a date field that was never filled in, stored either as missing or as the zero time.
+func unsetDate(field string) bson.M {
+	return bson.M{"$or": bson.A{
+		bson.M{field: bson.M{"$exists": false}},
+		bson.M{field: bson.M{"$lte": time.Time{}}},
+	}}
+}
+
+type countTotal struct {
+	Count int     `bson:"count"`
+	Total float64 `bson:"total"`
+}
+
+func countTotalFacet(match bson.M) bson.A {
+	return bson.A{
+		bson.M{"$match": match},
+		bson.M{"$group": bson.M{
+			"_id":   nil,
+			"count": bson.M{"$sum": 1},
+			"total": bson.M{"$sum": "$totalAmount"},
+		}},
+	}
+}
+
+func firstCountTotal(rows []countTotal) countTotal {
+	if len(rows) == 0 {
+		return countTotal{}
+	}
+	return rows[0]
+}
+
+func (s *MongoDashboardStore) GetDashboardSummary(ctx context.Context, period DashboardPeriod) (*types.DashboardSummary, error) {
+	summary := &types.DashboardSummary{
+		LowStockThreshold:  period.LowStockThreshold,
+		ProcessingByWorker: []*types.WorkerProcessingSummary{},
+		GeneratedAt:        period.Now,
+	}
+
+	if err := s.fillOrderKPIs(ctx, period, summary); err != nil {
+		return nil, err
+	}
+	if err := s.fillMaterialOrderKPIs(ctx, summary); err != nil {
+		return nil, err
+	}
+	if err := s.fillStockKPIs(ctx, period, summary); err != nil {
+		return nil, err
+	}
+	if err := s.fillProcessingKPIs(ctx, period, summary); err != nil {
+		return nil, err
+	}
+
+	return summary, nil
+}
+
+// fillOrderKPIs computes every order based figure in a single $facet aggregation.
+func (s *MongoDashboardStore) fillOrderKPIs(ctx context.Context, period DashboardPeriod, summary *types.DashboardSummary) error {
+	open := bson.M{"status": bson.M{"$not": closedOrderStatus}}
+	scheduled := bson.M{"deliveryDate": bson.M{"$gt": time.Time{}}}
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{archivedField: notArchived}}},
+		{{Key: "$facet", Value: bson.M{
+			"open":             countTotalFacet(open),
+			"awaitingDelivery": countTotalFacet(bson.M{"$and": bson.A{open, scheduled}}),
+			"overdue": countTotalFacet(bson.M{"$and": bson.A{
+				open,
+				bson.M{"deliveryDate": bson.M{"$gt": time.Time{}, "$lt": period.Now}},
+			}}),
+			"unpaid": countTotalFacet(bson.M{"$and": bson.A{
+				bson.M{"status": notCanceled},
+				unsetDate("paymentDate"),
+			}}),
+			"thisMonth": countTotalFacet(bson.M{
+				"status":    notCanceled,
+				"orderDate": bson.M{"$gte": period.ThisMonthStart, "$lte": period.Now},
+			}),
+			"lastMonth": countTotalFacet(bson.M{
+				"status":    notCanceled,
+				"orderDate": bson.M{"$gte": period.LastMonthStart, "$lt": period.ThisMonthStart},
+			}),
+		}}},
+	}
+
+	cursor, err := s.orderColl.Aggregate(ctx, pipeline)
+	if err != nil {
+		return err
+	}
+
+	var facets []struct {
+		Open             []countTotal `bson:"open"`
+		AwaitingDelivery []countTotal `bson:"awaitingDelivery"`
+		Overdue          []countTotal `bson:"overdue"`
+		Unpaid           []countTotal `bson:"unpaid"`
+		ThisMonth        []countTotal `bson:"thisMonth"`
+		LastMonth        []countTotal `bson:"lastMonth"`
+	}
+	if err := cursor.All(ctx, &facets); err != nil {
+		return err
+	}
+	if len(facets) == 0 {
+		return nil
+	}
+
+	f := facets[0]
+	unpaid := firstCountTotal(f.Unpaid)
+	thisMonth := firstCountTotal(f.ThisMonth)
+	lastMonth := firstCountTotal(f.LastMonth)
+
+	summary.OpenOrders = firstCountTotal(f.Open).Count
+	summary.AwaitingDelivery = firstCountTotal(f.AwaitingDelivery).Count
+	summary.OverdueDeliveries = firstCountTotal(f.Overdue).Count
+	summary.UnpaidOrders = unpaid.Count
+	summary.UnpaidOrderTotal = unpaid.Total
+	summary.OrdersThisMonth = thisMonth.Count
+	summary.SalesThisMonth = thisMonth.Total
+	summary.OrdersLastMonth = lastMonth.Count
+	summary.SalesLastMonth = lastMonth.Total
+
+	return nil
+}
+
+func (s *MongoDashboardStore) fillMaterialOrderKPIs(ctx context.Context, summary *types.DashboardSummary) error {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"$and": bson.A{
+			bson.M{archivedField: notArchived},
+			bson.M{"status": notCanceled},
+			unsetDate("paymentDate"),
+		}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   nil,
+			"count": bson.M{"$sum": 1},
+			"total": bson.M{"$sum": payableAmount},
+		}}},
+	}
+
+	cursor, err := s.materialOrderColl.Aggregate(ctx, pipeline)
+	if err != nil {
+		return err
+	}
+
+	var rows []countTotal
+	if err := cursor.All(ctx, &rows); err != nil {
+		return err
+	}
+
+	unpaid := firstCountTotal(rows)
+	summary.UnpaidMaterialOrders = unpaid.Count
+	summary.UnpaidMaterialTotal = unpaid.Total
+
+	return nil
+}
+
+func (s *MongoDashboardStore) fillStockKPIs(ctx context.Context, period DashboardPeriod, summary *types.DashboardSummary) error {
+	// Stock reserved for orders is already spoken for, so what is low is what remains available.
+	available := bson.M{"$subtract": bson.A{"$quantity", bson.M{"$ifNull": bson.A{"$reserved", 0}}}}
+	lowStock := bson.M{
+		"$expr":       bson.M{"$lte": bson.A{available, period.LowStockThreshold}},
+		archivedField: notArchived,
+	}
+
+	products, err := s.productColl.CountDocuments(ctx, lowStock)
+	if err != nil {
+		return err
+	}
+
+	materials, err := s.materialColl.CountDocuments(ctx, lowStock)
+	if err != nil {
+		return err
+	}
+
+	summary.LowStockProducts = int(products)
+	summary.LowStockMaterials = int(materials)
+
+	return nil
+}
+
+// fillProcessingKPIs groups processing items that have started and not yet ended by worker.
+func (s *MongoDashboardStore) fillProcessingKPIs(ctx context.Context, period DashboardPeriod, summary *types.DashboardSummary) error {
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"$and": bson.A{
+			bson.M{archivedField: notArchived},
+			bson.M{"startDate": bson.M{"$lte": period.Now}},
+			bson.M{"$or": bson.A{
+				unsetDate("endDate"),
+				bson.M{"endDate": bson.M{"$gte": period.Now}},
+			}},
+		}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":        "$workerId",
+			"workerName": bson.M{"$last": "$workerName"},
+			"itemCount":  bson.M{"$sum": 1},
+			"quantity":   bson.M{"$sum": "$quantity"},
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "itemCount", Value: -1}}}},
+	}
+
+	cursor, err := s.processingItemColl.Aggregate(ctx, pipeline)
+	if err != nil {
+		return err
+	}
+
+	return cursor.All(ctx, &summary.ProcessingByWorker)
+}
@@ -1,5 +1,13 @@
 package db
 
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
 const MongoDBNameEnvName = "MONGO_DB_NAME"
 
 type Pagination struct {
@@ -12,11 +20,128 @@ type Store struct {
 	User           UserStore
 	Material       MaterialStore
 	MaterialOrder  MaterialOrderStore
-	Worker         WorkerStore
-	Customer       CustomerStore
-	Buyer          BuyerStore
-	Seller         SellerStore
+	Party          PartyStore
 	Product        ProductStore
+	ProductParent  ProductParentStore
 	ProcessingItem ProcessingItemStore
 	Order          OrderStore
+	ProductCost    ProductCostStore
+	Report         ReportStore
+	Dashboard      DashboardStore
+	ImportJob      ImportJobStore
+	Archive        ArchiveStore
+	Lookup         LookupStore
+	Idempotency    IdempotencyStore
+	Warehouse      WarehouseStore
+	Stock          StockStore
+	Lot            LotStore
+	Stocktake      StocktakeStore
+	Shipment       ShipmentStore
+	CustomerReturn CustomerReturnStore
+	SupplierReturn SupplierReturnStore
+}
+
+// archivedField holds the time a document was soft deleted. Archived documents stay in their
+// collection until they are restored or purged, and are hidden from lists by default.
+const archivedField = "deletedAt"
+
+// notArchived matches documents that have not been soft deleted.
+var notArchived = bson.M{"$exists": false}
+
+type archivedOption struct {
+	only bool
+}
+
+// IncludeArchived makes a list filter match archived documents as well as live ones.
+func IncludeArchived(filter bson.M) bson.M {
+	filter[archivedField] = archivedOption{}
+	return filter
+}
+
+// OnlyArchived makes a list filter match archived documents only.
+func OnlyArchived(filter bson.M) bson.M {
+	filter[archivedField] = archivedOption{only: true}
+	return filter
+}
+
+// liveFilter hides archived documents unless IncludeArchived or OnlyArchived was applied.
+func liveFilter(filter bson.M) bson.M {
+	switch opt := filter[archivedField].(type) {
+	case nil:
+		filter[archivedField] = notArchived
+	case archivedOption:
+		if opt.only {
+			filter[archivedField] = bson.M{"$exists": true}
+		} else {
+			delete(filter, archivedField)
+		}
+	}
+	return filter
+}
+
+type archivedKey struct{}
+
+// WithArchived lets the by-ID getters return archived documents for lookups made with ctx.
+func WithArchived(ctx context.Context) context.Context {
+	return context.WithValue(ctx, archivedKey{}, true)
+}
+
+// liveID matches the document with the given ID, hiding it once archived unless ctx
+// came from WithArchived.
+func liveID(ctx context.Context, id primitive.ObjectID) bson.M {
+	filter := bson.M{"_id": id}
+	if archived, _ := ctx.Value(archivedKey{}).(bool); !archived {
+		filter[archivedField] = notArchived
+	}
+	return filter
+}
+
+// matchFilter applies the list endpoint matching rules: strings match case-insensitively
+// as regular expressions and numbers match exactly.
+func matchFilter(filter bson.M) bson.M {
+	for key, value := range filter {
+		switch v := value.(type) {
+		case string:
+			filter[key] = bson.M{"$regex": primitive.Regex{Pattern: v, Options: "i"}}
+		case int, int32, int64, float32, float64:
+			filter[key] = bson.M{"$eq": v}
+		}
+	}
+	return filter
+}
+
+// iterate decodes the documents matching filter one at a time and hands each to fn,
+// so callers can stream large result sets without holding them in memory.
+func iterate[T any](ctx context.Context, coll *mongo.Collection, filter bson.M, fn func(*T) error) error {
+	cursor, err := coll.Find(ctx, matchFilter(liveFilter(filter)))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var doc T
+		if err := cursor.Decode(&doc); err != nil {
+			return err
+		}
+		if err := fn(&doc); err != nil {
+			return err
+		}
+	}
+
+	return cursor.Err()
+}
+
+// withTransaction runs fn in a transaction, retrying it on transient errors.
+func withTransaction(ctx context.Context, client *mongo.Client, fn func(ctx context.Context) error) error {
+	session, err := client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sc mongo.SessionContext) (any, error) {
+		return nil, fn(sc)
+	})
+	return err
 }
@@ -33,36 +33,12 @@ func AddProcessingItem(store *db.Store, processing_items *types.ProcessingItem)
 	return insertedProcessingItem
 }
 
-func AddWorker(store *db.Store, worker *types.Worker) *types.Worker {
-	insertedWorker, err := store.Worker.InsertWorker(context.Background(), worker)
+func AddParty(store *db.Store, party *types.Party) *types.Party {
+	insertedParty, err := store.Party.InsertParty(context.Background(), party)
 	if err != nil {
 		log.Fatal(err)
 	}
-	return insertedWorker
-}
-
-func AddCustomer(store *db.Store, customer *types.Customer) *types.Customer {
-	insertedCustomer, err := store.Customer.InsertCustomer(context.Background(), customer)
-	if err != nil {
-		log.Fatal(err)
-	}
-	return insertedCustomer
-}
-
-func AddSeller(store *db.Store, seller *types.Seller) *types.Seller {
-	insertedSeller, err := store.Seller.InsertSeller(context.Background(), seller)
-	if err != nil {
-		log.Fatal(err)
-	}
-	return insertedSeller
-}
-
-func AddBuyer(store *db.Store, buyer *types.Buyer) *types.Buyer {
-	insertedBuyer, err := store.Buyer.InsertBuyer(context.Background(), buyer)
-	if err != nil {
-		log.Fatal(err)
-	}
-	return insertedBuyer
+	return insertedParty
 }
 
 func AddMaterial(store *db.Store, material *types.Material) *types.Material {
@@ -0,0 +1,104 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"os"
+	"time"
+
+	"github.com/johnson7543/ims/types"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const idempotencyColl = "idempotency_keys"
+
+// indexOptionsConflict is the server error for creating an index that exists with other options.
+const indexOptionsConflict = 85
+
+type IdempotencyStore interface {
+	EnsureIdempotencyTTL(context.Context, time.Duration) error
+	BeginIdempotentRequest(context.Context, *types.IdempotencyRecord) (*types.IdempotencyRecord, bool, error)
+	CompleteIdempotentRequest(ctx context.Context, id string, status int, contentType string, body []byte) error
+	ReleaseIdempotencyKey(ctx context.Context, id string) error
+}
+
+type MongoIdempotencyStore struct {
+	client *mongo.Client
+	coll   *mongo.Collection
+}
+
+func NewMongoIdempotencyStore(client *mongo.Client) *MongoIdempotencyStore {
+	dbname := os.Getenv(MongoDBNameEnvName)
+	return &MongoIdempotencyStore{
+		client: client,
+		coll:   client.Database(dbname).Collection(idempotencyColl),
+	}
+}
+
+// EnsureIdempotencyTTL makes MongoDB remove records once they are older than ttl, changing the
+// expiry of the index when it already exists with another one.
+func (s *MongoIdempotencyStore) EnsureIdempotencyTTL(ctx context.Context, ttl time.Duration) error {
+	seconds := int32(ttl / time.Second)
+	index := mongo.IndexModel{
+		Keys:    bson.D{{Key: "createdAt", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(seconds),
+	}
+
+	_, err := s.coll.Indexes().CreateOne(ctx, index)
+	var cmdErr mongo.CommandError
+	if !errors.As(err, &cmdErr) || cmdErr.Code != indexOptionsConflict {
+		return err
+	}
+
+	return s.coll.Database().RunCommand(ctx, bson.D{
+		{Key: "collMod", Value: idempotencyColl},
+		{Key: "index", Value: bson.M{"keyPattern": bson.M{"createdAt": 1}, "expireAfterSeconds": seconds}},
+	}).Err()
+}
+
+// BeginIdempotentRequest claims the key of record. When the key was already used it returns
+// the stored record and false instead.
+func (s *MongoIdempotencyStore) BeginIdempotentRequest(ctx context.Context, record *types.IdempotencyRecord) (*types.IdempotencyRecord, bool, error) {
+	_, err := s.coll.InsertOne(ctx, record)
+	if err == nil {
+		return record, true, nil
+	}
+	if !mongo.IsDuplicateKeyError(err) {
+		return nil, false, err
+	}
+
+	var existing types.IdempotencyRecord
+	if err := s.coll.FindOne(ctx, bson.M{"_id": record.ID}).Decode(&existing); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			// The record expired between the insert and the lookup, so claim the key again.
+			return s.BeginIdempotentRequest(ctx, record)
+		}
+		return nil, false, err
+	}
+
+	return &existing, false, nil
+}
+
+// CompleteIdempotentRequest stores the response to replay for retries of the request.
+func (s *MongoIdempotencyStore) CompleteIdempotentRequest(ctx context.Context, id string, status int, contentType string, body []byte) error {
+	update := bson.M{
+		"$set": bson.M{
+			"completed":   true,
+			"status":      status,
+			"contentType": contentType,
+			"body":        body,
+		},
+	}
+
+	_, err := s.coll.UpdateOne(ctx, bson.M{"_id": id}, update)
+	return err
+}
+
+// ReleaseIdempotencyKey forgets a request that failed, so the key can be retried.
+func (s *MongoIdempotencyStore) ReleaseIdempotencyKey(ctx context.Context, id string) error {
+	_, err := s.coll.DeleteOne(ctx, bson.M{"_id": id})
+	return err
+}
@@ -0,0 +1,71 @@
+package db
+
+import (
+	"context"
+	"os"
+
+	"github.com/johnson7543/ims/types"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const importJobColl = "import_jobs"
+
+type ImportJobStore interface {
+	GetImportJob(context.Context, primitive.ObjectID) (*types.ImportJob, error)
+	InsertImportJob(context.Context, *types.ImportJob) (*types.ImportJob, error)
+	UpdateImportJob(context.Context, *types.ImportJob) error
+}
+
+type MongoImportJobStore struct {
+	client *mongo.Client
+	coll   *mongo.Collection
+}
+
+func NewMongoImportJobStore(client *mongo.Client) *MongoImportJobStore {
+	dbname := os.Getenv(MongoDBNameEnvName)
+	return &MongoImportJobStore{
+		client: client,
+		coll:   client.Database(dbname).Collection(importJobColl),
+	}
+}
+
+func (s *MongoImportJobStore) GetImportJob(ctx context.Context, id primitive.ObjectID) (*types.ImportJob, error) {
+	var job types.ImportJob
+	if err := s.coll.FindOne(ctx, bson.M{"_id": id}).Decode(&job); err != nil {
+		return nil, err
+	}
+
+	return &job, nil
+}
+
+func (s *MongoImportJobStore) InsertImportJob(ctx context.Context, job *types.ImportJob) (*types.ImportJob, error) {
+	resp, err := s.coll.InsertOne(ctx, job)
+	if err != nil {
+		return nil, err
+	}
+	job.ID = resp.InsertedID.(primitive.ObjectID)
+
+	return job, nil
+}
+
+// UpdateImportJob records the progress and outcome of a job.
+func (s *MongoImportJobStore) UpdateImportJob(ctx context.Context, job *types.ImportJob) error {
+	update := bson.M{
+		"$set": bson.M{
+			"status":     job.Status,
+			"totalRows":  job.TotalRows,
+			"created":    job.Created,
+			"updated":    job.Updated,
+			"failed":     job.Failed,
+			"errors":     job.Errors,
+			"message":    job.Message,
+			"finishedAt": job.FinishedAt,
+		},
+	}
+
+	_, err := s.coll.UpdateOne(ctx, bson.M{"_id": job.ID}, update)
+	return err
+}
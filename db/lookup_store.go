@@ -0,0 +1,280 @@
+package db
+
+import (
+	"context"
+	"os"
+	"sort"
+
+	"github.com/johnson7543/ims/types"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const lookupColl = "lookups"
+
+// lookupField is a field of a collection that holds values of a lookup kind.
+type lookupField struct {
+	coll  string
+	field string
+	// array is set for fields holding a list of values.
+	array bool
+	// elem is set for fields holding a list of documents, naming the field of the documents
+	// that holds the value.
+	elem string
+}
+
+// path is the dotted path of the values, for queries.
+func (f lookupField) path() string {
+	if f.elem != "" {
+		return f.field + "." + f.elem
+	}
+	return f.field
+}
+
+// lookupFields lists where the values of each lookup kind are used. Snapshots on orders and
+// material orders keep the values as they were recorded and are not listed.
+var lookupFields = map[string][]lookupField{
+	types.LookupColor: {
+		{coll: productColl, field: "color"},
+		{coll: materialColl, field: "color"},
+		{coll: productParentColl, field: "colors", array: true},
+	},
+	types.LookupType: {
+		{coll: productColl, field: "type"},
+		{coll: materialColl, field: "type"},
+		{coll: productParentColl, field: "type"},
+	},
+	types.LookupSize: {
+		{coll: productColl, field: "size"},
+		{coll: materialColl, field: "size"},
+		{coll: productParentColl, field: "sizes", array: true},
+	},
+	types.LookupUnit: {
+		{coll: materialColl, field: "unit"},
+		{coll: materialColl, field: "units", elem: "unit"},
+		{coll: productColl, field: "materialUsage", elem: "unit"},
+	},
+}
+
+type LookupStore interface {
+	GetLookups(ctx context.Context, filter bson.M) ([]*types.Lookup, error)
+	GetLookup(context.Context, primitive.ObjectID) (*types.Lookup, error)
+	InsertLookup(context.Context, *types.Lookup) (*types.Lookup, error)
+	UpdateLookup(ctx context.Context, id primitive.ObjectID, lookup *types.Lookup, version int64) (int64, error)
+	DeleteLookup(ctx context.Context, id primitive.ObjectID, opts DeleteOptions) (int64, error)
+	RestoreLookup(ctx context.Context, id primitive.ObjectID) (int64, error)
+	GetLookupValuesInUse(ctx context.Context, kind string) ([]string, error)
+	MergeLookupValues(ctx context.Context, kind string, from []string, to string, opts DeleteOptions) (*types.LookupMergeResult, error)
+}
+
+type MongoLookupStore struct {
+	client *mongo.Client
+	coll   *mongo.Collection
+}
+
+func NewMongoLookupStore(client *mongo.Client) *MongoLookupStore {
+	dbName := os.Getenv(MongoDBNameEnvName)
+	return &MongoLookupStore{
+		client: client,
+		coll:   client.Database(dbName).Collection(lookupColl),
+	}
+}
+
+// GetLookups returns the lookups matching filter in their sort order. Unlike the other list
+// queries the filter values match exactly.
+func (s *MongoLookupStore) GetLookups(ctx context.Context, filter bson.M) ([]*types.Lookup, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "sortOrder", Value: 1}, {Key: "code", Value: 1}})
+	resp, err := s.coll.Find(ctx, liveFilter(filter), opts)
+	if err != nil {
+		return nil, err
+	}
+
+	lookups := []*types.Lookup{}
+	if err := resp.All(ctx, &lookups); err != nil {
+		return nil, err
+	}
+
+	return lookups, nil
+}
+
+func (s *MongoLookupStore) GetLookup(ctx context.Context, id primitive.ObjectID) (*types.Lookup, error) {
+	var lookup types.Lookup
+	if err := s.coll.FindOne(ctx, liveID(ctx, id)).Decode(&lookup); err != nil {
+		return nil, err
+	}
+
+	return &lookup, nil
+}
+
+func (s *MongoLookupStore) InsertLookup(ctx context.Context, lookup *types.Lookup) (*types.Lookup, error) {
+	lookup.Version = 1
+	resp, err := s.coll.InsertOne(ctx, lookup)
+	if err != nil {
+		return nil, err
+	}
+	lookup.ID = resp.InsertedID.(primitive.ObjectID)
+
+	return lookup, nil
+}
+
+func (s *MongoLookupStore) UpdateLookup(ctx context.Context, id primitive.ObjectID, lookup *types.Lookup, version int64) (int64, error) {
+	update := bson.M{
+		"$set": bson.M{
+			"code":      lookup.Code,
+			"nameZh":    lookup.NameZh,
+			"nameEn":    lookup.NameEn,
+			"sortOrder": lookup.SortOrder,
+			"active":    lookup.Active,
+		},
+	}
+
+	return updateVersioned(ctx, s.coll, id, version, update)
+}
+
+// DeleteLookup archives a lookup whose code no live record uses.
+func (s *MongoLookupStore) DeleteLookup(ctx context.Context, id primitive.ObjectID, opts DeleteOptions) (int64, error) {
+	var lookup types.Lookup
+	if err := s.coll.FindOne(ctx, bson.M{"_id": id}).Decode(&lookup); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	return archiveReferenced(ctx, s.client, s.coll, id, opts, lookupReferences(lookup.Kind, lookup.Code))
+}
+
+func (s *MongoLookupStore) RestoreLookup(ctx context.Context, id primitive.ObjectID) (int64, error) {
+	return restoreArchived(ctx, s.coll, id)
+}
+
+func lookupReferences(kind, code string) []reference {
+	refs := []reference{}
+	for _, f := range lookupFields[kind] {
+		refs = append(refs, reference{coll: f.coll, field: f.path(), value: code})
+	}
+	return refs
+}
+
+// GetLookupValuesInUse returns the distinct values of a kind held by live records, sorted.
+func (s *MongoLookupStore) GetLookupValuesInUse(ctx context.Context, kind string) ([]string, error) {
+	seen := map[string]bool{}
+	for _, f := range lookupFields[kind] {
+		values, err := s.coll.Database().Collection(f.coll).Distinct(ctx, f.path(), liveFilter(bson.M{}))
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range values {
+			if value, ok := v.(string); ok && value != "" {
+				seen[value] = true
+			}
+		}
+	}
+
+	values := make([]string, 0, len(seen))
+	for value := range seen {
+		values = append(values, value)
+	}
+	sort.Strings(values)
+	return values, nil
+}
+
+// MergeLookupValues rewrites every use of the from values of a kind to the code to, and
+// archives the lookups of the from values, in one transaction.
+func (s *MongoLookupStore) MergeLookupValues(ctx context.Context, kind string, from []string, to string, opts DeleteOptions) (*types.LookupMergeResult, error) {
+	result := &types.LookupMergeResult{Kind: kind, From: from, To: to}
+
+	err := withTransaction(ctx, s.client, func(ctx context.Context) error {
+		result.Updated = map[string]int64{}
+		database := s.coll.Database()
+		for _, f := range lookupFields[kind] {
+			coll := database.Collection(f.coll)
+			filter := bson.M{f.path(): bson.M{"$in": from}}
+
+			if f.elem != "" {
+				set := bson.M{"$set": bson.M{f.field + ".$[e]." + f.elem: to}, "$inc": bumpVersion}
+				opts := options.Update().SetArrayFilters(options.ArrayFilters{
+					Filters: []interface{}{bson.M{"e." + f.elem: bson.M{"$in": from}}},
+				})
+				res, err := coll.UpdateMany(ctx, filter, set, opts)
+				if err != nil {
+					return err
+				}
+				result.Updated[f.coll] += res.ModifiedCount
+				continue
+			}
+
+			if !f.array {
+				res, err := coll.UpdateMany(ctx, filter, bson.M{"$set": bson.M{f.field: to}, "$inc": bumpVersion})
+				if err != nil {
+					return err
+				}
+				result.Updated[f.coll] += res.ModifiedCount
+				continue
+			}
+
+			updated, err := mergeArrayValues(ctx, coll, f.field, filter, from, to)
+			if err != nil {
+				return err
+			}
+			result.Updated[f.coll] += updated
+		}
+
+		filter := bson.M{"kind": kind, "code": bson.M{"$in": from, "$ne": to}, archivedField: notArchived}
+		res, err := s.coll.UpdateMany(ctx, filter, opts.archiveUpdate())
+		if err != nil {
+			return err
+		}
+		result.Archived = res.ModifiedCount
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// mergeArrayValues replaces the from values of a list field with to, keeping each value once.
+func mergeArrayValues(ctx context.Context, coll *mongo.Collection, field string, filter bson.M, from []string, to string) (int64, error) {
+	cursor, err := coll.Find(ctx, filter, options.Find().SetProjection(bson.M{field: 1}))
+	if err != nil {
+		return 0, err
+	}
+	var docs []bson.M
+	if err := cursor.All(ctx, &docs); err != nil {
+		return 0, err
+	}
+
+	replace := map[string]bool{}
+	for _, v := range from {
+		replace[v] = true
+	}
+
+	var updated int64
+	for _, doc := range docs {
+		values, _ := doc[field].(bson.A)
+		merged := []string{}
+		seen := map[string]bool{}
+		for _, v := range values {
+			value, _ := v.(string)
+			if replace[value] {
+				value = to
+			}
+			if !seen[value] {
+				seen[value] = true
+				merged = append(merged, value)
+			}
+		}
+
+		update := bson.M{"$set": bson.M{field: merged}, "$inc": bumpVersion}
+		if _, err := coll.UpdateOne(ctx, bson.M{"_id": doc["_id"]}, update); err != nil {
+			return 0, err
+		}
+		updated++
+	}
+	return updated, nil
+}
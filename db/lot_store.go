@@ -0,0 +1,283 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/johnson7543/ims/types"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	lotColl         = "material_lots"
+	consumptionColl = "material_consumptions"
+)
+
+// ErrLotExists is returned when receiving a lot number the material already has.
+var ErrLotExists = errors.New("lot number already received")
+
+// ErrLotConsumed is returned when canceling the receipt of a lot that has been drawn from.
+var ErrLotConsumed = errors.New("lot has already been used")
+
+// LotStore keeps the lots of materials and the consumptions drawing from them.
+type LotStore interface {
+	GetLots(ctx context.Context, filter bson.M) ([]*types.MaterialLot, error)
+	GetLot(context.Context, primitive.ObjectID) (*types.MaterialLot, error)
+	ReceiveLots(ctx context.Context, lots []*types.MaterialLot) error
+	CancelReceivedLots(ctx context.Context, materialOrderID primitive.ObjectID) (int64, error)
+	ConsumeMaterial(ctx context.Context, consumption *types.MaterialConsumption, singleLot bool) (*types.MaterialConsumption, error)
+	GetConsumptions(ctx context.Context, filter bson.M) ([]*types.MaterialConsumption, error)
+}
+
+type MongoLotStore struct {
+	client       *mongo.Client
+	coll         *mongo.Collection
+	consumptions *mongo.Collection
+	materials    *mongo.Collection
+	stock        *MongoStockStore
+}
+
+func NewMongoLotStore(client *mongo.Client) *MongoLotStore {
+	dbName := os.Getenv(MongoDBNameEnvName)
+	database := client.Database(dbName)
+	return &MongoLotStore{
+		client:       client,
+		coll:         database.Collection(lotColl),
+		consumptions: database.Collection(consumptionColl),
+		materials:    database.Collection(materialColl),
+		stock:        NewMongoStockStore(client),
+	}
+}
+
+// GetLots returns the lots matching filter, oldest first. Unlike the other list queries the
+// filter values match exactly.
+func (s *MongoLotStore) GetLots(ctx context.Context, filter bson.M) ([]*types.MaterialLot, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "receivedAt", Value: 1}, {Key: "_id", Value: 1}})
+	resp, err := s.coll.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	lots := []*types.MaterialLot{}
+	if err := resp.All(ctx, &lots); err != nil {
+		return nil, err
+	}
+
+	return lots, nil
+}
+
+func (s *MongoLotStore) GetLot(ctx context.Context, id primitive.ObjectID) (*types.MaterialLot, error) {
+	var lot types.MaterialLot
+	if err := s.coll.FindOne(ctx, bson.M{"_id": id}).Decode(&lot); err != nil {
+		return nil, err
+	}
+
+	return &lot, nil
+}
+
+// ReceiveLots records received lots, numbering the ones without a lot number. It returns
+// ErrLotExists when a material already has one of the numbers. The material totals are left
+// to the caller.
+func (s *MongoLotStore) ReceiveLots(ctx context.Context, lots []*types.MaterialLot) error {
+	return withTransaction(ctx, s.client, func(ctx context.Context) error {
+		for _, lot := range lots {
+			if err := s.receive(ctx, lot); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// receive records one received lot in the caller's transaction.
+func (s *MongoLotStore) receive(ctx context.Context, lot *types.MaterialLot) error {
+	if lot.LotNumber == "" {
+		seq, err := nextSequence(ctx, s.coll.Database(), "lot")
+		if err != nil {
+			return err
+		}
+		lot.LotNumber = fmt.Sprintf("LOT%06d", seq)
+	}
+
+	count, err := s.coll.CountDocuments(ctx, bson.M{"materialId": lot.MaterialID, "lotNumber": lot.LotNumber})
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return fmt.Errorf("%w: %s", ErrLotExists, lot.LotNumber)
+	}
+
+	lot.Remaining = lot.Quantity
+	resp, err := s.coll.InsertOne(ctx, lot)
+	if err != nil {
+		return err
+	}
+	lot.ID = resp.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+// CancelReceivedLots empties the lots a material order received, when it is canceled. It
+// returns ErrLotConsumed when any of them has been drawn from, and cancels none then.
+func (s *MongoLotStore) CancelReceivedLots(ctx context.Context, materialOrderID primitive.ObjectID) (int64, error) {
+	var canceled int64
+	err := withTransaction(ctx, s.client, func(ctx context.Context) error {
+		lots, err := s.GetLots(ctx, bson.M{"materialOrderId": materialOrderID, "canceled": bson.M{"$ne": true}})
+		if err != nil {
+			return err
+		}
+		for _, lot := range lots {
+			if lot.Remaining < lot.Quantity-stockEpsilon {
+				return fmt.Errorf("%w: %s", ErrLotConsumed, lot.LotNumber)
+			}
+		}
+
+		res, err := s.coll.UpdateMany(ctx,
+			bson.M{"materialOrderId": materialOrderID, "canceled": bson.M{"$ne": true}},
+			bson.M{"$set": bson.M{"remaining": 0, "canceled": true}})
+		if err != nil {
+			return err
+		}
+		canceled = res.ModifiedCount
+		return nil
+	})
+	return canceled, err
+}
+
+// ConsumeMaterial takes the consumption out of stock and records it, all or nothing. The lots
+// to draw from can be given as draws; otherwise they are drawn first in, first out, from one
+// lot only when singleLot is set. Stock received before lots were tracked is drawn last. It
+// returns ErrInsufficientStock when the lots, the location or the material hold too little.
+func (s *MongoLotStore) ConsumeMaterial(ctx context.Context, consumption *types.MaterialConsumption, singleLot bool) (*types.MaterialConsumption, error) {
+	err := withTransaction(ctx, s.client, func(ctx context.Context) error {
+		if len(consumption.Draws) == 0 {
+			draws, err := s.planDraws(ctx, consumption.MaterialID, consumption.Quantity, singleLot)
+			if err != nil {
+				return err
+			}
+			consumption.Draws = draws
+		}
+
+		for i, draw := range consumption.Draws {
+			if draw.LotID == nil && draw.LotNumber == "" {
+				continue
+			}
+			filter := bson.M{"materialId": consumption.MaterialID, "canceled": bson.M{"$ne": true}, "remaining": bson.M{"$gte": draw.Quantity - stockEpsilon}}
+			if draw.LotID != nil {
+				filter["_id"] = *draw.LotID
+			} else {
+				filter["lotNumber"] = draw.LotNumber
+			}
+			var lot types.MaterialLot
+			err := s.coll.FindOneAndUpdate(ctx, filter, bson.M{"$inc": bson.M{"remaining": -draw.Quantity}}).Decode(&lot)
+			if err == mongo.ErrNoDocuments {
+				return ErrInsufficientStock
+			}
+			if err != nil {
+				return err
+			}
+			consumption.Draws[i].LotID = &lot.ID
+			consumption.Draws[i].LotNumber = lot.LotNumber
+		}
+
+		if consumption.Location != nil {
+			if err := s.stock.takeLevel(ctx, types.StockMaterial, consumption.MaterialID, *consumption.Location, consumption.Quantity); err != nil {
+				return err
+			}
+		}
+		var updated int64
+		var err error
+		if consumption.Location != nil {
+			updated, err = decreaseQuantity(ctx, s.materials, consumption.MaterialID, consumption.Quantity)
+		} else {
+			updated, err = decreaseUnassigned(ctx, s.materials, types.StockMaterial, consumption.MaterialID, consumption.Quantity)
+		}
+		if err != nil {
+			return err
+		}
+		if updated == 0 {
+			return mongo.ErrNoDocuments
+		}
+
+		resp, err := s.consumptions.InsertOne(ctx, consumption)
+		if err != nil {
+			return err
+		}
+		consumption.ID = resp.InsertedID.(primitive.ObjectID)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return consumption, nil
+}
+
+// planDraws picks the lots to draw quantity from, oldest first.
+func (s *MongoLotStore) planDraws(ctx context.Context, materialID primitive.ObjectID, quantity float64, singleLot bool) ([]types.LotDraw, error) {
+	lots, err := s.GetLots(ctx, bson.M{"materialId": materialID, "canceled": bson.M{"$ne": true}, "remaining": bson.M{"$gt": stockEpsilon}})
+	if err != nil {
+		return nil, err
+	}
+
+	if singleLot {
+		for _, lot := range lots {
+			if lot.Remaining+stockEpsilon >= quantity {
+				return []types.LotDraw{{LotID: &lot.ID, LotNumber: lot.LotNumber, Quantity: quantity}}, nil
+			}
+		}
+		return nil, ErrInsufficientStock
+	}
+
+	draws := []types.LotDraw{}
+	left, lotted := quantity, 0.0
+	for _, lot := range lots {
+		lotted += lot.Remaining
+		if left <= stockEpsilon {
+			continue
+		}
+		take := lot.Remaining
+		if take > left {
+			take = left
+		}
+		draws = append(draws, types.LotDraw{LotID: &lot.ID, LotNumber: lot.LotNumber, Quantity: take})
+		left -= take
+	}
+	if left <= stockEpsilon {
+		return draws, nil
+	}
+
+	// The rest comes from stock no lot accounts for.
+	var material struct {
+		Quantity float64 `bson:"quantity"`
+	}
+	if err := s.materials.FindOne(ctx, bson.M{"_id": materialID}).Decode(&material); err != nil {
+		return nil, err
+	}
+	if material.Quantity-lotted+stockEpsilon < left {
+		return nil, ErrInsufficientStock
+	}
+	return append(draws, types.LotDraw{Quantity: left}), nil
+}
+
+// GetConsumptions returns the consumptions matching filter, newest first. Unlike the other
+// list queries the filter values match exactly.
+func (s *MongoLotStore) GetConsumptions(ctx context.Context, filter bson.M) ([]*types.MaterialConsumption, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "consumedAt", Value: -1}, {Key: "_id", Value: -1}})
+	resp, err := s.consumptions.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	consumptions := []*types.MaterialConsumption{}
+	if err := resp.All(ctx, &consumptions); err != nil {
+		return nil, err
+	}
+
+	return consumptions, nil
+}
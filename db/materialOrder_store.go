@@ -9,19 +9,20 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 const materialOrderColl = "materialOrders"
 
 type MaterialOrderStore interface {
 	GetMaterialOrders(context.Context, bson.M) ([]*types.MaterialOrder, error)
+	IterateMaterialOrders(ctx context.Context, filter bson.M, fn func(*types.MaterialOrder) error) error
 	GetMaterialOrder(context.Context, primitive.ObjectID) (*types.MaterialOrder, error)
 	InsertMaterialOrder(context.Context, *types.MaterialOrder) (*types.MaterialOrder, error)
-	UpdateMaterialOrder(context.Context, primitive.ObjectID, *types.MaterialOrder) (int64, error)
+	UpdateMaterialOrder(context.Context, primitive.ObjectID, *types.MaterialOrder, int64) (int64, error)
 	UpdateMaterialOrderTotalAmount(context.Context, primitive.ObjectID, *types.MaterialOrder) (int64, error)
 	InsertMaterialOrderItems(context.Context, primitive.ObjectID, *types.MaterialOrder) (int64, error)
-	DeleteMaterialOrder(context.Context, primitive.ObjectID) (int64, error)
+	DeleteMaterialOrder(ctx context.Context, id primitive.ObjectID, opts DeleteOptions) (int64, error)
+	RestoreMaterialOrder(ctx context.Context, id primitive.ObjectID) (int64, error)
 }
 
 type MongoMaterialOrderStore struct {
@@ -47,7 +48,7 @@ func (s *MongoMaterialOrderStore) GetMaterialOrders(ctx context.Context, filter
 		}
 	}
 
-	resp, err := s.coll.Find(ctx, filter)
+	resp, err := s.coll.Find(ctx, liveFilter(filter))
 	if err != nil {
 		return nil, err
 	}
@@ -60,8 +61,12 @@ func (s *MongoMaterialOrderStore) GetMaterialOrders(ctx context.Context, filter
 	return materialOrders, nil
 }
 
+func (s *MongoMaterialOrderStore) IterateMaterialOrders(ctx context.Context, filter bson.M, fn func(*types.MaterialOrder) error) error {
+	return iterate(ctx, s.coll, filter, fn)
+}
+
 func (s *MongoMaterialOrderStore) GetMaterialOrder(ctx context.Context, materialOrderID primitive.ObjectID) (*types.MaterialOrder, error) {
-	filter := bson.M{"_id": materialOrderID}
+	filter := liveID(ctx, materialOrderID)
 	var materialOrder types.MaterialOrder
 
 	err := s.coll.FindOne(ctx, filter).Decode(&materialOrder)
@@ -73,6 +78,7 @@ func (s *MongoMaterialOrderStore) GetMaterialOrder(ctx context.Context, material
 }
 
 func (s *MongoMaterialOrderStore) InsertMaterialOrder(ctx context.Context, order *types.MaterialOrder) (*types.MaterialOrder, error) {
+	order.Version = 1
 	resp, err := s.coll.InsertOne(ctx, order)
 	if err != nil {
 		return nil, err
@@ -82,8 +88,7 @@ func (s *MongoMaterialOrderStore) InsertMaterialOrder(ctx context.Context, order
 	return order, nil
 }
 
-func (s *MongoMaterialOrderStore) UpdateMaterialOrder(ctx context.Context, orderID primitive.ObjectID, updatedOrder *types.MaterialOrder) (int64, error) {
-	filter := bson.M{"_id": orderID}
+func (s *MongoMaterialOrderStore) UpdateMaterialOrder(ctx context.Context, orderID primitive.ObjectID, updatedOrder *types.MaterialOrder, version int64) (int64, error) {
 
 	update := bson.M{
 		"$set": bson.M{
@@ -97,12 +102,7 @@ func (s *MongoMaterialOrderStore) UpdateMaterialOrder(ctx context.Context, order
 		},
 	}
 
-	updateResult, err := s.coll.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
-	if err != nil {
-		return 0, err
-	}
-
-	return updateResult.ModifiedCount, nil
+	return updateVersioned(ctx, s.coll, orderID, version, update)
 }
 
 func (s *MongoMaterialOrderStore) UpdateMaterialOrderTotalAmount(ctx context.Context, materialOrderID primitive.ObjectID, updatedMaterialOrder *types.MaterialOrder) (int64, error) {
@@ -111,6 +111,7 @@ func (s *MongoMaterialOrderStore) UpdateMaterialOrderTotalAmount(ctx context.Con
 		"$set": bson.M{
 			"totalAmount": updatedMaterialOrder.TotalAmount,
 		},
+		"$inc": bumpVersion,
 	}
 
 	updateResult, err := s.coll.UpdateOne(ctx, filter, update)
@@ -127,6 +128,7 @@ func (s *MongoMaterialOrderStore) InsertMaterialOrderItems(ctx context.Context,
 		"$push": bson.M{
 			"materialOrderItems": bson.M{"$each": updatedMaterialOrder.MaterialOrderItems},
 		},
+		"$inc": bumpVersion,
 	}
 
 	updateResult, err := s.coll.UpdateOne(ctx, filter, update)
@@ -137,7 +139,16 @@ func (s *MongoMaterialOrderStore) InsertMaterialOrderItems(ctx context.Context,
 	return updateResult.ModifiedCount, nil
 }
 
-func (s *MongoMaterialOrderStore) DeleteMaterialOrder(ctx context.Context, id primitive.ObjectID) (int64, error) {
-	deleteResult, err := s.coll.DeleteOne(ctx, bson.M{"_id": id})
-	return deleteResult.DeletedCount, err
+func (s *MongoMaterialOrderStore) DeleteMaterialOrder(ctx context.Context, id primitive.ObjectID, opts DeleteOptions) (int64, error) {
+	return archiveReferenced(ctx, s.client, s.coll, id, opts, materialOrderReferences(id))
+}
+
+// materialOrderReferences lists the supplier returns of a material order, which keep it from
+// being deleted and purged.
+func materialOrderReferences(id primitive.ObjectID) []reference {
+	return []reference{{coll: supplierReturnColl, field: "materialOrderId", value: id, kept: true}}
+}
+
+func (s *MongoMaterialOrderStore) RestoreMaterialOrder(ctx context.Context, id primitive.ObjectID) (int64, error) {
+	return restoreArchived(ctx, s.coll, id)
 }
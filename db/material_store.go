@@ -2,7 +2,6 @@ package db
 
 import (
 	"context"
-	"errors"
 	"os"
 
 	"github.com/johnson7543/ims/types"
@@ -16,15 +15,17 @@ const materialColl = "materials"
 
 type MaterialStore interface {
 	GetMaterials(context.Context, bson.M) ([]*types.Material, error)
+	IterateMaterials(ctx context.Context, filter bson.M, fn func(*types.Material) error) error
 	GetMaterial(context.Context, primitive.ObjectID) (*types.Material, error)
 	InsertMaterial(context.Context, *types.Material) (*types.Material, error)
-	UpdateMaterial(context.Context, primitive.ObjectID, *types.Material) (int64, error)
-	DeleteMaterial(context.Context, primitive.ObjectID) (int64, error)
+	UpdateMaterial(context.Context, primitive.ObjectID, *types.Material, int64) (int64, error)
+	DeleteMaterial(ctx context.Context, id primitive.ObjectID, opts DeleteOptions) (int64, error)
+	RestoreMaterial(ctx context.Context, id primitive.ObjectID) (int64, error)
 	GetMaterialColors(context.Context, string) ([]string, error)
 	GetMaterialTypes(context.Context) ([]string, error)
 	GetMaterialSizes(context.Context, string) ([]string, error)
-	DecreaseMaterialQuantity(ctx context.Context, materialID primitive.ObjectID, quantity int) (int64, error)
-	IncreaseMaterialQuantity(ctx context.Context, materialID primitive.ObjectID, quantity int) (int64, error)
+	DecreaseMaterialQuantity(ctx context.Context, materialID primitive.ObjectID, quantity float64) (int64, error)
+	IncreaseMaterialQuantity(ctx context.Context, materialID primitive.ObjectID, quantity float64) (int64, error)
 }
 
 type MongoMaterialStore struct {
@@ -50,7 +51,7 @@ func (s *MongoMaterialStore) GetMaterials(ctx context.Context, filter bson.M) ([
 		}
 	}
 
-	resp, err := s.coll.Find(ctx, filter)
+	resp, err := s.coll.Find(ctx, liveFilter(filter))
 	if err != nil {
 		return nil, err
 	}
@@ -63,15 +64,16 @@ func (s *MongoMaterialStore) GetMaterials(ctx context.Context, filter bson.M) ([
 	return materials, nil
 }
 
+func (s *MongoMaterialStore) IterateMaterials(ctx context.Context, filter bson.M, fn func(*types.Material) error) error {
+	return iterate(ctx, s.coll, filter, fn)
+}
+
 func (s *MongoMaterialStore) GetMaterial(ctx context.Context, materialID primitive.ObjectID) (*types.Material, error) {
-	filter := bson.M{"_id": materialID}
+	filter := liveID(ctx, materialID)
 	var material types.Material
 
 	err := s.coll.FindOne(ctx, filter).Decode(&material)
 	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			return nil, errors.New("material not found")
-		}
 		return nil, err
 	}
 
@@ -79,6 +81,7 @@ func (s *MongoMaterialStore) GetMaterial(ctx context.Context, materialID primiti
 }
 
 func (s *MongoMaterialStore) InsertMaterial(ctx context.Context, material *types.Material) (*types.Material, error) {
+	material.Version = 1
 	resp, err := s.coll.InsertOne(ctx, material)
 	if err != nil {
 		return nil, err
@@ -88,32 +91,43 @@ func (s *MongoMaterialStore) InsertMaterial(ctx context.Context, material *types
 	return material, nil
 }
 
-func (s *MongoMaterialStore) UpdateMaterial(ctx context.Context, materialID primitive.ObjectID, updates *types.Material) (int64, error) {
-	filter := bson.M{"_id": materialID}
+// UpdateMaterial replaces the material's fields apart from its stock and appends
+// updates.PriceHistory to the prices already recorded, so concurrent purchases cannot drop each
+// other's entries.
+func (s *MongoMaterialStore) UpdateMaterial(ctx context.Context, materialID primitive.ObjectID, updates *types.Material, version int64) (int64, error) {
 	update := bson.M{
 		"$set": bson.M{
-			"name":          updates.Name,
-			"color":         updates.Color,
-			"type":          updates.Type,
-			"size":          updates.Size,
-			"quantity":      updates.Quantity,
-			"remarks":       updates.Remarks,
-			"price_history": updates.PriceHistory,
+			"name":    updates.Name,
+			"color":   updates.Color,
+			"type":    updates.Type,
+			"size":    updates.Size,
+			"unit":    updates.Unit,
+			"units":   updates.Units,
+			"remarks": updates.Remarks,
 		},
 	}
-
-	updateResult, err := s.coll.UpdateOne(ctx, filter, update)
-	if err != nil {
-		return 0, err
+	if len(updates.PriceHistory) > 0 {
+		update["$push"] = bson.M{"price_history": bson.M{"$each": updates.PriceHistory}}
 	}
 
-	return updateResult.ModifiedCount, nil
+	return updateVersioned(ctx, s.coll, materialID, version, update)
 }
 
-func (s *MongoMaterialStore) DeleteMaterial(ctx context.Context, id primitive.ObjectID) (int64, error) {
-	deleteResult, err := s.coll.DeleteOne(ctx, bson.M{"_id": id})
+// DeleteMaterial archives a material that no live material order or product bill of materials
+// refers to. A cascade archives the material orders and removes the material from the products using it.
+func (s *MongoMaterialStore) DeleteMaterial(ctx context.Context, id primitive.ObjectID, opts DeleteOptions) (int64, error) {
+	return archiveReferenced(ctx, s.client, s.coll, id, opts, materialReferences(id))
+}
 
-	return deleteResult.DeletedCount, err
+func (s *MongoMaterialStore) RestoreMaterial(ctx context.Context, id primitive.ObjectID) (int64, error) {
+	return restoreArchived(ctx, s.coll, id)
+}
+
+func materialReferences(id primitive.ObjectID) []reference {
+	return []reference{
+		{coll: materialOrderColl, field: "materialOrderItems.material._id", value: id},
+		{coll: productColl, field: "materialUsage.materialId", value: id, pull: bson.M{"materialUsage": bson.M{"materialId": id}}},
+	}
 }
 
 func (s *MongoMaterialStore) GetMaterialColors(ctx context.Context, materialType string) ([]string, error) {
@@ -122,7 +136,7 @@ func (s *MongoMaterialStore) GetMaterialColors(ctx context.Context, materialType
 		filter["type"] = materialType
 	}
 
-	colors, err := s.coll.Distinct(ctx, "color", filter)
+	colors, err := s.coll.Distinct(ctx, "color", liveFilter(filter))
 	if err != nil {
 		return nil, err
 	}
@@ -138,7 +152,7 @@ func (s *MongoMaterialStore) GetMaterialColors(ctx context.Context, materialType
 }
 
 func (s *MongoMaterialStore) GetMaterialTypes(ctx context.Context) ([]string, error) {
-	types, err := s.coll.Distinct(ctx, "type", bson.M{})
+	types, err := s.coll.Distinct(ctx, "type", liveFilter(bson.M{}))
 	if err != nil {
 		return nil, err
 	}
@@ -159,7 +173,7 @@ func (s *MongoMaterialStore) GetMaterialSizes(ctx context.Context, materialType
 		filter["type"] = materialType
 	}
 
-	sizes, err := s.coll.Distinct(ctx, "size", filter)
+	sizes, err := s.coll.Distinct(ctx, "size", liveFilter(filter))
 	if err != nil {
 		return nil, err
 	}
@@ -174,30 +188,11 @@ func (s *MongoMaterialStore) GetMaterialSizes(ctx context.Context, materialType
 	return sizeStrings, nil
 }
 
-func (s *MongoMaterialStore) DecreaseMaterialQuantity(ctx context.Context, materialID primitive.ObjectID, quantity int) (int64, error) {
-	filter := bson.M{"_id": materialID, "quantity": bson.M{"$gte": quantity}}
-	update := bson.M{
-		"$inc": bson.M{"quantity": -quantity},
-	}
-
-	updateResult, err := s.coll.UpdateOne(ctx, filter, update)
-	if err != nil {
-		return 0, err
-	}
-
-	return updateResult.ModifiedCount, nil
+// DecreaseMaterialQuantity takes quantity from the unreserved stock not placed at a location, returning ErrInsufficientStock when there is not enough.
+func (s *MongoMaterialStore) DecreaseMaterialQuantity(ctx context.Context, materialID primitive.ObjectID, quantity float64) (int64, error) {
+	return decreaseUnassigned(ctx, s.coll, types.StockMaterial, materialID, quantity)
 }
 
-func (s *MongoMaterialStore) IncreaseMaterialQuantity(ctx context.Context, materialID primitive.ObjectID, quantity int) (int64, error) {
-	filter := bson.M{"_id": materialID}
-	update := bson.M{
-		"$inc": bson.M{"quantity": quantity},
-	}
-
-	updateResult, err := s.coll.UpdateOne(ctx, filter, update)
-	if err != nil {
-		return 0, err
-	}
-
-	return updateResult.ModifiedCount, nil
+func (s *MongoMaterialStore) IncreaseMaterialQuantity(ctx context.Context, materialID primitive.ObjectID, quantity float64) (int64, error) {
+	return increaseQuantity(ctx, s.coll, materialID, quantity)
 }
@@ -2,30 +2,35 @@ package db
 
 import (
 	"context"
+	"fmt"
 	"os"
+	"strings"
 
 	"github.com/johnson7543/ims/types"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 const orderColl = "orders"
 
 type OrderStore interface {
 	GetOrders(ctx context.Context, filter bson.M) ([]*types.Order, error)
+	GetOrder(context.Context, primitive.ObjectID) (*types.Order, error)
+	IterateOrders(ctx context.Context, filter bson.M, fn func(*types.Order) error) error
 	InsertOrder(ctx context.Context, order *types.Order) (*types.Order, error)
-	UpdateOrder(ctx context.Context, orderID primitive.ObjectID, updatedOrder *types.Order) (int64, error)
-	UpdateOrderTotalAmount(ctx context.Context, orderID primitive.ObjectID, updatedOrder *types.Order) (int64, error)
+	PlaceOrder(ctx context.Context, order *types.Order) (*types.Order, error)
+	UpdateOrder(ctx context.Context, orderID primitive.ObjectID, updatedOrder *types.Order, version int64) (int64, error)
 	InsertOrderItems(ctx context.Context, orderID primitive.ObjectID, updatedOrder *types.Order) (int64, error)
-	DeleteOrder(ctx context.Context, id primitive.ObjectID) (int64, error)
+	DeleteOrder(ctx context.Context, id primitive.ObjectID, opts DeleteOptions) (int64, error)
+	RestoreOrder(ctx context.Context, id primitive.ObjectID) (int64, error)
 }
 
 type MongoOrderStore struct {
 	client *mongo.Client
 	coll   *mongo.Collection
+	stock  *MongoStockStore
 }
 
 func NewMongoOrderStore(client *mongo.Client) *MongoOrderStore {
@@ -33,6 +38,7 @@ func NewMongoOrderStore(client *mongo.Client) *MongoOrderStore {
 	return &MongoOrderStore{
 		client: client,
 		coll:   client.Database(dbname).Collection(orderColl),
+		stock:  NewMongoStockStore(client),
 	}
 }
 
@@ -46,7 +52,7 @@ func (s *MongoOrderStore) GetOrders(ctx context.Context, filter bson.M) ([]*type
 		}
 	}
 
-	resp, err := s.coll.Find(ctx, filter)
+	resp, err := s.coll.Find(ctx, liveFilter(filter))
 	if err != nil {
 		return nil, err
 	}
@@ -59,7 +65,26 @@ func (s *MongoOrderStore) GetOrders(ctx context.Context, filter bson.M) ([]*type
 	return orders, nil
 }
 
+func (s *MongoOrderStore) IterateOrders(ctx context.Context, filter bson.M, fn func(*types.Order) error) error {
+	return iterate(ctx, s.coll, filter, fn)
+}
+
+func (s *MongoOrderStore) GetOrder(ctx context.Context, orderID primitive.ObjectID) (*types.Order, error) {
+	filter := liveID(ctx, orderID)
+	var order types.Order
+
+	err := s.coll.FindOne(ctx, filter).Decode(&order)
+	if err != nil {
+		return nil, err
+	}
+
+	return &order, nil
+}
+
+// InsertOrder saves the order as it is, leaving stock alone. Orders taken from customers go
+// through PlaceOrder.
 func (s *MongoOrderStore) InsertOrder(ctx context.Context, order *types.Order) (*types.Order, error) {
+	order.Version = 1
 	resp, err := s.coll.InsertOne(ctx, order)
 	if err != nil {
 		return nil, err
@@ -69,62 +94,270 @@ func (s *MongoOrderStore) InsertOrder(ctx context.Context, order *types.Order) (
 	return order, nil
 }
 
-func (s *MongoOrderStore) UpdateOrder(ctx context.Context, orderID primitive.ObjectID, updatedOrder *types.Order) (int64, error) {
-	filter := bson.M{"_id": orderID}
-	update := bson.M{
-		"$set": bson.M{
-			"customerId":      updatedOrder.CustomerID,
-			"customerName":    updatedOrder.CustomerName,
-			"orderDate":       updatedOrder.OrderDate,
-			"paymentDate":     updatedOrder.PaymentDate,
-			"deliveryDate":    updatedOrder.DeliveryDate,
-			"totalAmount":     updatedOrder.TotalAmount,
-			"status":          updatedOrder.Status,
-			"shippingAddress": updatedOrder.ShippingAddress,
-		},
+// PlaceOrder saves a new order together with its hold on stock, all or nothing: open orders
+// reserve their products until they ship, orders entered as shipped take them at once and
+// canceled ones leave stock alone. It returns ErrInsufficientStock when a product has too
+// little available, at the order's location when it has one.
+func (s *MongoOrderStore) PlaceOrder(ctx context.Context, order *types.Order) (*types.Order, error) {
+	err := withTransaction(ctx, s.client, func(ctx context.Context) error {
+		if err := s.holdStock(ctx, order.Status, order.Location, order.OrderItems); err != nil {
+			return err
+		}
+		_, err := s.InsertOrder(ctx, order)
+		return err
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	updateResult, err := s.coll.UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	return order, nil
+}
+
+// UpdateOrder changes the order and, when it is canceled, releases its reservations and puts
+// back what was taken for it, all or nothing. Shipping is left to shipments. It returns
+// ErrVersionConflict when the order changed after version and 0 when there is no such order.
+func (s *MongoOrderStore) UpdateOrder(ctx context.Context, orderID primitive.ObjectID, updatedOrder *types.Order, version int64) (int64, error) {
+	var updated int64
+	err := withTransaction(ctx, s.client, func(ctx context.Context) error {
+		current, err := s.GetOrder(ctx, orderID)
+		if err == mongo.ErrNoDocuments {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if current.Version != version {
+			return ErrVersionConflict
+		}
+
+		update := orderUpdate(updatedOrder)
+		if !strings.EqualFold(current.Status, types.OrderCanceled) && strings.EqualFold(updatedOrder.Status, types.OrderCanceled) {
+			if err := s.returnStock(ctx, current.Location, current.OrderItems); err != nil {
+				return err
+			}
+			update["$unset"] = bson.M{"orderItems.$[].reserved": ""}
+		}
+
+		updated, err = updateVersioned(ctx, s.coll, orderID, version, update)
+		return err
+	})
 	if err != nil {
 		return 0, err
 	}
 
-	return updateResult.ModifiedCount, nil
+	return updated, nil
 }
 
-func (s *MongoOrderStore) UpdateOrderTotalAmount(ctx context.Context, orderID primitive.ObjectID, updatedOrder *types.Order) (int64, error) {
-	filter := bson.M{"_id": orderID}
-	update := bson.M{
+func orderUpdate(updatedOrder *types.Order) bson.M {
+	return bson.M{
 		"$set": bson.M{
-			"totalAmount": updatedOrder.TotalAmount,
+			"customerId":              updatedOrder.CustomerID,
+			"customerName":            updatedOrder.CustomerName,
+			"orderDate":               updatedOrder.OrderDate,
+			"paymentDate":             updatedOrder.PaymentDate,
+			"deliveryDate":            updatedOrder.DeliveryDate,
+			"totalAmount":             updatedOrder.TotalAmount,
+			"status":                  updatedOrder.Status,
+			"shippingAddress":         updatedOrder.ShippingAddress,
+			"shippingAddressId":       updatedOrder.ShippingAddressID,
+			"shippingAddressSnapshot": updatedOrder.ShippingAddressSnapshot,
 		},
 	}
+}
+
+// InsertOrderItems adds items to the order and their prices to its total amount, and reserves
+// or takes their products like the rest of the order, all or nothing. It returns
+// ErrOrderCanceled when the order is canceled, ErrInsufficientStock when a product has too
+// little available and 0 when there is no such order.
+func (s *MongoOrderStore) InsertOrderItems(ctx context.Context, orderID primitive.ObjectID, updatedOrder *types.Order) (int64, error) {
+	var updated int64
+	err := withTransaction(ctx, s.client, func(ctx context.Context) error {
+		order, err := s.GetOrder(ctx, orderID)
+		if err == mongo.ErrNoDocuments {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if strings.EqualFold(order.Status, types.OrderCanceled) {
+			return ErrOrderCanceled
+		}
 
-	updateResult, err := s.coll.UpdateOne(ctx, filter, update)
+		if err := s.holdStock(ctx, order.Status, order.Location, updatedOrder.OrderItems); err != nil {
+			return err
+		}
+
+		total := 0.0
+		for _, item := range updatedOrder.OrderItems {
+			total += item.TotalPrice
+		}
+		update := bson.M{
+			"$push": bson.M{
+				"orderItems": bson.M{"$each": updatedOrder.OrderItems},
+			},
+			"$inc": bson.M{"totalAmount": total, versionField: 1},
+		}
+
+		res, err := s.coll.UpdateOne(ctx, bson.M{"_id": orderID}, update)
+		if err != nil {
+			return err
+		}
+		updated = res.ModifiedCount
+		return nil
+	})
 	if err != nil {
 		return 0, err
 	}
 
-	return updateResult.ModifiedCount, nil
+	return updated, nil
 }
 
-func (s *MongoOrderStore) InsertOrderItems(ctx context.Context, orderID primitive.ObjectID, updatedOrder *types.Order) (int64, error) {
-	filter := bson.M{"_id": orderID}
-	update := bson.M{
-		"$push": bson.M{
-			"orderItems": bson.M{"$each": updatedOrder.OrderItems},
-		},
+// holdStock reserves what is left to ship of items for an order with the status, or takes it
+// when the order is shipped already, setting what each item reserved. Canceled orders hold
+// nothing.
+func (s *MongoOrderStore) holdStock(ctx context.Context, status string, loc *types.StockLocation, items []types.OrderItem) error {
+	if strings.EqualFold(status, types.OrderCanceled) {
+		return nil
 	}
+	shipped := types.OrderShippedStatus(status)
+	for i := range items {
+		item := &items[i]
+		if item.Outstanding() == 0 {
+			continue
+		}
+		var err error
+		if shipped {
+			err = s.stock.take(ctx, types.StockProduct, item.Product.ID, loc, float64(item.Outstanding()))
+		} else {
+			err = s.stock.reserve(ctx, types.StockProduct, item.Product.ID, loc, float64(item.Outstanding()))
+			item.Reserved = item.Outstanding()
+		}
+		if err != nil {
+			return fmt.Errorf("product %s: %w", item.Product.Name, err)
+		}
+	}
+	return nil
+}
+
+// returnStock releases what is reserved for items and puts what was taken for them back into
+// stock. What went out on shipments is with the customer and stays out.
+func (s *MongoOrderStore) returnStock(ctx context.Context, loc *types.StockLocation, items []types.OrderItem) error {
+	for _, item := range items {
+		if item.Reserved > 0 {
+			if err := s.stock.release(ctx, types.StockProduct, item.Product.ID, loc, float64(item.Reserved)); err != nil {
+				return fmt.Errorf("product %s: %w", item.Product.Name, err)
+			}
+		}
+		if taken := item.Outstanding() - item.Reserved; taken > 0 {
+			if err := s.stock.put(ctx, types.StockProduct, item.Product.ID, loc, float64(taken)); err != nil {
+				return fmt.Errorf("product %s: %w", item.Product.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// DeleteOrder archives an order. An open order gives back the stock it holds in the same
+// transaction, as archived orders hold none.
+func (s *MongoOrderStore) DeleteOrder(ctx context.Context, id primitive.ObjectID, opts DeleteOptions) (int64, error) {
+	var archived int64
+	err := withTransaction(ctx, s.client, func(ctx context.Context) error {
+		order, err := s.GetOrder(ctx, id)
+		if err == mongo.ErrNoDocuments {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := s.releaseStock(ctx, order); err != nil {
+			return err
+		}
+		archived, err = archiveRecord(ctx, s.coll, id, opts, orderReferences(id))
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return archived, nil
+}
+
+// RestoreOrder brings back an archived order. An open order holds what it has left to ship
+// again, returning ErrInsufficientStock when too little is available.
+func (s *MongoOrderStore) RestoreOrder(ctx context.Context, id primitive.ObjectID) (int64, error) {
+	var restored int64
+	err := withTransaction(ctx, s.client, func(ctx context.Context) error {
+		var order types.Order
+		err := s.coll.FindOne(ctx, bson.M{"_id": id, archivedField: bson.M{"$exists": true}}).Decode(&order)
+		if err == mongo.ErrNoDocuments {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
 
-	updateResult, err := s.coll.UpdateOne(ctx, filter, update)
+		if restored, err = restoreArchived(ctx, s.coll, id); err != nil || !holdsStock(order.Status) {
+			return err
+		}
+		if err := s.holdStock(ctx, order.Status, order.Location, order.OrderItems); err != nil {
+			return err
+		}
+		_, err = s.coll.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$set": bson.M{"orderItems": order.OrderItems}})
+		return err
+	})
 	if err != nil {
 		return 0, err
 	}
 
-	return updateResult.ModifiedCount, nil
+	return restored, nil
+}
+
+// orderReferences lists the shipments, customer returns and credit notes of an order, which
+// keep it from being deleted and purged.
+func orderReferences(id primitive.ObjectID) []reference {
+	return []reference{
+		{coll: shipmentColl, field: "orderId", value: id, kept: true},
+		{coll: customerReturnColl, field: "orderId", value: id, kept: true},
+		{coll: creditNoteColl, field: "orderId", value: id, kept: true},
+	}
+}
+
+// holdsStock reports whether an order in status holds stock for what it has left to ship:
+// canceled orders gave theirs back and shipped ones have nothing left.
+func holdsStock(status string) bool {
+	return !strings.EqualFold(status, types.OrderCanceled) && !types.OrderShippedStatus(status)
 }
 
-func (s *MongoOrderStore) DeleteOrder(ctx context.Context, id primitive.ObjectID) (int64, error) {
-	deleteResult, err := s.coll.DeleteOne(ctx, bson.M{"_id": id})
-	return deleteResult.DeletedCount, err
+// releaseStock gives back what an open order holds, as canceling it would, before it is
+// archived.
+func (s *MongoOrderStore) releaseStock(ctx context.Context, order *types.Order) error {
+	if !holdsStock(order.Status) {
+		return nil
+	}
+	if err := s.returnStock(ctx, order.Location, order.OrderItems); err != nil {
+		return err
+	}
+	_, err := s.coll.UpdateOne(ctx, bson.M{"_id": order.ID}, bson.M{"$unset": bson.M{"orderItems.$[].reserved": ""}})
+	return err
+}
+
+// releaseOrderStock gives back the stock of the open orders matching filter, for a cascade
+// that archives them along with a record they refer to.
+func releaseOrderStock(ctx context.Context, client *mongo.Client, filter bson.M) error {
+	s := NewMongoOrderStore(client)
+	cursor, err := s.coll.Find(ctx, filter)
+	if err != nil {
+		return err
+	}
+	var orders []*types.Order
+	if err := cursor.All(ctx, &orders); err != nil {
+		return err
+	}
+
+	for _, order := range orders {
+		if err := s.releaseStock(ctx, order); err != nil {
+			return err
+		}
+	}
+	return nil
 }
@@ -0,0 +1,193 @@
+package db
+
+import (
+	"context"
+	"os"
+
+	"github.com/johnson7543/ims/types"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const partyColl = "parties"
+
+type PartyStore interface {
+	GetParties(context.Context, bson.M) ([]*types.Party, error)
+	IterateParties(ctx context.Context, filter bson.M, fn func(*types.Party) error) error
+	GetParty(context.Context, primitive.ObjectID) (*types.Party, error)
+	GetPartyByTaxIdNumber(context.Context, string) (*types.Party, error)
+	InsertParty(context.Context, *types.Party) (*types.Party, error)
+	UpdateParty(ctx context.Context, id primitive.ObjectID, updatedParty *types.Party, version int64) (int64, error)
+	AddPartyRole(ctx context.Context, id primitive.ObjectID, role string) (int64, error)
+	RemovePartyRole(ctx context.Context, id primitive.ObjectID, role string, opts DeleteOptions) (int64, error)
+	DeleteParty(ctx context.Context, id primitive.ObjectID, opts DeleteOptions) (int64, error)
+	RestoreParty(ctx context.Context, id primitive.ObjectID) (int64, error)
+}
+
+type MongoPartyStore struct {
+	client *mongo.Client
+	coll   *mongo.Collection
+}
+
+func NewMongoPartyStore(client *mongo.Client) *MongoPartyStore {
+	dbname := os.Getenv(MongoDBNameEnvName)
+	return &MongoPartyStore{
+		client: client,
+		coll:   client.Database(dbname).Collection(partyColl),
+	}
+}
+
+func (s *MongoPartyStore) GetParties(ctx context.Context, filter bson.M) ([]*types.Party, error) {
+	resp, err := s.coll.Find(ctx, matchFilter(liveFilter(filter)))
+	if err != nil {
+		return nil, err
+	}
+
+	var parties []*types.Party
+	if err := resp.All(ctx, &parties); err != nil {
+		return nil, err
+	}
+
+	return parties, nil
+}
+
+func (s *MongoPartyStore) IterateParties(ctx context.Context, filter bson.M, fn func(*types.Party) error) error {
+	return iterate(ctx, s.coll, filter, fn)
+}
+
+func (s *MongoPartyStore) GetParty(ctx context.Context, id primitive.ObjectID) (*types.Party, error) {
+	var party types.Party
+	if err := s.coll.FindOne(ctx, liveID(ctx, id)).Decode(&party); err != nil {
+		return nil, err
+	}
+
+	return &party, nil
+}
+
+func (s *MongoPartyStore) GetPartyByTaxIdNumber(ctx context.Context, taxIdNumber string) (*types.Party, error) {
+	var party types.Party
+	if err := s.coll.FindOne(ctx, bson.M{"taxIdNumber": taxIdNumber, archivedField: notArchived}).Decode(&party); err != nil {
+		return nil, err
+	}
+
+	return &party, nil
+}
+
+func (s *MongoPartyStore) InsertParty(ctx context.Context, party *types.Party) (*types.Party, error) {
+	party.Version = 1
+	resp, err := s.coll.InsertOne(ctx, party)
+	if err != nil {
+		return nil, err
+	}
+	party.ID = resp.InsertedID.(primitive.ObjectID)
+
+	return party, nil
+}
+
+func (s *MongoPartyStore) UpdateParty(ctx context.Context, id primitive.ObjectID, updatedParty *types.Party, version int64) (int64, error) {
+	update := bson.M{
+		"$set": bson.M{
+			"company":     updatedParty.Company,
+			"name":        updatedParty.Name,
+			"phone":       updatedParty.Phone,
+			"address":     updatedParty.Address,
+			"taxIdNumber": updatedParty.TaxIdNumber,
+			"roles":       updatedParty.Roles,
+			"contacts":    updatedParty.Contacts,
+			"addresses":   updatedParty.Addresses,
+		},
+	}
+
+	return updateVersioned(ctx, s.coll, id, version, update)
+}
+
+func (s *MongoPartyStore) AddPartyRole(ctx context.Context, id primitive.ObjectID, role string) (int64, error) {
+	update := bson.M{"$addToSet": bson.M{"roles": role}, "$inc": bumpVersion}
+
+	updateResult, err := s.coll.UpdateOne(ctx, bson.M{"_id": id}, update)
+	if err != nil {
+		return 0, err
+	}
+
+	return updateResult.MatchedCount, nil
+}
+
+// RemovePartyRole takes a role away from a party. Documents that refer to the party in that
+// role block the removal, unless the delete cascades to them. Archiving leaves them in place.
+func (s *MongoPartyStore) RemovePartyRole(ctx context.Context, id primitive.ObjectID, role string, opts DeleteOptions) (int64, error) {
+	filter := bson.M{"_id": id, "roles": role}
+	if n, err := s.coll.CountDocuments(ctx, filter); err != nil || n == 0 {
+		return 0, err
+	}
+
+	refs := partyReferences(id, role)
+	pull := func(ctx context.Context) (int64, error) {
+		updateResult, err := s.coll.UpdateOne(ctx, filter, bson.M{"$pull": bson.M{"roles": role}, "$inc": bumpVersion})
+		if err != nil {
+			return 0, err
+		}
+		return updateResult.ModifiedCount, nil
+	}
+
+	switch opts.Mode {
+	case DeleteArchive:
+		return pull(ctx)
+
+	case DeleteCascade:
+		var modified int64
+		err := withTransaction(ctx, s.client, func(ctx context.Context) error {
+			if err := cascadeReferences(ctx, s.coll.Database(), refs, opts); err != nil {
+				return err
+			}
+			var err error
+			modified, err = pull(ctx)
+			return err
+		})
+		return modified, err
+	}
+
+	found, err := findReferences(ctx, s.coll.Database(), refs)
+	if err != nil {
+		return 0, err
+	}
+	if len(found) > 0 {
+		return 0, &ReferenceError{References: found}
+	}
+	return pull(ctx)
+}
+
+// DeleteParty archives a party no live order, processing item or material order refers to.
+func (s *MongoPartyStore) DeleteParty(ctx context.Context, id primitive.ObjectID, opts DeleteOptions) (int64, error) {
+	return archiveReferenced(ctx, s.client, s.coll, id, opts, partyReferences(id, ""))
+}
+
+func (s *MongoPartyStore) RestoreParty(ctx context.Context, id primitive.ObjectID) (int64, error) {
+	return restoreArchived(ctx, s.coll, id)
+}
+
+// partyReferences lists where a party is referred to in a role, or in any role when role is empty.
+func partyReferences(id primitive.ObjectID, role string) []reference {
+	byRole := map[string]reference{
+		types.RoleCustomer: {coll: orderColl, field: "customerId", value: id},
+		types.RoleWorker:   {coll: processingItemColl, field: "workerId", value: id},
+		// Material orders store the seller ID as a hex string.
+		types.RoleSeller: {coll: materialOrderColl, field: "sellerId", value: id.Hex()},
+	}
+
+	if role != "" {
+		if ref, ok := byRole[role]; ok {
+			return []reference{ref}
+		}
+		return nil
+	}
+
+	refs := []reference{}
+	for _, r := range types.PartyRoles {
+		if ref, ok := byRole[r]; ok {
+			refs = append(refs, ref)
+		}
+	}
+	return refs
+}
@@ -15,9 +15,12 @@ const processingItemColl = "processing_items"
 
 type ProcessingItemStore interface {
 	GetProcessingItems(context.Context, bson.M) ([]*types.ProcessingItem, error)
+	GetProcessingItem(context.Context, primitive.ObjectID) (*types.ProcessingItem, error)
+	IterateProcessingItems(ctx context.Context, filter bson.M, fn func(*types.ProcessingItem) error) error
 	InsertProcessingItem(context.Context, *types.ProcessingItem) (*types.ProcessingItem, error)
-	UpdateProcessingItem(ctx context.Context, id primitive.ObjectID, updatedProcessingItem *types.ProcessingItem) (int64, error)
-	DeleteProcessingItem(ctx context.Context, id primitive.ObjectID) (int64, error)
+	UpdateProcessingItem(ctx context.Context, id primitive.ObjectID, updatedProcessingItem *types.ProcessingItem, version int64) (int64, error)
+	DeleteProcessingItem(ctx context.Context, id primitive.ObjectID, opts DeleteOptions) (int64, error)
+	RestoreProcessingItem(ctx context.Context, id primitive.ObjectID) (int64, error)
 }
 
 type MongoProcessingItemStore struct {
@@ -43,7 +46,7 @@ func (s *MongoProcessingItemStore) GetProcessingItems(ctx context.Context, filte
 		}
 	}
 
-	resp, err := s.coll.Find(ctx, filter)
+	resp, err := s.coll.Find(ctx, liveFilter(filter))
 	if err != nil {
 		return nil, err
 	}
@@ -56,7 +59,24 @@ func (s *MongoProcessingItemStore) GetProcessingItems(ctx context.Context, filte
 	return processingItems, nil
 }
 
+func (s *MongoProcessingItemStore) IterateProcessingItems(ctx context.Context, filter bson.M, fn func(*types.ProcessingItem) error) error {
+	return iterate(ctx, s.coll, filter, fn)
+}
+
+func (s *MongoProcessingItemStore) GetProcessingItem(ctx context.Context, processingItemID primitive.ObjectID) (*types.ProcessingItem, error) {
+	filter := liveID(ctx, processingItemID)
+	var processingItem types.ProcessingItem
+
+	err := s.coll.FindOne(ctx, filter).Decode(&processingItem)
+	if err != nil {
+		return nil, err
+	}
+
+	return &processingItem, nil
+}
+
 func (s *MongoProcessingItemStore) InsertProcessingItem(ctx context.Context, processingItem *types.ProcessingItem) (*types.ProcessingItem, error) {
+	processingItem.Version = 1
 	resp, err := s.coll.InsertOne(ctx, processingItem)
 	if err != nil {
 		return nil, err
@@ -66,8 +86,7 @@ func (s *MongoProcessingItemStore) InsertProcessingItem(ctx context.Context, pro
 	return processingItem, nil
 }
 
-func (s *MongoProcessingItemStore) UpdateProcessingItem(ctx context.Context, id primitive.ObjectID, updatedProcessingItem *types.ProcessingItem) (int64, error) {
-	filter := bson.M{"_id": id}
+func (s *MongoProcessingItemStore) UpdateProcessingItem(ctx context.Context, id primitive.ObjectID, updatedProcessingItem *types.ProcessingItem, version int64) (int64, error) {
 	update := bson.M{
 		"$set": bson.M{
 			"name":       updatedProcessingItem.Name,
@@ -78,19 +97,17 @@ func (s *MongoProcessingItemStore) UpdateProcessingItem(ctx context.Context, id
 			"remarks":    updatedProcessingItem.Remarks,
 			"startDate":  updatedProcessingItem.StartDate,
 			"endDate":    updatedProcessingItem.EndDate,
-			"SKU":        updatedProcessingItem.SKU,
+			"sku":        updatedProcessingItem.SKU,
 		},
 	}
 
-	updateResult, err := s.coll.UpdateOne(ctx, filter, update)
-	if err != nil {
-		return 0, err
-	}
+	return updateVersioned(ctx, s.coll, id, version, update)
+}
 
-	return updateResult.ModifiedCount, nil
+func (s *MongoProcessingItemStore) DeleteProcessingItem(ctx context.Context, id primitive.ObjectID, opts DeleteOptions) (int64, error) {
+	return archiveReferenced(ctx, s.client, s.coll, id, opts, nil)
 }
 
-func (s *MongoProcessingItemStore) DeleteProcessingItem(ctx context.Context, id primitive.ObjectID) (int64, error) {
-	deleteResult, err := s.coll.DeleteOne(ctx, bson.M{"_id": id})
-	return deleteResult.DeletedCount, err
+func (s *MongoProcessingItemStore) RestoreProcessingItem(ctx context.Context, id primitive.ObjectID) (int64, error) {
+	return restoreArchived(ctx, s.coll, id)
 }
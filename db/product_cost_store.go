@@ -0,0 +1,81 @@
+package db
+
+import (
+	"context"
+	"os"
+
+	"github.com/johnson7543/ims/types"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const productCostColl = "product_costs"
+
+type ProductCostStore interface {
+	GetProductCosts(context.Context, bson.M) ([]*types.ProductCost, error)
+	GetProductCostBySKU(ctx context.Context, sku string) (*types.ProductCost, error)
+	UpsertProductCost(context.Context, *types.ProductCost) (*types.ProductCost, error)
+}
+
+type MongoProductCostStore struct {
+	client *mongo.Client
+	coll   *mongo.Collection
+}
+
+func NewMongoProductCostStore(client *mongo.Client) *MongoProductCostStore {
+	dbName := os.Getenv(MongoDBNameEnvName)
+	return &MongoProductCostStore{
+		client: client,
+		coll:   client.Database(dbName).Collection(productCostColl),
+	}
+}
+
+func (s *MongoProductCostStore) GetProductCosts(ctx context.Context, filter bson.M) ([]*types.ProductCost, error) {
+	resp, err := s.coll.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	var costs []*types.ProductCost
+	if err := resp.All(ctx, &costs); err != nil {
+		return nil, err
+	}
+
+	return costs, nil
+}
+
+func (s *MongoProductCostStore) GetProductCostBySKU(ctx context.Context, sku string) (*types.ProductCost, error) {
+	var cost types.ProductCost
+	if err := s.coll.FindOne(ctx, bson.M{"sku": sku}).Decode(&cost); err != nil {
+		return nil, err
+	}
+
+	return &cost, nil
+}
+
+// UpsertProductCost replaces the stored cost for the SKU, creating it on first roll-up.
+func (s *MongoProductCostStore) UpsertProductCost(ctx context.Context, cost *types.ProductCost) (*types.ProductCost, error) {
+	filter := bson.M{"sku": cost.SKU}
+	update := bson.M{
+		"$set": bson.M{
+			"productId":     cost.ProductID,
+			"productName":   cost.ProductName,
+			"materialCost":  cost.MaterialCost,
+			"laborCost":     cost.LaborCost,
+			"unitCost":      cost.UnitCost,
+			"materialLines": cost.MaterialLines,
+			"calculatedAt":  cost.CalculatedAt,
+			"warnings":      cost.Warnings,
+		},
+	}
+
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	var updated types.ProductCost
+	if err := s.coll.FindOneAndUpdate(ctx, filter, update, opts).Decode(&updated); err != nil {
+		return nil, err
+	}
+
+	return &updated, nil
+}
@@ -0,0 +1,108 @@
+package db
+
+import (
+	"context"
+	"os"
+
+	"github.com/johnson7543/ims/types"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const productParentColl = "product_parents"
+
+type ProductParentStore interface {
+	GetProductParents(context.Context, bson.M) ([]*types.ProductParent, error)
+	GetProductParent(context.Context, primitive.ObjectID) (*types.ProductParent, error)
+	IterateProductParents(ctx context.Context, filter bson.M, fn func(*types.ProductParent) error) error
+	InsertProductParent(context.Context, *types.ProductParent) (*types.ProductParent, error)
+	UpdateProductParent(ctx context.Context, id primitive.ObjectID, parent *types.ProductParent, version int64) (int64, error)
+	DeleteProductParent(ctx context.Context, id primitive.ObjectID, opts DeleteOptions) (int64, error)
+	RestoreProductParent(ctx context.Context, id primitive.ObjectID) (int64, error)
+}
+
+type MongoProductParentStore struct {
+	client *mongo.Client
+	coll   *mongo.Collection
+}
+
+func NewMongoProductParentStore(client *mongo.Client) *MongoProductParentStore {
+	dbName := os.Getenv(MongoDBNameEnvName)
+	return &MongoProductParentStore{
+		client: client,
+		coll:   client.Database(dbName).Collection(productParentColl),
+	}
+}
+
+func (s *MongoProductParentStore) GetProductParents(ctx context.Context, filter bson.M) ([]*types.ProductParent, error) {
+	resp, err := s.coll.Find(ctx, matchFilter(liveFilter(filter)))
+	if err != nil {
+		return nil, err
+	}
+
+	var parents []*types.ProductParent
+	if err := resp.All(ctx, &parents); err != nil {
+		return nil, err
+	}
+
+	return parents, nil
+}
+
+func (s *MongoProductParentStore) IterateProductParents(ctx context.Context, filter bson.M, fn func(*types.ProductParent) error) error {
+	return iterate(ctx, s.coll, filter, fn)
+}
+
+func (s *MongoProductParentStore) GetProductParent(ctx context.Context, id primitive.ObjectID) (*types.ProductParent, error) {
+	var parent types.ProductParent
+	if err := s.coll.FindOne(ctx, liveID(ctx, id)).Decode(&parent); err != nil {
+		return nil, err
+	}
+
+	return &parent, nil
+}
+
+func (s *MongoProductParentStore) InsertProductParent(ctx context.Context, parent *types.ProductParent) (*types.ProductParent, error) {
+	parent.Version = 1
+	resp, err := s.coll.InsertOne(ctx, parent)
+	if err != nil {
+		return nil, err
+	}
+	parent.ID = resp.InsertedID.(primitive.ObjectID)
+
+	return parent, nil
+}
+
+func (s *MongoProductParentStore) UpdateProductParent(ctx context.Context, id primitive.ObjectID, parent *types.ProductParent, version int64) (int64, error) {
+	update := bson.M{
+		"$set": bson.M{
+			"code":        parent.Code,
+			"name":        parent.Name,
+			"type":        parent.Type,
+			"colors":      parent.Colors,
+			"sizes":       parent.Sizes,
+			"materials":   parent.Materials,
+			"skuTemplate": parent.SKUTemplate,
+			"price":       parent.Price,
+			"remark":      parent.Remark,
+		},
+	}
+
+	return updateVersioned(ctx, s.coll, id, version, update)
+}
+
+// DeleteProductParent archives a parent product none of whose variants is live.
+func (s *MongoProductParentStore) DeleteProductParent(ctx context.Context, id primitive.ObjectID, opts DeleteOptions) (int64, error) {
+	return archiveReferenced(ctx, s.client, s.coll, id, opts, productParentReferences(id))
+}
+
+func (s *MongoProductParentStore) RestoreProductParent(ctx context.Context, id primitive.ObjectID) (int64, error) {
+	return restoreArchived(ctx, s.coll, id)
+}
+
+func productParentReferences(id primitive.ObjectID) []reference {
+	return []reference{
+		{coll: productColl, field: "parentId", value: id},
+	}
+}
@@ -15,12 +15,16 @@ const productColl = "products"
 
 type ProductStore interface {
 	GetProducts(context.Context, bson.M) ([]*types.Product, error)
+	GetProduct(context.Context, primitive.ObjectID) (*types.Product, error)
+	IterateProducts(ctx context.Context, filter bson.M, fn func(*types.Product) error) error
 	InsertProduct(context.Context, *types.Product) (*types.Product, error)
-	UpdateProduct(ctx context.Context, productID primitive.ObjectID, updatedProduct *types.Product) (int64, error)
-	DeleteProduct(ctx context.Context, id primitive.ObjectID) (int64, error)
-	GetProductColors(context.Context, string) ([]string, error)
-	GetProductTypes(context.Context) ([]string, error)
-	GetProductSizes(context.Context, string) ([]string, error)
+	UpdateProduct(ctx context.Context, productID primitive.ObjectID, updatedProduct *types.Product, version int64) (int64, error)
+	DeleteProduct(ctx context.Context, id primitive.ObjectID, opts DeleteOptions) (int64, error)
+	RestoreProduct(ctx context.Context, id primitive.ObjectID) (int64, error)
+	GetProductColors(ctx context.Context, productType string, parentID primitive.ObjectID) ([]string, error)
+	GetProductTypes(ctx context.Context, parentID primitive.ObjectID) ([]string, error)
+	GetProductSizes(ctx context.Context, productType string, parentID primitive.ObjectID) ([]string, error)
+	NextSKUSequence(ctx context.Context, scope string) (int64, error)
 	CheckExistedSKU(ctx context.Context, sku string) (bool, error)
 	CheckDuplicateSKU(ctx context.Context, sku string, productID primitive.ObjectID) (bool, error)
 	DecreaseProductQuantity(ctx context.Context, productID primitive.ObjectID, quantity int) (int64, error)
@@ -50,7 +54,7 @@ func (s *MongoProductStore) GetProducts(ctx context.Context, filter bson.M) ([]*
 		}
 	}
 
-	resp, err := s.coll.Find(ctx, filter)
+	resp, err := s.coll.Find(ctx, liveFilter(filter))
 	if err != nil {
 		return nil, err
 	}
@@ -59,11 +63,40 @@ func (s *MongoProductStore) GetProducts(ctx context.Context, filter bson.M) ([]*
 	if err := resp.All(ctx, &products); err != nil {
 		return nil, err
 	}
+	for _, product := range products {
+		setAvailable(product)
+	}
 
 	return products, nil
 }
 
+func (s *MongoProductStore) IterateProducts(ctx context.Context, filter bson.M, fn func(*types.Product) error) error {
+	return iterate(ctx, s.coll, filter, func(product *types.Product) error {
+		setAvailable(product)
+		return fn(product)
+	})
+}
+
+func (s *MongoProductStore) GetProduct(ctx context.Context, productID primitive.ObjectID) (*types.Product, error) {
+	filter := liveID(ctx, productID)
+	var product types.Product
+
+	err := s.coll.FindOne(ctx, filter).Decode(&product)
+	if err != nil {
+		return nil, err
+	}
+	setAvailable(&product)
+
+	return &product, nil
+}
+
+// setAvailable works out how much of the product is not reserved for orders.
+func setAvailable(product *types.Product) {
+	product.Available = product.Quantity - product.Reserved
+}
+
 func (s *MongoProductStore) InsertProduct(ctx context.Context, product *types.Product) (*types.Product, error) {
+	product.Version = 1
 	resp, err := s.coll.InsertOne(ctx, product)
 	if err != nil {
 		return nil, err
@@ -73,43 +106,70 @@ func (s *MongoProductStore) InsertProduct(ctx context.Context, product *types.Pr
 	return product, nil
 }
 
-func (s *MongoProductStore) UpdateProduct(ctx context.Context, productID primitive.ObjectID, updatedProduct *types.Product) (int64, error) {
-	filter := bson.M{"_id": productID}
+// UpdateProduct replaces the product's fields apart from its stock, which only changes through
+// stock movements, orders and shipments.
+func (s *MongoProductStore) UpdateProduct(ctx context.Context, productID primitive.ObjectID, updatedProduct *types.Product, version int64) (int64, error) {
 	update := bson.M{
 		"$set": bson.M{
-			"sku":      updatedProduct.SKU,
-			"name":     updatedProduct.Name,
-			"material": updatedProduct.Material,
-			"color":    updatedProduct.Color,
-			"type":     updatedProduct.Type,
-			"size":     updatedProduct.Size,
-			"quantity": updatedProduct.Quantity,
-			"price":    updatedProduct.Price,
-			"date":     updatedProduct.Date,
-			"remark":   updatedProduct.Remark,
+			"sku":           updatedProduct.SKU,
+			"parentId":      updatedProduct.ParentID,
+			"name":          updatedProduct.Name,
+			"material":      updatedProduct.Material,
+			"color":         updatedProduct.Color,
+			"type":          updatedProduct.Type,
+			"size":          updatedProduct.Size,
+			"price":         updatedProduct.Price,
+			"date":          updatedProduct.Date,
+			"remark":        updatedProduct.Remark,
+			"materialUsage": updatedProduct.MaterialUsage,
 		},
 	}
 
-	updateResult, err := s.coll.UpdateOne(ctx, filter, update)
-	if err != nil {
+	return updateVersioned(ctx, s.coll, productID, version, update)
+}
+
+// DeleteProduct archives a product that no live order or processing item refers to.
+func (s *MongoProductStore) DeleteProduct(ctx context.Context, id primitive.ObjectID, opts DeleteOptions) (int64, error) {
+	var product types.Product
+	if err := s.coll.FindOne(ctx, bson.M{"_id": id}).Decode(&product); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return 0, nil
+		}
 		return 0, err
 	}
 
-	return updateResult.ModifiedCount, nil
+	return archiveReferenced(ctx, s.client, s.coll, id, opts, productReferences(id, product.SKU))
 }
 
-func (s *MongoProductStore) DeleteProduct(ctx context.Context, id primitive.ObjectID) (int64, error) {
-	deleteResult, err := s.coll.DeleteOne(ctx, bson.M{"_id": id})
-	return deleteResult.DeletedCount, err
+func (s *MongoProductStore) RestoreProduct(ctx context.Context, id primitive.ObjectID) (int64, error) {
+	return restoreArchived(ctx, s.coll, id)
 }
 
-func (s *MongoProductStore) GetProductColors(ctx context.Context, productType string) ([]string, error) {
+func productReferences(id primitive.ObjectID, sku string) []reference {
+	refs := []reference{
+		{coll: orderColl, field: "orderItems.product._id", value: id},
+	}
+	if sku != "" {
+		refs = append(refs, reference{coll: processingItemColl, field: "sku", value: sku})
+	}
+	return refs
+}
+
+// attributeFilter narrows the distinct attribute queries to a product type and to the variants
+// of a parent product; empty values match every product.
+func attributeFilter(productType string, parentID primitive.ObjectID) bson.M {
 	filter := bson.M{}
 	if productType != "" {
 		filter["type"] = productType
 	}
+	if !parentID.IsZero() {
+		filter["parentId"] = parentID
+	}
+	return liveFilter(filter)
+}
 
-	colors, err := s.coll.Distinct(ctx, "color", filter)
+func (s *MongoProductStore) GetProductColors(ctx context.Context, productType string, parentID primitive.ObjectID) ([]string, error) {
+	colors, err := s.coll.Distinct(ctx, "color", attributeFilter(productType, parentID))
 	if err != nil {
 		return nil, err
 	}
@@ -124,8 +184,8 @@ func (s *MongoProductStore) GetProductColors(ctx context.Context, productType st
 	return colorStrings, nil
 }
 
-func (s *MongoProductStore) GetProductTypes(ctx context.Context) ([]string, error) {
-	types, err := s.coll.Distinct(ctx, "type", bson.M{})
+func (s *MongoProductStore) GetProductTypes(ctx context.Context, parentID primitive.ObjectID) ([]string, error) {
+	types, err := s.coll.Distinct(ctx, "type", attributeFilter("", parentID))
 	if err != nil {
 		return nil, err
 	}
@@ -140,13 +200,8 @@ func (s *MongoProductStore) GetProductTypes(ctx context.Context) ([]string, erro
 	return typeStrings, nil
 }
 
-func (s *MongoProductStore) GetProductSizes(ctx context.Context, productType string) ([]string, error) {
-	filter := bson.M{}
-	if productType != "" {
-		filter["type"] = productType
-	}
-
-	sizes, err := s.coll.Distinct(ctx, "size", filter)
+func (s *MongoProductStore) GetProductSizes(ctx context.Context, productType string, parentID primitive.ObjectID) ([]string, error) {
+	sizes, err := s.coll.Distinct(ctx, "size", attributeFilter(productType, parentID))
 	if err != nil {
 		return nil, err
 	}
@@ -174,6 +229,11 @@ func (s *MongoProductStore) CheckExistedSKU(ctx context.Context, sku string) (bo
 	return count > 0, nil
 }
 
+// NextSKUSequence returns the next sequence number for the SKUs generated in scope.
+func (s *MongoProductStore) NextSKUSequence(ctx context.Context, scope string) (int64, error) {
+	return nextSequence(ctx, s.coll.Database(), "sku:"+scope)
+}
+
 func (s *MongoProductStore) CheckDuplicateSKU(ctx context.Context, sku string, productID primitive.ObjectID) (bool, error) {
 	filter := bson.M{
 		"_id": bson.M{"$ne": productID}, // Exclude the current product
@@ -188,31 +248,11 @@ func (s *MongoProductStore) CheckDuplicateSKU(ctx context.Context, sku string, p
 	return count > 0, nil
 }
 
+// DecreaseProductQuantity takes quantity from the unreserved stock not placed at a location, returning ErrInsufficientStock when there is not enough.
 func (s *MongoProductStore) DecreaseProductQuantity(ctx context.Context, productID primitive.ObjectID, quantity int) (int64, error) {
-	filter := bson.M{"_id": productID}
-
-	update := bson.M{
-		"$inc": bson.M{"quantity": -quantity},
-	}
-
-	updateResult, err := s.coll.UpdateOne(ctx, filter, update)
-	if err != nil {
-		return 0, err
-	}
-
-	return updateResult.ModifiedCount, nil
+	return decreaseUnassigned(ctx, s.coll, types.StockProduct, productID, quantity)
 }
 
 func (s *MongoProductStore) IncreaseProductQuantity(ctx context.Context, productID primitive.ObjectID, quantity int) (int64, error) {
-	filter := bson.M{"_id": productID}
-	update := bson.M{
-		"$inc": bson.M{"quantity": quantity},
-	}
-
-	updateResult, err := s.coll.UpdateOne(ctx, filter, update)
-	if err != nil {
-		return 0, err
-	}
-
-	return updateResult.ModifiedCount, nil
+	return increaseQuantity(ctx, s.coll, productID, quantity)
 }
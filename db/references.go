@@ -0,0 +1,222 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/johnson7543/ims/types"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// DeleteMode decides what happens to a record that live documents still refer to.
+type DeleteMode string
+
+const (
+	// DeleteRestrict refuses to delete a referenced record with a *ReferenceError.
+	DeleteRestrict DeleteMode = ""
+	// DeleteCascade archives the referencing documents along with the record.
+	DeleteCascade DeleteMode = "cascade"
+	// DeleteArchive archives the record even though documents still refer to it.
+	DeleteArchive DeleteMode = "archive"
+)
+
+// DeleteOptions control a delete. Deletes are soft: the record stays in its collection marked
+// with deletedAt and deletedBy until it is restored or purged.
+type DeleteOptions struct {
+	Mode DeleteMode
+	By   primitive.ObjectID
+}
+
+func (o DeleteOptions) archiveUpdate() bson.M {
+	return bson.M{
+		"$set": bson.M{
+			archivedField: time.Now(),
+			"deletedBy":   o.By,
+		},
+		"$inc": bumpVersion,
+	}
+}
+
+func ParseDeleteMode(s string) (DeleteMode, error) {
+	switch mode := DeleteMode(strings.ToLower(s)); mode {
+	case DeleteRestrict, DeleteCascade, DeleteArchive:
+		return mode, nil
+	}
+	return DeleteRestrict, fmt.Errorf("invalid delete mode %q, expected cascade or archive", s)
+}
+
+// ReferenceError is returned when a record cannot be deleted because other documents refer to it.
+type ReferenceError struct {
+	References []*types.DocumentReferences
+}
+
+func (e *ReferenceError) Error() string {
+	parts := make([]string, len(e.References))
+	for i, ref := range e.References {
+		parts[i] = fmt.Sprintf("%d %s", ref.Count, ref.Collection)
+	}
+	return "record is still referenced by " + strings.Join(parts, ", ")
+}
+
+// referenceListLimit caps the IDs listed per collection in a ReferenceError.
+const referenceListLimit = 20
+
+// reference describes the documents of a collection that point at a record.
+type reference struct {
+	coll  string
+	field string
+	value any
+	// pull, when set, makes a cascade remove the matching array elements instead of
+	// deleting the whole referencing document.
+	pull bson.M
+	// kept documents record what happened to the record, like the shipments of an order. A
+	// cascade is refused while there are any instead of archiving them.
+	kept bool
+}
+
+// filter matches the live documents holding the reference; archived ones never block a delete.
+func (r reference) filter() bson.M {
+	return bson.M{r.field: r.value, archivedField: notArchived}
+}
+
+// findReferences counts the documents matching each reference.
+func findReferences(ctx context.Context, database *mongo.Database, refs []reference) ([]*types.DocumentReferences, error) {
+	found := []*types.DocumentReferences{}
+	for _, ref := range refs {
+		coll := database.Collection(ref.coll)
+		count, err := coll.CountDocuments(ctx, ref.filter())
+		if err != nil {
+			return nil, err
+		}
+		if count == 0 {
+			continue
+		}
+
+		opts := options.Find().SetProjection(bson.M{"_id": 1}).SetLimit(referenceListLimit)
+		cursor, err := coll.Find(ctx, ref.filter(), opts)
+		if err != nil {
+			return nil, err
+		}
+		var docs []struct {
+			ID primitive.ObjectID `bson:"_id"`
+		}
+		if err := cursor.All(ctx, &docs); err != nil {
+			return nil, err
+		}
+
+		ids := make([]primitive.ObjectID, len(docs))
+		for i, doc := range docs {
+			ids[i] = doc.ID
+		}
+		found = append(found, &types.DocumentReferences{
+			Collection: ref.coll,
+			Field:      ref.field,
+			Count:      count,
+			IDs:        ids,
+		})
+	}
+	return found, nil
+}
+
+func cascadeReferences(ctx context.Context, database *mongo.Database, refs []reference, opts DeleteOptions) error {
+	for _, ref := range refs {
+		coll := database.Collection(ref.coll)
+		var err error
+		switch {
+		case ref.kept:
+			continue
+		case ref.pull != nil:
+			_, err = coll.UpdateMany(ctx, ref.filter(), bson.M{"$pull": ref.pull, "$inc": bumpVersion})
+		case ref.coll == orderColl:
+			// Archived orders hold no stock, so open ones give theirs back first.
+			if err = releaseOrderStock(ctx, database.Client(), ref.filter()); err == nil {
+				_, err = coll.UpdateMany(ctx, ref.filter(), opts.archiveUpdate())
+			}
+		default:
+			_, err = coll.UpdateMany(ctx, ref.filter(), opts.archiveUpdate())
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// archiveReferenced archives the record with id in coll, handling the documents that refer
+// to it according to the delete mode.
+func archiveReferenced(ctx context.Context, client *mongo.Client, coll *mongo.Collection, id primitive.ObjectID, opts DeleteOptions, refs []reference) (int64, error) {
+	if opts.Mode != DeleteCascade {
+		return archiveRecord(ctx, coll, id, opts, refs)
+	}
+
+	var archived int64
+	err := withTransaction(ctx, client, func(ctx context.Context) error {
+		var err error
+		archived, err = archiveRecord(ctx, coll, id, opts, refs)
+		return err
+	})
+	return archived, err
+}
+
+// archiveRecord is archiveReferenced for callers that already run in a transaction.
+func archiveRecord(ctx context.Context, coll *mongo.Collection, id primitive.ObjectID, opts DeleteOptions, refs []reference) (int64, error) {
+	archive := func(ctx context.Context) (int64, error) {
+		filter := bson.M{"_id": id, archivedField: notArchived}
+		res, err := coll.UpdateOne(ctx, filter, opts.archiveUpdate())
+		if err != nil {
+			return 0, err
+		}
+		return res.ModifiedCount, nil
+	}
+
+	switch opts.Mode {
+	case DeleteArchive:
+		return archive(ctx)
+
+	case DeleteCascade:
+		var kept []reference
+		for _, ref := range refs {
+			if ref.kept {
+				kept = append(kept, ref)
+			}
+		}
+		found, err := findReferences(ctx, coll.Database(), kept)
+		if err != nil {
+			return 0, err
+		}
+		if len(found) > 0 {
+			return 0, &ReferenceError{References: found}
+		}
+		if err := cascadeReferences(ctx, coll.Database(), refs, opts); err != nil {
+			return 0, err
+		}
+		return archive(ctx)
+	}
+
+	found, err := findReferences(ctx, coll.Database(), refs)
+	if err != nil {
+		return 0, err
+	}
+	if len(found) > 0 {
+		return 0, &ReferenceError{References: found}
+	}
+	return archive(ctx)
+}
+
+// restoreArchived brings an archived document back.
+func restoreArchived(ctx context.Context, coll *mongo.Collection, id primitive.ObjectID) (int64, error) {
+	filter := bson.M{"_id": id, archivedField: bson.M{"$exists": true}}
+	update := bson.M{"$unset": bson.M{archivedField: "", "deletedBy": ""}, "$inc": bumpVersion}
+
+	res, err := coll.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return 0, err
+	}
+	return res.ModifiedCount, nil
+}
@@ -0,0 +1,220 @@
+package db
+
+import (
+	"context"
+	"os"
+
+	"github.com/johnson7543/ims/types"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type ReportStore interface {
+	GetSalesByCustomer(context.Context, types.ReportRange) ([]*types.SalesByCustomer, error)
+	GetSalesByProduct(context.Context, types.ReportRange) ([]*types.SalesByProduct, error)
+	GetSalesByMonth(context.Context, types.ReportRange) ([]*types.MonthlyTotal, error)
+	GetTopSellingProducts(ctx context.Context, rng types.ReportRange, sortBy string, limit int64) ([]*types.SalesByProduct, error)
+	GetOrderCountsByStatus(context.Context, types.ReportRange) ([]*types.StatusCount, error)
+	GetPurchasesBySeller(context.Context, types.ReportRange) ([]*types.PurchasesBySeller, error)
+	GetPurchasesByMaterial(context.Context, types.ReportRange) ([]*types.PurchasesByMaterial, error)
+	GetPurchasesByMonth(context.Context, types.ReportRange) ([]*types.MonthlyTotal, error)
+	GetMaterialOrderCountsByStatus(context.Context, types.ReportRange) ([]*types.StatusCount, error)
+}
+
+type MongoReportStore struct {
+	client            *mongo.Client
+	orderColl         *mongo.Collection
+	materialOrderColl *mongo.Collection
+}
+
+func NewMongoReportStore(client *mongo.Client) *MongoReportStore {
+	dbname := os.Getenv(MongoDBNameEnvName)
+	return &MongoReportStore{
+		client:            client,
+		orderColl:         client.Database(dbname).Collection(orderColl),
+		materialOrderColl: client.Database(dbname).Collection(materialOrderColl),
+	}
+}
+
+// notCanceled excludes canceled documents, which never count towards sales or purchases.
+var notCanceled = bson.M{"$not": primitive.Regex{Pattern: "^canceled$", Options: "i"}}
+
+// matchStage restricts a pipeline to live, non-canceled documents whose orderDate falls within the range.
+func matchStage(rng types.ReportRange, excludeCanceled bool) bson.D {
+	match := bson.M{archivedField: notArchived}
+	if excludeCanceled {
+		match["status"] = notCanceled
+	}
+
+	date := bson.M{}
+	if !rng.From.IsZero() {
+		date["$gte"] = rng.From
+	}
+	if !rng.To.IsZero() {
+		date["$lte"] = rng.To
+	}
+	if len(date) > 0 {
+		match["orderDate"] = date
+	}
+
+	return bson.D{{Key: "$match", Value: match}}
+}
+
+func monthKey(rng types.ReportRange) bson.M {
+	format := bson.M{"format": "%Y-%m", "date": "$orderDate"}
+	if rng.Timezone != "" {
+		format["timezone"] = rng.Timezone
+	}
+	return bson.M{"$dateToString": format}
+}
+
+func aggregate[T any](ctx context.Context, coll *mongo.Collection, pipeline mongo.Pipeline) ([]*T, error) {
+	cursor, err := coll.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+
+	results := []*T{}
+	if err := cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// oldestFirst orders the documents by date ahead of a $group, so the names it takes with $last
+// are the ones of the latest order rather than whichever document came last.
+var oldestFirst = bson.D{{Key: "$sort", Value: bson.D{{Key: "orderDate", Value: 1}, {Key: "_id", Value: 1}}}}
+
+func (s *MongoReportStore) GetSalesByCustomer(ctx context.Context, rng types.ReportRange) ([]*types.SalesByCustomer, error) {
+	pipeline := mongo.Pipeline{
+		matchStage(rng, true),
+		oldestFirst,
+		{{Key: "$group", Value: bson.M{
+			"_id":          "$customerId",
+			"customerName": bson.M{"$last": "$customerName"},
+			"orderCount":   bson.M{"$sum": 1},
+			"totalAmount":  bson.M{"$sum": "$totalAmount"},
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "totalAmount", Value: -1}}}},
+	}
+
+	return aggregate[types.SalesByCustomer](ctx, s.orderColl, pipeline)
+}
+
+func (s *MongoReportStore) salesByProductPipeline(rng types.ReportRange, sortBy string) mongo.Pipeline {
+	return mongo.Pipeline{
+		matchStage(rng, true),
+		oldestFirst,
+		{{Key: "$unwind", Value: "$orderItems"}},
+		{{Key: "$group", Value: bson.M{
+			"_id":         "$orderItems.product._id",
+			"sku":         bson.M{"$last": "$orderItems.product.sku"},
+			"productName": bson.M{"$last": "$orderItems.product.name"},
+			"quantity":    bson.M{"$sum": "$orderItems.quantity"},
+			"totalAmount": bson.M{"$sum": "$orderItems.totalPrice"},
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: sortBy, Value: -1}}}},
+	}
+}
+
+func (s *MongoReportStore) GetSalesByProduct(ctx context.Context, rng types.ReportRange) ([]*types.SalesByProduct, error) {
+	return aggregate[types.SalesByProduct](ctx, s.orderColl, s.salesByProductPipeline(rng, "totalAmount"))
+}
+
+// lineBaseQuantity is the quantity of a material order line in the material's base unit. Lines
+// written before units were recorded have no base quantity and count in the base unit.
+var lineBaseQuantity = bson.M{"$cond": bson.A{
+	bson.M{"$gt": bson.A{"$materialOrderItems.baseQuantity", 0}},
+	"$materialOrderItems.baseQuantity",
+	"$materialOrderItems.quantity",
+}}
+
+// GetTopSellingProducts ranks products by "quantity" or "totalAmount" and keeps the first limit rows.
+func (s *MongoReportStore) GetTopSellingProducts(ctx context.Context, rng types.ReportRange, sortBy string, limit int64) ([]*types.SalesByProduct, error) {
+	pipeline := append(s.salesByProductPipeline(rng, sortBy), bson.D{{Key: "$limit", Value: limit}})
+	return aggregate[types.SalesByProduct](ctx, s.orderColl, pipeline)
+}
+
+func (s *MongoReportStore) GetSalesByMonth(ctx context.Context, rng types.ReportRange) ([]*types.MonthlyTotal, error) {
+	pipeline := mongo.Pipeline{
+		matchStage(rng, true),
+		{{Key: "$group", Value: bson.M{
+			"_id":         monthKey(rng),
+			"orderCount":  bson.M{"$sum": 1},
+			"totalAmount": bson.M{"$sum": "$totalAmount"},
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "_id", Value: 1}}}},
+	}
+
+	return aggregate[types.MonthlyTotal](ctx, s.orderColl, pipeline)
+}
+
+func statusCountPipeline(rng types.ReportRange) mongo.Pipeline {
+	return mongo.Pipeline{
+		matchStage(rng, false),
+		{{Key: "$group", Value: bson.M{
+			"_id":         bson.M{"$toLower": "$status"},
+			"count":       bson.M{"$sum": 1},
+			"totalAmount": bson.M{"$sum": "$totalAmount"},
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "_id", Value: 1}}}},
+	}
+}
+
+func (s *MongoReportStore) GetOrderCountsByStatus(ctx context.Context, rng types.ReportRange) ([]*types.StatusCount, error) {
+	return aggregate[types.StatusCount](ctx, s.orderColl, statusCountPipeline(rng))
+}
+
+func (s *MongoReportStore) GetPurchasesBySeller(ctx context.Context, rng types.ReportRange) ([]*types.PurchasesBySeller, error) {
+	pipeline := mongo.Pipeline{
+		matchStage(rng, true),
+		oldestFirst,
+		{{Key: "$group", Value: bson.M{
+			"_id":         "$sellerId",
+			"sellerName":  bson.M{"$last": "$sellerName"},
+			"orderCount":  bson.M{"$sum": 1},
+			"totalAmount": bson.M{"$sum": "$totalAmount"},
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "totalAmount", Value: -1}}}},
+	}
+
+	return aggregate[types.PurchasesBySeller](ctx, s.materialOrderColl, pipeline)
+}
+
+func (s *MongoReportStore) GetPurchasesByMaterial(ctx context.Context, rng types.ReportRange) ([]*types.PurchasesByMaterial, error) {
+	pipeline := mongo.Pipeline{
+		matchStage(rng, true),
+		oldestFirst,
+		{{Key: "$unwind", Value: "$materialOrderItems"}},
+		{{Key: "$group", Value: bson.M{
+			"_id":          "$materialOrderItems.material._id",
+			"materialName": bson.M{"$last": "$materialOrderItems.material.name"},
+			"quantity":     bson.M{"$sum": lineBaseQuantity},
+			"totalAmount":  bson.M{"$sum": "$materialOrderItems.totalPrice"},
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "totalAmount", Value: -1}}}},
+	}
+
+	return aggregate[types.PurchasesByMaterial](ctx, s.materialOrderColl, pipeline)
+}
+
+func (s *MongoReportStore) GetPurchasesByMonth(ctx context.Context, rng types.ReportRange) ([]*types.MonthlyTotal, error) {
+	pipeline := mongo.Pipeline{
+		matchStage(rng, true),
+		{{Key: "$group", Value: bson.M{
+			"_id":         monthKey(rng),
+			"orderCount":  bson.M{"$sum": 1},
+			"totalAmount": bson.M{"$sum": "$totalAmount"},
+		}}},
+		{{Key: "$sort", Value: bson.D{{Key: "_id", Value: 1}}}},
+	}
+
+	return aggregate[types.MonthlyTotal](ctx, s.materialOrderColl, pipeline)
+}
+
+func (s *MongoReportStore) GetMaterialOrderCountsByStatus(ctx context.Context, rng types.ReportRange) ([]*types.StatusCount, error) {
+	return aggregate[types.StatusCount](ctx, s.materialOrderColl, statusCountPipeline(rng))
+}
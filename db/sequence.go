@@ -0,0 +1,24 @@
+package db
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const counterColl = "counters"
+
+// nextSequence atomically increments the counter called name and returns its new value,
+// starting at 1.
+func nextSequence(ctx context.Context, database *mongo.Database, name string) (int64, error) {
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	var counter struct {
+		Seq int64 `bson:"seq"`
+	}
+	err := database.Collection(counterColl).
+		FindOneAndUpdate(ctx, bson.M{"_id": name}, bson.M{"$inc": bson.M{"seq": 1}}, opts).
+		Decode(&counter)
+	return counter.Seq, err
+}
@@ -0,0 +1,187 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/johnson7543/ims/types"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const shipmentColl = "shipments"
+
+var (
+	// ErrOrderCanceled is returned when shipping a canceled order.
+	ErrOrderCanceled = errors.New("order is canceled")
+	// ErrOverShipment is returned when a shipment carries more of a line than is left to ship.
+	ErrOverShipment = errors.New("shipment exceeds what is left to ship")
+)
+
+// ShipmentStore keeps the shipments of orders. Recording a shipment takes the stock reserved
+// for its lines and moves the order's status along.
+type ShipmentStore interface {
+	GetShipments(ctx context.Context, filter bson.M) ([]*types.Shipment, error)
+	GetShipment(context.Context, primitive.ObjectID) (*types.Shipment, error)
+	InsertShipment(context.Context, *types.Shipment) (*types.Shipment, error)
+	UpdateShipment(ctx context.Context, id primitive.ObjectID, shipment *types.Shipment, version int64) (int64, error)
+}
+
+type MongoShipmentStore struct {
+	client   *mongo.Client
+	database *mongo.Database
+	coll     *mongo.Collection
+	orders   *mongo.Collection
+	stock    *MongoStockStore
+}
+
+func NewMongoShipmentStore(client *mongo.Client) *MongoShipmentStore {
+	dbName := os.Getenv(MongoDBNameEnvName)
+	database := client.Database(dbName)
+	return &MongoShipmentStore{
+		client:   client,
+		database: database,
+		coll:     database.Collection(shipmentColl),
+		orders:   database.Collection(orderColl),
+		stock:    NewMongoStockStore(client),
+	}
+}
+
+// GetShipments returns the shipments matching filter, latest first. Unlike the other list
+// queries the filter values match exactly.
+func (s *MongoShipmentStore) GetShipments(ctx context.Context, filter bson.M) ([]*types.Shipment, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "shipDate", Value: -1}, {Key: "_id", Value: -1}})
+	resp, err := s.coll.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	shipments := []*types.Shipment{}
+	if err := resp.All(ctx, &shipments); err != nil {
+		return nil, err
+	}
+
+	return shipments, nil
+}
+
+func (s *MongoShipmentStore) GetShipment(ctx context.Context, id primitive.ObjectID) (*types.Shipment, error) {
+	var shipment types.Shipment
+	if err := s.coll.FindOne(ctx, bson.M{"_id": id}).Decode(&shipment); err != nil {
+		return nil, err
+	}
+
+	return &shipment, nil
+}
+
+// InsertShipment records a shipment of the order's lines, all or nothing: it takes the stock
+// reserved for them, adds them to what the order has shipped and sets the order to partially
+// shipped or shipped. The customer, address, location and product details are copied from the
+// order. It returns mongo.ErrNoDocuments when there is no such order, ErrOrderCanceled when it
+// is canceled and ErrOverShipment when a line is not in the order or has less left to ship.
+func (s *MongoShipmentStore) InsertShipment(ctx context.Context, shipment *types.Shipment) (*types.Shipment, error) {
+	err := withTransaction(ctx, s.client, func(ctx context.Context) error {
+		var order types.Order
+		if err := s.orders.FindOne(ctx, bson.M{"_id": shipment.OrderID, archivedField: notArchived}).Decode(&order); err != nil {
+			return err
+		}
+		if strings.EqualFold(order.Status, types.OrderCanceled) {
+			return ErrOrderCanceled
+		}
+
+		for i := range shipment.Lines {
+			line := &shipment.Lines[i]
+			if line.Line < 0 || line.Line >= len(order.OrderItems) {
+				return ErrOverShipment
+			}
+			item := &order.OrderItems[line.Line]
+			if line.Quantity > item.Outstanding() {
+				return ErrOverShipment
+			}
+
+			// Lines of orders placed before reservations, or entered as shipped, were taken
+			// from stock already; only what is still reserved is taken now.
+			reserved := line.Quantity
+			if reserved > item.Reserved {
+				reserved = item.Reserved
+			}
+			if reserved > 0 {
+				if err := s.stock.takeReserved(ctx, types.StockProduct, item.Product.ID, order.Location, float64(reserved)); err != nil {
+					return err
+				}
+			}
+			item.Reserved -= reserved
+			item.Shipped += line.Quantity
+
+			line.ProductID = item.Product.ID
+			line.SKU = item.Product.SKU
+			line.Name = item.Product.Name
+			line.Ordered = item.Quantity
+		}
+
+		update := bson.M{"$set": bson.M{
+			"orderItems": order.OrderItems,
+			"status":     shippedStatus(&order),
+		}}
+		if _, err := updateVersioned(ctx, s.orders, order.ID, order.Version, update); err != nil {
+			return err
+		}
+
+		seq, err := nextSequence(ctx, s.database, "shipment")
+		if err != nil {
+			return err
+		}
+		shipment.Number = fmt.Sprintf("SH%06d", seq)
+		shipment.CustomerID = order.CustomerID
+		shipment.CustomerName = order.CustomerName
+		shipment.ShippingAddress = order.ShippingAddress
+		shipment.Location = order.Location
+		shipment.Version = 1
+
+		resp, err := s.coll.InsertOne(ctx, shipment)
+		if err != nil {
+			return err
+		}
+		shipment.ID = resp.InsertedID.(primitive.ObjectID)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return shipment, nil
+}
+
+// UpdateShipment changes the carrier, tracking number, ship date and remark of a shipment. The
+// lines cannot change once stock was taken for them.
+func (s *MongoShipmentStore) UpdateShipment(ctx context.Context, id primitive.ObjectID, shipment *types.Shipment, version int64) (int64, error) {
+	update := bson.M{
+		"$set": bson.M{
+			"carrier":        shipment.Carrier,
+			"trackingNumber": shipment.TrackingNumber,
+			"shipDate":       shipment.ShipDate,
+			"remark":         shipment.Remark,
+		},
+	}
+
+	return updateVersioned(ctx, s.coll, id, version, update)
+}
+
+// shippedStatus is the status of an order after a shipment: shipped once every line is out,
+// partially shipped before that. Orders already past shipping keep their status.
+func shippedStatus(order *types.Order) string {
+	if types.OrderShippedStatus(order.Status) {
+		return order.Status
+	}
+	for _, item := range order.OrderItems {
+		if item.Outstanding() > 0 {
+			return types.OrderPartiallyShipped
+		}
+	}
+	return types.OrderShipped
+}
@@ -0,0 +1,31 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/johnson7543/ims/types"
+)
+
+func TestShippedStatus(t *testing.T) {
+	tests := []struct {
+		name   string
+		status string
+		items  []types.OrderItem
+		want   string
+	}{
+		{"first shipment of several lines", types.OrderProcessing, []types.OrderItem{{Quantity: 5, Shipped: 5}, {Quantity: 3}}, types.OrderPartiallyShipped},
+		{"part of a line", types.OrderPending, []types.OrderItem{{Quantity: 5, Shipped: 2}}, types.OrderPartiallyShipped},
+		{"last shipment", types.OrderPartiallyShipped, []types.OrderItem{{Quantity: 5, Shipped: 5}, {Quantity: 3, Shipped: 3}}, types.OrderShipped},
+		{"delivered stays delivered", types.OrderDelivered, []types.OrderItem{{Quantity: 5, Shipped: 5}}, types.OrderDelivered},
+		{"completed stays completed", types.OrderCompleted, []types.OrderItem{{Quantity: 5}}, types.OrderCompleted},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			order := &types.Order{Status: tt.status, OrderItems: tt.items}
+			if got := shippedStatus(order); got != tt.want {
+				t.Errorf("shippedStatus() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
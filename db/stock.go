@@ -0,0 +1,92 @@
+package db
+
+import (
+	"context"
+	"errors"
+
+	"github.com/johnson7543/ims/types"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ErrInsufficientStock is returned when taking more of a product or material than is in stock.
+var ErrInsufficientStock = errors.New("insufficient stock")
+
+// decreaseQuantity takes quantity from the stock of the document with id, refusing to touch
+// what is reserved. It returns ErrInsufficientStock when less is available, and 0 when there is
+// no such document.
+func decreaseQuantity[N int | float64](ctx context.Context, coll *mongo.Collection, id primitive.ObjectID, quantity N) (int64, error) {
+	return decreaseKeeping(ctx, coll, id, quantity, 0)
+}
+
+// decreaseUnassigned takes quantity from the stock of the item of the kind that is not placed
+// at any location, like decreaseQuantity, so the item keeps at least what its locations hold.
+func decreaseUnassigned[N int | float64](ctx context.Context, coll *mongo.Collection, kind string, id primitive.ObjectID, quantity N) (int64, error) {
+	placed, err := placedAvailable(ctx, coll.Database(), kind, id)
+	if err != nil {
+		return 0, err
+	}
+	return decreaseKeeping(ctx, coll, id, quantity, placed)
+}
+
+// placedAvailable sums what the locations of the item of the kind hold that is not reserved.
+// Stock not placed at a location is what the item has available beyond it.
+func placedAvailable(ctx context.Context, database *mongo.Database, kind string, id primitive.ObjectID) (float64, error) {
+	cursor, err := database.Collection(stockLevelColl).Find(ctx, bson.M{"itemKind": kind, "itemId": id})
+	if err != nil {
+		return 0, err
+	}
+	var levels []types.StockLevel
+	if err := cursor.All(ctx, &levels); err != nil {
+		return 0, err
+	}
+
+	placed := 0.0
+	for _, level := range levels {
+		placed += level.Quantity - level.Reserved
+	}
+	return placed, nil
+}
+
+// decreaseKeeping takes quantity from the stock of the document with id when at least keep
+// more is available.
+func decreaseKeeping[N int | float64](ctx context.Context, coll *mongo.Collection, id primitive.ObjectID, quantity N, keep float64) (int64, error) {
+	filter := bson.M{"_id": id, "$expr": availableAtLeast(float64(quantity) + keep)}
+	update := bson.M{
+		"$inc": bson.M{"quantity": -quantity, versionField: 1},
+	}
+
+	updateResult, err := coll.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return 0, err
+	}
+	if updateResult.ModifiedCount > 0 {
+		return updateResult.ModifiedCount, nil
+	}
+
+	count, err := coll.CountDocuments(ctx, bson.M{"_id": id})
+	if err != nil {
+		return 0, err
+	}
+	if count > 0 {
+		return 0, ErrInsufficientStock
+	}
+	return 0, nil
+}
+
+// increaseQuantity adds quantity to the stock of the document with id. It returns 0 when there
+// is no such document.
+func increaseQuantity[N int | float64](ctx context.Context, coll *mongo.Collection, id primitive.ObjectID, quantity N) (int64, error) {
+	update := bson.M{
+		"$inc": bson.M{"quantity": quantity, versionField: 1},
+	}
+
+	updateResult, err := coll.UpdateOne(ctx, bson.M{"_id": id}, update)
+	if err != nil {
+		return 0, err
+	}
+
+	return updateResult.ModifiedCount, nil
+}
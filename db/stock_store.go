@@ -0,0 +1,481 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"time"
+
+	"github.com/johnson7543/ims/types"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	stockLevelColl    = "stock_levels"
+	stockTransferColl = "stock_transfers"
+	stockMovementColl = "stock_movements"
+)
+
+// stockEpsilon absorbs the rounding of decimal quantities; a level holding less is empty.
+const stockEpsilon = 1e-9
+
+// StockStore keeps the stock of products and materials per location. Every change to a level
+// that also changes the item's total does both in one transaction.
+type StockStore interface {
+	GetStockLevels(ctx context.Context, filter bson.M) ([]*types.StockLevel, error)
+	GetStockBreakdown(ctx context.Context, kind string, itemID primitive.ObjectID) (*types.StockBreakdown, error)
+	AddStock(ctx context.Context, kind string, itemID primitive.ObjectID, loc types.StockLocation, quantity float64) error
+	TakeStock(ctx context.Context, kind string, itemID primitive.ObjectID, loc types.StockLocation, quantity float64) error
+	PlaceStock(ctx context.Context, kind string, itemID primitive.ObjectID, loc types.StockLocation, quantity float64) error
+	TransferStock(context.Context, *types.StockTransfer) (*types.StockTransfer, error)
+	GetStockTransfers(ctx context.Context, filter bson.M) ([]*types.StockTransfer, error)
+	GetStockTransfer(context.Context, primitive.ObjectID) (*types.StockTransfer, error)
+	AdjustStock(ctx context.Context, movements []*types.StockMovement) error
+	GetStockMovements(ctx context.Context, filter bson.M) ([]*types.StockMovement, error)
+}
+
+type MongoStockStore struct {
+	client    *mongo.Client
+	database  *mongo.Database
+	levels    *mongo.Collection
+	transfers *mongo.Collection
+	movements *mongo.Collection
+}
+
+func NewMongoStockStore(client *mongo.Client) *MongoStockStore {
+	dbName := os.Getenv(MongoDBNameEnvName)
+	database := client.Database(dbName)
+	return &MongoStockStore{
+		client:    client,
+		database:  database,
+		levels:    database.Collection(stockLevelColl),
+		transfers: database.Collection(stockTransferColl),
+		movements: database.Collection(stockMovementColl),
+	}
+}
+
+// GetStockLevels returns the levels matching filter, by warehouse and bin. Unlike the other
+// list queries the filter values match exactly.
+func (s *MongoStockStore) GetStockLevels(ctx context.Context, filter bson.M) ([]*types.StockLevel, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "warehouseId", Value: 1}, {Key: "bin", Value: 1}})
+	resp, err := s.levels.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	levels := []*types.StockLevel{}
+	if err := resp.All(ctx, &levels); err != nil {
+		return nil, err
+	}
+
+	return levels, nil
+}
+
+// GetStockBreakdown returns where the stock of an item is. It returns mongo.ErrNoDocuments
+// when there is no such item.
+func (s *MongoStockStore) GetStockBreakdown(ctx context.Context, kind string, itemID primitive.ObjectID) (*types.StockBreakdown, error) {
+	total, err := s.stockOf(ctx, kind, itemID)
+	if err != nil {
+		return nil, err
+	}
+
+	levels, err := s.GetStockLevels(ctx, bson.M{"itemKind": kind, "itemId": itemID})
+	if err != nil {
+		return nil, err
+	}
+
+	breakdown := &types.StockBreakdown{
+		ItemKind:   kind,
+		ItemID:     itemID.Hex(),
+		Total:      total.Quantity,
+		Reserved:   total.Reserved,
+		Unassigned: total.Quantity,
+		Levels:     levels,
+	}
+	for _, level := range levels {
+		breakdown.Unassigned -= level.Quantity
+	}
+	return breakdown, nil
+}
+
+// AddStock adds quantity to the item's total and to the location. A negative quantity takes
+// stock, and returns ErrInsufficientStock when less is available.
+func (s *MongoStockStore) AddStock(ctx context.Context, kind string, itemID primitive.ObjectID, loc types.StockLocation, quantity float64) error {
+	return withTransaction(ctx, s.client, func(ctx context.Context) error {
+		if quantity < 0 {
+			return s.take(ctx, kind, itemID, &loc, -quantity)
+		}
+		return s.put(ctx, kind, itemID, &loc, quantity)
+	})
+}
+
+// TakeStock takes quantity from the location and from the item's total. It returns
+// ErrInsufficientStock when the location holds less.
+func (s *MongoStockStore) TakeStock(ctx context.Context, kind string, itemID primitive.ObjectID, loc types.StockLocation, quantity float64) error {
+	return withTransaction(ctx, s.client, func(ctx context.Context) error {
+		return s.take(ctx, kind, itemID, &loc, quantity)
+	})
+}
+
+// take and put do the work of TakeStock and AddStock in the caller's transaction. Without a
+// location only the item's total changes, and take only takes stock not placed at a location.
+func (s *MongoStockStore) take(ctx context.Context, kind string, itemID primitive.ObjectID, loc *types.StockLocation, quantity float64) error {
+	var updated int64
+	var err error
+	if loc != nil {
+		if err := s.takeLevel(ctx, kind, itemID, *loc, quantity); err != nil {
+			return err
+		}
+		updated, err = s.changeTotal(ctx, kind, itemID, -quantity)
+	} else if kind == types.StockProduct {
+		updated, err = decreaseUnassigned(ctx, s.itemColl(kind), kind, itemID, int(math.Round(quantity)))
+	} else {
+		updated, err = decreaseUnassigned(ctx, s.itemColl(kind), kind, itemID, quantity)
+	}
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+func (s *MongoStockStore) put(ctx context.Context, kind string, itemID primitive.ObjectID, loc *types.StockLocation, quantity float64) error {
+	updated, err := s.changeTotal(ctx, kind, itemID, quantity)
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return mongo.ErrNoDocuments
+	}
+	if loc != nil {
+		return s.putLevel(ctx, kind, itemID, *loc, quantity)
+	}
+	return nil
+}
+
+// PlaceStock puts quantity of the item's unassigned stock at the location, leaving the total as
+// it is. It returns ErrInsufficientStock when less is unassigned.
+func (s *MongoStockStore) PlaceStock(ctx context.Context, kind string, itemID primitive.ObjectID, loc types.StockLocation, quantity float64) error {
+	return withTransaction(ctx, s.client, func(ctx context.Context) error {
+		return s.placeLevel(ctx, kind, itemID, loc, quantity)
+	})
+}
+
+// TransferStock moves the items of the transfer and records it, all or nothing. Transfers
+// without a source place unassigned stock.
+func (s *MongoStockStore) TransferStock(ctx context.Context, transfer *types.StockTransfer) (*types.StockTransfer, error) {
+	err := withTransaction(ctx, s.client, func(ctx context.Context) error {
+		for _, item := range transfer.Items {
+			if transfer.From == nil {
+				if err := s.placeLevel(ctx, item.ItemKind, item.ItemID, transfer.To, item.Quantity); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := s.takeLevel(ctx, item.ItemKind, item.ItemID, *transfer.From, item.Quantity); err != nil {
+				return err
+			}
+			if err := s.putLevel(ctx, item.ItemKind, item.ItemID, transfer.To, item.Quantity); err != nil {
+				return err
+			}
+		}
+
+		seq, err := nextSequence(ctx, s.database, "stockTransfer")
+		if err != nil {
+			return err
+		}
+		transfer.Number = fmt.Sprintf("TR%06d", seq)
+
+		resp, err := s.transfers.InsertOne(ctx, transfer)
+		if err != nil {
+			return err
+		}
+		transfer.ID = resp.InsertedID.(primitive.ObjectID)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return transfer, nil
+}
+
+// GetStockTransfers returns the transfers matching filter, newest first. Unlike the other
+// list queries the filter values match exactly.
+func (s *MongoStockStore) GetStockTransfers(ctx context.Context, filter bson.M) ([]*types.StockTransfer, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "transferDate", Value: -1}, {Key: "_id", Value: -1}})
+	resp, err := s.transfers.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var transfers []*types.StockTransfer
+	if err := resp.All(ctx, &transfers); err != nil {
+		return nil, err
+	}
+
+	return transfers, nil
+}
+
+func (s *MongoStockStore) GetStockTransfer(ctx context.Context, id primitive.ObjectID) (*types.StockTransfer, error) {
+	var transfer types.StockTransfer
+	if err := s.transfers.FindOne(ctx, bson.M{"_id": id}).Decode(&transfer); err != nil {
+		return nil, err
+	}
+
+	return &transfer, nil
+}
+
+// AdjustStock applies the movements and records them in the stock ledger, all or nothing. It
+// returns ErrInsufficientStock when a movement takes more than its location or item holds.
+func (s *MongoStockStore) AdjustStock(ctx context.Context, movements []*types.StockMovement) error {
+	return withTransaction(ctx, s.client, func(ctx context.Context) error {
+		for _, movement := range movements {
+			if err := s.adjust(ctx, movement); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// GetStockMovements returns the ledger entries matching filter, newest first. Unlike the other
+// list queries the filter values match exactly.
+func (s *MongoStockStore) GetStockMovements(ctx context.Context, filter bson.M) ([]*types.StockMovement, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}, {Key: "_id", Value: -1}})
+	resp, err := s.movements.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	movements := []*types.StockMovement{}
+	if err := resp.All(ctx, &movements); err != nil {
+		return nil, err
+	}
+
+	return movements, nil
+}
+
+// reserve holds quantity of what is available at the location, or of the item's available stock
+// not placed at a location when there is none, so it cannot be sold twice. Reserving unassigned
+// stock keeps it from being taken by a reservation later shipped without a location. It returns
+// ErrInsufficientStock when less is available and mongo.ErrNoDocuments when there is no such
+// item. Like release and takeReserved it runs in the caller's transaction.
+func (s *MongoStockStore) reserve(ctx context.Context, kind string, itemID primitive.ObjectID, loc *types.StockLocation, quantity float64) error {
+	need := quantity
+	if loc != nil {
+		filter := levelFilter(kind, itemID, *loc)
+		filter["$expr"] = availableAtLeast(quantity)
+		if err := s.changeLevel(ctx, filter, bson.M{"reserved": quantity}); err != nil {
+			return err
+		}
+	} else {
+		placed, err := placedAvailable(ctx, s.database, kind, itemID)
+		if err != nil {
+			return err
+		}
+		need += placed
+	}
+
+	filter := bson.M{"_id": itemID, "$expr": availableAtLeast(need)}
+	return s.changeItem(ctx, kind, filter, bson.M{"reserved": itemAmount(kind, quantity)})
+}
+
+// release gives back quantity of the stock reserved for the item, at the location when there is
+// one. It returns ErrInsufficientStock when less is reserved.
+func (s *MongoStockStore) release(ctx context.Context, kind string, itemID primitive.ObjectID, loc *types.StockLocation, quantity float64) error {
+	if loc != nil {
+		filter := levelFilter(kind, itemID, *loc)
+		filter["reserved"] = bson.M{"$gte": quantity - stockEpsilon}
+		if err := s.changeLevel(ctx, filter, bson.M{"reserved": -quantity}); err != nil {
+			return err
+		}
+	}
+
+	filter := bson.M{"_id": itemID, "reserved": bson.M{"$gte": quantity - stockEpsilon}}
+	return s.changeItem(ctx, kind, filter, bson.M{"reserved": itemAmount(kind, -quantity)})
+}
+
+// takeReserved takes quantity of the stock reserved for the item out of stock, from the location
+// when there is one, as when the order holding it ships. It returns ErrInsufficientStock when
+// less is reserved.
+func (s *MongoStockStore) takeReserved(ctx context.Context, kind string, itemID primitive.ObjectID, loc *types.StockLocation, quantity float64) error {
+	if loc != nil {
+		filter := levelFilter(kind, itemID, *loc)
+		filter["reserved"] = bson.M{"$gte": quantity - stockEpsilon}
+		if err := s.changeLevel(ctx, filter, bson.M{"quantity": -quantity, "reserved": -quantity}); err != nil {
+			return err
+		}
+	}
+
+	filter := bson.M{"_id": itemID, "reserved": bson.M{"$gte": quantity - stockEpsilon}}
+	amount := itemAmount(kind, -quantity)
+	return s.changeItem(ctx, kind, filter, bson.M{"quantity": amount, "reserved": amount})
+}
+
+// adjust changes the stock by the movement and records it. It runs in the caller's
+// transaction.
+func (s *MongoStockStore) adjust(ctx context.Context, movement *types.StockMovement) error {
+	var err error
+	if movement.Quantity < 0 {
+		err = s.take(ctx, movement.ItemKind, movement.ItemID, movement.Location, -movement.Quantity)
+	} else {
+		err = s.put(ctx, movement.ItemKind, movement.ItemID, movement.Location, movement.Quantity)
+	}
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.movements.InsertOne(ctx, movement)
+	if err != nil {
+		return err
+	}
+	movement.ID = resp.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+func levelFilter(kind string, itemID primitive.ObjectID, loc types.StockLocation) bson.M {
+	return bson.M{"itemKind": kind, "itemId": itemID, "warehouseId": loc.WarehouseID, "bin": loc.Bin}
+}
+
+// putLevel adds quantity to the level of the location, creating it when needed.
+func (s *MongoStockStore) putLevel(ctx context.Context, kind string, itemID primitive.ObjectID, loc types.StockLocation, quantity float64) error {
+	update := bson.M{
+		"$inc": bson.M{"quantity": quantity},
+		"$set": bson.M{"updatedAt": time.Now()},
+	}
+	_, err := s.levels.UpdateOne(ctx, levelFilter(kind, itemID, loc), update, options.Update().SetUpsert(true))
+	return err
+}
+
+// takeLevel takes quantity from the level of the location. Stock reserved there is left alone.
+func (s *MongoStockStore) takeLevel(ctx context.Context, kind string, itemID primitive.ObjectID, loc types.StockLocation, quantity float64) error {
+	filter := levelFilter(kind, itemID, loc)
+	filter["$expr"] = availableAtLeast(quantity)
+	return s.changeLevel(ctx, filter, bson.M{"quantity": -quantity})
+}
+
+// changeLevel applies inc to the level matching filter, removing the level once it is empty so
+// only locations holding stock have one. It returns ErrInsufficientStock when no level matches.
+func (s *MongoStockStore) changeLevel(ctx context.Context, filter bson.M, inc bson.M) error {
+	update := bson.M{
+		"$inc": inc,
+		"$set": bson.M{"updatedAt": time.Now()},
+	}
+
+	res, err := s.levels.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrInsufficientStock
+	}
+
+	empty := bson.M{}
+	for _, key := range []string{"itemKind", "itemId", "warehouseId", "bin"} {
+		empty[key] = filter[key]
+	}
+	empty["quantity"] = bson.M{"$lt": stockEpsilon}
+	_, err = s.levels.DeleteOne(ctx, empty)
+	return err
+}
+
+// changeItem applies inc to the item matching filter. It returns ErrInsufficientStock when the
+// item exists but does not match, and mongo.ErrNoDocuments when there is no such item.
+func (s *MongoStockStore) changeItem(ctx context.Context, kind string, filter bson.M, inc bson.M) error {
+	inc[versionField] = 1
+	coll := s.itemColl(kind)
+	res, err := coll.UpdateOne(ctx, filter, bson.M{"$inc": inc})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount > 0 {
+		return nil
+	}
+
+	count, err := coll.CountDocuments(ctx, bson.M{"_id": filter["_id"]})
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrInsufficientStock
+	}
+	return mongo.ErrNoDocuments
+}
+
+// availableAtLeast matches documents whose unreserved quantity covers quantity.
+func availableAtLeast(quantity float64) bson.M {
+	available := bson.M{"$subtract": bson.A{"$quantity", bson.M{"$ifNull": bson.A{"$reserved", 0}}}}
+	return bson.M{"$gte": bson.A{available, quantity - stockEpsilon}}
+}
+
+// itemAmount is quantity as stored on an item: products count whole pieces.
+func itemAmount(kind string, quantity float64) any {
+	if kind == types.StockProduct {
+		return int(math.Round(quantity))
+	}
+	return quantity
+}
+
+// placeLevel puts unassigned stock of the item at the location.
+func (s *MongoStockStore) placeLevel(ctx context.Context, kind string, itemID primitive.ObjectID, loc types.StockLocation, quantity float64) error {
+	breakdown, err := s.GetStockBreakdown(ctx, kind, itemID)
+	if err != nil {
+		return err
+	}
+	if breakdown.Unassigned+stockEpsilon < quantity {
+		return ErrInsufficientStock
+	}
+
+	// Writing the item makes concurrent placements of its stock conflict, so they cannot
+	// both place the same unassigned stock.
+	if _, err := s.itemColl(kind).UpdateOne(ctx, bson.M{"_id": itemID}, bson.M{"$inc": bumpVersion}); err != nil {
+		return err
+	}
+	return s.putLevel(ctx, kind, itemID, loc, quantity)
+}
+
+func (s *MongoStockStore) itemColl(kind string) *mongo.Collection {
+	if kind == types.StockMaterial {
+		return s.database.Collection(materialColl)
+	}
+	return s.database.Collection(productColl)
+}
+
+// itemStock is the stock of an item as kept on the item itself.
+type itemStock struct {
+	Quantity float64 `bson:"quantity"`
+	Reserved float64 `bson:"reserved"`
+}
+
+func (s *MongoStockStore) stockOf(ctx context.Context, kind string, itemID primitive.ObjectID) (itemStock, error) {
+	var item itemStock
+	if err := s.itemColl(kind).FindOne(ctx, bson.M{"_id": itemID}).Decode(&item); err != nil {
+		return itemStock{}, err
+	}
+	return item, nil
+}
+
+// changeTotal adds delta to the item's total, which may be negative. Products count whole
+// pieces. Decreases of the total alone go through decreaseUnassigned instead.
+func (s *MongoStockStore) changeTotal(ctx context.Context, kind string, itemID primitive.ObjectID, delta float64) (int64, error) {
+	coll := s.itemColl(kind)
+	if kind == types.StockProduct {
+		n := int(math.Round(delta))
+		if n < 0 {
+			return decreaseQuantity(ctx, coll, itemID, -n)
+		}
+		return increaseQuantity(ctx, coll, itemID, n)
+	}
+	if delta < 0 {
+		return decreaseQuantity(ctx, coll, itemID, -delta)
+	}
+	return increaseQuantity(ctx, coll, itemID, delta)
+}
@@ -0,0 +1,156 @@
+//go:build integration
+
+package db
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/johnson7543/ims/types"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// These tests need a MongoDB replica set at MONGO_DB_URL, as stock changes run in
+// transactions. Each test works in a database of its own that is dropped afterwards:
+//
+//	MONGO_DB_URL=mongodb://localhost:27017/?replicaSet=rs0 go test -tags integration ./db/
+
+// newTestStockStore returns a stock store on a fresh database and a product holding quantity
+// pieces, none of them placed at a location.
+func newTestStockStore(t *testing.T, quantity int) (*MongoStockStore, primitive.ObjectID) {
+	t.Helper()
+	uri := os.Getenv("MONGO_DB_URL")
+	if uri == "" {
+		t.Skip("MONGO_DB_URL is not set")
+	}
+
+	ctx := context.Background()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(MongoDBNameEnvName, "ims_test_"+primitive.NewObjectID().Hex())
+	store := NewMongoStockStore(client)
+	t.Cleanup(func() {
+		store.database.Drop(ctx)
+		client.Disconnect(ctx)
+	})
+
+	productID := primitive.NewObjectID()
+	product := bson.M{"_id": productID, "name": "Widget", "quantity": quantity, "reserved": 0}
+	if _, err := store.database.Collection(productColl).InsertOne(ctx, product); err != nil {
+		t.Fatal(err)
+	}
+	return store, productID
+}
+
+// checkStock compares the stock of the product with want and checks the invariants of the
+// ledger: nothing is negative, nothing reserved is more than what is held, and the locations
+// hold no more than the product's total.
+func checkStock(t *testing.T, store *MongoStockStore, productID primitive.ObjectID, want types.StockBreakdown) {
+	t.Helper()
+	got, err := store.GetStockBreakdown(context.Background(), types.StockProduct, productID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Total != want.Total || got.Reserved != want.Reserved || got.Unassigned != want.Unassigned {
+		t.Errorf("stock = total %g, reserved %g, unassigned %g, want %g, %g, %g",
+			got.Total, got.Reserved, got.Unassigned, want.Total, want.Reserved, want.Unassigned)
+	}
+
+	levelsReserved := 0.0
+	for _, level := range got.Levels {
+		if level.Quantity <= 0 || level.Reserved < 0 || level.Reserved > level.Quantity {
+			t.Errorf("level at %s holds %g with %g reserved", level.Bin, level.Quantity, level.Reserved)
+		}
+		levelsReserved += level.Reserved
+	}
+	if got.Reserved < levelsReserved || got.Reserved > got.Total || got.Unassigned < 0 {
+		t.Errorf("total %g with %g reserved, of which %g at locations, and %g unassigned",
+			got.Total, got.Reserved, levelsReserved, got.Unassigned)
+	}
+}
+
+func wantErr(t *testing.T, op string, err, want error) {
+	t.Helper()
+	if !errors.Is(err, want) {
+		t.Fatalf("%s: err = %v, want %v", op, err, want)
+	}
+}
+
+func TestStockReservationsAtALocation(t *testing.T) {
+	ctx := context.Background()
+	store, productID := newTestStockStore(t, 4)
+	loc := &types.StockLocation{WarehouseID: primitive.NewObjectID(), Bin: "A1"}
+
+	wantErr(t, "add", store.AddStock(ctx, types.StockProduct, productID, *loc, 6), nil)
+	wantErr(t, "reserve", store.reserve(ctx, types.StockProduct, productID, loc, 4), nil)
+	checkStock(t, store, productID, types.StockBreakdown{Total: 10, Reserved: 4, Unassigned: 4})
+
+	// Only 2 of the 6 at the location are not reserved.
+	wantErr(t, "reserve beyond the location", store.reserve(ctx, types.StockProduct, productID, loc, 3), ErrInsufficientStock)
+	wantErr(t, "take reserved stock", store.TakeStock(ctx, types.StockProduct, productID, *loc, 3), ErrInsufficientStock)
+	wantErr(t, "release more than reserved", store.release(ctx, types.StockProduct, productID, loc, 5), ErrInsufficientStock)
+	checkStock(t, store, productID, types.StockBreakdown{Total: 10, Reserved: 4, Unassigned: 4})
+
+	wantErr(t, "release", store.release(ctx, types.StockProduct, productID, loc, 1), nil)
+	wantErr(t, "take reserved", store.takeReserved(ctx, types.StockProduct, productID, loc, 3), nil)
+	checkStock(t, store, productID, types.StockBreakdown{Total: 7, Reserved: 0, Unassigned: 4})
+
+	wantErr(t, "take reserved beyond what is reserved", store.takeReserved(ctx, types.StockProduct, productID, loc, 1), ErrInsufficientStock)
+	wantErr(t, "take", store.TakeStock(ctx, types.StockProduct, productID, *loc, 3), nil)
+	checkStock(t, store, productID, types.StockBreakdown{Total: 4, Reserved: 0, Unassigned: 4})
+}
+
+func TestStockReservationsWithoutALocation(t *testing.T) {
+	ctx := context.Background()
+	store, productID := newTestStockStore(t, 10)
+	loc := types.StockLocation{WarehouseID: primitive.NewObjectID()}
+
+	wantErr(t, "place", store.PlaceStock(ctx, types.StockProduct, productID, loc, 6), nil)
+
+	// A reservation without a location can't hold what is placed at one.
+	wantErr(t, "reserve placed stock", store.reserve(ctx, types.StockProduct, productID, nil, 5), ErrInsufficientStock)
+	wantErr(t, "reserve", store.reserve(ctx, types.StockProduct, productID, nil, 4), nil)
+	checkStock(t, store, productID, types.StockBreakdown{Total: 10, Reserved: 4, Unassigned: 4})
+
+	// What is left unassigned is reserved, while the location's stock can still be taken.
+	wantErr(t, "take reserved stock", store.take(ctx, types.StockProduct, productID, nil, 1), ErrInsufficientStock)
+	wantErr(t, "take from the location", store.TakeStock(ctx, types.StockProduct, productID, loc, 1), nil)
+	checkStock(t, store, productID, types.StockBreakdown{Total: 9, Reserved: 4, Unassigned: 4})
+
+	wantErr(t, "release", store.release(ctx, types.StockProduct, productID, nil, 1), nil)
+	wantErr(t, "take reserved", store.takeReserved(ctx, types.StockProduct, productID, nil, 3), nil)
+	checkStock(t, store, productID, types.StockBreakdown{Total: 6, Reserved: 0, Unassigned: 1})
+	wantErr(t, "release more than reserved", store.release(ctx, types.StockProduct, productID, nil, 1), ErrInsufficientStock)
+}
+
+func TestStockTakeWithoutALocationKeepsPlacedStock(t *testing.T) {
+	ctx := context.Background()
+	store, productID := newTestStockStore(t, 10)
+	loc := types.StockLocation{WarehouseID: primitive.NewObjectID(), Bin: "B2"}
+
+	wantErr(t, "place", store.PlaceStock(ctx, types.StockProduct, productID, loc, 8), nil)
+	wantErr(t, "take placed stock", store.take(ctx, types.StockProduct, productID, nil, 3), ErrInsufficientStock)
+	wantErr(t, "take", store.take(ctx, types.StockProduct, productID, nil, 2), nil)
+	checkStock(t, store, productID, types.StockBreakdown{Total: 8, Reserved: 0, Unassigned: 0})
+
+	wantErr(t, "take from the location", store.TakeStock(ctx, types.StockProduct, productID, loc, 8), nil)
+	checkStock(t, store, productID, types.StockBreakdown{Total: 0, Reserved: 0, Unassigned: 0})
+}
+
+func TestStockOfAMissingItem(t *testing.T) {
+	ctx := context.Background()
+	store, _ := newTestStockStore(t, 0)
+	missing := primitive.NewObjectID()
+
+	wantErr(t, "reserve", store.reserve(ctx, types.StockProduct, missing, nil, 1), mongo.ErrNoDocuments)
+	wantErr(t, "release", store.release(ctx, types.StockProduct, missing, nil, 1), mongo.ErrNoDocuments)
+	wantErr(t, "take reserved", store.takeReserved(ctx, types.StockProduct, missing, nil, 1), mongo.ErrNoDocuments)
+}
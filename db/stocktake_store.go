@@ -0,0 +1,321 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/johnson7543/ims/types"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const stocktakeColl = "stocktakes"
+
+// ErrStocktakeClosed is returned when counting, posting or canceling a stocktake that has
+// already been posted or canceled.
+var ErrStocktakeClosed = errors.New("stocktake is no longer open")
+
+// stocktakeRetries bounds how often a count is retried when other counters keep writing the
+// same stocktake.
+const stocktakeRetries = 5
+
+// StocktakeStore keeps stocktakes and posts their variances to the stock ledger.
+type StocktakeStore interface {
+	GetStocktakes(ctx context.Context, filter bson.M) ([]*types.Stocktake, error)
+	GetStocktake(context.Context, primitive.ObjectID) (*types.Stocktake, error)
+	InsertStocktake(ctx context.Context, stocktake *types.Stocktake, itemIDs []primitive.ObjectID) (*types.Stocktake, error)
+	UpdateStocktakeLines(ctx context.Context, id primitive.ObjectID, update func(*types.Stocktake) error) (*types.Stocktake, error)
+	PostStocktake(ctx context.Context, id primitive.ObjectID, version int64, opts PostStocktakeOptions) (*types.Stocktake, error)
+	CancelStocktake(ctx context.Context, id primitive.ObjectID) (int64, error)
+}
+
+// PostStocktakeOptions control how a stocktake is posted.
+type PostStocktakeOptions struct {
+	// Reason is recorded on the adjustments of lines without one in LineReasons, which is
+	// keyed by item ID.
+	Reason      string
+	LineReasons map[primitive.ObjectID]string
+	// UncountedAsZero counts the lines nobody counted as empty instead of leaving them alone.
+	UncountedAsZero bool
+	By              primitive.ObjectID
+}
+
+type MongoStocktakeStore struct {
+	client   *mongo.Client
+	database *mongo.Database
+	coll     *mongo.Collection
+	stock    *MongoStockStore
+}
+
+func NewMongoStocktakeStore(client *mongo.Client) *MongoStocktakeStore {
+	dbName := os.Getenv(MongoDBNameEnvName)
+	database := client.Database(dbName)
+	return &MongoStocktakeStore{
+		client:   client,
+		database: database,
+		coll:     database.Collection(stocktakeColl),
+		stock:    NewMongoStockStore(client),
+	}
+}
+
+// GetStocktakes returns the stocktakes matching filter, newest first, without their lines.
+// Unlike the other list queries the filter values match exactly.
+func (s *MongoStocktakeStore) GetStocktakes(ctx context.Context, filter bson.M) ([]*types.Stocktake, error) {
+	opts := options.Find().
+		SetSort(bson.D{{Key: "createdAt", Value: -1}, {Key: "_id", Value: -1}}).
+		SetProjection(bson.M{"lines": 0})
+	resp, err := s.coll.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	stocktakes := []*types.Stocktake{}
+	if err := resp.All(ctx, &stocktakes); err != nil {
+		return nil, err
+	}
+
+	return stocktakes, nil
+}
+
+func (s *MongoStocktakeStore) GetStocktake(ctx context.Context, id primitive.ObjectID) (*types.Stocktake, error) {
+	var stocktake types.Stocktake
+	if err := s.coll.FindOne(ctx, bson.M{"_id": id}).Decode(&stocktake); err != nil {
+		return nil, err
+	}
+
+	return &stocktake, nil
+}
+
+// InsertStocktake starts a stocktake, freezing the quantities expected of every item it
+// counts: the live products and materials of its kind, or only itemIDs when given. At a
+// location the items held there are counted, along with itemIDs.
+func (s *MongoStocktakeStore) InsertStocktake(ctx context.Context, stocktake *types.Stocktake, itemIDs []primitive.ObjectID) (*types.Stocktake, error) {
+	kinds := types.StockItemKinds
+	if stocktake.ItemKind != "" {
+		kinds = []string{stocktake.ItemKind}
+	}
+
+	err := withTransaction(ctx, s.client, func(ctx context.Context) error {
+		stocktake.Lines = []types.StocktakeLine{}
+		for _, kind := range kinds {
+			lines, err := s.expectedLines(ctx, kind, stocktake.Location, itemIDs)
+			if err != nil {
+				return err
+			}
+			stocktake.Lines = append(stocktake.Lines, lines...)
+		}
+
+		seq, err := nextSequence(ctx, s.database, "stocktake")
+		if err != nil {
+			return err
+		}
+		stocktake.Number = fmt.Sprintf("ST%06d", seq)
+		stocktake.Status = types.StocktakeOpen
+
+		resp, err := s.coll.InsertOne(ctx, stocktake)
+		if err != nil {
+			return err
+		}
+		stocktake.ID = resp.InsertedID.(primitive.ObjectID)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return stocktake, nil
+}
+
+// expectedLines lists the items of one kind a stocktake counts, with their current stock.
+func (s *MongoStocktakeStore) expectedLines(ctx context.Context, kind string, loc *types.StockLocation, itemIDs []primitive.ObjectID) ([]types.StocktakeLine, error) {
+	expected := map[primitive.ObjectID]float64{}
+	filter := liveFilter(bson.M{})
+	if loc != nil {
+		levels, err := s.stock.GetStockLevels(ctx, bson.M{"itemKind": kind, "warehouseId": loc.WarehouseID, "bin": loc.Bin})
+		if err != nil {
+			return nil, err
+		}
+		ids := append([]primitive.ObjectID{}, itemIDs...)
+		for _, level := range levels {
+			expected[level.ItemID] += level.Quantity
+			ids = append(ids, level.ItemID)
+		}
+		filter["_id"] = bson.M{"$in": ids}
+	} else if len(itemIDs) > 0 {
+		filter["_id"] = bson.M{"$in": itemIDs}
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: "name", Value: 1}, {Key: "_id", Value: 1}})
+	resp, err := s.stock.itemColl(kind).Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	var items []struct {
+		ID       primitive.ObjectID `bson:"_id"`
+		Name     string             `bson:"name"`
+		Quantity float64            `bson:"quantity"`
+	}
+	if err := resp.All(ctx, &items); err != nil {
+		return nil, err
+	}
+
+	lines := make([]types.StocktakeLine, 0, len(items))
+	for _, item := range items {
+		line := types.StocktakeLine{
+			ItemKind: kind,
+			ItemID:   item.ID,
+			Name:     item.Name,
+			Expected: item.Quantity,
+			Counts:   []types.StocktakeCount{},
+		}
+		if loc != nil {
+			line.Expected = expected[item.ID]
+		}
+		lines = append(lines, line)
+	}
+	return lines, nil
+}
+
+// UpdateStocktakeLines changes an open stocktake through update, retrying when a concurrent
+// count changed it in between. It returns ErrStocktakeClosed when the stocktake is no longer
+// open.
+func (s *MongoStocktakeStore) UpdateStocktakeLines(ctx context.Context, id primitive.ObjectID, update func(*types.Stocktake) error) (*types.Stocktake, error) {
+	for attempt := 0; ; attempt++ {
+		stocktake, err := s.GetStocktake(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if stocktake.Status != types.StocktakeOpen {
+			return nil, ErrStocktakeClosed
+		}
+		if err := update(stocktake); err != nil {
+			return nil, err
+		}
+
+		filter := versionFilter(id, stocktake.Version)
+		filter["status"] = types.StocktakeOpen
+		res, err := s.coll.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"lines": stocktake.Lines}, "$inc": bumpVersion})
+		if err != nil {
+			return nil, err
+		}
+		if res.MatchedCount > 0 {
+			stocktake.Version++
+			return stocktake, nil
+		}
+		if attempt == stocktakeRetries {
+			return nil, ErrVersionConflict
+		}
+	}
+}
+
+// PostStocktake adjusts stock by the variances of the counted lines, recording a movement for
+// each in the stock ledger, and closes the stocktake, all or nothing. Variances are applied to
+// the stock as it is now, so what moved in or out during the count is kept. It returns
+// ErrVersionConflict when the stocktake changed after version, ErrStocktakeClosed when it is
+// no longer open and ErrInsufficientStock when stock taken out since the count leaves too
+// little to adjust.
+func (s *MongoStocktakeStore) PostStocktake(ctx context.Context, id primitive.ObjectID, version int64, opts PostStocktakeOptions) (*types.Stocktake, error) {
+	var stocktake *types.Stocktake
+	err := withTransaction(ctx, s.client, func(ctx context.Context) error {
+		var err error
+		if stocktake, err = s.GetStocktake(ctx, id); err != nil {
+			return err
+		}
+		if stocktake.Version != version {
+			return ErrVersionConflict
+		}
+		if stocktake.Status != types.StocktakeOpen {
+			return ErrStocktakeClosed
+		}
+
+		now := time.Now()
+		for i := range stocktake.Lines {
+			line := &stocktake.Lines[i]
+			if line.Counted == nil {
+				if !opts.UncountedAsZero {
+					continue
+				}
+				line.Counts = append(line.Counts, types.StocktakeCount{CountedBy: opts.By, Remark: "not counted", CountedAt: now})
+				line.Recount()
+			}
+			if reason, ok := opts.LineReasons[line.ItemID]; ok {
+				line.Reason = reason
+			}
+			if line.Variance > -stockEpsilon && line.Variance < stockEpsilon {
+				continue
+			}
+
+			movement := &types.StockMovement{
+				ItemKind:     line.ItemKind,
+				ItemID:       line.ItemID,
+				Name:         line.Name,
+				Location:     stocktake.Location,
+				Quantity:     line.Variance,
+				Reason:       line.Reason,
+				Source:       types.StockSourceStocktake,
+				SourceID:     stocktake.ID,
+				SourceNumber: stocktake.Number,
+				Remark:       stocktake.Remark,
+				CreatedBy:    opts.By,
+				CreatedAt:    now,
+			}
+			if movement.Reason == "" {
+				movement.Reason = opts.Reason
+			}
+			if err := s.stock.adjust(ctx, movement); err != nil {
+				return err
+			}
+		}
+
+		stocktake.Status = types.StocktakePosted
+		stocktake.Reason = opts.Reason
+		stocktake.PostedBy = &opts.By
+		stocktake.PostedAt = &now
+		update := bson.M{"$set": bson.M{
+			"lines":    stocktake.Lines,
+			"status":   stocktake.Status,
+			"reason":   opts.Reason,
+			"postedBy": opts.By,
+			"postedAt": now,
+		}}
+		if _, err := updateVersioned(ctx, s.coll, id, version, update); err != nil {
+			return err
+		}
+		stocktake.Version++
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return stocktake, nil
+}
+
+// CancelStocktake closes an open stocktake without changing stock. It returns
+// ErrStocktakeClosed when it is no longer open, and 0 when there is no such stocktake.
+func (s *MongoStocktakeStore) CancelStocktake(ctx context.Context, id primitive.ObjectID) (int64, error) {
+	res, err := s.coll.UpdateOne(ctx,
+		bson.M{"_id": id, "status": types.StocktakeOpen},
+		bson.M{"$set": bson.M{"status": types.StocktakeCanceled}, "$inc": bumpVersion})
+	if err != nil {
+		return 0, err
+	}
+	if res.MatchedCount > 0 {
+		return res.MatchedCount, nil
+	}
+
+	count, err := s.coll.CountDocuments(ctx, bson.M{"_id": id})
+	if err != nil {
+		return 0, err
+	}
+	if count > 0 {
+		return 0, ErrStocktakeClosed
+	}
+	return 0, nil
+}
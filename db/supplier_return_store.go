@@ -0,0 +1,400 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/johnson7543/ims/types"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const supplierReturnColl = "supplier_returns"
+
+var (
+	// ErrMaterialOrderNotCompleted is returned when returning material of an order that was not
+	// received.
+	ErrMaterialOrderNotCompleted = errors.New("material order is not completed")
+	// ErrOverSupplierReturn is returned when a return sends back more of a material order line
+	// than was received and not returned already, or receives more replacement than is due.
+	ErrOverSupplierReturn = errors.New("return exceeds what can be returned")
+	// ErrSupplierReturnClosed is returned when receiving a replacement for a closed return.
+	ErrSupplierReturnClosed = errors.New("supplier return is closed")
+	// ErrNoRefundDue is returned when recording a refund for a return that has none due.
+	ErrNoRefundDue = errors.New("no refund is due for the return")
+)
+
+// SupplierReturnStore keeps the returns of defective material to sellers. Recording a return
+// takes the material out of stock and credits the seller's material order or asks for a
+// refund; replacement deliveries put it back as new lots.
+type SupplierReturnStore interface {
+	GetSupplierReturns(ctx context.Context, filter bson.M) ([]*types.SupplierReturn, error)
+	GetSupplierReturn(context.Context, primitive.ObjectID) (*types.SupplierReturn, error)
+	InsertSupplierReturn(context.Context, *types.SupplierReturn) (*types.SupplierReturn, error)
+	ReceiveReplacement(ctx context.Context, id primitive.ObjectID, version int64, replacements []ReplacementInput) (*types.SupplierReturn, error)
+	MarkRefundReceived(ctx context.Context, id primitive.ObjectID, version int64, receivedAt time.Time) (int64, error)
+	GetSellerBalance(ctx context.Context, sellerID string) (*types.SellerBalance, error)
+}
+
+// ReplacementInput is a replacement delivery for a line of a return, which Line picks by the
+// material order line it returns.
+type ReplacementInput struct {
+	Line        int
+	Replacement types.SupplierReplacement
+}
+
+type MongoSupplierReturnStore struct {
+	client         *mongo.Client
+	database       *mongo.Database
+	coll           *mongo.Collection
+	materialOrders *mongo.Collection
+	lots           *MongoLotStore
+	stock          *MongoStockStore
+}
+
+func NewMongoSupplierReturnStore(client *mongo.Client) *MongoSupplierReturnStore {
+	dbName := os.Getenv(MongoDBNameEnvName)
+	database := client.Database(dbName)
+	return &MongoSupplierReturnStore{
+		client:         client,
+		database:       database,
+		coll:           database.Collection(supplierReturnColl),
+		materialOrders: database.Collection(materialOrderColl),
+		lots:           NewMongoLotStore(client),
+		stock:          NewMongoStockStore(client),
+	}
+}
+
+// GetSupplierReturns returns the returns matching filter, newest first. Unlike the other list
+// queries the filter values match exactly.
+func (s *MongoSupplierReturnStore) GetSupplierReturns(ctx context.Context, filter bson.M) ([]*types.SupplierReturn, error) {
+	opts := options.Find().SetSort(bson.D{{Key: "createdAt", Value: -1}, {Key: "_id", Value: -1}})
+	resp, err := s.coll.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	returns := []*types.SupplierReturn{}
+	if err := resp.All(ctx, &returns); err != nil {
+		return nil, err
+	}
+
+	return returns, nil
+}
+
+func (s *MongoSupplierReturnStore) GetSupplierReturn(ctx context.Context, id primitive.ObjectID) (*types.SupplierReturn, error) {
+	var ret types.SupplierReturn
+	if err := s.coll.FindOne(ctx, bson.M{"_id": id}).Decode(&ret); err != nil {
+		return nil, err
+	}
+
+	return &ret, nil
+}
+
+// InsertSupplierReturn sends material of a completed material order back to its seller, all
+// or nothing. Every line is taken out of the lot its order line was received as and out of
+// stock through the stock ledger, and booked against the order line. Lines to be credited are
+// priced at what the order paid for them: the credit is taken off the order while it is unpaid
+// and becomes a refund due from the seller once it was paid. The seller and material details
+// are copied from the order. It returns mongo.ErrNoDocuments when there is no such order,
+// ErrMaterialOrderNotCompleted when it was not received, ErrOverSupplierReturn when a line is
+// not in the order or has less left to return and ErrInsufficientStock when the material is no
+// longer in stock.
+func (s *MongoSupplierReturnStore) InsertSupplierReturn(ctx context.Context, ret *types.SupplierReturn) (*types.SupplierReturn, error) {
+	err := withTransaction(ctx, s.client, func(ctx context.Context) error {
+		var order types.MaterialOrder
+		if err := s.materialOrders.FindOne(ctx, bson.M{"_id": ret.MaterialOrderID, archivedField: notArchived}).Decode(&order); err != nil {
+			return err
+		}
+		if !strings.EqualFold(order.Status, types.MaterialOrderCompleted) {
+			return ErrMaterialOrderNotCompleted
+		}
+
+		seq, err := nextSequence(ctx, s.database, "supplierReturn")
+		if err != nil {
+			return err
+		}
+		ret.ID = primitive.NewObjectID()
+		ret.Number = fmt.Sprintf("SR%06d", seq)
+		if ret.Location == nil {
+			ret.Location = order.Location
+		}
+
+		ret.Credit = 0
+		for i := range ret.Lines {
+			line := &ret.Lines[i]
+			if line.Line < 0 || line.Line >= len(order.MaterialOrderItems) {
+				return ErrOverSupplierReturn
+			}
+			item := &order.MaterialOrderItems[line.Line]
+			if item.Returned+line.Quantity > item.StockQuantity()+stockEpsilon {
+				return ErrOverSupplierReturn
+			}
+			item.Returned += line.Quantity
+
+			line.MaterialID = item.Material.MaterialID
+			line.Name = item.Material.Name
+			line.Replacements = []types.SupplierReplacement{}
+			if err := s.takeFromLot(ctx, order.ID, item, line); err != nil {
+				return err
+			}
+
+			movement := &types.StockMovement{
+				ItemKind:     types.StockMaterial,
+				ItemID:       line.MaterialID,
+				Name:         line.Name,
+				Location:     ret.Location,
+				Quantity:     -line.Quantity,
+				Reason:       line.Reason,
+				Source:       types.StockSourceSupplierReturn,
+				SourceID:     ret.ID,
+				SourceNumber: ret.Number,
+				Remark:       line.Remark,
+				CreatedBy:    ret.CreatedBy,
+				CreatedAt:    ret.CreatedAt,
+			}
+			if err := s.stock.adjust(ctx, movement); err != nil {
+				return err
+			}
+			line.MovementID = movement.ID
+
+			// The line total carries any discount given on the order, so it sets the price.
+			line.UnitPrice = item.Material.Price
+			if quantity := item.StockQuantity(); quantity > 0 {
+				line.UnitPrice = item.TotalPrice / quantity
+			}
+			line.Amount = 0
+			if line.Resolution == types.SupplierReturnCredit {
+				line.Amount = math.Round(line.UnitPrice*line.Quantity*100) / 100
+				ret.Credit += line.Amount
+			}
+		}
+		ret.Credit = math.Round(ret.Credit*100) / 100
+
+		set := bson.M{"materialOrderItems": order.MaterialOrderItems}
+		ret.Settlement = ""
+		if ret.Credit > 0 {
+			if order.PaymentDate.After(time.Time{}) {
+				ret.Settlement = types.SupplierSettlementRefund
+			} else {
+				ret.Settlement = types.SupplierSettlementOffset
+				set["credited"] = math.Round((order.Credited+ret.Credit)*100) / 100
+			}
+		}
+		if _, err := updateVersioned(ctx, s.materialOrders, order.ID, order.Version, bson.M{"$set": set}); err != nil {
+			return err
+		}
+
+		ret.SellerID = order.SellerID
+		ret.SellerName = order.SellerName
+		ret.Status = replacementStatus(ret)
+		ret.Version = 1
+		_, err = s.coll.InsertOne(ctx, ret)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ret, nil
+}
+
+// takeFromLot draws the line's quantity from the lot its material order line was received
+// as. Orders received before lots were tracked have none, and only their stock changes.
+func (s *MongoSupplierReturnStore) takeFromLot(ctx context.Context, orderID primitive.ObjectID, item *types.MaterialOrderItem, line *types.SupplierReturnLine) error {
+	filter := bson.M{"materialOrderId": orderID, "materialId": item.Material.MaterialID, "canceled": bson.M{"$ne": true}}
+	if item.LotNumber != "" {
+		filter["lotNumber"] = item.LotNumber
+	}
+	count, err := s.lots.coll.CountDocuments(ctx, filter)
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return nil
+	}
+
+	filter["remaining"] = bson.M{"$gte": line.Quantity - stockEpsilon}
+	var lot types.MaterialLot
+	err = s.lots.coll.FindOneAndUpdate(ctx, filter, bson.M{"$inc": bson.M{"remaining": -line.Quantity}}).Decode(&lot)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return ErrInsufficientStock
+	}
+	if err != nil {
+		return err
+	}
+	line.LotID = &lot.ID
+	line.LotNumber = lot.LotNumber
+	return nil
+}
+
+// ReceiveReplacement records replacement deliveries for lines of a return, all or nothing.
+// Each is received as a new lot of the material order and put into stock through the stock
+// ledger; the return closes once every line to be replaced has been delivered again. It
+// returns ErrVersionConflict when the return changed after version, ErrSupplierReturnClosed
+// when it is closed, ErrOverSupplierReturn when a line is not being replaced or gets more than
+// is left, and ErrLotExists when the material already has the lot number.
+func (s *MongoSupplierReturnStore) ReceiveReplacement(ctx context.Context, id primitive.ObjectID, version int64, replacements []ReplacementInput) (*types.SupplierReturn, error) {
+	var ret *types.SupplierReturn
+	err := withTransaction(ctx, s.client, func(ctx context.Context) error {
+		var err error
+		if ret, err = s.GetSupplierReturn(ctx, id); err != nil {
+			return err
+		}
+		if ret.Version != version {
+			return ErrVersionConflict
+		}
+		if ret.Status != types.SupplierReturnOpen {
+			return ErrSupplierReturnClosed
+		}
+
+		for _, input := range replacements {
+			line := ret.FindLine(input.Line)
+			replacement := input.Replacement
+			if line == nil || line.Resolution != types.SupplierReturnReplacement || line.Replaced+replacement.Quantity > line.Quantity+stockEpsilon {
+				return ErrOverSupplierReturn
+			}
+
+			lot := &types.MaterialLot{
+				MaterialID:      line.MaterialID,
+				LotNumber:       replacement.LotNumber,
+				MaterialOrderID: &ret.MaterialOrderID,
+				ReceivedAt:      replacement.ReceivedAt,
+				Quantity:        replacement.Quantity,
+				Remark:          fmt.Sprintf("Replacement for %s", ret.Number),
+			}
+			if err := s.lots.receive(ctx, lot); err != nil {
+				return err
+			}
+			replacement.LotID = lot.ID
+			replacement.LotNumber = lot.LotNumber
+
+			movement := &types.StockMovement{
+				ItemKind:     types.StockMaterial,
+				ItemID:       line.MaterialID,
+				Name:         line.Name,
+				Location:     ret.Location,
+				Quantity:     replacement.Quantity,
+				Reason:       line.Reason,
+				Source:       types.StockSourceSupplierReturn,
+				SourceID:     ret.ID,
+				SourceNumber: ret.Number,
+				Remark:       replacement.Remark,
+				CreatedBy:    replacement.ReceivedBy,
+				CreatedAt:    replacement.ReceivedAt,
+			}
+			if err := s.stock.adjust(ctx, movement); err != nil {
+				return err
+			}
+			replacement.MovementID = movement.ID
+
+			line.Replacements = append(line.Replacements, replacement)
+			line.Replaced += replacement.Quantity
+		}
+
+		ret.Status = replacementStatus(ret)
+		update := bson.M{"$set": bson.M{"lines": ret.Lines, "status": ret.Status}}
+		if _, err := updateVersioned(ctx, s.coll, id, version, update); err != nil {
+			return err
+		}
+		ret.Version++
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ret, nil
+}
+
+// replacementStatus is open while a line to be replaced has not been delivered again in full.
+func replacementStatus(ret *types.SupplierReturn) string {
+	for _, line := range ret.Lines {
+		if line.Resolution == types.SupplierReturnReplacement && line.Replaced < line.Quantity-stockEpsilon {
+			return types.SupplierReturnOpen
+		}
+	}
+	return types.SupplierReturnClosed
+}
+
+// MarkRefundReceived records that the seller paid back the credit of a return of a paid
+// order. It returns ErrNoRefundDue when the return has no refund due or it was received
+// already, and 0 when there is no such return or it changed after version.
+func (s *MongoSupplierReturnStore) MarkRefundReceived(ctx context.Context, id primitive.ObjectID, version int64, receivedAt time.Time) (int64, error) {
+	ret, err := s.GetSupplierReturn(ctx, id)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if ret.Settlement != types.SupplierSettlementRefund || ret.RefundReceivedAt != nil {
+		return 0, ErrNoRefundDue
+	}
+
+	return updateVersioned(ctx, s.coll, id, version, bson.M{"$set": bson.M{"refundReceivedAt": receivedAt}})
+}
+
+// GetSellerBalance sums what is owed between the business and a seller: its live, unpaid
+// material orders less what returns took off them, and the refunds of returns it has not paid
+// back.
+func (s *MongoSupplierReturnStore) GetSellerBalance(ctx context.Context, sellerID string) (*types.SellerBalance, error) {
+	balance := &types.SellerBalance{SellerID: sellerID}
+
+	unpaid, err := sumCountTotal(ctx, s.materialOrders, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"$and": bson.A{
+			bson.M{"sellerId": sellerID, archivedField: notArchived, "status": notCanceled},
+			unsetDate("paymentDate"),
+		}}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   nil,
+			"count": bson.M{"$sum": 1},
+			"total": bson.M{"$sum": payableAmount},
+		}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	balance.UnpaidOrders = unpaid.Count
+	balance.Payable = math.Round(unpaid.Total*100) / 100
+
+	refunds, err := sumCountTotal(ctx, s.coll, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"sellerId":         sellerID,
+			"settlement":       types.SupplierSettlementRefund,
+			"refundReceivedAt": bson.M{"$exists": false},
+		}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":   nil,
+			"count": bson.M{"$sum": 1},
+			"total": bson.M{"$sum": "$credit"},
+		}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	balance.RefundsDue = math.Round(refunds.Total*100) / 100
+	balance.Balance = math.Round((balance.Payable-balance.RefundsDue)*100) / 100
+
+	return balance, nil
+}
+
+// payableAmount is what is owed for a material order: its total less what returns credited.
+var payableAmount = bson.M{"$subtract": bson.A{"$totalAmount", bson.M{"$ifNull": bson.A{"$credited", 0}}}}
+
+// sumCountTotal runs a pipeline grouping into a single countTotal row.
+func sumCountTotal(ctx context.Context, coll *mongo.Collection, pipeline mongo.Pipeline) (countTotal, error) {
+	rows, err := aggregate[countTotal](ctx, coll, pipeline)
+	if err != nil || len(rows) == 0 {
+		return countTotal{}, err
+	}
+	return *rows[0], nil
+}
@@ -0,0 +1,54 @@
+package db
+
+import (
+	"context"
+	"errors"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ErrVersionConflict is returned by a versioned update when the document changed after the
+// caller read it.
+var ErrVersionConflict = errors.New("document was changed by someone else")
+
+// versionField counts the writes to a document. Every update increments it, so a client that
+// sends back the version it read can tell whether its copy is still current.
+const versionField = "version"
+
+// bumpVersion is the increment every update applies alongside its own changes.
+var bumpVersion = bson.M{versionField: 1}
+
+// versionFilter matches the document with id at the given version. Documents written before
+// versioning have no version field and count as version 0.
+func versionFilter(id primitive.ObjectID, version int64) bson.M {
+	if version == 0 {
+		return bson.M{"_id": id, versionField: bson.M{"$in": bson.A{0, nil}}}
+	}
+	return bson.M{"_id": id, versionField: version}
+}
+
+// updateVersioned applies update to the document with id only if it is still at version,
+// and increments the version. It returns ErrVersionConflict when the document exists at
+// another version, and 0 when there is no such document.
+func updateVersioned(ctx context.Context, coll *mongo.Collection, id primitive.ObjectID, version int64, update bson.M) (int64, error) {
+	update["$inc"] = bumpVersion
+
+	res, err := coll.UpdateOne(ctx, versionFilter(id, version), update)
+	if err != nil {
+		return 0, err
+	}
+	if res.MatchedCount > 0 {
+		return res.MatchedCount, nil
+	}
+
+	count, err := coll.CountDocuments(ctx, bson.M{"_id": id})
+	if err != nil {
+		return 0, err
+	}
+	if count > 0 {
+		return 0, ErrVersionConflict
+	}
+	return 0, nil
+}
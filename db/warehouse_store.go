@@ -0,0 +1,147 @@
+package db
+
+import (
+	"context"
+	"os"
+
+	"github.com/johnson7543/ims/types"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const warehouseColl = "warehouses"
+
+type WarehouseStore interface {
+	GetWarehouses(context.Context, bson.M) ([]*types.Warehouse, error)
+	GetWarehouse(context.Context, primitive.ObjectID) (*types.Warehouse, error)
+	GetDefaultWarehouse(context.Context) (*types.Warehouse, error)
+	InsertWarehouse(context.Context, *types.Warehouse) (*types.Warehouse, error)
+	UpdateWarehouse(ctx context.Context, id primitive.ObjectID, warehouse *types.Warehouse, version int64) (int64, error)
+	DeleteWarehouse(ctx context.Context, id primitive.ObjectID, opts DeleteOptions) (int64, error)
+	RestoreWarehouse(ctx context.Context, id primitive.ObjectID) (int64, error)
+}
+
+type MongoWarehouseStore struct {
+	client *mongo.Client
+	coll   *mongo.Collection
+}
+
+func NewMongoWarehouseStore(client *mongo.Client) *MongoWarehouseStore {
+	dbName := os.Getenv(MongoDBNameEnvName)
+	return &MongoWarehouseStore{
+		client: client,
+		coll:   client.Database(dbName).Collection(warehouseColl),
+	}
+}
+
+func (s *MongoWarehouseStore) GetWarehouses(ctx context.Context, filter bson.M) ([]*types.Warehouse, error) {
+	resp, err := s.coll.Find(ctx, matchFilter(liveFilter(filter)))
+	if err != nil {
+		return nil, err
+	}
+
+	var warehouses []*types.Warehouse
+	if err := resp.All(ctx, &warehouses); err != nil {
+		return nil, err
+	}
+
+	return warehouses, nil
+}
+
+func (s *MongoWarehouseStore) GetWarehouse(ctx context.Context, id primitive.ObjectID) (*types.Warehouse, error) {
+	var warehouse types.Warehouse
+	if err := s.coll.FindOne(ctx, liveID(ctx, id)).Decode(&warehouse); err != nil {
+		return nil, err
+	}
+
+	return &warehouse, nil
+}
+
+// GetDefaultWarehouse returns the live default warehouse, or nil when none is marked.
+func (s *MongoWarehouseStore) GetDefaultWarehouse(ctx context.Context) (*types.Warehouse, error) {
+	var warehouse types.Warehouse
+	err := s.coll.FindOne(ctx, bson.M{"default": true, archivedField: notArchived}).Decode(&warehouse)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &warehouse, nil
+}
+
+// InsertWarehouse adds a warehouse. A new default warehouse takes the mark from the previous one.
+func (s *MongoWarehouseStore) InsertWarehouse(ctx context.Context, warehouse *types.Warehouse) (*types.Warehouse, error) {
+	warehouse.Version = 1
+	err := withTransaction(ctx, s.client, func(ctx context.Context) error {
+		if warehouse.Default {
+			if err := s.clearDefault(ctx, primitive.NilObjectID); err != nil {
+				return err
+			}
+		}
+		resp, err := s.coll.InsertOne(ctx, warehouse)
+		if err != nil {
+			return err
+		}
+		warehouse.ID = resp.InsertedID.(primitive.ObjectID)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return warehouse, nil
+}
+
+func (s *MongoWarehouseStore) UpdateWarehouse(ctx context.Context, id primitive.ObjectID, warehouse *types.Warehouse, version int64) (int64, error) {
+	update := bson.M{
+		"$set": bson.M{
+			"code":    warehouse.Code,
+			"name":    warehouse.Name,
+			"address": warehouse.Address,
+			"bins":    warehouse.Bins,
+			"default": warehouse.Default,
+			"remark":  warehouse.Remark,
+		},
+	}
+
+	var updated int64
+	err := withTransaction(ctx, s.client, func(ctx context.Context) error {
+		if warehouse.Default {
+			if err := s.clearDefault(ctx, id); err != nil {
+				return err
+			}
+		}
+		var err error
+		updated, err = updateVersioned(ctx, s.coll, id, version, update)
+		return err
+	})
+	return updated, err
+}
+
+// clearDefault takes the default mark off every warehouse but the one with id.
+func (s *MongoWarehouseStore) clearDefault(ctx context.Context, id primitive.ObjectID) error {
+	filter := bson.M{"default": true, "_id": bson.M{"$ne": id}}
+	_, err := s.coll.UpdateMany(ctx, filter, bson.M{"$set": bson.M{"default": false}, "$inc": bumpVersion})
+	return err
+}
+
+// DeleteWarehouse archives a warehouse that holds no stock.
+func (s *MongoWarehouseStore) DeleteWarehouse(ctx context.Context, id primitive.ObjectID, opts DeleteOptions) (int64, error) {
+	return archiveReferenced(ctx, s.client, s.coll, id, opts, warehouseReferences(id))
+}
+
+func (s *MongoWarehouseStore) RestoreWarehouse(ctx context.Context, id primitive.ObjectID) (int64, error) {
+	return restoreArchived(ctx, s.coll, id)
+}
+
+// warehouseReferences are the stock levels of the warehouse. Levels are removed when they run
+// out, so only locations still holding stock refer to it.
+func warehouseReferences(id primitive.ObjectID) []reference {
+	return []reference{
+		{coll: stockLevelColl, field: "warehouseId", value: id},
+	}
+}
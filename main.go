@@ -4,9 +4,11 @@ import (
 	"context"
 	"log"
 	"os"
+	"time"
 
 	"github.com/johnson7543/ims/api"
 	"github.com/johnson7543/ims/db"
+	"github.com/johnson7543/ims/types"
 
 	_ "github.com/johnson7543/ims/docs"
 
@@ -46,48 +48,87 @@ func main() {
 		userStore           = db.NewMongoUserStore(client)
 		materialStore       = db.NewMongoMaterialStore(client)
 		materialOrderStore  = db.NewMongoMaterialOrderStore(client)
-		workerStore         = db.NewMongoWorkerStore(client)
-		customerStore       = db.NewMongoCustomerStore(client)
-		buyerStore          = db.NewMongoBuyerStore(client)
-		sellerStore         = db.NewMongoSellerStore(client)
+		partyStore          = db.NewMongoPartyStore(client)
 		porcessingItemStore = db.NewMongoProcessingItemStore(client)
 		productStore        = db.NewMongoProductStore(client)
+		productParentStore  = db.NewMongoProductParentStore(client)
 		orderStore          = db.NewMongoOrderStore(client)
+		productCostStore    = db.NewMongoProductCostStore(client)
+		reportStore         = db.NewMongoReportStore(client)
+		dashboardStore      = db.NewMongoDashboardStore(client)
+		importJobStore      = db.NewMongoImportJobStore(client)
+		archiveStore        = db.NewMongoArchiveStore(client)
+		idempotencyStore    = db.NewMongoIdempotencyStore(client)
+		lookupStore         = db.NewMongoLookupStore(client)
+		warehouseStore      = db.NewMongoWarehouseStore(client)
+		stockStore          = db.NewMongoStockStore(client)
+		lotStore            = db.NewMongoLotStore(client)
+		stocktakeStore      = db.NewMongoStocktakeStore(client)
+		shipmentStore       = db.NewMongoShipmentStore(client)
+		customerReturnStore = db.NewMongoCustomerReturnStore(client)
+		supplierReturnStore = db.NewMongoSupplierReturnStore(client)
 		store               = &db.Store{
 			HealthCheck:    healthCheckStore,
 			User:           userStore,
 			Material:       materialStore,
 			MaterialOrder:  materialOrderStore,
-			Worker:         workerStore,
-			Customer:       customerStore,
-			Buyer:          buyerStore,
-			Seller:         sellerStore,
+			Party:          partyStore,
 			ProcessingItem: porcessingItemStore,
 			Product:        productStore,
+			ProductParent:  productParentStore,
 			Order:          orderStore,
+			ProductCost:    productCostStore,
+			Report:         reportStore,
+			Dashboard:      dashboardStore,
+			ImportJob:      importJobStore,
+			Archive:        archiveStore,
+			Idempotency:    idempotencyStore,
+			Lookup:         lookupStore,
+			Warehouse:      warehouseStore,
+			Stock:          stockStore,
+			Lot:            lotStore,
+			Stocktake:      stocktakeStore,
+			Shipment:       shipmentStore,
+			CustomerReturn: customerReturnStore,
+			SupplierReturn: supplierReturnStore,
 		}
 		HealthCheckHandler    = api.NewHealthCheckHandler(store)
 		authHandler           = api.NewAuthHandler(store)
 		materialHandler       = api.NewMaterialHandler(store)
 		materialOrderHandler  = api.NewMaterialOrderHandler(store)
-		workerHandler         = api.NewWorkerHandler(store)
-		customerHandler       = api.NewCustomerHandler(store)
-		buyerHandler          = api.NewBuyerHandler(store)
-		sellerHandler         = api.NewSellerHandler(store)
+		partyHandler          = api.NewPartyHandler(store)
 		processingItemHandler = api.NewProcessingItemHandler(store)
 		productHandler        = api.NewProductHandler(store)
+		productParentHandler  = api.NewProductParentHandler(store)
 		orderHandler          = api.NewOrderHandler(store)
+		productCostHandler    = api.NewProductCostHandler(store)
+		reportHandler         = api.NewReportHandler(store)
+		dashboardHandler      = api.NewDashboardHandler(store)
+		importHandler         = api.NewImportHandler(store)
+		archiveHandler        = api.NewArchiveHandler(store)
+		lookupHandler         = api.NewLookupHandler(store)
+		warehouseHandler      = api.NewWarehouseHandler(store)
+		stockHandler          = api.NewStockHandler(store)
+		lotHandler            = api.NewLotHandler(store)
+		labelHandler          = api.NewLabelHandler(store)
+		stocktakeHandler      = api.NewStocktakeHandler(store)
+		shipmentHandler       = api.NewShipmentHandler(store)
+		customerReturnHandler = api.NewCustomerReturnHandler(store)
+		supplierReturnHandler = api.NewSupplierReturnHandler(store)
 		app                   = fiber.New(config)
-		homePage              = app.Group("/")
-		healthCheck           = app.Group("/health")
-		auth                  = app.Group("/api")
-		apiv1                 = app.Group("/api/v1", api.JWTAuthentication(userStore))
 	)
 
+	if err := idempotencyStore.EnsureIdempotencyTTL(context.TODO(), api.IdempotencyTTL()); err != nil {
+		log.Fatal(err)
+	}
+
+	app.Use(api.RequestID())
+	app.Use(api.Recover())
 	app.Use(cors.New(cors.Config{
 		AllowOrigins:     "*",
 		AllowMethods:     "GET,POST,PUT,PATCH,DELETE,OPTIONS",
-		AllowHeaders:     "X-Api-Token,Origin,Content-Type,Accept,Content-Length,Accept-Language,Accept-Encoding,Connection,Access-Control-Allow-Origin",
+		AllowHeaders:     "X-Api-Token,Origin,Content-Type,Accept,Content-Length,Accept-Language,Accept-Encoding,Connection,Access-Control-Allow-Origin,If-Match,X-Request-ID,Idempotency-Key",
+		ExposeHeaders:    "ETag,X-Request-ID,Idempotent-Replayed",
 		AllowCredentials: true,
 	}))
 
@@ -95,6 +136,17 @@ func main() {
 		return c.SendStatus(fiber.StatusNoContent)
 	})
 
+	// Groups with handlers register them as middleware, so they are created after the app
+	// wide middleware: authentication and idempotency failures then carry a request ID too,
+	// and panics in them are recovered.
+	var (
+		homePage    = app.Group("/")
+		healthCheck = app.Group("/health")
+		auth        = app.Group("/api")
+		apiv1       = app.Group("/api/v1", api.JWTAuthentication(userStore), api.Idempotency(idempotencyStore))
+		admin       = apiv1.Group("/admin", api.AdminAuth)
+	)
+
 	homePage.Get("/", HealthCheckHandler.HandleHealthCheck)
 	healthCheck.Get("/", HealthCheckHandler.HandleHealthCheck)
 
@@ -104,54 +156,158 @@ func main() {
 	apiv1.Post("/material", materialHandler.HandleInsertMaterial)
 	apiv1.Patch("/material/:id", materialHandler.HandleUpdateMaterial)
 	apiv1.Delete("/material/:id", materialHandler.HandleDeleteMaterial)
+	apiv1.Post("/material/:id/restore", materialHandler.HandleRestoreMaterial)
 	apiv1.Get("/material/colors", materialHandler.HandleGetMaterialColors)
 	apiv1.Get("/material/types", materialHandler.HandleGetMaterialTypes)
 	apiv1.Get("/material/sizes", materialHandler.HandleGetMaterialSizes)
+	apiv1.Get("/material/:id", materialHandler.HandleGetMaterial)
+	apiv1.Post("/material/:id/consume", lotHandler.HandleConsumeMaterial)
+	apiv1.Get("/material/:id/barcode", labelHandler.HandleGetMaterialBarcode)
 
 	apiv1.Get("/materialOrder", materialOrderHandler.HandleGetMaterialOrders)
+	apiv1.Get("/materialOrder/:id", materialOrderHandler.HandleGetMaterialOrder)
 	apiv1.Post("/materialOrder", materialOrderHandler.HandleInsertMaterialOrder)
 	apiv1.Patch("/materialOrder/:id", materialOrderHandler.HandleUpdateMaterialOrder)
 	apiv1.Delete("/materialOrder/:id", materialOrderHandler.HandleDeleteMaterialOrder)
+	apiv1.Post("/materialOrder/:id/restore", materialOrderHandler.HandleRestoreMaterialOrder)
 	apiv1.Post("/materialOrder/materialOrderItems/:id", materialOrderHandler.HandleInsertMaterialOrderItemsToOrder)
 
-	apiv1.Get("/worker", workerHandler.HandleGetWorkers)
-	apiv1.Post("/worker", workerHandler.HandleInsertWorker)
-	apiv1.Patch("/worker/:id", workerHandler.HandleUpdateWorker)
-	apiv1.Delete("/worker/:id", workerHandler.HandleDeleteWorker)
-
-	apiv1.Get("/customer", customerHandler.HandleGetCustomers)
-	apiv1.Post("/customer", customerHandler.HandleInsertCustomer)
-	apiv1.Patch("/customer/:id", customerHandler.HandleUpdateCustomer)
-	apiv1.Delete("/customer/:id", customerHandler.HandleDeleteCustomer)
+	apiv1.Get("/party", partyHandler.HandleGetParties(""))
+	apiv1.Get("/party/:id", partyHandler.HandleGetParty(""))
+	apiv1.Post("/party", partyHandler.HandleInsertParty(""))
+	apiv1.Patch("/party/:id", partyHandler.HandleUpdateParty(""))
+	apiv1.Delete("/party/:id", partyHandler.HandleDeleteParty(""))
+	apiv1.Post("/party/:id/restore", partyHandler.HandleRestoreParty)
 
-	apiv1.Get("/buyer", buyerHandler.HandleGetBuyers)
-	apiv1.Post("/buyer", buyerHandler.HandleInsertBuyer)
-	apiv1.Patch("/buyer/:id", buyerHandler.HandleUpdateBuyer)
-	apiv1.Delete("/buyer/:id", buyerHandler.HandleDeleteBuyer)
-
-	apiv1.Get("/seller", sellerHandler.HandleGetSellers)
-	apiv1.Post("/seller", sellerHandler.HandleInsertSeller)
-	apiv1.Patch("/seller/:id", sellerHandler.HandleUpdateSeller)
-	apiv1.Delete("/seller/:id", sellerHandler.HandleDeleteSeller)
+	for _, role := range types.PartyRoles {
+		apiv1.Get("/"+role, partyHandler.HandleGetParties(role))
+		apiv1.Get("/"+role+"/:id", partyHandler.HandleGetParty(role))
+		apiv1.Post("/"+role, partyHandler.HandleInsertParty(role))
+		apiv1.Patch("/"+role+"/:id", partyHandler.HandleUpdateParty(role))
+		apiv1.Delete("/"+role+"/:id", partyHandler.HandleDeleteParty(role))
+	}
 
 	apiv1.Get("/processingItem", processingItemHandler.HandleGetProcessingItems)
+	apiv1.Get("/processingItem/:id", processingItemHandler.HandleGetProcessingItem)
 	apiv1.Post("/processingItem", processingItemHandler.HandleInsertProcessingItem)
 	apiv1.Patch("/processingItem/:id", processingItemHandler.HandleUpdateProcessingItem)
 	apiv1.Delete("/processingItem/:id", processingItemHandler.HandleDeleteProcessingItem)
+	apiv1.Post("/processingItem/:id/restore", processingItemHandler.HandleRestoreProcessingItem)
 
 	apiv1.Get("/product", productHandler.HandleGetProducts)
 	apiv1.Post("/product", productHandler.HandleInsertProduct)
 	apiv1.Patch("/product/:id", productHandler.HandleUpdateProduct)
 	apiv1.Delete("/product/:id", productHandler.HandleDeleteProduct)
+	apiv1.Post("/product/:id/restore", productHandler.HandleRestoreProduct)
 	apiv1.Get("/product/colors", productHandler.HandleGetProductColors)
 	apiv1.Get("/product/types", productHandler.HandleGetProductTypes)
 	apiv1.Get("/product/sizes", productHandler.HandleGetProductSizes)
+	apiv1.Get("/product/:id", productHandler.HandleGetProduct)
+	apiv1.Get("/product/:id/barcode", labelHandler.HandleGetProductBarcode)
+
+	apiv1.Get("/productParent", productParentHandler.HandleGetProductParents)
+	apiv1.Get("/productParent/:id", productParentHandler.HandleGetProductParent)
+	apiv1.Post("/productParent", productParentHandler.HandleInsertProductParent)
+	apiv1.Patch("/productParent/:id", productParentHandler.HandleUpdateProductParent)
+	apiv1.Delete("/productParent/:id", productParentHandler.HandleDeleteProductParent)
+	apiv1.Post("/productParent/:id/restore", productParentHandler.HandleRestoreProductParent)
+	apiv1.Post("/productParent/:id/variants", productParentHandler.HandleGenerateVariants)
 
 	apiv1.Get("/order", orderHandler.HandleGetOrders)
+	apiv1.Get("/order/:id", orderHandler.HandleGetOrder)
 	apiv1.Post("/order", orderHandler.HandleInsertOrder)
 	apiv1.Patch("/order/:id", orderHandler.HandleUpdateOrder)
 	apiv1.Delete("/order/:id", orderHandler.HandleDeleteOrder)
+	apiv1.Post("/order/:id/restore", orderHandler.HandleRestoreOrder)
 	apiv1.Post("/order/orderItems/:id", orderHandler.HandleInsertOrderItemsToOrder)
+	apiv1.Get("/order/:id/barcode", labelHandler.HandleGetOrderBarcode)
+
+	apiv1.Get("/productCost", productCostHandler.HandleGetProductCosts)
+	apiv1.Post("/productCost/rollup", productCostHandler.HandleRollUpProductCosts)
+	apiv1.Get("/productCost/margin/product", productCostHandler.HandleGetProductMargins)
+	apiv1.Get("/productCost/margin/order", productCostHandler.HandleGetOrderLineMargins)
+
+	apiv1.Get("/report/sales/customer", reportHandler.HandleGetSalesByCustomer)
+	apiv1.Get("/report/sales/product", reportHandler.HandleGetSalesByProduct)
+	apiv1.Get("/report/sales/month", reportHandler.HandleGetSalesByMonth)
+	apiv1.Get("/report/sales/top", reportHandler.HandleGetTopSellingProducts)
+	apiv1.Get("/report/orders/status", reportHandler.HandleGetOrderCountsByStatus)
+	apiv1.Get("/report/purchases/seller", reportHandler.HandleGetPurchasesBySeller)
+	apiv1.Get("/report/purchases/material", reportHandler.HandleGetPurchasesByMaterial)
+	apiv1.Get("/report/purchases/month", reportHandler.HandleGetPurchasesByMonth)
+	apiv1.Get("/report/materialOrders/status", reportHandler.HandleGetMaterialOrderCountsByStatus)
+
+	apiv1.Get("/dashboard", dashboardHandler.HandleGetDashboard)
+
+	apiv1.Post("/import/:entity", importHandler.HandleImport)
+	apiv1.Get("/import/jobs/:id", importHandler.HandleGetImportJob)
+
+	apiv1.Get("/lookup/:kind", lookupHandler.HandleGetLookups)
+
+	apiv1.Get("/warehouse", warehouseHandler.HandleGetWarehouses)
+	apiv1.Get("/warehouse/:id", warehouseHandler.HandleGetWarehouse)
+	apiv1.Post("/warehouse", warehouseHandler.HandleInsertWarehouse)
+	apiv1.Patch("/warehouse/:id", warehouseHandler.HandleUpdateWarehouse)
+	apiv1.Delete("/warehouse/:id", warehouseHandler.HandleDeleteWarehouse)
+	apiv1.Post("/warehouse/:id/restore", warehouseHandler.HandleRestoreWarehouse)
+
+	apiv1.Get("/stock", stockHandler.HandleGetStockLevels)
+	apiv1.Get("/stock/:kind/:id", stockHandler.HandleGetStockBreakdown)
+	apiv1.Get("/stockTransfer", stockHandler.HandleGetStockTransfers)
+	apiv1.Get("/stockTransfer/:id", stockHandler.HandleGetStockTransfer)
+	apiv1.Post("/stockTransfer", stockHandler.HandleInsertStockTransfer)
+	apiv1.Get("/stockMovement", stockHandler.HandleGetStockMovements)
+
+	apiv1.Get("/stocktake", stocktakeHandler.HandleGetStocktakes)
+	apiv1.Get("/stocktake/:id", stocktakeHandler.HandleGetStocktake)
+	apiv1.Post("/stocktake", stocktakeHandler.HandleInsertStocktake)
+	apiv1.Post("/stocktake/:id/count", stocktakeHandler.HandleRecordStocktakeCounts)
+	apiv1.Post("/stocktake/:id/cancel", stocktakeHandler.HandleCancelStocktake)
+
+	apiv1.Get("/shipment", shipmentHandler.HandleGetShipments)
+	apiv1.Get("/shipment/:id", shipmentHandler.HandleGetShipment)
+	apiv1.Get("/shipment/:id/deliveryNote", shipmentHandler.HandleGetDeliveryNote)
+	apiv1.Post("/shipment", shipmentHandler.HandleInsertShipment)
+	apiv1.Patch("/shipment/:id", shipmentHandler.HandleUpdateShipment)
+
+	apiv1.Get("/customerReturn", customerReturnHandler.HandleGetCustomerReturns)
+	apiv1.Get("/customerReturn/:id", customerReturnHandler.HandleGetCustomerReturn)
+	apiv1.Post("/customerReturn", customerReturnHandler.HandleInsertCustomerReturn)
+	apiv1.Post("/customerReturn/:id/inspect", customerReturnHandler.HandleInspectCustomerReturn)
+	apiv1.Post("/customerReturn/:id/cancel", customerReturnHandler.HandleCancelCustomerReturn)
+	apiv1.Post("/customerReturn/:id/creditNote", customerReturnHandler.HandleIssueCreditNote)
+	apiv1.Get("/creditNote", customerReturnHandler.HandleGetCreditNotes)
+	apiv1.Get("/creditNote/:id", customerReturnHandler.HandleGetCreditNote)
+
+	apiv1.Get("/supplierReturn", supplierReturnHandler.HandleGetSupplierReturns)
+	apiv1.Get("/supplierReturn/:id", supplierReturnHandler.HandleGetSupplierReturn)
+	apiv1.Post("/supplierReturn", supplierReturnHandler.HandleInsertSupplierReturn)
+	apiv1.Post("/supplierReturn/:id/replacement", supplierReturnHandler.HandleReceiveReplacement)
+	apiv1.Post("/supplierReturn/:id/refund", supplierReturnHandler.HandleRefundReceived)
+	apiv1.Get("/seller/:id/balance", supplierReturnHandler.HandleGetSellerBalance)
+
+	apiv1.Get("/lot", lotHandler.HandleGetLots)
+	apiv1.Get("/lot/:id", lotHandler.HandleGetLot)
+	apiv1.Get("/lot/:id/trace", lotHandler.HandleTraceLot)
+	apiv1.Get("/materialConsumption", lotHandler.HandleGetConsumptions)
+
+	apiv1.Post("/label", labelHandler.HandlePrintLabels)
+	apiv1.Get("/scan/:code", labelHandler.HandleScan)
+
+	admin.Post("/purge", archiveHandler.HandlePurgeArchived)
+
+	admin.Post("/stocktake/:id/post", stocktakeHandler.HandlePostStocktake)
+
+	admin.Post("/lookup/:kind", lookupHandler.HandleInsertLookup)
+	admin.Get("/lookup/:kind/unmatched", lookupHandler.HandleGetUnmatchedLookupValues)
+	admin.Post("/lookup/:kind/merge", lookupHandler.HandleMergeLookupValues)
+	admin.Patch("/lookup/:kind/:id", lookupHandler.HandleUpdateLookup)
+	admin.Delete("/lookup/:kind/:id", lookupHandler.HandleDeleteLookup)
+	admin.Post("/lookup/:kind/:id/restore", lookupHandler.HandleRestoreLookup)
+
+	if retention, ok := api.ArchiveRetention(); ok {
+		go api.RunArchivePurge(store, retention, 24*time.Hour)
+	}
 
 	app.Get("/swagger/*", fiberSwagger.WrapHandler)
 
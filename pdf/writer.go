@@ -0,0 +1,184 @@
+// Package pdf writes the plain PDF documents the IMS prints, such as label sheets: pages of
+// filled rectangles, lines and Helvetica text. Coordinates are in points from the top left
+// corner of the page.
+package pdf
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// A4 page size in points.
+const (
+	A4Width  = 595.28
+	A4Height = 841.89
+)
+
+// Document collects pages and writes them out as one PDF file.
+type Document struct {
+	width  float64
+	height float64
+	pages  []*Page
+}
+
+// New starts a document whose pages are width by height points.
+func New(width, height float64) *Document {
+	return &Document{width: width, height: height}
+}
+
+// Page is one page of a document. Drawing operations are recorded in order.
+type Page struct {
+	height  float64
+	content bytes.Buffer
+}
+
+// AddPage appends an empty page and returns it.
+func (d *Document) AddPage() *Page {
+	p := &Page{height: d.height}
+	d.pages = append(d.pages, p)
+	return p
+}
+
+// Rect fills a black rectangle whose top left corner is at x, y.
+func (p *Page) Rect(x, y, width, height float64) {
+	fmt.Fprintf(&p.content, "%s %s %s %s re f\n", num(x), num(p.height-y-height), num(width), num(height))
+}
+
+// Line strokes a thin black line from x1, y1 to x2, y2.
+func (p *Page) Line(x1, y1, x2, y2 float64) {
+	fmt.Fprintf(&p.content, "0.5 w %s %s m %s %s l S\n", num(x1), num(p.height-y1), num(x2), num(p.height-y2))
+}
+
+// Text writes text in Helvetica with its baseline at y. Characters outside Latin-1, which the
+// standard fonts cannot show, are printed as question marks.
+func (p *Page) Text(x, y, size float64, text string) {
+	fmt.Fprintf(&p.content, "BT /F1 %s Tf %s %s Td (%s) Tj ET\n", num(size), num(x), num(p.height-y), escape(text))
+}
+
+// TextWidth estimates the width of text in Helvetica at size points, for fitting text into a
+// box. It errs on the wide side.
+func TextWidth(size float64, text string) float64 {
+	width := 0.0
+	for _, r := range text {
+		switch {
+		case r == ' ' || strings.ContainsRune("fijlrt.,:;'!|", r):
+			width += 0.3
+		case r >= 'A' && r <= 'Z' || strings.ContainsRune("mw@%", r):
+			width += 0.75
+		default:
+			width += 0.56
+		}
+	}
+	return width * size
+}
+
+// Truncate shortens text with an ellipsis so it fits width at size points.
+func Truncate(size, width float64, text string) string {
+	if TextWidth(size, text) <= width {
+		return text
+	}
+	runes := []rune(text)
+	for len(runes) > 0 && TextWidth(size, string(runes)+"...") > width {
+		runes = runes[:len(runes)-1]
+	}
+	return string(runes) + "..."
+}
+
+// WriteTo writes the document to w.
+func (d *Document) WriteTo(w io.Writer) (int64, error) {
+	cw := &countingWriter{w: bufio.NewWriter(w)}
+	offsets := []int64{}
+	object := func(body string) {
+		offsets = append(offsets, cw.n)
+		fmt.Fprintf(cw, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	cw.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+
+	// Objects 1 to 3 are the catalog, the page tree and the font; each page then takes a page
+	// object and a content stream.
+	kids := make([]string, len(d.pages))
+	for i := range d.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 4+2*i)
+	}
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.pages)))
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+
+	for i, p := range d.pages {
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %s %s] /Resources << /Font << /F1 3 0 R >> >> /Contents %d 0 R >>",
+			num(d.width), num(d.height), 5+2*i))
+
+		var stream bytes.Buffer
+		zw := zlib.NewWriter(&stream)
+		if _, err := zw.Write(p.content.Bytes()); err != nil {
+			return cw.n, err
+		}
+		if err := zw.Close(); err != nil {
+			return cw.n, err
+		}
+		object(fmt.Sprintf("<< /Length %d /Filter /FlateDecode >>\nstream\n%s\nendstream", stream.Len(), stream.Bytes()))
+	}
+
+	xref := cw.n
+	fmt.Fprintf(cw, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(cw, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(cw, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+
+	if cw.err != nil {
+		return cw.n, cw.err
+	}
+	return cw.n, cw.w.Flush()
+}
+
+type countingWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (w *countingWriter) Write(b []byte) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	n, err := w.w.Write(b)
+	w.n += int64(n)
+	w.err = err
+	return n, err
+}
+
+func (w *countingWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// num formats a coordinate to a hundredth of a point.
+func num(v float64) string {
+	return strconv.FormatFloat(math.Round(v*100)/100, 'f', -1, 64)
+}
+
+// escape encodes text as a WinAnsi string literal.
+func escape(text string) string {
+	var b strings.Builder
+	for _, r := range text {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r >= 0x20 && r < 0x7f:
+			b.WriteRune(r)
+		case r >= 0xa0 && r <= 0xff:
+			fmt.Fprintf(&b, "\\%03o", r)
+		default:
+			b.WriteByte('?')
+		}
+	}
+	return b.String()
+}
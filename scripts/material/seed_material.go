@@ -45,7 +45,7 @@ func main() {
 			Name:         fmt.Sprintf("Material_%d", i),
 			Color:        fmt.Sprintf("Color_%d", i),
 			Size:         fmt.Sprintf("Size_%d", i),
-			Quantity:     i * 10,
+			Quantity:     float64(i * 10),
 			Remarks:      fmt.Sprintf("Remarks for Material %d", i),
 			PriceHistory: []types.PriceHistoryEntry{},
 		}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/johnson7543/ims/types"
+
+	"github.com/joho/godotenv"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const partyColl = "parties"
+
+// legacyColls maps the collections that held each role before parties were introduced.
+var legacyColls = []struct {
+	name string
+	role string
+}{
+	{"customers", types.RoleCustomer},
+	{"buyers", types.RoleBuyer},
+	{"sellers", types.RoleSeller},
+	{"workers", types.RoleWorker},
+}
+
+// migrate_party merges the customers, buyers, sellers and workers collections into parties.
+// Records are the same party when their tax ID numbers match or, without a tax ID number,
+// when company and name match. The merged party keeps the first ID seen and references in
+// orders, processing items and material orders are rewritten to it. Running it again only
+// picks up records that are not yet in parties.
+func main() {
+	drop := flag.Bool("drop", false, "drop the legacy collections after migrating")
+	dryRun := flag.Bool("dry-run", false, "report the merge without writing")
+	flag.Parse()
+
+	if err := godotenv.Load(); err != nil {
+		log.Fatal(err)
+	}
+	var (
+		ctx           = context.Background()
+		mongoEndpoint = os.Getenv("MONGO_DB_URL")
+		mongoDBName   = os.Getenv("MONGO_DB_NAME")
+	)
+	serverAPI := options.ServerAPI(options.ServerAPIVersion1)
+	opts := options.Client().ApplyURI(mongoEndpoint).SetServerAPIOptions(serverAPI)
+	client, err := mongo.Connect(context.TODO(), opts)
+	if err != nil {
+		panic(err)
+	}
+	database := client.Database(mongoDBName)
+
+	var (
+		parties []*types.Party
+		byKey   = map[string]*types.Party{}
+		// remapped holds the legacy IDs that were merged into a party with another ID.
+		remapped = map[primitive.ObjectID]primitive.ObjectID{}
+	)
+
+	cursor, err := database.Collection(partyColl).Find(ctx, bson.M{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := cursor.All(ctx, &parties); err != nil {
+		log.Fatal(err)
+	}
+	existing := len(parties)
+	for _, p := range parties {
+		byKey[partyKey(p)] = p
+	}
+
+	for _, legacy := range legacyColls {
+		cursor, err := database.Collection(legacy.name).Find(ctx, bson.M{})
+		if err != nil {
+			log.Fatal(err)
+		}
+		var records []*types.Party
+		if err := cursor.All(ctx, &records); err != nil {
+			log.Fatal(err)
+		}
+
+		for _, record := range records {
+			key := partyKey(record)
+			party, ok := byKey[key]
+			if !ok {
+				record.Roles = []string{legacy.role}
+				parties = append(parties, record)
+				byKey[key] = record
+				continue
+			}
+
+			if !party.HasRole(legacy.role) {
+				party.Roles = append(party.Roles, legacy.role)
+			}
+			fillBlank(&party.Company, record.Company)
+			fillBlank(&party.Name, record.Name)
+			fillBlank(&party.Phone, record.Phone)
+			fillBlank(&party.Address, record.Address)
+			if record.ID != party.ID {
+				remapped[record.ID] = party.ID
+			}
+		}
+		fmt.Printf("%s: %d records read\n", legacy.name, len(records))
+	}
+
+	fmt.Printf("parties: %d before, %d after, %d legacy IDs merged\n", existing, len(parties), len(remapped))
+	if *dryRun {
+		return
+	}
+
+	for _, p := range parties {
+		_, err := database.Collection(partyColl).ReplaceOne(ctx, bson.M{"_id": p.ID}, p, options.Replace().SetUpsert(true))
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	for oldID, newID := range remapped {
+		rewrites := []struct {
+			coll  string
+			field string
+			from  any
+			to    any
+		}{
+			{"orders", "customerId", oldID, newID},
+			{"processing_items", "workerId", oldID, newID},
+			// Material orders store the seller ID as a hex string.
+			{"materialOrders", "sellerId", oldID.Hex(), newID.Hex()},
+		}
+		for _, r := range rewrites {
+			res, err := database.Collection(r.coll).UpdateMany(ctx, bson.M{r.field: r.from}, bson.M{"$set": bson.M{r.field: r.to}})
+			if err != nil {
+				log.Fatal(err)
+			}
+			if res.ModifiedCount > 0 {
+				fmt.Printf("%s: %d %s references moved %s -> %s\n", r.coll, res.ModifiedCount, r.field, oldID.Hex(), newID.Hex())
+			}
+		}
+	}
+
+	if *drop {
+		for _, legacy := range legacyColls {
+			if err := database.Collection(legacy.name).Drop(ctx); err != nil {
+				log.Fatal(err)
+			}
+			fmt.Println("dropped", legacy.name)
+		}
+	}
+}
+
+// partyKey identifies the same company across the legacy collections.
+func partyKey(p *types.Party) string {
+	if taxID := strings.TrimSpace(p.TaxIdNumber); taxID != "" {
+		return "tax:" + taxID
+	}
+	return "name:" + strings.ToLower(strings.TrimSpace(p.Company)) + "|" + strings.ToLower(strings.TrimSpace(p.Name))
+}
+
+func fillBlank(dst *string, value string) {
+	if *dst == "" {
+		*dst = value
+	}
+}
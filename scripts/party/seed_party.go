@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/johnson7543/ims/db"
+	"github.com/johnson7543/ims/db/fixtures"
+	"github.com/johnson7543/ims/types"
+
+	"github.com/joho/godotenv"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const partyColl = "parties"
+
+func main() {
+	if err := godotenv.Load(); err != nil {
+		log.Fatal(err)
+	}
+	var (
+		ctx           = context.Background()
+		mongoEndpoint = os.Getenv("MONGO_DB_URL")
+		mongoDBName   = os.Getenv("MONGO_DB_NAME")
+	)
+	serverAPI := options.ServerAPI(options.ServerAPIVersion1)
+	opts := options.Client().ApplyURI(mongoEndpoint).SetServerAPIOptions(serverAPI)
+	client, err := mongo.Connect(context.TODO(), opts)
+	if err != nil {
+		panic(err)
+	}
+
+	if err := client.Database(mongoDBName).Collection(partyColl).Drop(ctx); err != nil {
+		log.Fatal(err)
+	}
+	store := &db.Store{
+		Party: db.NewMongoPartyStore(client),
+	}
+
+	for _, role := range types.PartyRoles {
+		for i := 1; i <= 5; i++ {
+			party := &types.Party{
+				Company:     fmt.Sprintf("%s_Company_%d", role, i),
+				Name:        fmt.Sprintf("%s_Name_%d", role, i),
+				Phone:       fmt.Sprintf("Phone_%d", i),
+				Address:     fmt.Sprintf("Address_%d", i),
+				TaxIdNumber: taxIdNumber(fmt.Sprintf("%03d%04d", len(role), i)),
+				Roles:       []string{role},
+				Contacts: []types.PartyContact{
+					{Name: fmt.Sprintf("%s_Contact_%d", role, i), Phone: fmt.Sprintf("Phone_%d", i), Email: fmt.Sprintf("contact_%d@example.com", i), Role: "purchasing"},
+				},
+				Addresses: []types.PartyAddress{
+					{ID: primitive.NewObjectID(), Label: types.AddressBilling, Address: fmt.Sprintf("Billing_Address_%d", i), IsDefault: true},
+					{ID: primitive.NewObjectID(), Label: types.AddressShipping, Recipient: fmt.Sprintf("%s_Name_%d", role, i), Address: fmt.Sprintf("Shipping_Address_%d", i), IsDefault: true},
+				},
+			}
+
+			party = fixtures.AddParty(store, party)
+			fmt.Printf("%s added -> %s\n", role, party.Name)
+		}
+	}
+
+	// A supplier that also buys from us.
+	party := fixtures.AddParty(store, &types.Party{
+		Company:     "Company_Both",
+		Name:        "Name_Both",
+		Phone:       "Phone_Both",
+		Address:     "Address_Both",
+		TaxIdNumber: taxIdNumber("9999999"),
+		Roles:       []string{types.RoleSeller, types.RoleCustomer},
+		Contacts:    []types.PartyContact{},
+		Addresses:   []types.PartyAddress{},
+	})
+	fmt.Println("seller and customer added ->", party.Name)
+}
+
+// taxIdNumber appends the check digit that makes the seven digit prefix a valid unified business number.
+func taxIdNumber(prefix string) string {
+	for d := 0; d <= 9; d++ {
+		if id := fmt.Sprintf("%s%d", prefix, d); types.IsValidTaxIdNumber(id) {
+			return id
+		}
+	}
+	return ""
+}
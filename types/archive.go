@@ -0,0 +1,9 @@
+package types
+
+// PurgeResult counts the archived documents of one collection removed by a purge. Documents
+// that live records still refer to are kept and counted as skipped.
+type PurgeResult struct {
+	Collection string `json:"collection"`
+	Purged     int64  `json:"purged"`
+	Skipped    int64  `json:"skipped"`
+}
@@ -0,0 +1,123 @@
+package types
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Customer return statuses. A return is authorized before the goods come back and completed
+// once every line has been inspected.
+const (
+	ReturnAuthorized = "authorized"
+	ReturnCompleted  = "completed"
+	ReturnCanceled   = "canceled"
+)
+
+var ReturnStatuses = []string{ReturnAuthorized, ReturnCompleted, ReturnCanceled}
+
+// Reasons customers give for returning products.
+const (
+	ReturnReasonDefective      = "defective"
+	ReturnReasonDamaged        = "damaged"
+	ReturnReasonWrongItem      = "wrong_item"
+	ReturnReasonNotAsDescribed = "not_as_described"
+	ReturnReasonUnwanted       = "unwanted"
+	ReturnReasonOther          = "other"
+)
+
+var ReturnReasons = []string{ReturnReasonDefective, ReturnReasonDamaged, ReturnReasonWrongItem, ReturnReasonNotAsDescribed, ReturnReasonUnwanted, ReturnReasonOther}
+
+// Outcomes of inspecting returned products: back into stock, thrown away, or sent to a worker
+// to be reworked.
+const (
+	ReturnRestock = "restock"
+	ReturnScrap   = "scrap"
+	ReturnRework  = "rework"
+)
+
+var ReturnOutcomes = []string{ReturnRestock, ReturnScrap, ReturnRework}
+
+// CustomerReturn is a return authorization (RMA) for products of an order that were shipped.
+type CustomerReturn struct {
+	ID           primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	Number       string             `bson:"number" json:"number"`
+	OrderID      primitive.ObjectID `bson:"orderId" json:"orderId"`
+	CustomerID   primitive.ObjectID `bson:"customerId" json:"customerId"`
+	CustomerName string             `bson:"customerName" json:"customerName"`
+	Status       string             `bson:"status" json:"status"`
+	Lines        []ReturnLine       `bson:"lines" json:"lines"`
+	// Location is where restocked products are put back, that of the order unless another is
+	// given. Without one only the product totals change.
+	Location  *StockLocation     `bson:"location,omitempty" json:"location,omitempty"`
+	Remark    string             `bson:"remark" json:"remark"`
+	CreatedBy primitive.ObjectID `bson:"createdBy" json:"createdBy"`
+	CreatedAt time.Time          `bson:"createdAt" json:"createdAt"`
+	// CreditNoteID is the credit note issued for the return, once there is one.
+	CreditNoteID *primitive.ObjectID `bson:"creditNoteId,omitempty" json:"creditNoteId,omitempty"`
+	Version      int64               `bson:"version" json:"version"`
+}
+
+// FindLine returns the line of the return for order line i, or nil when it returns none of it.
+func (r *CustomerReturn) FindLine(i int) *ReturnLine {
+	for j := range r.Lines {
+		if r.Lines[j].Line == i {
+			return &r.Lines[j]
+		}
+	}
+	return nil
+}
+
+// ReturnLine is the quantity of one order line coming back. Line is the index of the line in
+// the order's items; a return has at most one line for each.
+type ReturnLine struct {
+	Line        int                `bson:"line" json:"line"`
+	ProductID   primitive.ObjectID `bson:"productId" json:"productId"`
+	SKU         string             `bson:"sku,omitempty" json:"sku,omitempty"`
+	Name        string             `bson:"name" json:"name"`
+	Quantity    int                `bson:"quantity" json:"quantity"`
+	Reason      string             `bson:"reason" json:"reason"`
+	Remark      string             `bson:"remark" json:"remark"`
+	Inspections []ReturnInspection `bson:"inspections" json:"inspections"`
+	Inspected   int                `bson:"inspected" json:"inspected"`
+}
+
+// ReturnInspection records what was done with part of a returned line.
+type ReturnInspection struct {
+	Outcome  string `bson:"outcome" json:"outcome"`
+	Quantity int    `bson:"quantity" json:"quantity"`
+	// ProcessingItemID is the processing item created to rework the products.
+	ProcessingItemID *primitive.ObjectID `bson:"processingItemId,omitempty" json:"processingItemId,omitempty"`
+	// MovementID is the stock movement that put restocked products back.
+	MovementID  *primitive.ObjectID `bson:"movementId,omitempty" json:"movementId,omitempty"`
+	Remark      string              `bson:"remark" json:"remark"`
+	InspectedBy primitive.ObjectID  `bson:"inspectedBy" json:"inspectedBy"`
+	InspectedAt time.Time           `bson:"inspectedAt" json:"inspectedAt"`
+}
+
+// CreditNote credits a customer for the products of a return, against the original order.
+type CreditNote struct {
+	ID           primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	Number       string             `bson:"number" json:"number"`
+	OrderID      primitive.ObjectID `bson:"orderId" json:"orderId"`
+	ReturnID     primitive.ObjectID `bson:"returnId" json:"returnId"`
+	ReturnNumber string             `bson:"returnNumber" json:"returnNumber"`
+	CustomerID   primitive.ObjectID `bson:"customerId" json:"customerId"`
+	CustomerName string             `bson:"customerName" json:"customerName"`
+	Lines        []CreditNoteLine   `bson:"lines" json:"lines"`
+	Amount       float64            `bson:"amount" json:"amount"`
+	Remark       string             `bson:"remark" json:"remark"`
+	IssuedBy     primitive.ObjectID `bson:"issuedBy" json:"issuedBy"`
+	IssuedAt     time.Time          `bson:"issuedAt" json:"issuedAt"`
+}
+
+// CreditNoteLine credits the returned quantity of an order line at the price it was sold for.
+type CreditNoteLine struct {
+	Line      int                `bson:"line" json:"line"`
+	ProductID primitive.ObjectID `bson:"productId" json:"productId"`
+	SKU       string             `bson:"sku,omitempty" json:"sku,omitempty"`
+	Name      string             `bson:"name" json:"name"`
+	Quantity  int                `bson:"quantity" json:"quantity"`
+	UnitPrice float64            `bson:"unitPrice" json:"unitPrice"`
+	Amount    float64            `bson:"amount" json:"amount"`
+}
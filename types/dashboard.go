@@ -0,0 +1,36 @@
+package types
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// DashboardSummary holds the KPIs drawn on the frontend home page.
+type DashboardSummary struct {
+	OpenOrders           int                        `json:"openOrders"`
+	AwaitingDelivery     int                        `json:"awaitingDelivery"`
+	OverdueDeliveries    int                        `json:"overdueDeliveries"`
+	UnpaidOrders         int                        `json:"unpaidOrders"`
+	UnpaidOrderTotal     float64                    `json:"unpaidOrderTotal"`
+	UnpaidMaterialOrders int                        `json:"unpaidMaterialOrders"`
+	UnpaidMaterialTotal  float64                    `json:"unpaidMaterialTotal"`
+	LowStockThreshold    int                        `json:"lowStockThreshold"`
+	LowStockProducts     int                        `json:"lowStockProducts"`
+	LowStockMaterials    int                        `json:"lowStockMaterials"`
+	ProcessingByWorker   []*WorkerProcessingSummary `json:"processingByWorker"`
+	SalesThisMonth       float64                    `json:"salesThisMonth"`
+	SalesLastMonth       float64                    `json:"salesLastMonth"`
+	SalesChangePercent   float64                    `json:"salesChangePercent"`
+	OrdersThisMonth      int                        `json:"ordersThisMonth"`
+	OrdersLastMonth      int                        `json:"ordersLastMonth"`
+	GeneratedAt          time.Time                  `json:"generatedAt"`
+}
+
+// WorkerProcessingSummary counts the processing items a worker currently has in progress.
+type WorkerProcessingSummary struct {
+	WorkerID   primitive.ObjectID `bson:"_id" json:"workerId"`
+	WorkerName string             `bson:"workerName" json:"workerName"`
+	ItemCount  int                `bson:"itemCount" json:"itemCount"`
+	Quantity   int                `bson:"quantity" json:"quantity"`
+}
@@ -0,0 +1,19 @@
+package types
+
+import "time"
+
+// IdempotencyRecord keeps the first response to a POST sent with an Idempotency-Key, so a
+// retry of the same request is answered with that response instead of running again.
+type IdempotencyRecord struct {
+	// ID scopes the key to the user who sent it.
+	ID          string    `bson:"_id" json:"id"`
+	Key         string    `bson:"key" json:"key"`
+	Method      string    `bson:"method" json:"method"`
+	Path        string    `bson:"path" json:"path"`
+	RequestHash string    `bson:"requestHash" json:"requestHash"`
+	Completed   bool      `bson:"completed" json:"completed"`
+	Status      int       `bson:"status,omitempty" json:"status,omitempty"`
+	ContentType string    `bson:"contentType,omitempty" json:"contentType,omitempty"`
+	Body        []byte    `bson:"body,omitempty" json:"body,omitempty"`
+	CreatedAt   time.Time `bson:"createdAt" json:"createdAt"`
+}
@@ -0,0 +1,41 @@
+package types
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	ImportJobPending   = "pending"
+	ImportJobRunning   = "running"
+	ImportJobCompleted = "completed"
+	ImportJobFailed    = "failed"
+)
+
+// ImportJob tracks a spreadsheet import from upload to completion.
+type ImportJob struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	Entity     string             `bson:"entity" json:"entity"`
+	FileName   string             `bson:"fileName" json:"fileName"`
+	Format     string             `bson:"format" json:"format"`
+	DryRun     bool               `bson:"dryRun" json:"dryRun"`
+	Mapping    map[string]string  `bson:"mapping" json:"mapping"`
+	Status     string             `bson:"status" json:"status"`
+	TotalRows  int                `bson:"totalRows" json:"totalRows"`
+	Created    int                `bson:"created" json:"created"`
+	Updated    int                `bson:"updated" json:"updated"`
+	Failed     int                `bson:"failed" json:"failed"`
+	Errors     []ImportRowError   `bson:"errors" json:"errors"`
+	Message    string             `bson:"message,omitempty" json:"message,omitempty"`
+	CreatedBy  primitive.ObjectID `bson:"createdBy" json:"createdBy"`
+	CreatedAt  time.Time          `bson:"createdAt" json:"createdAt"`
+	FinishedAt time.Time          `bson:"finishedAt" json:"finishedAt"`
+}
+
+// ImportRowError is a validation failure on one spreadsheet row. Row is 1-based and counts the header.
+type ImportRowError struct {
+	Row     int    `bson:"row" json:"row"`
+	Field   string `bson:"field" json:"field"`
+	Message string `bson:"message" json:"message"`
+}
@@ -0,0 +1,28 @@
+package types
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// Kinds of records a barcode can identify. Product barcodes hold the SKU, the others the ID.
+const (
+	LabelProduct  = "product"
+	LabelMaterial = "material"
+	LabelOrder    = "order"
+)
+
+var LabelKinds = []string{LabelProduct, LabelMaterial, LabelOrder}
+
+// Barcode symbologies.
+const (
+	SymbologyCode128 = "code128"
+	SymbologyQR      = "qr"
+)
+
+var Symbologies = []string{SymbologyCode128, SymbologyQR}
+
+// ScanResult is the record a scanned code identifies.
+type ScanResult struct {
+	Code   string             `json:"code"`
+	Kind   string             `json:"kind"`
+	ID     primitive.ObjectID `json:"id"`
+	Record any                `json:"record"`
+}
@@ -0,0 +1,49 @@
+package types
+
+import (
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Lookup kinds. Colors, types and sizes are shared by products and materials.
+const (
+	LookupColor = "color"
+	LookupType  = "type"
+	LookupSize  = "size"
+	LookupUnit  = "unit"
+)
+
+var LookupKinds = []string{LookupColor, LookupType, LookupSize, LookupUnit}
+
+// Lookup is a curated value of a kind of reference data. Records store its code; the names
+// are for display.
+type Lookup struct {
+	ID        primitive.ObjectID  `bson:"_id,omitempty" json:"id,omitempty"`
+	Kind      string              `bson:"kind" json:"kind"`
+	Code      string              `bson:"code" json:"code"`
+	NameZh    string              `bson:"nameZh" json:"nameZh"`
+	NameEn    string              `bson:"nameEn" json:"nameEn"`
+	SortOrder int                 `bson:"sortOrder" json:"sortOrder"`
+	Active    bool                `bson:"active" json:"active"`
+	Version   int64               `bson:"version" json:"version"`
+	DeletedAt *time.Time          `bson:"deletedAt,omitempty" json:"deletedAt,omitempty"`
+	DeletedBy *primitive.ObjectID `bson:"deletedBy,omitempty" json:"deletedBy,omitempty"`
+}
+
+// Matches reports whether value names the lookup by its code or either name, ignoring case.
+func (l *Lookup) Matches(value string) bool {
+	return strings.EqualFold(value, l.Code) ||
+		(l.NameZh != "" && strings.EqualFold(value, l.NameZh)) ||
+		(l.NameEn != "" && strings.EqualFold(value, l.NameEn))
+}
+
+// LookupMergeResult reports how many records a merge of lookup values rewrote, per collection.
+type LookupMergeResult struct {
+	Kind     string           `json:"kind"`
+	From     []string         `json:"from"`
+	To       string           `json:"to"`
+	Updated  map[string]int64 `json:"updated"`
+	Archived int64            `json:"archived"`
+}
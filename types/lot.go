@@ -0,0 +1,59 @@
+package types
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MaterialLot is a batch of a material received together, such as one dye lot of a fabric.
+// Quantities are in the material's base unit. The material's quantity is the total over its
+// lots plus stock received before lots were tracked.
+type MaterialLot struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	MaterialID primitive.ObjectID `bson:"materialId" json:"materialId"`
+	// LotNumber is unique per material. Receipts without one get a generated number.
+	LotNumber       string              `bson:"lotNumber" json:"lotNumber"`
+	MaterialOrderID *primitive.ObjectID `bson:"materialOrderId,omitempty" json:"materialOrderId,omitempty"`
+	ReceivedAt      time.Time           `bson:"receivedAt" json:"receivedAt"`
+	Quantity        float64             `bson:"quantity" json:"quantity"`
+	Remaining       float64             `bson:"remaining" json:"remaining"`
+	// Canceled is set when the material order that received the lot was canceled.
+	Canceled bool   `bson:"canceled,omitempty" json:"canceled,omitempty"`
+	Remark   string `bson:"remark" json:"remark"`
+}
+
+// MaterialConsumption records material taken out of stock to make products, and the lots it
+// was drawn from.
+type MaterialConsumption struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	MaterialID primitive.ObjectID `bson:"materialId" json:"materialId"`
+	// Quantity is in the material's base unit.
+	Quantity         float64             `bson:"quantity" json:"quantity"`
+	Draws            []LotDraw           `bson:"draws" json:"draws"`
+	ProductID        *primitive.ObjectID `bson:"productId,omitempty" json:"productId,omitempty"`
+	OrderID          *primitive.ObjectID `bson:"orderId,omitempty" json:"orderId,omitempty"`
+	ProcessingItemID *primitive.ObjectID `bson:"processingItemId,omitempty" json:"processingItemId,omitempty"`
+	// Location is where the material was taken from, when it was kept at one.
+	Location   *StockLocation     `bson:"location,omitempty" json:"location,omitempty"`
+	Remark     string             `bson:"remark" json:"remark"`
+	ConsumedAt time.Time          `bson:"consumedAt" json:"consumedAt"`
+	CreatedBy  primitive.ObjectID `bson:"createdBy" json:"createdBy"`
+}
+
+// LotDraw is the part of a consumption taken from one lot. Draws without a lot took stock
+// received before lots were tracked.
+type LotDraw struct {
+	LotID     *primitive.ObjectID `bson:"lotId,omitempty" json:"lotId,omitempty"`
+	LotNumber string              `bson:"lotNumber,omitempty" json:"lotNumber,omitempty"`
+	Quantity  float64             `bson:"quantity" json:"quantity"`
+}
+
+// LotTrace shows where a lot came from and what used it.
+type LotTrace struct {
+	Lot           *MaterialLot           `json:"lot"`
+	MaterialOrder *MaterialOrder         `json:"materialOrder,omitempty"`
+	Consumptions  []*MaterialConsumption `json:"consumptions"`
+	Products      []*Product             `json:"products"`
+	Orders        []*Order               `json:"orders"`
+}
@@ -12,12 +12,44 @@ type PriceHistoryEntry struct {
 }
 
 type Material struct {
-	ID           primitive.ObjectID  `bson:"_id,omitempty" json:"id,omitempty"`
-	Name         string              `bson:"name" json:"name"`
-	Color        string              `bson:"color" json:"color"`
-	Type         string              `bson:"type" json:"type"`
-	Size         string              `bson:"size" json:"size"`
-	Quantity     int                 `bson:"quantity" json:"quantity"`
+	ID    primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	Name  string             `bson:"name" json:"name"`
+	Color string             `bson:"color" json:"color"`
+	Type  string             `bson:"type" json:"type"`
+	Size  string             `bson:"size" json:"size"`
+	// Unit is the base unit the stock is counted in; prices in PriceHistory are per base unit.
+	Unit     string  `bson:"unit" json:"unit"`
+	Quantity float64 `bson:"quantity" json:"quantity"`
+	// Units lists the other units the material is bought or used in.
+	Units        []UnitConversion    `bson:"units" json:"units"`
 	Remarks      string              `bson:"remarks" json:"remarks"`
 	PriceHistory []PriceHistoryEntry `bson:"price_history" json:"price_history"`
+	Version      int64               `bson:"version" json:"version"`
+	DeletedAt    *time.Time          `bson:"deletedAt,omitempty" json:"deletedAt,omitempty"`
+	DeletedBy    *primitive.ObjectID `bson:"deletedBy,omitempty" json:"deletedBy,omitempty"`
+}
+
+// UnitConversion says how many base units of a material one Unit holds, e.g. a roll of 50 meters.
+type UnitConversion struct {
+	Unit   string  `bson:"unit" json:"unit"`
+	Factor float64 `bson:"factor" json:"factor"`
+}
+
+// UnitFactor returns how many base units one unit holds. An empty unit is the base unit.
+func (m *Material) UnitFactor(unit string) (float64, bool) {
+	if unit == "" || unit == m.Unit {
+		return 1, true
+	}
+	for _, u := range m.Units {
+		if u.Unit == unit {
+			return u.Factor, true
+		}
+	}
+	return 0, false
+}
+
+// ToBase converts a quantity in unit into the base unit.
+func (m *Material) ToBase(quantity float64, unit string) (float64, bool) {
+	factor, ok := m.UnitFactor(unit)
+	return quantity * factor, ok
 }
@@ -6,6 +6,16 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// Material order statuses. Completing an order adds its materials to stock and canceling a
+// completed order takes them out again.
+const (
+	MaterialOrderPending   = "pending"
+	MaterialOrderCompleted = "completed"
+	MaterialOrderCanceled  = "canceled"
+)
+
+var MaterialOrderStatuses = []string{MaterialOrderPending, MaterialOrderCompleted, MaterialOrderCanceled}
+
 type MaterialOrder struct {
 	ID                 primitive.ObjectID  `bson:"_id,omitempty" json:"id,omitempty"`
 	SellerID           string              `bson:"sellerId" json:"sellerId"`
@@ -16,12 +26,38 @@ type MaterialOrder struct {
 	TotalAmount        float64             `bson:"totalAmount" json:"totalAmount"`
 	Status             string              `bson:"status" json:"status"`
 	MaterialOrderItems []MaterialOrderItem `bson:"materialOrderItems" json:"materialOrderItems"`
+	// Location is where the materials are received. Material orders without one only change
+	// the material totals.
+	Location *StockLocation `bson:"location,omitempty" json:"location,omitempty"`
+	// Credited is what supplier returns took off the order before it was paid. The seller is
+	// owed TotalAmount less Credited.
+	Credited  float64             `bson:"credited,omitempty" json:"credited,omitempty"`
+	Version   int64               `bson:"version" json:"version"`
+	DeletedAt *time.Time          `bson:"deletedAt,omitempty" json:"deletedAt,omitempty"`
+	DeletedBy *primitive.ObjectID `bson:"deletedBy,omitempty" json:"deletedBy,omitempty"`
 }
 
 type MaterialOrderItem struct {
-	Material   MaterialOrderMaterial `bson:"material" json:"material"`
-	Quantity   int                   `bson:"quantity" json:"quantity"`
-	TotalPrice float64               `bson:"totalPrice" json:"totalPrice"`
+	Material MaterialOrderMaterial `bson:"material" json:"material"`
+	Quantity float64               `bson:"quantity" json:"quantity"`
+	// Unit is the unit Quantity is bought in, the material's base unit when empty.
+	Unit string `bson:"unit,omitempty" json:"unit,omitempty"`
+	// BaseQuantity is Quantity converted into the material's base unit when the line was added.
+	BaseQuantity float64 `bson:"baseQuantity" json:"baseQuantity"`
+	TotalPrice   float64 `bson:"totalPrice" json:"totalPrice"`
+	// LotNumber is the lot the line is received as; a number is generated when it is empty.
+	LotNumber string `bson:"lotNumber,omitempty" json:"lotNumber,omitempty"`
+	// Returned is how much of the line went back to the seller, in the material's base unit.
+	Returned float64 `bson:"returned,omitempty" json:"returned,omitempty"`
+}
+
+// StockQuantity is what the line adds to the material's stock, in its base unit. Lines written
+// before units were recorded have no base quantity and count in the base unit.
+func (i MaterialOrderItem) StockQuantity() float64 {
+	if i.BaseQuantity != 0 {
+		return i.BaseQuantity
+	}
+	return i.Quantity
 }
 
 type MaterialOrderMaterial struct {
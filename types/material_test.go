@@ -0,0 +1,35 @@
+package types
+
+import "testing"
+
+func TestMaterialToBase(t *testing.T) {
+	m := &Material{
+		Unit: "m",
+		Units: []UnitConversion{
+			{Unit: "roll", Factor: 50},
+			{Unit: "cm", Factor: 0.01},
+		},
+	}
+
+	tests := []struct {
+		quantity float64
+		unit     string
+		want     float64
+		wantOK   bool
+	}{
+		{3, "", 3, true},
+		{3, "m", 3, true},
+		{2, "roll", 100, true},
+		{250, "cm", 2.5, true},
+		{0, "roll", 0, true},
+		{1, "kg", 0, false},
+		{1, "Roll", 0, false},
+	}
+
+	for _, tt := range tests {
+		got, ok := m.ToBase(tt.quantity, tt.unit)
+		if ok != tt.wantOK || got != tt.want {
+			t.Errorf("ToBase(%g, %q) = %g, %v, want %g, %v", tt.quantity, tt.unit, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
@@ -1,11 +1,32 @@
 package types
 
 import (
+	"strings"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// Order statuses. Older records may hold them capitalized, so compare them case-insensitively.
+// Shipments move an order to partially shipped and then shipped as its lines go out.
+const (
+	OrderPending          = "pending"
+	OrderProcessing       = "processing"
+	OrderPartiallyShipped = "partially_shipped"
+	OrderShipped          = "shipped"
+	OrderDelivered        = "delivered"
+	OrderCompleted        = "completed"
+	OrderCanceled         = "canceled"
+)
+
+var OrderStatuses = []string{OrderPending, OrderProcessing, OrderPartiallyShipped, OrderShipped, OrderDelivered, OrderCompleted, OrderCanceled}
+
+// OrderShippedStatus reports whether an order in status has left the warehouse, so its stock
+// has been taken rather than reserved.
+func OrderShippedStatus(status string) bool {
+	return strings.EqualFold(status, OrderShipped) || strings.EqualFold(status, OrderDelivered) || strings.EqualFold(status, OrderCompleted)
+}
+
 type Order struct {
 	ID              primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
 	CustomerID      primitive.ObjectID `bson:"customerId" json:"customerId"`
@@ -16,7 +37,19 @@ type Order struct {
 	TotalAmount     float64            `bson:"totalAmount" json:"totalAmount"`
 	Status          string             `bson:"status" json:"status"`
 	ShippingAddress string             `bson:"shippingAddress" json:"shippingAddress"`
-	OrderItems      []OrderItem        `bson:"orderItems" json:"orderItems"`
+	// ShippingAddressID refers to one of the customer's stored addresses. The snapshot keeps the
+	// address as it was when the order was placed, so later edits to the customer do not change it.
+	ShippingAddressID       primitive.ObjectID `bson:"shippingAddressId,omitempty" json:"shippingAddressId,omitempty"`
+	ShippingAddressSnapshot *PartyAddress      `bson:"shippingAddressSnapshot,omitempty" json:"shippingAddressSnapshot,omitempty"`
+	OrderItems              []OrderItem        `bson:"orderItems" json:"orderItems"`
+	// Location is where the order's products are taken from. Orders without one only change
+	// the product totals.
+	Location *StockLocation `bson:"location,omitempty" json:"location,omitempty"`
+	// Credited is the total of the credit notes issued against the order for returns.
+	Credited  float64             `bson:"credited,omitempty" json:"credited,omitempty"`
+	Version   int64               `bson:"version" json:"version"`
+	DeletedAt *time.Time          `bson:"deletedAt,omitempty" json:"deletedAt,omitempty"`
+	DeletedBy *primitive.ObjectID `bson:"deletedBy,omitempty" json:"deletedBy,omitempty"`
 }
 
 // OrderItem represents an item within a customer order.
@@ -24,6 +57,27 @@ type OrderItem struct {
 	Product    OrderProduct `bson:"product" json:"product"`
 	Quantity   int          `bson:"quantity" json:"quantity"`
 	TotalPrice float64      `bson:"totalPrice" json:"totalPrice"`
+	// Reserved is the part of Quantity held for the order and not yet taken out of stock. Lines
+	// of orders placed before stock was reserved have none; their stock was taken at once.
+	Reserved int `bson:"reserved,omitempty" json:"reserved,omitempty"`
+	// Shipped is how much of Quantity has gone out on shipments.
+	Shipped int `bson:"shipped,omitempty" json:"shipped,omitempty"`
+	// Returned is how much of the line customer returns have authorized to come back.
+	Returned int `bson:"returned,omitempty" json:"returned,omitempty"`
+}
+
+// Outstanding is how much of the line is still to be shipped.
+func (i OrderItem) Outstanding() int {
+	return i.Quantity - i.Shipped
+}
+
+// Delivered is how much of line i has gone out to the customer: all of it once the order is
+// shipped, what its shipments carried before.
+func (o *Order) Delivered(i int) int {
+	if OrderShippedStatus(o.Status) {
+		return o.OrderItems[i].Quantity
+	}
+	return o.OrderItems[i].Shipped
 }
 
 // OrderProduct represents a product associated with an order item.
@@ -0,0 +1,114 @@
+package types
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Roles a party can play. A single company may hold several, e.g. both seller and customer.
+const (
+	RoleCustomer = "customer"
+	RoleBuyer    = "buyer"
+	RoleSeller   = "seller"
+	RoleWorker   = "worker"
+)
+
+var PartyRoles = []string{RoleCustomer, RoleBuyer, RoleSeller, RoleWorker}
+
+// Labels of the addresses a party keeps on file.
+const (
+	AddressBilling   = "billing"
+	AddressShipping  = "shipping"
+	AddressWarehouse = "warehouse"
+)
+
+var AddressLabels = []string{AddressBilling, AddressShipping, AddressWarehouse}
+
+// Party is a company or person the business deals with: customers, buyers, sellers and workers.
+type Party struct {
+	ID          primitive.ObjectID  `bson:"_id,omitempty" json:"id,omitempty"`
+	Company     string              `bson:"company" json:"company"`
+	Name        string              `bson:"name" json:"name"`
+	Phone       string              `bson:"phone" json:"phone"`
+	Address     string              `bson:"address" json:"address"`
+	TaxIdNumber string              `bson:"taxIdNumber" json:"taxIdNumber"`
+	Roles       []string            `bson:"roles" json:"roles"`
+	Contacts    []PartyContact      `bson:"contacts" json:"contacts"`
+	Addresses   []PartyAddress      `bson:"addresses" json:"addresses"`
+	Version     int64               `bson:"version" json:"version"`
+	DeletedAt   *time.Time          `bson:"deletedAt,omitempty" json:"deletedAt,omitempty"`
+	DeletedBy   *primitive.ObjectID `bson:"deletedBy,omitempty" json:"deletedBy,omitempty"`
+}
+
+// PartyContact is a person to reach at a party, e.g. the purchasing or accounting contact.
+type PartyContact struct {
+	Name  string `bson:"name" json:"name"`
+	Phone string `bson:"phone" json:"phone"`
+	Email string `bson:"email" json:"email"`
+	Role  string `bson:"role" json:"role"`
+}
+
+// PartyAddress is a labeled address. Orders refer to shipping addresses by ID and keep a copy.
+type PartyAddress struct {
+	ID        primitive.ObjectID `bson:"_id" json:"id"`
+	Label     string             `bson:"label" json:"label"`
+	Recipient string             `bson:"recipient" json:"recipient"`
+	Phone     string             `bson:"phone" json:"phone"`
+	Address   string             `bson:"address" json:"address"`
+	IsDefault bool               `bson:"isDefault" json:"isDefault"`
+}
+
+func (p *Party) HasRole(role string) bool {
+	for _, r := range p.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// FindAddress returns the address with the given ID, or nil when the party has none.
+func (p *Party) FindAddress(id primitive.ObjectID) *PartyAddress {
+	for i := range p.Addresses {
+		if p.Addresses[i].ID == id {
+			return &p.Addresses[i]
+		}
+	}
+	return nil
+}
+
+// DefaultAddress returns the default address with the label, falling back to the first one.
+func (p *Party) DefaultAddress(label string) *PartyAddress {
+	var first *PartyAddress
+	for i := range p.Addresses {
+		if p.Addresses[i].Label != label {
+			continue
+		}
+		if p.Addresses[i].IsDefault {
+			return &p.Addresses[i]
+		}
+		if first == nil {
+			first = &p.Addresses[i]
+		}
+	}
+	return first
+}
+
+func IsValidAddressLabel(label string) bool {
+	for _, l := range AddressLabels {
+		if l == label {
+			return true
+		}
+	}
+	return false
+}
+
+func IsValidPartyRole(role string) bool {
+	for _, r := range PartyRoles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
@@ -7,15 +7,33 @@ import (
 )
 
 type Product struct {
-	ID       primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
-	SKU      string             `bson:"sku" json:"sku"`
-	Name     string             `bson:"name" json:"name"`
-	Material string             `bson:"material" json:"material"`
-	Color    string             `bson:"color" json:"color"`
-	Type     string             `bson:"type" json:"type"`
-	Size     string             `bson:"size" json:"size"`
-	Quantity int                `bson:"quantity" json:"quantity"`
-	Price    float64            `bson:"price" json:"price"`
-	Date     time.Time          `bson:"date" json:"date"`
-	Remark   string             `bson:"remark" json:"remark"`
+	ID       primitive.ObjectID  `bson:"_id,omitempty" json:"id,omitempty"`
+	SKU      string              `bson:"sku" json:"sku"`
+	ParentID *primitive.ObjectID `bson:"parentId,omitempty" json:"parentId,omitempty"`
+	Name     string              `bson:"name" json:"name"`
+	Material string              `bson:"material" json:"material"`
+	Color    string              `bson:"color" json:"color"`
+	Type     string              `bson:"type" json:"type"`
+	Size     string              `bson:"size" json:"size"`
+	// Quantity is the stock on hand. Reserved is the part of it promised to orders that have
+	// not shipped, and Available what is left to sell; Available is worked out when products
+	// are read and is not stored.
+	Quantity      int                 `bson:"quantity" json:"quantity"`
+	Reserved      int                 `bson:"reserved" json:"reserved"`
+	Available     int                 `bson:"-" json:"available"`
+	Price         float64             `bson:"price" json:"price"`
+	Date          time.Time           `bson:"date" json:"date"`
+	Remark        string              `bson:"remark" json:"remark"`
+	MaterialUsage []MaterialUsage     `bson:"materialUsage" json:"materialUsage"`
+	Version       int64               `bson:"version" json:"version"`
+	DeletedAt     *time.Time          `bson:"deletedAt,omitempty" json:"deletedAt,omitempty"`
+	DeletedBy     *primitive.ObjectID `bson:"deletedBy,omitempty" json:"deletedBy,omitempty"`
+}
+
+// MaterialUsage is the amount of a material consumed to make one unit of a product.
+type MaterialUsage struct {
+	MaterialID primitive.ObjectID `bson:"materialId" json:"materialId"`
+	Quantity   float64            `bson:"quantity" json:"quantity"`
+	// Unit is the unit Quantity is given in, the material's base unit when empty.
+	Unit string `bson:"unit,omitempty" json:"unit,omitempty"`
 }
@@ -0,0 +1,63 @@
+package types
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ProductCost is the rolled-up cost of making one unit of a product, stored per SKU.
+type ProductCost struct {
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	SKU           string             `bson:"sku" json:"sku"`
+	ProductID     primitive.ObjectID `bson:"productId" json:"productId"`
+	ProductName   string             `bson:"productName" json:"productName"`
+	MaterialCost  float64            `bson:"materialCost" json:"materialCost"`
+	LaborCost     float64            `bson:"laborCost" json:"laborCost"`
+	UnitCost      float64            `bson:"unitCost" json:"unitCost"`
+	MaterialLines []MaterialCostLine `bson:"materialLines" json:"materialLines"`
+	CalculatedAt  time.Time          `bson:"calculatedAt" json:"calculatedAt"`
+	// Warnings name what the roll-up had to leave out, such as materials that no longer exist.
+	Warnings []string `bson:"warnings,omitempty" json:"warnings,omitempty"`
+}
+
+// MaterialCostLine is the cost contributed by a single material to a product's unit cost.
+type MaterialCostLine struct {
+	MaterialID   primitive.ObjectID `bson:"materialId" json:"materialId"`
+	MaterialName string             `bson:"materialName" json:"materialName"`
+	// Quantity is in the material's base unit, which UnitPrice is per.
+	Quantity  float64 `bson:"quantity" json:"quantity"`
+	Unit      string  `bson:"unit,omitempty" json:"unit,omitempty"`
+	UnitPrice float64 `bson:"unitPrice" json:"unitPrice"`
+	Cost      float64 `bson:"cost" json:"cost"`
+	// Missing marks a material that was deleted or archived. Its line costs nothing and
+	// Quantity and Unit are as the product's usage gives them.
+	Missing bool `bson:"missing,omitempty" json:"missing,omitempty"`
+}
+
+// ProductMargin compares a product's sell price against its rolled-up unit cost.
+type ProductMargin struct {
+	SKU           string  `json:"sku"`
+	ProductName   string  `json:"productName"`
+	Price         float64 `json:"price"`
+	UnitCost      float64 `json:"unitCost"`
+	Margin        float64 `json:"margin"`
+	MarginPercent float64 `json:"marginPercent"`
+	CostAvailable bool    `json:"costAvailable"`
+}
+
+// OrderLineMargin compares an order line's unit price against the rolled-up unit cost.
+type OrderLineMargin struct {
+	OrderID       primitive.ObjectID `json:"orderId"`
+	CustomerName  string             `json:"customerName"`
+	OrderDate     time.Time          `json:"orderDate"`
+	SKU           string             `json:"sku"`
+	ProductName   string             `json:"productName"`
+	Quantity      int                `json:"quantity"`
+	UnitPrice     float64            `json:"unitPrice"`
+	UnitCost      float64            `json:"unitCost"`
+	Margin        float64            `json:"margin"`
+	MarginPercent float64            `json:"marginPercent"`
+	TotalMargin   float64            `json:"totalMargin"`
+	CostAvailable bool               `json:"costAvailable"`
+}
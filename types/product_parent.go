@@ -0,0 +1,34 @@
+package types
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ProductParent groups the variants of a product. Its colors, sizes and materials span the
+// variant matrix; every combination is a Product carrying the parent's ID.
+type ProductParent struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	Code      string             `bson:"code" json:"code"`
+	Name      string             `bson:"name" json:"name"`
+	Type      string             `bson:"type" json:"type"`
+	Colors    []string           `bson:"colors" json:"colors"`
+	Sizes     []string           `bson:"sizes" json:"sizes"`
+	Materials []string           `bson:"materials" json:"materials"`
+	// SKUTemplate builds the SKUs of the variants, e.g. "{code}-{color}-{size}-{seq:3}".
+	// The default template is used when it is empty.
+	SKUTemplate string              `bson:"skuTemplate" json:"skuTemplate"`
+	Price       float64             `bson:"price" json:"price"`
+	Remark      string              `bson:"remark" json:"remark"`
+	Version     int64               `bson:"version" json:"version"`
+	DeletedAt   *time.Time          `bson:"deletedAt,omitempty" json:"deletedAt,omitempty"`
+	DeletedBy   *primitive.ObjectID `bson:"deletedBy,omitempty" json:"deletedBy,omitempty"`
+}
+
+// VariantResult reports the variants created for a parent product and the combinations that
+// already had one.
+type VariantResult struct {
+	Created  []*Product `json:"created"`
+	Existing int        `json:"existing"`
+}
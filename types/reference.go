@@ -0,0 +1,12 @@
+package types
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// DocumentReferences lists the documents of one collection that refer to a record.
+// IDs holds at most the first few matches; Count is the full number.
+type DocumentReferences struct {
+	Collection string               `json:"collection"`
+	Field      string               `json:"field"`
+	Count      int64                `json:"count"`
+	IDs        []primitive.ObjectID `json:"ids"`
+}
@@ -0,0 +1,57 @@
+package types
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ReportRange limits a report to documents dated within [From, To]. Zero values leave that side open.
+type ReportRange struct {
+	From     time.Time
+	To       time.Time
+	Timezone string
+}
+
+type SalesByCustomer struct {
+	CustomerID   primitive.ObjectID `bson:"_id" json:"customerId"`
+	CustomerName string             `bson:"customerName" json:"customerName"`
+	OrderCount   int                `bson:"orderCount" json:"orderCount"`
+	TotalAmount  float64            `bson:"totalAmount" json:"totalAmount"`
+}
+
+type SalesByProduct struct {
+	ProductID   primitive.ObjectID `bson:"_id" json:"productId"`
+	SKU         string             `bson:"sku" json:"sku"`
+	ProductName string             `bson:"productName" json:"productName"`
+	Quantity    int                `bson:"quantity" json:"quantity"`
+	TotalAmount float64            `bson:"totalAmount" json:"totalAmount"`
+}
+
+type PurchasesBySeller struct {
+	SellerID    string  `bson:"_id" json:"sellerId"`
+	SellerName  string  `bson:"sellerName" json:"sellerName"`
+	OrderCount  int     `bson:"orderCount" json:"orderCount"`
+	TotalAmount float64 `bson:"totalAmount" json:"totalAmount"`
+}
+
+type PurchasesByMaterial struct {
+	MaterialID   primitive.ObjectID `bson:"_id" json:"materialId"`
+	MaterialName string             `bson:"materialName" json:"materialName"`
+	// Quantity is in the material's base unit.
+	Quantity    float64 `bson:"quantity" json:"quantity"`
+	TotalAmount float64 `bson:"totalAmount" json:"totalAmount"`
+}
+
+// MonthlyTotal is a per-month sum, Month formatted as YYYY-MM.
+type MonthlyTotal struct {
+	Month       string  `bson:"_id" json:"month"`
+	OrderCount  int     `bson:"orderCount" json:"orderCount"`
+	TotalAmount float64 `bson:"totalAmount" json:"totalAmount"`
+}
+
+type StatusCount struct {
+	Status      string  `bson:"_id" json:"status"`
+	Count       int     `bson:"count" json:"count"`
+	TotalAmount float64 `bson:"totalAmount" json:"totalAmount"`
+}
@@ -0,0 +1,40 @@
+package types
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Shipment is one consignment of an order's products. An order can go out in several
+// shipments; each takes the stock reserved for the lines it carries.
+type Shipment struct {
+	ID           primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	Number       string             `bson:"number" json:"number"`
+	OrderID      primitive.ObjectID `bson:"orderId" json:"orderId"`
+	CustomerID   primitive.ObjectID `bson:"customerId" json:"customerId"`
+	CustomerName string             `bson:"customerName" json:"customerName"`
+	// ShippingAddress is copied from the order when the shipment is recorded.
+	ShippingAddress string         `bson:"shippingAddress" json:"shippingAddress"`
+	Carrier         string         `bson:"carrier" json:"carrier"`
+	TrackingNumber  string         `bson:"trackingNumber" json:"trackingNumber"`
+	ShipDate        time.Time      `bson:"shipDate" json:"shipDate"`
+	Lines           []ShipmentLine `bson:"lines" json:"lines"`
+	// Location is where the products were taken from, that of the order.
+	Location  *StockLocation     `bson:"location,omitempty" json:"location,omitempty"`
+	Remark    string             `bson:"remark" json:"remark"`
+	CreatedBy primitive.ObjectID `bson:"createdBy" json:"createdBy"`
+	CreatedAt time.Time          `bson:"createdAt" json:"createdAt"`
+	Version   int64              `bson:"version" json:"version"`
+}
+
+// ShipmentLine is the quantity of one order line in a shipment. Line is the index of the line
+// in the order's items.
+type ShipmentLine struct {
+	Line      int                `bson:"line" json:"line"`
+	ProductID primitive.ObjectID `bson:"productId" json:"productId"`
+	SKU       string             `bson:"sku,omitempty" json:"sku,omitempty"`
+	Name      string             `bson:"name" json:"name"`
+	Ordered   int                `bson:"ordered" json:"ordered"`
+	Quantity  int                `bson:"quantity" json:"quantity"`
+}
@@ -0,0 +1,107 @@
+package types
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Kinds of stocked items. Products count in pieces and materials in their base unit.
+const (
+	StockProduct  = "product"
+	StockMaterial = "material"
+)
+
+var StockItemKinds = []string{StockProduct, StockMaterial}
+
+// StockLocation is a warehouse, or a bin of it when Bin is set.
+type StockLocation struct {
+	WarehouseID primitive.ObjectID `bson:"warehouseId" json:"warehouseId"`
+	Bin         string             `bson:"bin,omitempty" json:"bin,omitempty"`
+}
+
+// StockLevel is how much of a product or material is kept at one location. The quantity on
+// the product or material stays the total over every location plus the stock not placed at
+// any location yet.
+type StockLevel struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	ItemKind    string             `bson:"itemKind" json:"itemKind"`
+	ItemID      primitive.ObjectID `bson:"itemId" json:"itemId"`
+	WarehouseID primitive.ObjectID `bson:"warehouseId" json:"warehouseId"`
+	Bin         string             `bson:"bin" json:"bin"`
+	Quantity    float64            `bson:"quantity" json:"quantity"`
+	// Reserved is the part of Quantity held for orders taking their products from here.
+	Reserved  float64   `bson:"reserved" json:"reserved"`
+	UpdatedAt time.Time `bson:"updatedAt" json:"updatedAt"`
+}
+
+// StockBreakdown shows where the stock of a product or material is. Unassigned is the part of
+// Total not placed at a location.
+type StockBreakdown struct {
+	ItemKind   string        `json:"itemKind"`
+	ItemID     string        `json:"itemId"`
+	Total      float64       `json:"total"`
+	Reserved   float64       `json:"reserved"`
+	Unassigned float64       `json:"unassigned"`
+	Levels     []*StockLevel `json:"levels"`
+}
+
+// StockTransfer moves stock between locations. Transfers are applied when they are recorded
+// and cannot be changed afterwards; a transfer the other way undoes one.
+type StockTransfer struct {
+	ID     primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	Number string             `bson:"number" json:"number"`
+	// From is nil when the transfer places stock that was not at any location yet.
+	From         *StockLocation      `bson:"from,omitempty" json:"from,omitempty"`
+	To           StockLocation       `bson:"to" json:"to"`
+	Items        []StockTransferItem `bson:"items" json:"items"`
+	TransferDate time.Time           `bson:"transferDate" json:"transferDate"`
+	Remark       string              `bson:"remark" json:"remark"`
+	CreatedBy    primitive.ObjectID  `bson:"createdBy" json:"createdBy"`
+	CreatedAt    time.Time           `bson:"createdAt" json:"createdAt"`
+}
+
+type StockTransferItem struct {
+	ItemKind string             `bson:"itemKind" json:"itemKind"`
+	ItemID   primitive.ObjectID `bson:"itemId" json:"itemId"`
+	Name     string             `bson:"name" json:"name"`
+	Quantity float64            `bson:"quantity" json:"quantity"`
+}
+
+// Reasons recorded on stock adjustments.
+const (
+	StockReasonMiscount = "miscount"
+	StockReasonDamaged  = "damaged"
+	StockReasonLost     = "lost"
+	StockReasonFound    = "found"
+	StockReasonOther    = "other"
+)
+
+var StockReasons = []string{StockReasonMiscount, StockReasonDamaged, StockReasonLost, StockReasonFound, StockReasonOther}
+
+// Documents stock movements are recorded for.
+const (
+	StockSourceStocktake      = "stocktake"
+	StockSourceReturn         = "return"
+	StockSourceSupplierReturn = "supplier_return"
+)
+
+// StockMovement is an entry of the stock ledger: a change to the stock of a product or
+// material, why it was made and the document that made it. Quantity is negative when stock
+// was taken out. Movements are applied when they are recorded and never change.
+type StockMovement struct {
+	ID       primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	ItemKind string             `bson:"itemKind" json:"itemKind"`
+	ItemID   primitive.ObjectID `bson:"itemId" json:"itemId"`
+	Name     string             `bson:"name" json:"name"`
+	// Location is where the stock changed; nil when only the item's total did.
+	Location     *StockLocation     `bson:"location,omitempty" json:"location,omitempty"`
+	Quantity     float64            `bson:"quantity" json:"quantity"`
+	Reason       string             `bson:"reason" json:"reason"`
+	Source       string             `bson:"source" json:"source"`
+	SourceID     primitive.ObjectID `bson:"sourceId" json:"sourceId"`
+	SourceNumber string             `bson:"sourceNumber" json:"sourceNumber"`
+	Remark       string             `bson:"remark" json:"remark"`
+	CreatedBy    primitive.ObjectID `bson:"createdBy" json:"createdBy"`
+	CreatedAt    time.Time          `bson:"createdAt" json:"createdAt"`
+}
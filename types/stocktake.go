@@ -0,0 +1,74 @@
+package types
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Stocktake statuses. A stocktake is counted while open and changes stock only when it is
+// posted.
+const (
+	StocktakeOpen     = "open"
+	StocktakePosted   = "posted"
+	StocktakeCanceled = "canceled"
+)
+
+var StocktakeStatuses = []string{StocktakeOpen, StocktakePosted, StocktakeCanceled}
+
+// Stocktake is a physical count of stock. The quantities expected are frozen when it is
+// started; posting it adjusts stock by the variances between them and the counts.
+type Stocktake struct {
+	ID     primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	Number string             `bson:"number" json:"number"`
+	// ItemKind limits the count to products or materials; both are counted when it is empty.
+	ItemKind string `bson:"itemKind,omitempty" json:"itemKind,omitempty"`
+	// Location is the warehouse or bin counted. Without one the item totals are counted.
+	Location  *StockLocation     `bson:"location,omitempty" json:"location,omitempty"`
+	Status    string             `bson:"status" json:"status"`
+	Lines     []StocktakeLine    `bson:"lines" json:"lines"`
+	Remark    string             `bson:"remark" json:"remark"`
+	CreatedBy primitive.ObjectID `bson:"createdBy" json:"createdBy"`
+	CreatedAt time.Time          `bson:"createdAt" json:"createdAt"`
+	// Reason is recorded on the adjustments of lines that give none of their own.
+	Reason   string              `bson:"reason,omitempty" json:"reason,omitempty"`
+	PostedBy *primitive.ObjectID `bson:"postedBy,omitempty" json:"postedBy,omitempty"`
+	PostedAt *time.Time          `bson:"postedAt,omitempty" json:"postedAt,omitempty"`
+	Version  int64               `bson:"version" json:"version"`
+}
+
+// StocktakeLine is the count of one product or material.
+type StocktakeLine struct {
+	ItemKind string             `bson:"itemKind" json:"itemKind"`
+	ItemID   primitive.ObjectID `bson:"itemId" json:"itemId"`
+	Name     string             `bson:"name" json:"name"`
+	Expected float64            `bson:"expected" json:"expected"`
+	// Counts holds the latest count of every counter. Counters count separate parts of the
+	// stock, such as different shelves, so the line's count is their sum.
+	Counts []StocktakeCount `bson:"counts" json:"counts"`
+	// Counted is nil until the item has been counted.
+	Counted  *float64 `bson:"counted,omitempty" json:"counted,omitempty"`
+	Variance float64  `bson:"variance" json:"variance"`
+	Reason   string   `bson:"reason,omitempty" json:"reason,omitempty"`
+}
+
+type StocktakeCount struct {
+	CountedBy primitive.ObjectID `bson:"countedBy" json:"countedBy"`
+	Quantity  float64            `bson:"quantity" json:"quantity"`
+	Remark    string             `bson:"remark" json:"remark"`
+	CountedAt time.Time          `bson:"countedAt" json:"countedAt"`
+}
+
+// Recount sums the counts into Counted and works out the variance.
+func (l *StocktakeLine) Recount() {
+	if len(l.Counts) == 0 {
+		l.Counted, l.Variance = nil, 0
+		return
+	}
+	counted := 0.0
+	for _, count := range l.Counts {
+		counted += count.Quantity
+	}
+	l.Counted = &counted
+	l.Variance = counted - l.Expected
+}
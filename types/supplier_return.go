@@ -0,0 +1,112 @@
+package types
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Supplier return statuses. A return stays open until every line to be replaced has been
+// delivered again.
+const (
+	SupplierReturnOpen   = "open"
+	SupplierReturnClosed = "closed"
+)
+
+var SupplierReturnStatuses = []string{SupplierReturnOpen, SupplierReturnClosed}
+
+// How the seller makes up for returned material: by crediting its price or by delivering it
+// again.
+const (
+	SupplierReturnCredit      = "credit"
+	SupplierReturnReplacement = "replacement"
+)
+
+var SupplierReturnResolutions = []string{SupplierReturnCredit, SupplierReturnReplacement}
+
+// How a credit is settled: taken off the material order while it is unpaid, refunded by the
+// seller once it was paid.
+const (
+	SupplierSettlementOffset = "offset"
+	SupplierSettlementRefund = "refund"
+)
+
+// SupplierReturn sends defective material of a completed material order back to its seller.
+type SupplierReturn struct {
+	ID              primitive.ObjectID   `bson:"_id,omitempty" json:"id,omitempty"`
+	Number          string               `bson:"number" json:"number"`
+	MaterialOrderID primitive.ObjectID   `bson:"materialOrderId" json:"materialOrderId"`
+	SellerID        string               `bson:"sellerId" json:"sellerId"`
+	SellerName      string               `bson:"sellerName" json:"sellerName"`
+	Status          string               `bson:"status" json:"status"`
+	Lines           []SupplierReturnLine `bson:"lines" json:"lines"`
+	// Location is where the material is taken from and replacements are received, that of the
+	// material order unless another is given. Without one only the material totals change.
+	Location *StockLocation `bson:"location,omitempty" json:"location,omitempty"`
+	// Credit is the price of the lines to be credited, settled as Settlement says.
+	Credit     float64 `bson:"credit" json:"credit"`
+	Settlement string  `bson:"settlement,omitempty" json:"settlement,omitempty"`
+	// RefundReceivedAt is when the seller paid a refund back.
+	RefundReceivedAt *time.Time         `bson:"refundReceivedAt,omitempty" json:"refundReceivedAt,omitempty"`
+	Remark           string             `bson:"remark" json:"remark"`
+	CreatedBy        primitive.ObjectID `bson:"createdBy" json:"createdBy"`
+	CreatedAt        time.Time          `bson:"createdAt" json:"createdAt"`
+	Version          int64              `bson:"version" json:"version"`
+}
+
+// FindLine returns the line of the return for material order line i, or nil when it returns
+// none of it.
+func (r *SupplierReturn) FindLine(i int) *SupplierReturnLine {
+	for j := range r.Lines {
+		if r.Lines[j].Line == i {
+			return &r.Lines[j]
+		}
+	}
+	return nil
+}
+
+// SupplierReturnLine is the material of one material order line going back. Line is the index
+// of the line in the order's items; a return has at most one line for each. Quantity is in the
+// material's base unit.
+type SupplierReturnLine struct {
+	Line       int                `bson:"line" json:"line"`
+	MaterialID primitive.ObjectID `bson:"materialId" json:"materialId"`
+	Name       string             `bson:"name" json:"name"`
+	Quantity   float64            `bson:"quantity" json:"quantity"`
+	Reason     string             `bson:"reason" json:"reason"`
+	Resolution string             `bson:"resolution" json:"resolution"`
+	// LotID and LotNumber are the lot the material was taken from, when the line was received
+	// as one.
+	LotID     *primitive.ObjectID `bson:"lotId,omitempty" json:"lotId,omitempty"`
+	LotNumber string              `bson:"lotNumber,omitempty" json:"lotNumber,omitempty"`
+	// UnitPrice is what the order paid per base unit; Amount is the credit for the line.
+	UnitPrice  float64            `bson:"unitPrice" json:"unitPrice"`
+	Amount     float64            `bson:"amount" json:"amount"`
+	MovementID primitive.ObjectID `bson:"movementId" json:"movementId"`
+	Remark     string             `bson:"remark" json:"remark"`
+	// Replaced is how much of a line to be replaced has been delivered again.
+	Replaced     float64               `bson:"replaced" json:"replaced"`
+	Replacements []SupplierReplacement `bson:"replacements" json:"replacements"`
+}
+
+// SupplierReplacement is a delivery of replacement material, received as a new lot.
+type SupplierReplacement struct {
+	Quantity   float64            `bson:"quantity" json:"quantity"`
+	LotID      primitive.ObjectID `bson:"lotId" json:"lotId"`
+	LotNumber  string             `bson:"lotNumber" json:"lotNumber"`
+	MovementID primitive.ObjectID `bson:"movementId" json:"movementId"`
+	Remark     string             `bson:"remark" json:"remark"`
+	ReceivedBy primitive.ObjectID `bson:"receivedBy" json:"receivedBy"`
+	ReceivedAt time.Time          `bson:"receivedAt" json:"receivedAt"`
+}
+
+// SellerBalance is what is owed between the business and a seller: the unpaid material orders
+// less what returns took off them, and the refunds the seller has yet to pay for returns of
+// paid orders. Balance is what the business owes, negative when the seller owes.
+type SellerBalance struct {
+	SellerID     string  `json:"sellerId"`
+	UnpaidOrders int     `json:"unpaidOrders"`
+	Payable      float64 `json:"payable"`
+	RefundsDue   float64 `json:"refundsDue"`
+	Balance      float64 `json:"balance"`
+}
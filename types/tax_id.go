@@ -0,0 +1,29 @@
+package types
+
+// ubnWeights are the multipliers applied to the eight digits of a Taiwanese unified business number.
+var ubnWeights = [8]int{1, 2, 1, 2, 1, 2, 4, 1}
+
+// IsValidTaxIdNumber reports whether s is a Taiwanese unified business number (統一編號) with a
+// valid checksum. Each digit is multiplied by its weight and the digits of every product are
+// added up; the total must be divisible by 5. When the seventh digit is 7 its product, 28, may
+// count as either 1 or 0, so a total one short of a multiple of 5 is accepted as well.
+func IsValidTaxIdNumber(s string) bool {
+	if len(s) != 8 {
+		return false
+	}
+
+	sum := 0
+	for i := 0; i < 8; i++ {
+		d := s[i]
+		if d < '0' || d > '9' {
+			return false
+		}
+		product := int(d-'0') * ubnWeights[i]
+		sum += product/10 + product%10
+	}
+
+	if sum%5 == 0 {
+		return true
+	}
+	return s[6] == '7' && (sum+1)%5 == 0
+}
@@ -0,0 +1,30 @@
+package types
+
+import "testing"
+
+func TestIsValidTaxIdNumber(t *testing.T) {
+	tests := []struct {
+		number string
+		want   bool
+	}{
+		{"04595257", true},
+		{"22099131", true},
+		{"04595252", true},
+		{"12345678", false},
+		// A seventh digit of 7 lets a total one short of a multiple of 5 pass.
+		{"12345675", true},
+		{"12345676", true},
+		{"12345677", false},
+		{"", false},
+		{"0459525", false},
+		{"045952570", false},
+		{"0459525a", false},
+		{"0459-257", false},
+	}
+
+	for _, tt := range tests {
+		if got := IsValidTaxIdNumber(tt.number); got != tt.want {
+			t.Errorf("IsValidTaxIdNumber(%q) = %v, want %v", tt.number, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package types
+
+import (
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Warehouse is a place stock is kept, such as the factory storeroom or the retail shop. Stock
+// can be placed in the warehouse as a whole or in one of its bins.
+type Warehouse struct {
+	ID      primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	Code    string             `bson:"code" json:"code"`
+	Name    string             `bson:"name" json:"name"`
+	Address string             `bson:"address" json:"address"`
+	Bins    []Bin              `bson:"bins" json:"bins"`
+	// Default marks the warehouse orders and material orders use when they name no location.
+	Default   bool                `bson:"default" json:"default"`
+	Remark    string              `bson:"remark" json:"remark"`
+	Version   int64               `bson:"version" json:"version"`
+	DeletedAt *time.Time          `bson:"deletedAt,omitempty" json:"deletedAt,omitempty"`
+	DeletedBy *primitive.ObjectID `bson:"deletedBy,omitempty" json:"deletedBy,omitempty"`
+}
+
+// Bin is a shelf, rack or other spot within a warehouse.
+type Bin struct {
+	Code string `bson:"code" json:"code"`
+	Name string `bson:"name" json:"name"`
+}
+
+// FindBin returns the bin with code, ignoring case, or nil.
+func (w *Warehouse) FindBin(code string) *Bin {
+	for i := range w.Bins {
+		if strings.EqualFold(w.Bins[i].Code, code) {
+			return &w.Bins[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,291 @@
+// Package xlsx reads and writes the subset of Office Open XML spreadsheets the IMS
+// exchanges with users: a single worksheet of plain text and number cells.
+package xlsx
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+)
+
+var ErrNoWorksheet = errors.New("xlsx: workbook has no worksheet")
+
+// The size limits of a worksheet. Files claiming cells beyond them are rejected rather than
+// padded out to reach them.
+const (
+	maxColumns = 16384
+	maxRows    = 1048576
+)
+
+// Reader returns the rows of the first worksheet one at a time, mirroring csv.Reader.
+type Reader struct {
+	sheet      io.ReadCloser
+	dec        *xml.Decoder
+	strings    []string
+	nextRow    int
+	pending    []string
+	pendingNum int
+}
+
+// NewReader opens the first worksheet of the workbook held in r.
+func NewReader(r io.ReaderAt, size int64) (*Reader, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	files := map[string]*zip.File{}
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+
+	sheetPath, err := firstSheetPath(files)
+	if err != nil {
+		return nil, err
+	}
+
+	shared, err := readSharedStrings(files["xl/sharedStrings.xml"])
+	if err != nil {
+		return nil, err
+	}
+
+	sheetFile, ok := files[sheetPath]
+	if !ok {
+		return nil, ErrNoWorksheet
+	}
+	sheet, err := sheetFile.Open()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Reader{
+		sheet:   sheet,
+		dec:     xml.NewDecoder(sheet),
+		strings: shared,
+		nextRow: 1,
+	}, nil
+}
+
+// Close releases the worksheet stream.
+func (r *Reader) Close() error {
+	return r.sheet.Close()
+}
+
+// Read returns the next row. Rows left out of the file because they are empty come back as
+// empty records so row numbers stay aligned with the spreadsheet. It returns io.EOF after the
+// last row.
+func (r *Reader) Read() ([]string, error) {
+	if r.pending == nil {
+		if err := r.decodeRow(); err != nil {
+			return nil, err
+		}
+	}
+
+	if r.nextRow < r.pendingNum {
+		r.nextRow++
+		return []string{}, nil
+	}
+
+	row := r.pending
+	r.pending = nil
+	r.nextRow++
+	return row, nil
+}
+
+func (r *Reader) decodeRow() error {
+	for {
+		tok, err := r.dec.Token()
+		if err != nil {
+			return err
+		}
+
+		start, ok := tok.(xml.StartElement)
+		if !ok || start.Name.Local != "row" {
+			continue
+		}
+
+		var row xmlRow
+		if err := r.dec.DecodeElement(&row, &start); err != nil {
+			return err
+		}
+
+		record, err := r.record(row)
+		if err != nil {
+			return err
+		}
+
+		r.pending = record
+		r.pendingNum = row.R
+		if r.pendingNum < r.nextRow {
+			r.pendingNum = r.nextRow
+		}
+		if r.pendingNum > maxRows {
+			return fmt.Errorf("xlsx: row %d is beyond the last row %d", r.pendingNum, maxRows)
+		}
+		return nil
+	}
+}
+
+func (r *Reader) record(row xmlRow) ([]string, error) {
+	record := []string{}
+	if len(row.Cells) > maxColumns {
+		return nil, fmt.Errorf("xlsx: row %d has more than %d cells", row.R, maxColumns)
+	}
+	for i, c := range row.Cells {
+		col := i
+		if c.Ref != "" {
+			idx, err := columnIndex(c.Ref)
+			if err != nil {
+				return nil, err
+			}
+			col = idx
+		}
+		for len(record) <= col {
+			record = append(record, "")
+		}
+
+		switch c.Type {
+		case "s":
+			idx, err := strconv.Atoi(strings.TrimSpace(c.Value))
+			if err != nil || idx < 0 || idx >= len(r.strings) {
+				return nil, fmt.Errorf("xlsx: invalid shared string index %q in %s", c.Value, c.Ref)
+			}
+			record[col] = r.strings[idx]
+		case "inlineStr":
+			record[col] = c.Inline.text()
+		default:
+			record[col] = c.Value
+		}
+	}
+	return record, nil
+}
+
+// columnIndex converts the letters of a cell reference such as "AB12" to a zero based column.
+func columnIndex(ref string) (int, error) {
+	col := 0
+	n := 0
+	for _, ch := range ref {
+		if ch < 'A' || ch > 'Z' {
+			break
+		}
+		col = col*26 + int(ch-'A'+1)
+		n++
+		if col > maxColumns {
+			return 0, fmt.Errorf("xlsx: cell reference %q is beyond the last column", ref)
+		}
+	}
+	if n == 0 {
+		return 0, fmt.Errorf("xlsx: invalid cell reference %q", ref)
+	}
+	return col - 1, nil
+}
+
+type xmlRow struct {
+	R     int       `xml:"r,attr"`
+	Cells []xmlCell `xml:"c"`
+}
+
+type xmlCell struct {
+	Ref    string    `xml:"r,attr"`
+	Type   string    `xml:"t,attr"`
+	Value  string    `xml:"v"`
+	Inline xmlString `xml:"is"`
+}
+
+type xmlString struct {
+	Text string `xml:"t"`
+	Runs []struct {
+		Text string `xml:"t"`
+	} `xml:"r"`
+}
+
+func (s xmlString) text() string {
+	if len(s.Runs) == 0 {
+		return s.Text
+	}
+	var b strings.Builder
+	b.WriteString(s.Text)
+	for _, run := range s.Runs {
+		b.WriteString(run.Text)
+	}
+	return b.String()
+}
+
+func readSharedStrings(f *zip.File) ([]string, error) {
+	if f == nil {
+		return nil, nil
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	var sst struct {
+		Items []xmlString `xml:"si"`
+	}
+	if err := xml.NewDecoder(rc).Decode(&sst); err != nil {
+		return nil, err
+	}
+
+	shared := make([]string, len(sst.Items))
+	for i, si := range sst.Items {
+		shared[i] = si.text()
+	}
+	return shared, nil
+}
+
+// firstSheetPath resolves the part name of the first sheet listed in the workbook.
+func firstSheetPath(files map[string]*zip.File) (string, error) {
+	var workbook struct {
+		Sheets []struct {
+			ID string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+		} `xml:"sheets>sheet"`
+	}
+	if err := decodePart(files["xl/workbook.xml"], &workbook); err != nil {
+		return "", err
+	}
+	if len(workbook.Sheets) == 0 {
+		return "", ErrNoWorksheet
+	}
+
+	var rels struct {
+		Relationships []struct {
+			ID     string `xml:"Id,attr"`
+			Target string `xml:"Target,attr"`
+		} `xml:"Relationship"`
+	}
+	if err := decodePart(files["xl/_rels/workbook.xml.rels"], &rels); err != nil {
+		return "", err
+	}
+
+	for _, rel := range rels.Relationships {
+		if rel.ID != workbook.Sheets[0].ID {
+			continue
+		}
+		if strings.HasPrefix(rel.Target, "/") {
+			return strings.TrimPrefix(rel.Target, "/"), nil
+		}
+		return path.Join("xl", rel.Target), nil
+	}
+
+	return "", ErrNoWorksheet
+}
+
+func decodePart(f *zip.File, v any) error {
+	if f == nil {
+		return ErrNoWorksheet
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	return xml.NewDecoder(rc).Decode(v)
+}
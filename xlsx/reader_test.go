@@ -0,0 +1,195 @@
+package xlsx
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestColumnIndex(t *testing.T) {
+	tests := []struct {
+		ref     string
+		want    int
+		wantErr bool
+	}{
+		{"A1", 0, false},
+		{"Z9", 25, false},
+		{"AA10", 26, false},
+		{"AB12", 27, false},
+		{"XFD1048576", 16383, false},
+		{"XFE1", 0, true},
+		{"ZZZZZZZZZZZZZZ1", 0, true},
+		{"12", 0, true},
+		{"", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := columnIndex(tt.ref)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("columnIndex(%q) error = %v, want error %v", tt.ref, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && got != tt.want {
+			t.Errorf("columnIndex(%q) = %d, want %d", tt.ref, got, tt.want)
+		}
+	}
+}
+
+func TestReaderReadsWhatWriterWrites(t *testing.T) {
+	rows := [][]string{
+		{"sku", "name", "quantity"},
+		{"A-001", "Shirt", "12"},
+		{"007", "=cmd", "-1.5"},
+		{"", "only second", ""},
+	}
+
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, row := range rows {
+		if err := w.Write(row); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	got := readAll(t, buf.Bytes())
+	want := [][]string{
+		{"sku", "name", "quantity"},
+		{"A-001", "Shirt", "12"},
+		{"007", "=cmd", "-1.5"},
+		{"", "only second", ""},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("read %q, want %q", got, want)
+	}
+}
+
+func TestReaderSheets(t *testing.T) {
+	tests := []struct {
+		name    string
+		sheet   string
+		want    [][]string
+		wantErr string
+	}{
+		{
+			name:  "skipped rows come back empty",
+			sheet: `<row r="1"><c r="A1" t="inlineStr"><is><t>a</t></is></c></row><row r="3"><c r="B3"><v>2</v></c></row>`,
+			want:  [][]string{{"a"}, {}, {"", "2"}},
+		},
+		{
+			name:  "cells without references follow each other",
+			sheet: `<row><c t="inlineStr"><is><t>a</t></is></c><c><v>1</v></c></row>`,
+			want:  [][]string{{"a", "1"}},
+		},
+		{
+			name:  "last column",
+			sheet: `<row r="1"><c r="XFD1"><v>1</v></c></row>`,
+		},
+		{
+			name:    "column beyond the last",
+			sheet:   `<row r="1"><c r="XFE1"><v>1</v></c></row>`,
+			wantErr: "beyond the last column",
+		},
+		{
+			name:    "row beyond the last",
+			sheet:   `<row r="1048577"><c r="A1048577"><v>1</v></c></row>`,
+			wantErr: "beyond the last row",
+		},
+		{
+			name:    "unknown shared string",
+			sheet:   `<row r="1"><c r="A1" t="s"><v>3</v></c></row>`,
+			wantErr: "invalid shared string index",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := openSheet(t, tt.sheet)
+			defer r.Close()
+
+			var got [][]string
+			var err error
+			for {
+				var row []string
+				if row, err = r.Read(); err != nil {
+					break
+				}
+				got = append(got, row)
+			}
+
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != io.EOF {
+				t.Fatalf("error = %v, want io.EOF", err)
+			}
+			if tt.want != nil && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("read %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func readAll(t *testing.T, data []byte) [][]string {
+	t.Helper()
+	r, err := NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	var rows [][]string
+	for {
+		row, err := r.Read()
+		if err == io.EOF {
+			return rows
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows = append(rows, row)
+	}
+}
+
+// openSheet builds a workbook whose only worksheet holds rows, the contents of sheetData.
+func openSheet(t *testing.T, rows string) *Reader {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	parts := []struct{ name, body string }{
+		{"[Content_Types].xml", contentTypesXML},
+		{"_rels/.rels", rootRelsXML},
+		{"xl/workbook.xml", workbookXML},
+		{"xl/_rels/workbook.xml.rels", workbookRelsXML},
+		{"xl/worksheets/sheet1.xml", sheetHeader + rows + sheetFooter},
+	}
+	for _, part := range parts {
+		f, err := zw.Create(part.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(f, part.body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
@@ -0,0 +1,61 @@
+package xlsx
+
+import "testing"
+
+func TestColumnName(t *testing.T) {
+	tests := []struct {
+		index int
+		want  string
+	}{
+		{0, "A"},
+		{1, "B"},
+		{25, "Z"},
+		{26, "AA"},
+		{27, "AB"},
+		{51, "AZ"},
+		{52, "BA"},
+		{701, "ZZ"},
+		{702, "AAA"},
+		{16383, "XFD"},
+	}
+
+	for _, tt := range tests {
+		if got := columnName(tt.index); got != tt.want {
+			t.Errorf("columnName(%d) = %q, want %q", tt.index, got, tt.want)
+		}
+	}
+}
+
+func TestIsNumeric(t *testing.T) {
+	tests := []struct {
+		value string
+		want  bool
+	}{
+		{"0", true},
+		{"42", true},
+		{"-42", true},
+		{"3.25", true},
+		{"0.5", true},
+		{"-0.5", true},
+		{"", false},
+		{"-", false},
+		{".", false},
+		{"1.2.3", false},
+		// Leading zeros mark codes such as SKUs or phone numbers, which must stay text.
+		{"007", false},
+		{"0912345678", false},
+		{"1e5", false},
+		{"+5", false},
+		{" 5", false},
+		{"12abc", false},
+		// Excel keeps 15 significant digits, so longer numbers are written as text.
+		{"123456789012345", true},
+		{"1234567890123456", false},
+	}
+
+	for _, tt := range tests {
+		if got := isNumeric(tt.value); got != tt.want {
+			t.Errorf("isNumeric(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}